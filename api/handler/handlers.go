@@ -1,10 +1,17 @@
 package handler
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	"github.com/vgheri/gennaker/engine"
 )
 
@@ -14,6 +21,12 @@ type Handler struct {
 }
 
 const mimeTypeJSON string = "application/json; charset=UTF-8"
+const mimeTypeCSV string = "text/csv; charset=UTF-8"
+const mimeTypeGraphviz string = "text/vnd.graphviz; charset=UTF-8"
+const mimeTypeText string = "text/plain; charset=UTF-8"
+
+// idempotencyKeyHeader lets clients safely retry release notifications
+const idempotencyKeyHeader = "Idempotency-Key"
 
 // New initializes the package with the underlying data store instance
 func New(e engine.DeploymentEngine) *Handler {
@@ -26,6 +39,8 @@ func New(e engine.DeploymentEngine) *Handler {
 type APIError struct {
 	Code    int    //`json:"code"`
 	Message string //`json:"message"`
+	// Errors lists the values not matching the values schema of the chart
+	Errors []*engine.FieldError `json:"Errors,omitempty"`
 }
 
 // CreateDeploymentHandler creates a deployment
@@ -63,6 +78,145 @@ func (h *Handler) CreateDeploymentHandler(w http.ResponseWriter, r *http.Request
 	w.WriteHeader(http.StatusCreated)
 }
 
+// UpdateDeploymentHandler updates a deployment and resyncs its pipeline
+// with the gennaker.yml file of the chart
+func (h *Handler) UpdateDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deploymentName := vars["name"]
+	// Decode request
+	var reqBody UpdateDeploymentRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&reqBody); err != nil {
+		writeJSONError(w, err.Error(), 422)
+		return
+	}
+	// Prepare business call
+	diff, err := h.deploymentEngine.UpdateDeployment(
+		&engine.Deployment{
+			Name:          deploymentName,
+			ChartVersion:  reqBody.ChartVersion,
+			RepositoryURL: reqBody.RepositoryURL,
+		})
+	if err != nil {
+		// TODO: Get the status code from map of errors
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := UpdateDeploymentResponse{Diff: diff}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// ResyncDeploymentHandler resyncs the pipeline of a deployment with
+// the gennaker.yml file of its chart
+func (h *Handler) ResyncDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deploymentName := vars["name"]
+
+	// Prepare business call
+	diff, err := h.deploymentEngine.Resync(deploymentName)
+	if err != nil {
+		// TODO: Get the status code from map of errors
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := UpdateDeploymentResponse{Diff: diff}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// ValidatePipelineHandler checks the content of a gennaker.yml file.
+// Problems found in the file are part of the response, not errors
+func (h *Handler) ValidatePipelineHandler(w http.ResponseWriter, r *http.Request) {
+	// Decode request
+	var reqBody ValidatePipelineRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&reqBody); err != nil {
+		writeJSONError(w, err.Error(), 422)
+		return
+	}
+	// Prepare business call
+	respBody := ValidatePipelineResponse{Valid: true}
+	pipeline, err := h.deploymentEngine.ValidatePipeline([]byte(reqBody.Content))
+	if err != nil {
+		validationErr, ok := errors.Cause(err).(*engine.PipelineValidationError)
+		if !ok {
+			writeJSONError(w, err.Error(),
+				http.StatusBadRequest)
+			return
+		}
+		respBody.Valid = false
+		respBody.Errors = validationErr.Errors
+	}
+	respBody.Pipeline = pipeline
+
+	// Encode response
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// DeleteDeploymentHandler archives a deployment, or deletes it when
+// the hard query parameter is true. Helm releases are uninstalled when
+// the uninstall query parameter is true
+func (h *Handler) DeleteDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deploymentName := vars["name"]
+	hardDelete, err := parseBoolParam(r, "hard")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	uninstall, err := parseBoolParam(r, "uninstall")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Prepare business call
+	reports, err := h.deploymentEngine.DeleteDeployment(
+		&engine.DeleteRequest{
+			DeploymentName:    deploymentName,
+			HardDelete:        hardDelete,
+			UninstallReleases: uninstall,
+		})
+	if err != nil {
+		// TODO: Get the status code from map of errors
+		status := http.StatusBadRequest
+		if errors.Cause(err) == engine.ErrDeploymentInUse {
+			status = http.StatusConflict
+		}
+		writeJSONError(w, err.Error(), status)
+		return
+	}
+
+	// Encode response
+	respBody := DeleteDeploymentResponse{Reports: reports}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
 // NewDeploymentReleaseNotificationHandler manages the workflow triggered by
 // the notification of a new release for a registered deployment
 func (h *Handler) NewDeploymentReleaseNotificationHandler(w http.ResponseWriter, r *http.Request) {
@@ -74,21 +228,33 @@ func (h *Handler) NewDeploymentReleaseNotificationHandler(w http.ResponseWriter,
 		return
 	}
 	// Prepare business call
-	reports, err := h.deploymentEngine.HandleNewReleaseNotification(
+	record, err := h.deploymentEngine.HandleNewReleaseNotification(
 		&engine.ReleaseNotification{
 			DeploymentName: reqBody.DeploymentName,
 			ImageTag:       reqBody.ImageTag,
+			ImageTags:      reqBody.ImageTags,
 			ReleaseValues:  reqBody.ReleaseValues,
+			SecretValues:   reqBody.SecretValues,
+			IdempotencyKey: r.Header.Get(idempotencyKeyHeader),
 		})
 	if err != nil {
 		// TODO: Get the status code from map of errors
+		if validationErr, ok := errors.Cause(err).(*engine.ValuesValidationError); ok {
+			writeJSONValidationError(w, validationErr)
+			return
+		}
 		writeJSONError(w, err.Error(),
 			http.StatusBadRequest)
 		return
 	}
 
 	// Encode response
-	respBody := NewDeploymentReleaseNotificationResponse{Reports: reports}
+	respBody := NewDeploymentReleaseNotificationResponse{
+		ID:        record.ID,
+		Status:    record.Status,
+		Duplicate: record.Duplicate,
+		Reports:   record.Reports,
+	}
 	err = json.NewEncoder(w).Encode(respBody)
 	if err != nil {
 		writeJSONError(w, err.Error(),
@@ -117,10 +283,15 @@ func (h *Handler) PromoteReleaseHandler(w http.ResponseWriter, r *http.Request)
 			DeploymentName: deploymentName,
 			FromNamespace:  reqBody.FromNamespace,
 			ReleaseValues:  reqBody.ReleaseValues,
+			SecretValues:   reqBody.SecretValues,
 			ImageTag:       reqBody.ImageTag,
 		})
 	if err != nil {
 		// TODO: Get the status code from map of errors
+		if validationErr, ok := errors.Cause(err).(*engine.ValuesValidationError); ok {
+			writeJSONValidationError(w, validationErr)
+			return
+		}
 		writeJSONError(w, err.Error(),
 			http.StatusBadRequest)
 		return
@@ -137,6 +308,48 @@ func (h *Handler) PromoteReleaseHandler(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusCreated)
 }
 
+// PromoteToTargetHandler serves requests promoting an image tag to a namespace further down the pipeline
+func (h *Handler) PromoteToTargetHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deploymentName := vars["name"]
+	// Decode request
+	var reqBody PromoteToTargetRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&reqBody); err != nil {
+		writeJSONError(w, err.Error(), 422)
+		return
+	}
+
+	// Prepare business call
+	reports, err := h.deploymentEngine.PromoteToTarget(
+		&engine.PromoteToTargetRequest{
+			DeploymentName:        deploymentName,
+			TargetNamespace:       reqBody.TargetNamespace,
+			ImageTag:              reqBody.ImageTag,
+			FillIntermediateSteps: reqBody.FillIntermediateSteps,
+			ReleaseValues:         reqBody.ReleaseValues,
+			SecretValues:          reqBody.SecretValues,
+		})
+	if err != nil {
+		if validationErr, ok := errors.Cause(err).(*engine.ValuesValidationError); ok {
+			writeJSONValidationError(w, validationErr)
+			return
+		}
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := PromoteReleaseResponse{Reports: reports}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
 // RollbackReleaseHandler serves rollback requests
 func (h *Handler) RollbackReleaseHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
@@ -155,6 +368,8 @@ func (h *Handler) RollbackReleaseHandler(w http.ResponseWriter, r *http.Request)
 			DeploymentName: deploymentName,
 			Namespace:      reqBody.Namespace,
 			Revision:       reqBody.Revision,
+			ReleaseID:      reqBody.ReleaseID,
+			ImageTag:       reqBody.ImageTag,
 		})
 	if err != nil {
 		// TODO: Get the status code from map of errors
@@ -174,13 +389,171 @@ func (h *Handler) RollbackReleaseHandler(w http.ResponseWriter, r *http.Request)
 	w.WriteHeader(http.StatusCreated)
 }
 
-// GetDeployment gets the desired deployment
-func (h *Handler) GetDeployment(w http.ResponseWriter, r *http.Request) {
+// RollbackImageTagHandler serves requests reverting an image tag across the pipeline
+func (h *Handler) RollbackImageTagHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deploymentName := vars["name"]
+	// Decode request
+	var reqBody RollbackImageTagRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&reqBody); err != nil {
+		writeJSONError(w, err.Error(), 422)
+		return
+	}
+
+	// Prepare business call
+	reports, err := h.deploymentEngine.RollbackImageTag(
+		&engine.PipelineRollbackRequest{
+			DeploymentName: deploymentName,
+			ImageTag:       reqBody.ImageTag,
+		})
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := RollbackImageTagResponse{Reports: reports}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// ListDeployments lists deployments. Supported query parameters are
+// limit, offset, sort, order, chart, repository, namespace and with_status
+func (h *Handler) ListDeployments(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, err := parseIntParam(r, "limit")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := parseIntParam(r, "offset")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	withStatus, err := parseBoolParam(r, "with_status")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request := &engine.ListDeploymentsRequest{
+		Limit:         limit,
+		Offset:        offset,
+		SortBy:        query.Get("sort"),
+		SortOrder:     query.Get("order"),
+		ChartName:     query.Get("chart"),
+		RepositoryURL: query.Get("repository"),
+		Namespace:     query.Get("namespace"),
+	}
+
+	// Prepare business call
+	var deployments []*engine.Deployment
+	if withStatus {
+		deployments, err = h.deploymentEngine.ListDeploymentsWithStatus(request)
+	} else {
+		deployments, err = h.deploymentEngine.ListDeployments(request)
+	}
+	if err != nil {
+		// TODO: Get the status code from map of errors
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := ListDeploymentsResponse{Deployments: deployments}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetEnvironments returns which version of each deployment is running in each namespace.
+// It accepts the same query parameters as ListDeployments, plus format=json|csv
+func (h *Handler) GetEnvironments(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format := query.Get("format")
+	if len(format) == 0 {
+		format = "json"
+		if strings.Contains(r.Header.Get("Accept"), "text/csv") {
+			format = "csv"
+		}
+	}
+	if format != "json" && format != "csv" {
+		writeJSONError(w, fmt.Sprintf("Unsupported format %s", format), http.StatusBadRequest)
+		return
+	}
+	limit, err := parseIntParam(r, "limit")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	offset, err := parseIntParam(r, "offset")
+	if err != nil {
+		writeJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Prepare business call
+	matrix, err := h.deploymentEngine.GetEnvironmentMatrix(
+		&engine.ListDeploymentsRequest{
+			Limit:         limit,
+			Offset:        offset,
+			SortBy:        query.Get("sort"),
+			SortOrder:     query.Get("order"),
+			ChartName:     query.Get("chart"),
+			RepositoryURL: query.Get("repository"),
+			Namespace:     query.Get("namespace"),
+		})
+	if err != nil {
+		// TODO: Get the status code from map of errors
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	if format == "csv" {
+		w.Header().Set("Content-Type", mimeTypeCSV)
+		if err = writeEnvironmentsCSV(w, matrix); err != nil {
+			writeJSONError(w, err.Error(),
+				http.StatusInternalServerError)
+		}
+		return
+	}
+	respBody := GetEnvironmentsResponse{Matrix: matrix}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetPipelineGraph renders the pipeline of a deployment with the current release of each namespace.
+// It accepts format=json|dot|mermaid
+func (h *Handler) GetPipelineGraph(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	deploymentName := vars["name"]
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = "json"
+	}
+	if format != "json" && format != "dot" && format != "mermaid" {
+		writeJSONError(w, fmt.Sprintf("Unsupported format %s", format), http.StatusBadRequest)
+		return
+	}
 
 	// Prepare business call
-	d, err := h.deploymentEngine.GetDeployment(deploymentName)
+	graph, err := h.deploymentEngine.GetPipelineGraph(deploymentName)
 	if err != nil {
 		// TODO: Get the status code from map of errors
 		writeJSONError(w, err.Error(),
@@ -189,21 +562,779 @@ func (h *Handler) GetDeployment(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Encode response
-	respBody := GetDeploymentResponse{Deployment: d}
+	switch format {
+	case "dot":
+		w.Header().Set("Content-Type", mimeTypeGraphviz)
+		io.WriteString(w, graph.DOT())
+		return
+	case "mermaid":
+		w.Header().Set("Content-Type", mimeTypeText)
+		io.WriteString(w, graph.Mermaid())
+		return
+	}
+	respBody := GetPipelineGraphResponse{Graph: graph}
 	err = json.NewEncoder(w).Encode(respBody)
 	if err != nil {
 		writeJSONError(w, err.Error(),
 			http.StatusInternalServerError)
 		return
 	}
-	w.WriteHeader(http.StatusCreated)
 }
 
-func writeJSONError(w http.ResponseWriter, errorMsg string, httpErrorCode int) {
-	w.Header().Set("Content-Type", mimeTypeJSON)
-	w.WriteHeader(httpErrorCode)
-	apiErr := APIError{Message: errorMsg}
-	if err := json.NewEncoder(w).Encode(apiErr); err != nil {
-		// TODO log
+// GetReleaseDiagnostics gets the diagnostics collected when a release of the deployment failed
+func (h *Handler) GetReleaseDiagnostics(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deploymentName := vars["name"]
+	releaseID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Invalid release id %s", vars["id"]), http.StatusBadRequest)
+		return
+	}
+
+	// Prepare business call
+	diagnostics, err := h.deploymentEngine.GetReleaseDiagnostics(deploymentName, releaseID)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Cause(err) == engine.ErrResourceNotFound {
+			status = http.StatusNotFound
+		}
+		writeJSONError(w, err.Error(), status)
+		return
+	}
+
+	// Encode response
+	respBody := GetReleaseDiagnosticsResponse{Diagnostics: diagnostics}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// writeEnvironmentsCSV writes one line per deployment and namespace
+func writeEnvironmentsCSV(w io.Writer, matrix *engine.EnvironmentMatrix) error {
+	writer := csv.NewWriter(w)
+	header := []string{"deployment", "namespace", "image_tag", "chart_version",
+		"revision", "status", "date", "behind", "upstream_namespace"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, row := range matrix.Deployments {
+		for _, namespace := range matrix.Namespaces {
+			cell, found := row.Environments[namespace]
+			if !found {
+				continue
+			}
+			var date string
+			if cell.Date != nil {
+				date = cell.Date.UTC().Format(time.RFC3339)
+			}
+			record := []string{row.Deployment, cell.Namespace, cell.ImageTag, cell.ChartVersion,
+				strconv.Itoa(cell.Revision), cell.Status.String(), date, strconv.Itoa(cell.Behind),
+				cell.UpstreamNamespace}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// GetDeployment gets the desired deployment
+func (h *Handler) GetDeployment(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deploymentName := vars["name"]
+
+	// Prepare business call
+	d, err := h.deploymentEngine.GetDeployment(deploymentName)
+	if err != nil {
+		// TODO: Get the status code from map of errors
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := GetDeploymentResponse{Deployment: d}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+// GetValuesLayer gets the values managed by gennaker for a namespace of the deployment,
+// or its defaults when no namespace is specified
+func (h *Handler) GetValuesLayer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deploymentName := vars["name"]
+	namespace := vars["namespace"]
+
+	// Prepare business call
+	layer, err := h.deploymentEngine.GetValuesLayer(deploymentName, namespace)
+	if err != nil {
+		// TODO: Get the status code from map of errors
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := ValuesLayerResponse{Layer: layer}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// UpdateValuesLayer saves a new version of the values managed by gennaker for
+// a namespace of the deployment, or of its defaults when no namespace is specified
+func (h *Handler) UpdateValuesLayer(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deploymentName := vars["name"]
+	namespace := vars["namespace"]
+	// Decode request
+	var reqBody UpdateValuesRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&reqBody); err != nil {
+		writeJSONError(w, err.Error(), 422)
+		return
+	}
+
+	// Prepare business call
+	layer, err := h.deploymentEngine.UpdateValuesLayer(deploymentName, namespace, reqBody.Values)
+	if err != nil {
+		// TODO: Get the status code from map of errors
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := ValuesLayerResponse{Layer: layer}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetValuesLayerHistory lists every version of the values managed by gennaker for
+// a namespace of the deployment, or of its defaults when no namespace is specified
+func (h *Handler) GetValuesLayerHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deploymentName := vars["name"]
+	namespace := vars["namespace"]
+
+	// Prepare business call
+	layers, err := h.deploymentEngine.GetValuesLayerHistory(deploymentName, namespace)
+	if err != nil {
+		// TODO: Get the status code from map of errors
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := ValuesLayerHistoryResponse{Layers: layers}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetMergedValues shows the values helm receives for a namespace of the deployment,
+// once every source of values has been merged
+func (h *Handler) GetMergedValues(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deploymentName := vars["name"]
+	namespace := vars["namespace"]
+
+	// Prepare business call
+	values, err := h.deploymentEngine.GetMergedValues(deploymentName, namespace)
+	if err != nil {
+		// TODO: Get the status code from map of errors
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := MergedValuesResponse{Namespace: namespace, Values: values}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// CreateVariableSet creates a variable set
+func (h *Handler) CreateVariableSet(w http.ResponseWriter, r *http.Request) {
+	// Decode request
+	var reqBody VariableSetRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&reqBody); err != nil {
+		writeJSONError(w, err.Error(), 422)
+		return
+	}
+	// Prepare business call
+	id, err := h.deploymentEngine.CreateVariableSet(
+		&engine.VariableSet{
+			Name:        reqBody.Name,
+			Description: reqBody.Description,
+			Variables:   reqBody.Variables,
+		})
+	if err != nil {
+		// TODO: Get the status code from map of errors
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	w.Header().Set("Content-Type", mimeTypeJSON)
+	w.WriteHeader(http.StatusCreated)
+	respBody := CreateVariableSetResponse{ID: id}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// ListVariableSets lists every variable set
+func (h *Handler) ListVariableSets(w http.ResponseWriter, r *http.Request) {
+	// Prepare business call
+	sets, err := h.deploymentEngine.ListVariableSets()
+	if err != nil {
+		// TODO: Get the status code from map of errors
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := ListVariableSetsResponse{VariableSets: sets}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetVariableSet gets the desired variable set
+func (h *Handler) GetVariableSet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	// Prepare business call
+	set, err := h.deploymentEngine.GetVariableSet(name)
+	if err != nil {
+		// TODO: Get the status code from map of errors
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := VariableSetResponse{VariableSet: set}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// UpdateVariableSet replaces the description and the variables of a variable set
+func (h *Handler) UpdateVariableSet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+	// Decode request
+	var reqBody VariableSetRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&reqBody); err != nil {
+		writeJSONError(w, err.Error(), 422)
+		return
+	}
+	set := &engine.VariableSet{
+		Name:        name,
+		Description: reqBody.Description,
+		Variables:   reqBody.Variables,
+	}
+	// Prepare business call
+	if err := h.deploymentEngine.UpdateVariableSet(set); err != nil {
+		// TODO: Get the status code from map of errors
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := VariableSetResponse{VariableSet: set}
+	if err := json.NewEncoder(w).Encode(respBody); err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// DeleteVariableSet deletes a variable set which is not attached to any deployment
+func (h *Handler) DeleteVariableSet(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	// Prepare business call
+	if err := h.deploymentEngine.DeleteVariableSet(name); err != nil {
+		status := http.StatusBadRequest
+		if errors.Cause(err) == engine.ErrVariableSetInUse {
+			status = http.StatusConflict
+		}
+		writeJSONError(w, err.Error(), status)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetDeploymentVariableSets lists the variable sets attached to a deployment
+func (h *Handler) GetDeploymentVariableSets(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deploymentName := vars["name"]
+
+	// Prepare business call
+	sets, err := h.deploymentEngine.GetDeploymentVariableSets(deploymentName)
+	if err != nil {
+		// TODO: Get the status code from map of errors
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := ListVariableSetsResponse{VariableSets: sets}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// SetDeploymentVariableSets replaces the variable sets attached to a deployment
+func (h *Handler) SetDeploymentVariableSets(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	deploymentName := vars["name"]
+	// Decode request
+	var reqBody SetDeploymentVariableSetsRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&reqBody); err != nil {
+		writeJSONError(w, err.Error(), 422)
+		return
+	}
+
+	// Prepare business call
+	sets, err := h.deploymentEngine.SetDeploymentVariableSets(deploymentName, reqBody.VariableSets)
+	if err != nil {
+		// TODO: Get the status code from map of errors
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := ListVariableSetsResponse{VariableSets: sets}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// parseBoolParam reads an optional boolean query parameter, defaulting to false
+func parseBoolParam(r *http.Request, name string) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if len(value) == 0 {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid value %s for parameter %s", value, name)
+	}
+	return b, nil
+}
+
+// parseIntParam reads an optional integer query parameter, defaulting to 0
+func parseIntParam(r *http.Request, name string) (int, error) {
+	value := r.URL.Query().Get(name)
+	if len(value) == 0 {
+		return 0, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid value %s for parameter %s", value, name)
+	}
+	return i, nil
+}
+
+func writeJSONError(w http.ResponseWriter, errorMsg string, httpErrorCode int) {
+	w.Header().Set("Content-Type", mimeTypeJSON)
+	w.WriteHeader(httpErrorCode)
+	apiErr := APIError{Message: errorMsg}
+	if err := json.NewEncoder(w).Encode(apiErr); err != nil {
+		// TODO log
+	}
+}
+
+// writeJSONValidationError sends back the list of values not matching the values schema of the chart
+func writeJSONValidationError(w http.ResponseWriter, validationErr *engine.ValuesValidationError) {
+	w.Header().Set("Content-Type", mimeTypeJSON)
+	w.WriteHeader(http.StatusBadRequest)
+	apiErr := APIError{Message: validationErr.Error(), Errors: validationErr.Errors}
+	if err := json.NewEncoder(w).Encode(apiErr); err != nil {
+		// TODO log
+	}
+}
+
+// CreateApplication creates an application grouping deployments
+func (h *Handler) CreateApplication(w http.ResponseWriter, r *http.Request) {
+	// Decode request
+	var reqBody ApplicationRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&reqBody); err != nil {
+		writeJSONError(w, err.Error(), 422)
+		return
+	}
+	// Prepare business call
+	id, err := h.deploymentEngine.CreateApplication(
+		&engine.Application{
+			Name:        reqBody.Name,
+			Deployments: reqBody.Deployments,
+		})
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	w.Header().Set("Content-Type", mimeTypeJSON)
+	w.WriteHeader(http.StatusCreated)
+	respBody := CreateApplicationResponse{ID: id}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// ListApplications lists every application
+func (h *Handler) ListApplications(w http.ResponseWriter, r *http.Request) {
+	// Prepare business call
+	applications, err := h.deploymentEngine.ListApplications()
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := ListApplicationsResponse{Applications: applications}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetApplication gets the desired application
+func (h *Handler) GetApplication(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	name := vars["name"]
+
+	// Prepare business call
+	application, err := h.deploymentEngine.GetApplication(name)
+	if err != nil {
+		if errors.Cause(err) == engine.ErrResourceNotFound {
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := ApplicationResponse{Application: application}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// CreateSnapshot records the image tag of each deployment of an application
+func (h *Handler) CreateSnapshot(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	applicationName := vars["name"]
+	// Decode request
+	var reqBody SnapshotRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&reqBody); err != nil {
+		writeJSONError(w, err.Error(), 422)
+		return
+	}
+	// Prepare business call
+	snapshot, err := h.deploymentEngine.CreateSnapshot(
+		&engine.SnapshotRequest{
+			ApplicationName: applicationName,
+			Name:            reqBody.Name,
+			ImageTags:       reqBody.ImageTags,
+			FromNamespace:   reqBody.FromNamespace,
+		})
+	if err != nil {
+		if errors.Cause(err) == engine.ErrResourceNotFound {
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	w.Header().Set("Content-Type", mimeTypeJSON)
+	w.WriteHeader(http.StatusCreated)
+	respBody := SnapshotResponse{Snapshot: snapshot}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// ListSnapshots lists the snapshots of an application, from the most recent
+func (h *Handler) ListSnapshots(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	applicationName := vars["name"]
+
+	// Prepare business call
+	snapshots, err := h.deploymentEngine.ListSnapshots(applicationName)
+	if err != nil {
+		if errors.Cause(err) == engine.ErrResourceNotFound {
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := ListSnapshotsResponse{Snapshots: snapshots}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// PromoteSnapshot releases the deployments of an application snapshot to a namespace
+func (h *Handler) PromoteSnapshot(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	applicationName := vars["name"]
+	snapshotName := vars["snapshot"]
+	// Decode request
+	var reqBody PromoteSnapshotRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&reqBody); err != nil {
+		writeJSONError(w, err.Error(), 422)
+		return
+	}
+
+	// Prepare business call
+	reports, err := h.deploymentEngine.PromoteSnapshot(
+		&engine.PromoteSnapshotRequest{
+			ApplicationName: applicationName,
+			SnapshotName:    snapshotName,
+			TargetNamespace: reqBody.TargetNamespace,
+		})
+	if err != nil {
+		if errors.Cause(err) == engine.ErrResourceNotFound {
+			writeJSONError(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := PromoteReleaseResponse{Reports: reports}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// CreateSchedule queues a promotion or a new release for a future time
+func (h *Handler) CreateSchedule(w http.ResponseWriter, r *http.Request) {
+	// Decode request
+	var reqBody ScheduleRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&reqBody); err != nil {
+		writeJSONError(w, err.Error(), 422)
+		return
+	}
+	schedule := &engine.Schedule{Kind: reqBody.Type, Cron: reqBody.Cron}
+	if reqBody.RunAt != nil {
+		schedule.RunAt = *reqBody.RunAt
+	}
+	if p := reqBody.Promote; p != nil {
+		schedule.Promote = &engine.PromoteRequest{
+			DeploymentName: reqBody.DeploymentName,
+			FromNamespace:  p.FromNamespace,
+			ImageTag:       p.ImageTag,
+			ReleaseValues:  p.ReleaseValues,
+			SecretValues:   p.SecretValues,
+		}
+	}
+	if n := reqBody.NewRelease; n != nil {
+		deploymentName := n.DeploymentName
+		if len(deploymentName) == 0 {
+			deploymentName = reqBody.DeploymentName
+		}
+		schedule.NewRelease = &engine.ReleaseNotification{
+			DeploymentName: deploymentName,
+			ImageTag:       n.ImageTag,
+			ImageTags:      n.ImageTags,
+			ReleaseValues:  n.ReleaseValues,
+			SecretValues:   n.SecretValues,
+			IdempotencyKey: r.Header.Get(idempotencyKeyHeader),
+		}
+	}
+
+	// Prepare business call
+	id, err := h.deploymentEngine.CreateSchedule(schedule)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Cause(err) == engine.ErrResourceNotFound {
+			status = http.StatusNotFound
+		}
+		writeJSONError(w, err.Error(), status)
+		return
+	}
+
+	// Encode response
+	w.Header().Set("Content-Type", mimeTypeJSON)
+	w.WriteHeader(http.StatusCreated)
+	respBody := CreateScheduleResponse{ID: id}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// ListSchedules lists every scheduled job, the next to run first
+func (h *Handler) ListSchedules(w http.ResponseWriter, r *http.Request) {
+	// Prepare business call
+	schedules, err := h.deploymentEngine.ListSchedules()
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	// Encode response
+	respBody := ListSchedulesResponse{Schedules: schedules}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetSchedule gets a scheduled job and the outcome of its last run
+func (h *Handler) GetSchedule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Invalid schedule id %s", vars["id"]), http.StatusBadRequest)
+		return
+	}
+
+	// Prepare business call
+	schedule, err := h.deploymentEngine.GetSchedule(id)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Cause(err) == engine.ErrResourceNotFound {
+			status = http.StatusNotFound
+		}
+		writeJSONError(w, err.Error(), status)
+		return
+	}
+
+	// Encode response
+	respBody := ScheduleResponse{Schedule: schedule}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
+	}
+}
+
+// CancelSchedule cancels a scheduled job which has not run yet
+func (h *Handler) CancelSchedule(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		writeJSONError(w, fmt.Sprintf("Invalid schedule id %s", vars["id"]), http.StatusBadRequest)
+		return
+	}
+
+	// Prepare business call
+	schedule, err := h.deploymentEngine.CancelSchedule(id)
+	if err != nil {
+		status := http.StatusBadRequest
+		switch errors.Cause(err) {
+		case engine.ErrResourceNotFound:
+			status = http.StatusNotFound
+		case engine.ErrScheduleNotPending:
+			status = http.StatusConflict
+		}
+		writeJSONError(w, err.Error(), status)
+		return
+	}
+
+	// Encode response
+	respBody := ScheduleResponse{Schedule: schedule}
+	err = json.NewEncoder(w).Encode(respBody)
+	if err != nil {
+		writeJSONError(w, err.Error(),
+			http.StatusInternalServerError)
+		return
 	}
 }
@@ -112,7 +112,7 @@ func TestNewDeploymentReleaseNotificationHandler(t *testing.T) {
 		name          string
 		deployName    string
 		imageTag      string
-		releaseValues string
+		releaseValues engine.Values
 		shouldErr     bool
 	}{
 		{name: "Empty deployment name", deployName: "", imageTag: "0.0.1", shouldErr: true},
@@ -161,12 +161,12 @@ func TestPromoteReleaseHandler(t *testing.T) {
 		name          string
 		deployName    string
 		namespace     string
-		releaseValues string
+		releaseValues engine.Values
 		shouldErr     bool
 	}{
 		{name: "Empty deployment name", deployName: "", namespace: "int", shouldErr: true},
 		{name: "Empty namespace", deployName: "test", namespace: "", shouldErr: true},
-		{name: "Invalid release values", deployName: "test", namespace: "int", releaseValues: "abc", shouldErr: true},
+		{name: "Invalid release values", deployName: "test", namespace: "int", releaseValues: engine.Values{"": "abc"}, shouldErr: true},
 	}
 	for _, tc := range tt {
 		t.Run(tc.name, func(t *testing.T) {
@@ -249,3 +249,132 @@ func TestGetDeploymentHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestListDeploymentsHandler(t *testing.T) {
+	tt := []struct {
+		name      string
+		query     string
+		shouldErr bool
+	}{
+		{name: "Default parameters", query: ""},
+		{name: "With status", query: "?with_status=true&sort=last_update&order=desc"},
+		{name: "Invalid limit", query: "?limit=abc", shouldErr: true},
+		{name: "Limit too high", query: "?limit=1000", shouldErr: true},
+		{name: "Invalid sort field", query: "?sort=abc", shouldErr: true},
+		{name: "Invalid with_status", query: "?with_status=abc", shouldErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", fmt.Sprintf("/api/v1/deployments%s", tc.query), nil)
+			if err != nil {
+				t.Fatalf("could not create request: %v", err)
+			}
+			rec := httptest.NewRecorder()
+			testhandler.ListDeployments(rec, req)
+
+			res := rec.Result()
+			defer res.Body.Close()
+
+			_, err = ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("could not read response: %v", err)
+			}
+
+			if tc.shouldErr {
+				if res.StatusCode != http.StatusBadRequest {
+					t.Errorf("expected status Bad Request; got %v", res.StatusCode)
+				}
+				return
+			}
+
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("expected status OK; got %v", res.Status)
+			}
+		})
+	}
+}
+
+func TestGetEnvironmentsHandler(t *testing.T) {
+	tt := []struct {
+		name        string
+		query       string
+		contentType string
+		shouldErr   bool
+	}{
+		{name: "JSON by default", query: "", contentType: mimeTypeJSON},
+		{name: "CSV", query: "?format=csv", contentType: mimeTypeCSV},
+		{name: "Unsupported format", query: "?format=xml", shouldErr: true},
+		{name: "Invalid offset", query: "?offset=abc", shouldErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", fmt.Sprintf("/api/v1/environments%s", tc.query), nil)
+			if err != nil {
+				t.Fatalf("could not create request: %v", err)
+			}
+			rec := httptest.NewRecorder()
+			testhandler.GetEnvironments(rec, req)
+
+			res := rec.Result()
+			defer res.Body.Close()
+
+			_, err = ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatalf("could not read response: %v", err)
+			}
+
+			if tc.shouldErr {
+				if res.StatusCode != http.StatusBadRequest {
+					t.Errorf("expected status Bad Request; got %v", res.StatusCode)
+				}
+				return
+			}
+
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("expected status OK; got %v", res.Status)
+			}
+			if tc.contentType == mimeTypeCSV && res.Header.Get("Content-Type") != mimeTypeCSV {
+				t.Errorf("expected content type %s; got %s", tc.contentType, res.Header.Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestValidatePipelineHandler(t *testing.T) {
+	tt := []struct {
+		name    string
+		content string
+		valid   bool
+	}{
+		{name: "valid pipeline", content: "version: 2\npipeline:\n  steps:\n    - step: 1\n      namespace: int\n", valid: true},
+		{name: "duplicate step number", content: "pipeline:\n  steps:\n    - step: 1\n      namespace: int\n    - step: 1\n      namespace: prod\n", valid: false},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			bodyMarshaled, _ := json.Marshal(ValidatePipelineRequest{Content: tc.content})
+			req, err := http.NewRequest("POST", "localhost:8080/api/v1/pipelines/validate", bytes.NewReader(bodyMarshaled))
+			if err != nil {
+				t.Fatalf("could not create request: %v", err)
+			}
+			rec := httptest.NewRecorder()
+			testhandler.ValidatePipelineHandler(rec, req)
+
+			res := rec.Result()
+			defer res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("expected status OK; got %v", res.Status)
+			}
+			var respBody ValidatePipelineResponse
+			if err = json.NewDecoder(res.Body).Decode(&respBody); err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+			if respBody.Valid != tc.valid {
+				t.Fatalf("expected valid to be %v; got %+v", tc.valid, respBody)
+			}
+			if !tc.valid && len(respBody.Errors) == 0 {
+				t.Fatalf("expected errors to be reported")
+			}
+		})
+	}
+}
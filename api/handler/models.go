@@ -1,6 +1,10 @@
 package handler
 
-import "github.com/vgheri/gennaker/engine"
+import (
+	"time"
+
+	"github.com/vgheri/gennaker/engine"
+)
 
 // CreateDeploymentRequest POST /api/v1/deployment
 // CreateDeployment endpoint
@@ -15,39 +19,232 @@ type CreateDeploymentResponse struct {
 	ID int `json:"id"` // TODO: remove as it's useless
 }
 
+// UpdateDeploymentRequest PUT /api/v1/deployment/{name}
+// UpdateDeployment endpoint
+type UpdateDeploymentRequest struct {
+	ChartVersion  string `json:"chart_version"`
+	RepositoryURL string `json:"repository_url"`
+}
+
+type UpdateDeploymentResponse struct {
+	Diff *engine.PipelineDiff `json:"diff"`
+}
+
+// ValidatePipelineRequest POST /api/v1/pipelines/validate
+// Content is the content of a gennaker.yml file
+type ValidatePipelineRequest struct {
+	Content string `json:"content"`
+}
+
+type ValidatePipelineResponse struct {
+	Valid    bool                    `json:"valid"`
+	Pipeline []*engine.PipelineStep  `json:"pipeline,omitempty"`
+	Errors   []*engine.PipelineError `json:"errors,omitempty"`
+}
+
+// DeleteDeploymentResponse DELETE /api/v1/deployment/{name}
+type DeleteDeploymentResponse struct {
+	Reports []string `json:"reports"`
+}
+
 // NewDeploymentReleaseNotificationRequest POST /api/v1/deployment/release
 // NewRelease endpoint
 type NewDeploymentReleaseNotificationRequest struct {
-	DeploymentName string `json:"deployment_name"`
-	ImageTag       string `json:"image_tag"`
-	ReleaseValues  string `json:"release_values"` // --set parameters to helm install/upgrade
+	DeploymentName string              `json:"deployment_name"`
+	ImageTag       string              `json:"image_tag"`
+	ImageTags      map[string]string   `json:"image_tags"`     // image tag of each component, overrides image_tag
+	ReleaseValues  engine.Values       `json:"release_values"` // values object, or YAML document as a string
+	SecretValues   engine.SecretValues `json:"secret_values"`  // encrypted when stored, redacted in responses
 }
 
 type NewDeploymentReleaseNotificationResponse struct {
-	Reports []string `json:"reports"`
+	ID        int                       `json:"id"`
+	Status    engine.NotificationStatus `json:"status"`
+	Duplicate bool                      `json:"duplicate"`
+	Reports   []string                  `json:"reports"`
 }
 
 // PromoteReleaseRequest POST /api/v1/deployment/{name}/release/promote
 type PromoteReleaseRequest struct {
-	FromNamespace string `json:"from_namespace"`
-	ImageTag      string `json:"image_tag"`
-	ReleaseValues string `json:"release_values"`
+	FromNamespace string              `json:"from_namespace"`
+	ImageTag      string              `json:"image_tag"`
+	ReleaseValues engine.Values       `json:"release_values"` // values object, or YAML document as a string
+	SecretValues  engine.SecretValues `json:"secret_values"`  // encrypted when stored, redacted in responses
 }
 
 type PromoteReleaseResponse struct {
 	Reports []string `json:"reports"`
 }
 
+// PromoteToTargetRequest POST /api/v1/deployment/{name}/release/promote/target
+type PromoteToTargetRequest struct {
+	TargetNamespace       string              `json:"target_namespace"`
+	ImageTag              string              `json:"image_tag"`
+	FillIntermediateSteps bool                `json:"fill_intermediate_steps"`
+	ReleaseValues         engine.Values       `json:"release_values"`
+	SecretValues          engine.SecretValues `json:"secret_values"`
+}
+
 // PromoteReleaseRequest POST /api/v1/deployment/{name}/release/promote
 type RollbackReleaseRequest struct {
 	Namespace string `json:"namespace"`
 	Revision  int    `json:"revision"`
+	ReleaseID int    `json:"release_id"`
+	ImageTag  string `json:"image_tag"`
 }
 
 type RollbackReleaseResponse struct {
 	Report string `json:"report"`
 }
 
+// RollbackImageTagRequest POST /api/v1/deployment/{name}/release/rollback/pipeline
+type RollbackImageTagRequest struct {
+	ImageTag string `json:"image_tag"`
+}
+
+type RollbackImageTagResponse struct {
+	Reports []string `json:"reports"`
+}
+
+// ListDeploymentsResponse GET /api/v1/deployments
+type ListDeploymentsResponse struct {
+	Deployments []*engine.Deployment `json:"deployments"`
+}
+
+// GetEnvironmentsResponse GET /api/v1/environments
+type GetEnvironmentsResponse struct {
+	Matrix *engine.EnvironmentMatrix `json:"matrix"`
+}
+
+// GetPipelineGraphResponse GET /api/v1/deployment/{name}/pipeline?format=json
+type GetPipelineGraphResponse struct {
+	Graph *engine.PipelineGraph `json:"graph"`
+}
+
+// GetReleaseDiagnosticsResponse GET /api/v1/deployment/{name}/releases/{id}/diagnostics
+type GetReleaseDiagnosticsResponse struct {
+	Diagnostics *engine.ReleaseDiagnostics `json:"diagnostics"`
+}
+
 type GetDeploymentResponse struct {
 	Deployment *engine.Deployment
 }
+
+// UpdateValuesRequest PUT /api/v1/deployment/{name}/values
+// and PUT /api/v1/deployment/{name}/namespace/{namespace}/values
+type UpdateValuesRequest struct {
+	Values engine.Values `json:"values"` // values object, or YAML document as a string
+}
+
+// ValuesLayerResponse GET /api/v1/deployment/{name}/values
+// and GET /api/v1/deployment/{name}/namespace/{namespace}/values
+type ValuesLayerResponse struct {
+	Layer *engine.ValuesLayer `json:"layer"`
+}
+
+// ValuesLayerHistoryResponse GET /api/v1/deployment/{name}/values/history
+// and GET /api/v1/deployment/{name}/namespace/{namespace}/values/history
+type ValuesLayerHistoryResponse struct {
+	Layers []*engine.ValuesLayer `json:"layers"`
+}
+
+// MergedValuesResponse GET /api/v1/deployment/{name}/namespace/{namespace}/values/merged
+type MergedValuesResponse struct {
+	Namespace string        `json:"namespace"`
+	Values    engine.Values `json:"values"`
+}
+
+// VariableSetRequest POST /api/v1/variableset
+// and PUT /api/v1/variableset/{name}
+type VariableSetRequest struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Variables   []*engine.Variable `json:"variables"`
+}
+
+type CreateVariableSetResponse struct {
+	ID int `json:"id"`
+}
+
+// VariableSetResponse GET /api/v1/variableset/{name}
+type VariableSetResponse struct {
+	VariableSet *engine.VariableSet `json:"variable_set"`
+}
+
+// ListVariableSetsResponse GET /api/v1/variablesets
+// and GET /api/v1/deployment/{name}/variablesets
+type ListVariableSetsResponse struct {
+	VariableSets []*engine.VariableSet `json:"variable_sets"`
+}
+
+// SetDeploymentVariableSetsRequest PUT /api/v1/deployment/{name}/variablesets
+type SetDeploymentVariableSetsRequest struct {
+	VariableSets []string `json:"variable_sets"` // names of the variable sets, the last one wins
+}
+
+// ApplicationRequest POST /api/v1/application
+type ApplicationRequest struct {
+	Name        string                          `json:"name"`
+	Deployments []*engine.ApplicationDeployment `json:"deployments"`
+}
+
+type CreateApplicationResponse struct {
+	ID int `json:"id"`
+}
+
+// ApplicationResponse GET /api/v1/application/{name}
+type ApplicationResponse struct {
+	Application *engine.Application `json:"application"`
+}
+
+// ListApplicationsResponse GET /api/v1/applications
+type ListApplicationsResponse struct {
+	Applications []*engine.Application `json:"applications"`
+}
+
+// SnapshotRequest POST /api/v1/application/{name}/snapshot
+type SnapshotRequest struct {
+	Name          string            `json:"name"`
+	ImageTags     map[string]string `json:"image_tags"`     // image tag by deployment name
+	FromNamespace string            `json:"from_namespace"` // image tags of the deployments missing from image_tags
+}
+
+type SnapshotResponse struct {
+	Snapshot *engine.ApplicationSnapshot `json:"snapshot"`
+}
+
+// ListSnapshotsResponse GET /api/v1/application/{name}/snapshots
+type ListSnapshotsResponse struct {
+	Snapshots []*engine.ApplicationSnapshot `json:"snapshots"`
+}
+
+// PromoteSnapshotRequest POST /api/v1/application/{name}/snapshot/{snapshot}/promote
+type PromoteSnapshotRequest struct {
+	TargetNamespace string `json:"target_namespace"`
+}
+
+// ScheduleRequest POST /api/v1/schedules
+// Type is promote or newrelease, with the payload of the matching endpoint.
+// DeploymentName is the deployment to promote. Exactly one of RunAt and Cron must be set
+type ScheduleRequest struct {
+	Type           string                                   `json:"type"`
+	DeploymentName string                                   `json:"deployment_name"`
+	Promote        *PromoteReleaseRequest                   `json:"promote"`
+	NewRelease     *NewDeploymentReleaseNotificationRequest `json:"new_release"`
+	RunAt          *time.Time                               `json:"run_at"` // RFC 3339, ex: 2017-11-03T06:00:00Z
+	Cron           string                                   `json:"cron"`   // 5 fields, in UTC, ex: 0 6 * * 1-5
+}
+
+type CreateScheduleResponse struct {
+	ID int `json:"id"`
+}
+
+// ScheduleResponse GET and DELETE /api/v1/schedule/{id}
+type ScheduleResponse struct {
+	Schedule *engine.Schedule `json:"schedule"`
+}
+
+// ListSchedulesResponse GET /api/v1/schedules
+type ListSchedulesResponse struct {
+	Schedules []*engine.Schedule `json:"schedules"`
+}
@@ -25,6 +25,30 @@ func setupRoutes(handler *handler.Handler) Routes {
 			Pattern:     "/api/v1/deployment",
 			HandlerFunc: handler.CreateDeploymentHandler,
 		},
+		&Route{
+			Name:        "UpdateDeployment",
+			Method:      "PUT",
+			Pattern:     "/api/v1/deployment/{name}",
+			HandlerFunc: handler.UpdateDeploymentHandler,
+		},
+		&Route{
+			Name:        "DeleteDeployment",
+			Method:      "DELETE",
+			Pattern:     "/api/v1/deployment/{name}",
+			HandlerFunc: handler.DeleteDeploymentHandler,
+		},
+		&Route{
+			Name:        "ResyncDeployment",
+			Method:      "POST",
+			Pattern:     "/api/v1/deployment/{name}/resync",
+			HandlerFunc: handler.ResyncDeploymentHandler,
+		},
+		&Route{
+			Name:        "ValidatePipeline",
+			Method:      "POST",
+			Pattern:     "/api/v1/pipelines/validate",
+			HandlerFunc: handler.ValidatePipelineHandler,
+		},
 		&Route{
 			Name:        "NewReleaseNotification",
 			Method:      "POST",
@@ -37,12 +61,192 @@ func setupRoutes(handler *handler.Handler) Routes {
 			Pattern:     "/api/v1/deployment/{name}/release/promote",
 			HandlerFunc: handler.PromoteReleaseHandler,
 		},
+		&Route{
+			Name:        "PromoteToTarget",
+			Method:      "POST",
+			Pattern:     "/api/v1/deployment/{name}/release/promote/target",
+			HandlerFunc: handler.PromoteToTargetHandler,
+		},
 		&Route{
 			Name:        "RollbackRelease",
 			Method:      "POST",
 			Pattern:     "/api/v1/deployment/{name}/release/rollback",
 			HandlerFunc: handler.RollbackReleaseHandler,
 		},
+		&Route{
+			Name:        "RollbackImageTag",
+			Method:      "POST",
+			Pattern:     "/api/v1/deployment/{name}/release/rollback/pipeline",
+			HandlerFunc: handler.RollbackImageTagHandler,
+		},
+		&Route{
+			Name:        "ListDeployments",
+			Method:      "GET",
+			Pattern:     "/api/v1/deployments",
+			HandlerFunc: handler.ListDeployments,
+		},
+		&Route{
+			Name:        "GetEnvironments",
+			Method:      "GET",
+			Pattern:     "/api/v1/environments",
+			HandlerFunc: handler.GetEnvironments,
+		},
+		&Route{
+			Name:        "GetPipelineGraph",
+			Method:      "GET",
+			Pattern:     "/api/v1/deployment/{name}/pipeline",
+			HandlerFunc: handler.GetPipelineGraph,
+		},
+		&Route{
+			Name:        "GetReleaseDiagnostics",
+			Method:      "GET",
+			Pattern:     "/api/v1/deployment/{name}/releases/{id}/diagnostics",
+			HandlerFunc: handler.GetReleaseDiagnostics,
+		},
+		&Route{
+			Name:        "GetDefaultValues",
+			Method:      "GET",
+			Pattern:     "/api/v1/deployment/{name}/values",
+			HandlerFunc: handler.GetValuesLayer,
+		},
+		&Route{
+			Name:        "UpdateDefaultValues",
+			Method:      "PUT",
+			Pattern:     "/api/v1/deployment/{name}/values",
+			HandlerFunc: handler.UpdateValuesLayer,
+		},
+		&Route{
+			Name:        "GetDefaultValuesHistory",
+			Method:      "GET",
+			Pattern:     "/api/v1/deployment/{name}/values/history",
+			HandlerFunc: handler.GetValuesLayerHistory,
+		},
+		&Route{
+			Name:        "GetNamespaceValues",
+			Method:      "GET",
+			Pattern:     "/api/v1/deployment/{name}/namespace/{namespace}/values",
+			HandlerFunc: handler.GetValuesLayer,
+		},
+		&Route{
+			Name:        "UpdateNamespaceValues",
+			Method:      "PUT",
+			Pattern:     "/api/v1/deployment/{name}/namespace/{namespace}/values",
+			HandlerFunc: handler.UpdateValuesLayer,
+		},
+		&Route{
+			Name:        "GetNamespaceValuesHistory",
+			Method:      "GET",
+			Pattern:     "/api/v1/deployment/{name}/namespace/{namespace}/values/history",
+			HandlerFunc: handler.GetValuesLayerHistory,
+		},
+		&Route{
+			Name:        "GetMergedValues",
+			Method:      "GET",
+			Pattern:     "/api/v1/deployment/{name}/namespace/{namespace}/values/merged",
+			HandlerFunc: handler.GetMergedValues,
+		},
+		&Route{
+			Name:        "GetDeploymentVariableSets",
+			Method:      "GET",
+			Pattern:     "/api/v1/deployment/{name}/variablesets",
+			HandlerFunc: handler.GetDeploymentVariableSets,
+		},
+		&Route{
+			Name:        "SetDeploymentVariableSets",
+			Method:      "PUT",
+			Pattern:     "/api/v1/deployment/{name}/variablesets",
+			HandlerFunc: handler.SetDeploymentVariableSets,
+		},
+		&Route{
+			Name:        "CreateVariableSet",
+			Method:      "POST",
+			Pattern:     "/api/v1/variableset",
+			HandlerFunc: handler.CreateVariableSet,
+		},
+		&Route{
+			Name:        "ListVariableSets",
+			Method:      "GET",
+			Pattern:     "/api/v1/variablesets",
+			HandlerFunc: handler.ListVariableSets,
+		},
+		&Route{
+			Name:        "GetVariableSet",
+			Method:      "GET",
+			Pattern:     "/api/v1/variableset/{name}",
+			HandlerFunc: handler.GetVariableSet,
+		},
+		&Route{
+			Name:        "UpdateVariableSet",
+			Method:      "PUT",
+			Pattern:     "/api/v1/variableset/{name}",
+			HandlerFunc: handler.UpdateVariableSet,
+		},
+		&Route{
+			Name:        "DeleteVariableSet",
+			Method:      "DELETE",
+			Pattern:     "/api/v1/variableset/{name}",
+			HandlerFunc: handler.DeleteVariableSet,
+		},
+		&Route{
+			Name:        "CreateApplication",
+			Method:      "POST",
+			Pattern:     "/api/v1/application",
+			HandlerFunc: handler.CreateApplication,
+		},
+		&Route{
+			Name:        "ListApplications",
+			Method:      "GET",
+			Pattern:     "/api/v1/applications",
+			HandlerFunc: handler.ListApplications,
+		},
+		&Route{
+			Name:        "GetApplication",
+			Method:      "GET",
+			Pattern:     "/api/v1/application/{name}",
+			HandlerFunc: handler.GetApplication,
+		},
+		&Route{
+			Name:        "CreateSnapshot",
+			Method:      "POST",
+			Pattern:     "/api/v1/application/{name}/snapshot",
+			HandlerFunc: handler.CreateSnapshot,
+		},
+		&Route{
+			Name:        "ListSnapshots",
+			Method:      "GET",
+			Pattern:     "/api/v1/application/{name}/snapshots",
+			HandlerFunc: handler.ListSnapshots,
+		},
+		&Route{
+			Name:        "PromoteSnapshot",
+			Method:      "POST",
+			Pattern:     "/api/v1/application/{name}/snapshot/{snapshot}/promote",
+			HandlerFunc: handler.PromoteSnapshot,
+		},
+		&Route{
+			Name:        "CreateSchedule",
+			Method:      "POST",
+			Pattern:     "/api/v1/schedules",
+			HandlerFunc: handler.CreateSchedule,
+		},
+		&Route{
+			Name:        "ListSchedules",
+			Method:      "GET",
+			Pattern:     "/api/v1/schedules",
+			HandlerFunc: handler.ListSchedules,
+		},
+		&Route{
+			Name:        "GetSchedule",
+			Method:      "GET",
+			Pattern:     "/api/v1/schedule/{id}",
+			HandlerFunc: handler.GetSchedule,
+		},
+		&Route{
+			Name:        "CancelSchedule",
+			Method:      "DELETE",
+			Pattern:     "/api/v1/schedule/{id}",
+			HandlerFunc: handler.CancelSchedule,
+		},
 		&Route{
 			Name:        "GetDeployment",
 			Method:      "GET",
@@ -87,7 +87,7 @@ func TestCreateDeploymentRouting(t *testing.T) {
 }
 
 func TestNewDeploymentReleaseNotificationRouting(t *testing.T) {
-	bodyValue := handler.NewDeploymentReleaseNotificationRequest{DeploymentName: "a", ImageTag: "b", ReleaseValues: ""}
+	bodyValue := handler.NewDeploymentReleaseNotificationRequest{DeploymentName: "a", ImageTag: "b", ReleaseValues: nil}
 	bodyMarshaled, _ := json.Marshal(bodyValue)
 	body := bytes.NewReader(bodyMarshaled)
 	res, err := http.Post(fmt.Sprintf("%s/vapi/v1/deployment/newrelease", server.URL), "application/json", body)
@@ -100,7 +100,7 @@ func TestNewDeploymentReleaseNotificationRouting(t *testing.T) {
 }
 
 func TestPromoteReleaseRouting(t *testing.T) {
-	bodyValue := handler.PromoteReleaseRequest{FromNamespace: "b", ReleaseValues: ""}
+	bodyValue := handler.PromoteReleaseRequest{FromNamespace: "b", ReleaseValues: nil}
 	bodyMarshaled, _ := json.Marshal(bodyValue)
 	body := bytes.NewReader(bodyMarshaled)
 	res, err := http.Post(fmt.Sprintf("%s/vapi/v1/deployment/a/release/promote", server.URL), "application/json", body)
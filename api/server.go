@@ -1,15 +1,20 @@
 package api
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/vgheri/gennaker/api/handler"
 	"github.com/vgheri/gennaker/api/route"
 	"github.com/vgheri/gennaker/engine"
 )
 
+// shutdownTimeout is how long the requests in progress are waited for when the server stops
+const shutdownTimeout = 30 * time.Second
+
 type Server struct {
 	deploymentEngine engine.DeploymentEngine
 }
@@ -21,18 +26,30 @@ func New(engine engine.DeploymentEngine) (*Server, error) {
 	return &Server{deploymentEngine: engine}, nil
 }
 
-func (s *Server) Start(port int32) error {
+// Start serves the API until stop is closed, then waits for the requests in progress to be served
+func (s *Server) Start(port int32, stop <-chan struct{}) error {
 	handlers := handler.New(s.deploymentEngine)
 	router := route.NewRouter(handlers)
 	http.Handle("/", accessControl(router))
-	return http.ListenAndServe(fmt.Sprintf(":%d", port), router)
+	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: router}
+	shutdown := make(chan error, 1)
+	go func() {
+		<-stop
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		shutdown <- server.Shutdown(ctx)
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return <-shutdown
 }
 
 func accessControl(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Idempotency-Key")
 
 		if r.Method == "OPTIONS" {
 			return
@@ -22,11 +22,18 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/vgheri/gennaker/api"
 	"github.com/vgheri/gennaker/engine"
+	"github.com/vgheri/gennaker/kube"
 	"github.com/vgheri/gennaker/repository/pg"
+	"github.com/vgheri/gennaker/secret"
 )
 
 // startCmd represents the start command
@@ -42,18 +49,74 @@ var startCmd = &cobra.Command{
 		if err != nil {
 			panic(err)
 		}
-		deploymentEngine := engine.New(repository, chartsDownloadFolder)
+		options := []engine.Option{engine.WithDeduplicationWindow(deduplicationWindow)}
+		key, err := secret.LoadKey(secretKeyFile)
+		if err != nil {
+			panic(err)
+		}
+		if key != nil {
+			cipher, err := secret.NewCipher(key)
+			if err != nil {
+				panic(err)
+			}
+			options = append(options, engine.WithCipher(cipher))
+		}
+		if len(secretsDir) != 0 {
+			provider, err := secret.NewFileProvider(secretsDir)
+			if err != nil {
+				panic(err)
+			}
+			options = append(options, engine.WithSecretProvider(provider))
+		}
+		if useKubernetes || watchRollouts {
+			cluster, err := kube.NewCluster(kubeconfig, kubeContext)
+			if err != nil {
+				panic(err)
+			}
+			options = append(options, engine.WithKubernetes(cluster))
+		}
+		if watchRollouts {
+			options = append(options, engine.WithRolloutWatching())
+		}
+		if commandHooks {
+			options = append(options, engine.WithCommandHooks())
+		}
+		deploymentEngine := engine.New(repository, chartsDownloadFolder, options...)
+		// The server and the scheduler stop on SIGINT or SIGTERM
+		stop := make(chan struct{})
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			close(stop)
+		}()
+		var scheduler sync.WaitGroup
+		if scheduleInterval > 0 {
+			scheduler.Add(1)
+			go func() {
+				defer scheduler.Done()
+				deploymentEngine.RunScheduler(scheduleInterval, stop)
+			}()
+		}
 		server, err := api.New(deploymentEngine)
 		if err != nil {
 			panic(err)
 		}
-		server.Start(HTTPListenPort)
+		if err = server.Start(HTTPListenPort, stop); err != nil {
+			panic(err)
+		}
+		// Jobs left running would only be recovered once their lease expired
+		scheduler.Wait()
 	},
 }
 
 var HTTPListenPort, postgresPort, postgresMaxConnections int32
 var postgresHost, postgresUsername, postgresPassword, postgresDBName string
 var chartsDownloadFolder string
+var deduplicationWindow, scheduleInterval time.Duration
+var secretKeyFile, secretsDir string
+var useKubernetes, watchRollouts, commandHooks bool
+var kubeconfig, kubeContext string
 
 func init() {
 	RootCmd.AddCommand(startCmd)
@@ -74,4 +137,13 @@ func init() {
 	startCmd.Flags().StringVar(&postgresUsername, "pg-username", "postgres", "Postgres username")
 	startCmd.Flags().StringVar(&postgresPassword, "pg-password", "password", "Postgres password")
 	startCmd.Flags().StringVarP(&chartsDownloadFolder, "save-dir", "d", "localhost", "Path used to download charts. Must be absolute")
+	startCmd.Flags().StringVar(&secretKeyFile, "secret-key-file", "", fmt.Sprintf("File holding the base64 encoded %d bytes key encrypting secret values. Defaults to the %s environment variable", secret.KeySize, secret.KeyEnvVar))
+	startCmd.Flags().StringVar(&secretsDir, "secrets-dir", "", "Directory of the file secret provider, one file per secret")
+	startCmd.Flags().BoolVar(&useKubernetes, "kubernetes", false, "Use the Kubernetes API to provision namespaces and collect diagnostics of failed releases")
+	startCmd.Flags().BoolVar(&watchRollouts, "watch-rollouts", false, "Wait for the workloads of releases to roll out before declaring them deployed. Implies --kubernetes")
+	startCmd.Flags().BoolVar(&commandHooks, "command-hooks", false, "Allow the hooks of gennaker.yml to run commands on this server. Anyone able to publish a chart can then run code here")
+	startCmd.Flags().StringVar(&kubeconfig, "kubeconfig", "", "Kubeconfig file. Defaults to $KUBECONFIG, ~/.kube/config or the service account of the pod")
+	startCmd.Flags().StringVar(&kubeContext, "kube-context", "", "Kubeconfig context. Defaults to the current context")
+	startCmd.Flags().DurationVar(&scheduleInterval, "schedule-interval", engine.DefaultSchedulerInterval, "How often scheduled releases and promotions due are run. 0 disables the scheduler")
+	startCmd.Flags().DurationVar(&deduplicationWindow, "dedup-window", engine.DefaultDeduplicationWindow, "Period during which identical release notifications are ignored. 0 disables it")
 }
@@ -0,0 +1,396 @@
+package engine
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+func (e *engine) ListApplications() ([]*Application, error) {
+	applications, err := e.db.ListApplications()
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot list applications")
+	}
+	return applications, nil
+}
+
+func (e *engine) GetApplication(name string) (*Application, error) {
+	if len(strings.TrimSpace(name)) == 0 {
+		return nil, errors.New("Application name cannot be empty")
+	}
+	return e.db.GetApplication(name)
+}
+
+// CreateApplication groups existing deployments, which must share the same pipeline shape
+func (e *engine) CreateApplication(application *Application) (int, error) {
+	if application == nil {
+		return 0, ErrBadRequest
+	}
+	if err := application.valid(); err != nil {
+		return 0, errors.Wrap(err, "Application is invalid")
+	}
+	if _, err := deploymentOrder(application); err != nil {
+		return 0, errors.Wrap(err, "Application is invalid")
+	}
+	deployments, err := e.applicationDeployments(application)
+	if err != nil {
+		return 0, err
+	}
+	if err = samePipelineShape(application, deployments); err != nil {
+		return 0, errors.Wrap(err, "Application is invalid")
+	}
+	if err = e.db.CreateApplication(application); err != nil {
+		return 0, errors.Wrap(err, "Cannot save application")
+	}
+	return application.ID, nil
+}
+
+// CreateSnapshot records the image tag of each deployment of an application
+func (e *engine) CreateSnapshot(request *SnapshotRequest) (*ApplicationSnapshot, error) {
+	if request == nil {
+		return nil, ErrBadRequest
+	}
+	if err := request.valid(); err != nil {
+		return nil, errors.Wrap(err, "Snapshot request is invalid")
+	}
+	application, err := e.db.GetApplication(request.ApplicationName)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get application")
+	}
+	deployments, err := e.applicationDeployments(application)
+	if err != nil {
+		return nil, err
+	}
+	imageTags, err := snapshotImageTags(application, deployments, request.ImageTags, request.FromNamespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot snapshot application")
+	}
+	snapshot := &ApplicationSnapshot{
+		ApplicationID: application.ID,
+		Name:          request.Name,
+		ImageTags:     imageTags,
+	}
+	if err = e.db.CreateSnapshot(snapshot); err != nil {
+		return nil, errors.Wrap(err, "Cannot save snapshot")
+	}
+	return snapshot, nil
+}
+
+// ListSnapshots returns the snapshots of an application, from the most recent to the oldest
+func (e *engine) ListSnapshots(applicationName string) ([]*ApplicationSnapshot, error) {
+	application, err := e.GetApplication(applicationName)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get application")
+	}
+	return e.db.GetSnapshots(application.ID)
+}
+
+// bundleRelease is the release of a deployment of an application to the target namespace of a promotion
+type bundleRelease struct {
+	deployment *Deployment
+	plan       *releasePlan
+	// previous is the last release of the target namespace before the promotion, nil if none
+	previous *Release
+}
+
+// PromoteSnapshot releases the image tag of each deployment of the snapshot to the target namespace,
+// dependencies first, each one once the previous one is deployed. Every deployment must have its image tag
+// deployed upstream of the target. When a deployment fails, the deployments already released, and the failing one
+// if it was released, are rolled back to their previous release, in reverse order.
+// Deployments are released in the background once every release is planned, their releases and rollbacks
+// being recorded as release events
+func (e *engine) PromoteSnapshot(request *PromoteSnapshotRequest) ([]string, error) {
+	if request == nil {
+		return nil, ErrBadRequest
+	}
+	if err := request.valid(); err != nil {
+		return nil, errors.Wrap(err, "Promote request is invalid")
+	}
+	snapshot, bundle, err := e.planSnapshot(request)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if reports, err := e.releaseBundle(snapshot, bundle, request.TargetNamespace); err != nil {
+			log.Printf("%v: %s", err, strings.Join(reports, "; "))
+		}
+	}()
+	names := make([]string, 0, len(bundle))
+	for _, br := range bundle {
+		names = append(names, br.deployment.Name)
+	}
+	return []string{fmt.Sprintf("Started promoting snapshot %s to namespace %s, releasing %s one after the other, "+
+		"their releases are recorded as release events", snapshot.Name, request.TargetNamespace, strings.Join(names, ", "))}, nil
+}
+
+// planSnapshot plans the release of every deployment of the snapshot to the target namespace, dependencies first
+func (e *engine) planSnapshot(request *PromoteSnapshotRequest) (*ApplicationSnapshot, []*bundleRelease, error) {
+	application, err := e.db.GetApplication(request.ApplicationName)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Cannot get application")
+	}
+	snapshot, err := e.getSnapshot(application, request.SnapshotName)
+	if err != nil {
+		return nil, nil, err
+	}
+	order, err := deploymentOrder(application)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Cannot promote")
+	}
+	deployments, err := e.applicationDeployments(application)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Every release is planned before anything is released.
+	// Dependencies planned first count as released for the deployments depending on them
+	bundle := make([]*bundleRelease, 0, len(order))
+	pending := make(map[string]string)
+	for _, name := range order {
+		d := deployments[name]
+		imageTag, found := snapshot.ImageTags[name]
+		if !found {
+			return nil, nil, errors.Errorf("Cannot promote: snapshot %s has no image tag for deployment %s", snapshot.Name, name)
+		}
+		steps, source, err := promotionPath(d, request.TargetNamespace, imageTag, false)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, fmt.Sprintf("Cannot promote deployment %s", name))
+		}
+		imageTags, err := resolveImageTags(d, source.ImageTag, source.ImageTags)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, fmt.Sprintf("Cannot promote deployment %s", name))
+		}
+		plan, err := e.planRelease(d, steps, &releaseInput{imageTag: source.ImageTag, imageTags: imageTags, pending: pending})
+		if err != nil {
+			return nil, nil, errors.Wrap(err, fmt.Sprintf("Cannot promote deployment %s", name))
+		}
+		// The release must be deployed, its rollout and health checks included, before the next deployment
+		plan.awaited = len(plan.steps)
+		bundle = append(bundle, &bundleRelease{
+			deployment: d,
+			plan:       plan,
+			previous:   getLastReleaseForNamespace(request.TargetNamespace, d),
+		})
+		pending[name] = source.ImageTag
+	}
+
+	return snapshot, bundle, nil
+}
+
+// releaseBundle releases the deployments of the bundle one after the other,
+// rolling back those already released when one fails
+func (e *engine) releaseBundle(snapshot *ApplicationSnapshot, bundle []*bundleRelease, namespace string) ([]string, error) {
+	var reports []string
+	for i, br := range bundle {
+		deploymentReports, err := e.runPlan(br.deployment, br.plan)
+		reports = append(reports, deploymentReports...)
+		if err != nil {
+			reports = append(reports, e.rollbackBundle(bundle[:i+1], namespace)...)
+			return reports, errors.Wrap(err, fmt.Sprintf("Snapshot %s rolled back, deployment %s failed",
+				snapshot.Name, br.deployment.Name))
+		}
+	}
+	return reports, nil
+}
+
+// rollbackBundle rolls back the released deployments of a bundle to their previous release, last released first.
+// Deployments without a release recorded by the promotion are left as they are
+func (e *engine) rollbackBundle(released []*bundleRelease, namespace string) []string {
+	var reports []string
+	for i := len(released) - 1; i >= 0; i-- {
+		br := released[i]
+		d, err := e.db.GetDeployment(br.deployment.Name)
+		if err != nil {
+			reports = append(reports, fmt.Sprintf("Cannot rollback deployment %s: %v", br.deployment.Name, err))
+			continue
+		}
+		last := getLastReleaseForNamespace(namespace, d)
+		if last == nil || (br.previous != nil && last.ID == br.previous.ID) {
+			reports = append(reports, fmt.Sprintf("Deployment %s was not released in namespace %s, nothing to roll back",
+				br.deployment.Name, namespace))
+			continue
+		}
+		if br.previous == nil {
+			reports = append(reports, fmt.Sprintf("Cannot rollback deployment %s: no previous release in namespace %s",
+				br.deployment.Name, namespace))
+			continue
+		}
+		report, err := e.rollbackNamespace(d, namespace, br.previous, last.Revision+1)
+		if err != nil {
+			reports = append(reports, fmt.Sprintf("Cannot rollback deployment %s: %v", br.deployment.Name, err))
+			continue
+		}
+		reports = append(reports, fmt.Sprintf("Deployment %s rolled back to image tag %s in namespace %s",
+			br.deployment.Name, br.previous.ImageTag, namespace), report)
+	}
+	return reports
+}
+
+func (e *engine) getSnapshot(application *Application, name string) (*ApplicationSnapshot, error) {
+	snapshots, err := e.db.GetSnapshots(application.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get snapshots")
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Name == name {
+			return snapshot, nil
+		}
+	}
+	return nil, errors.Wrap(ErrResourceNotFound, fmt.Sprintf("Snapshot %s of application %s", name, application.Name))
+}
+
+// applicationDeployments returns the deployments of an application, by name
+func (e *engine) applicationDeployments(application *Application) (map[string]*Deployment, error) {
+	deployments := make(map[string]*Deployment)
+	for _, ad := range application.Deployments {
+		d, err := e.db.GetDeployment(ad.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Cannot get deployment %s", ad.Name))
+		}
+		if d.archived() {
+			return nil, errors.Wrap(ErrDeploymentArchived, ad.Name)
+		}
+		deployments[ad.Name] = d
+	}
+	return deployments, nil
+}
+
+func (a *Application) valid() error {
+	if len(strings.TrimSpace(a.Name)) == 0 {
+		return errors.New("Application name cannot be empty")
+	}
+	if len(a.Deployments) == 0 {
+		return errors.New("Application must group at least one deployment")
+	}
+	names := make(map[string]bool)
+	for _, ad := range a.Deployments {
+		if ad == nil || len(strings.TrimSpace(ad.Name)) == 0 {
+			return errors.New("Deployment name cannot be empty")
+		}
+		if names[ad.Name] {
+			return errors.Errorf("Deployment %s is listed twice", ad.Name)
+		}
+		names[ad.Name] = true
+	}
+	return nil
+}
+
+// deploymentOrder returns the names of the deployments of the application, each one after those it depends on.
+// Deployments which do not depend on each other keep the order they are declared in
+func deploymentOrder(application *Application) ([]string, error) {
+	declared := make(map[string]bool)
+	for _, ad := range application.Deployments {
+		declared[ad.Name] = true
+	}
+	for _, ad := range application.Deployments {
+		for _, dependency := range ad.DependsOn {
+			if !declared[dependency] {
+				return nil, errors.Errorf("Deployment %s depends on %s, which is not part of the application",
+					ad.Name, dependency)
+			}
+			if dependency == ad.Name {
+				return nil, errors.Errorf("Deployment %s depends on itself", ad.Name)
+			}
+		}
+	}
+	var order []string
+	released := make(map[string]bool)
+	for len(order) < len(application.Deployments) {
+		progress := false
+		for _, ad := range application.Deployments {
+			if released[ad.Name] {
+				continue
+			}
+			ready := true
+			for _, dependency := range ad.DependsOn {
+				ready = ready && released[dependency]
+			}
+			// The first deployment declared whose dependencies are released comes next
+			if ready {
+				order = append(order, ad.Name)
+				released[ad.Name] = true
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			var cycle []string
+			for _, ad := range application.Deployments {
+				if !released[ad.Name] {
+					cycle = append(cycle, ad.Name)
+				}
+			}
+			return nil, errors.Errorf("Dependencies between deployments %s form a cycle", strings.Join(cycle, ", "))
+		}
+	}
+	return order, nil
+}
+
+// samePipelineShape checks the deployments of the application have pipelines
+// with the same namespaces, each one following the same namespaces
+func samePipelineShape(application *Application, deployments map[string]*Deployment) error {
+	first := application.Deployments[0].Name
+	shape := pipelineShape(deployments[first])
+	for _, ad := range application.Deployments[1:] {
+		if s := pipelineShape(deployments[ad.Name]); s != shape {
+			return errors.Errorf("Pipeline of deployment %s (%s) does not have the shape of the pipeline of %s (%s)",
+				ad.Name, s, first, shape)
+		}
+	}
+	return nil
+}
+
+// pipelineShape describes the pipeline of a deployment by its namespaces and the namespaces they follow,
+// ex: "int, ppd<-int, prod<-ppd"
+func pipelineShape(d *Deployment) string {
+	steps := flattenPipeline(d.Pipeline)
+	namespaces := make(map[int]string)
+	for _, step := range steps {
+		namespaces[step.StepNumber] = step.TargetNamespace
+	}
+	var shape []string
+	for _, step := range steps {
+		var parents []string
+		for _, parent := range step.parents() {
+			parents = append(parents, namespaces[parent])
+		}
+		sort.Strings(parents)
+		description := step.TargetNamespace
+		if len(parents) != 0 {
+			description += "<-" + strings.Join(parents, "+")
+		}
+		shape = append(shape, description)
+	}
+	sort.Strings(shape)
+	return strings.Join(shape, ", ")
+}
+
+// snapshotImageTags returns the image tag of each deployment of the application, taken from imageTags,
+// or from the last successful release in fromNamespace when not set there
+func snapshotImageTags(application *Application, deployments map[string]*Deployment,
+	imageTags map[string]string, fromNamespace string) (map[string]string, error) {
+	for name := range imageTags {
+		if _, found := deployments[name]; !found {
+			return nil, errors.Errorf("Deployment %s is not part of application %s", name, application.Name)
+		}
+	}
+	tags := make(map[string]string)
+	for _, ad := range application.Deployments {
+		tag := imageTags[ad.Name]
+		if len(strings.TrimSpace(tag)) == 0 && len(strings.TrimSpace(fromNamespace)) != 0 {
+			last := getLastReleaseForNamespace(fromNamespace, deployments[ad.Name])
+			if last == nil || last.Status != Deployed {
+				return nil, errors.Errorf("Deployment %s has no successful release in namespace %s",
+					ad.Name, fromNamespace)
+			}
+			tag = last.ImageTag
+		}
+		if len(strings.TrimSpace(tag)) == 0 {
+			return nil, errors.Errorf("Missing image tag for deployment %s", ad.Name)
+		}
+		tags[ad.Name] = tag
+	}
+	return tags, nil
+}
@@ -0,0 +1,253 @@
+package engine
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// applicationRepository serves an application and its deployments, recording their releases
+type applicationRepository struct {
+	fakeRepository
+	mutex       sync.Mutex
+	application *Application
+	snapshot    *ApplicationSnapshot
+	deployments map[string]*Deployment
+	releases    int
+}
+
+func (r *applicationRepository) GetApplication(name string) (*Application, error) {
+	return r.application, nil
+}
+
+func (r *applicationRepository) ListApplications() ([]*Application, error) {
+	return []*Application{r.application}, nil
+}
+
+func (r *applicationRepository) GetSnapshots(applicationID int) ([]*ApplicationSnapshot, error) {
+	return []*ApplicationSnapshot{r.snapshot}, nil
+}
+
+func (r *applicationRepository) GetDeployment(name string) (*Deployment, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	d, found := r.deployments[name]
+	if !found {
+		return nil, ErrResourceNotFound
+	}
+	copied := *d
+	copied.Releases = append([]*Release{}, d.Releases...)
+	return &copied, nil
+}
+
+// CreateRelease records the release as the most recent one of its deployment
+func (r *applicationRepository) CreateRelease(release *Release) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.releases++
+	release.ID = 100 + r.releases
+	for _, d := range r.deployments {
+		if d.ID == release.DeploymentID {
+			d.Releases = append([]*Release{release}, d.Releases...)
+		}
+	}
+	return release.ID, nil
+}
+
+func (r *applicationRepository) lastRelease(deployment, namespace string) *Release {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return getLastReleaseForNamespace(namespace, r.deployments[deployment])
+}
+
+func Test_deploymentOrder(t *testing.T) {
+	application := &Application{Name: "shop", Deployments: []*ApplicationDeployment{
+		{Name: "front", DependsOn: []string{"api"}},
+		{Name: "api", DependsOn: []string{"db", "cache"}},
+		{Name: "cache"},
+		{Name: "db"},
+		{Name: "worker", DependsOn: []string{"db"}},
+	}}
+	order, err := deploymentOrder(application)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if strings.Join(order, ",") != "cache,db,api,front,worker" {
+		t.Fatalf("Expected dependencies first, got %v", order)
+	}
+
+	application.Deployments[2].DependsOn = []string{"front"}
+	if _, err = deploymentOrder(application); err == nil || !strings.Contains(err.Error(), "front, api, cache") {
+		t.Fatalf("Expected a cycle, got %v", err)
+	}
+	application.Deployments[2].DependsOn = []string{"queue"}
+	if _, err = deploymentOrder(application); err == nil {
+		t.Fatalf("Expected unknown dependency to be refused")
+	}
+}
+
+func Test_samePipelineShape(t *testing.T) {
+	api := promotionDeployment()
+	front := promotionDeployment()
+	application := &Application{Name: "shop", Deployments: []*ApplicationDeployment{{Name: "api"}, {Name: "front"}}}
+	deployments := map[string]*Deployment{"api": api, "front": front}
+	if err := samePipelineShape(application, deployments); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if shape := pipelineShape(api); shape != "int, load<-int, ppd<-qa, prod<-load+ppd, qa<-int" {
+		t.Fatalf("Unexpected shape %s", shape)
+	}
+	front.Pipeline[0].NextSteps = front.Pipeline[0].NextSteps[:1]
+	if err := samePipelineShape(application, deployments); err == nil {
+		t.Fatalf("Expected different pipelines to be refused")
+	}
+}
+
+func Test_snapshotImageTags(t *testing.T) {
+	application := &Application{Name: "shop", Deployments: []*ApplicationDeployment{{Name: "api"}, {Name: "front"}}}
+	deployments := map[string]*Deployment{
+		"api": {Name: "api", Releases: []*Release{
+			{Namespace: "ppd", ImageTag: "2.1.0", Status: Deployed},
+		}},
+		"front": {Name: "front", Releases: []*Release{
+			{Namespace: "ppd", ImageTag: "1.4.0", Status: Failed},
+			{Namespace: "ppd", ImageTag: "1.3.0", Status: Deployed},
+		}},
+	}
+	tags, err := snapshotImageTags(application, deployments, map[string]string{"front": "1.3.0"}, "ppd")
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	if tags["api"] != "2.1.0" || tags["front"] != "1.3.0" {
+		t.Fatalf("Unexpected image tags %v", tags)
+	}
+	if _, err = snapshotImageTags(application, deployments, nil, "ppd"); err == nil {
+		t.Fatalf("Expected failed release of front to be refused")
+	}
+	if _, err = snapshotImageTags(application, deployments, map[string]string{"api": "2.1.0"}, ""); err == nil {
+		t.Fatalf("Expected missing image tag of front to be refused")
+	}
+	if _, err = snapshotImageTags(application, deployments, map[string]string{"db": "9.6"}, "ppd"); err == nil {
+		t.Fatalf("Expected unknown deployment to be refused")
+	}
+}
+
+// snapshotRepository serves the shop application, whose v2 snapshot promotes db, api and front to ppd.
+// api was never released to ppd
+func snapshotRepository() *applicationRepository {
+	deployments := map[string]*Deployment{}
+	for i, name := range []string{"db", "api", "front"} {
+		d := promotionDeployment()
+		d.ID, d.Name, d.ChartName = i+1, name, "chart"
+		d.Releases = []*Release{
+			{ID: 10 * (i + 1), Namespace: "qa", ImageTag: "1.1.0", Status: Deployed},
+			{ID: 10*(i+1) + 1, Namespace: "int", ImageTag: "1.1.0", Status: Deployed},
+		}
+		deployments[name] = d
+	}
+	deployments["db"].Releases = append([]*Release{
+		{ID: 12, Name: "db-ppd", Namespace: "ppd", ImageTag: "1.0.0", Status: Deployed, Revision: 2}}, deployments["db"].Releases...)
+	deployments["front"].Releases = append([]*Release{
+		{ID: 32, Name: "front-ppd", Namespace: "ppd", ImageTag: "1.0.0", Status: Deployed, Revision: 5}}, deployments["front"].Releases...)
+	return &applicationRepository{
+		application: &Application{ID: 1, Name: "shop", Deployments: []*ApplicationDeployment{
+			{Name: "front", DependsOn: []string{"api"}}, {Name: "api", DependsOn: []string{"db"}}, {Name: "db"},
+		}},
+		snapshot:    &ApplicationSnapshot{Name: "v2", ImageTags: map[string]string{"db": "1.1.0", "api": "1.1.0", "front": "1.1.0"}},
+		deployments: deployments,
+	}
+}
+
+// waitRollback waits for the rollback of a deployment to image tag 1.0.0 in ppd to be recorded
+func waitRollback(t *testing.T, db *applicationRepository, deployment string) *Release {
+	deadline := time.Now().Add(5 * time.Second)
+	for last := db.lastRelease(deployment, "ppd"); ; last = db.lastRelease(deployment, "ppd") {
+		if last.ImageTag == "1.0.0" && last.ID != 12 {
+			return last
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected rollback of %s to be recorded, got %+v", deployment, last)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func Test_PromoteSnapshotRollback(t *testing.T) {
+	db := snapshotRepository()
+	h := &fakeHelm{fail: func(releaseName, values string) bool { return releaseName == "front-ppd" }}
+	e := &engine{db: db, helm: h}
+	request := &PromoteSnapshotRequest{ApplicationName: "shop", SnapshotName: "v2", TargetNamespace: "ppd"}
+
+	snapshot, bundle, err := e.planSnapshot(request)
+	if err != nil {
+		t.Fatalf("Expected promotion to be planned, got %v", err)
+	}
+	reports, err := e.releaseBundle(snapshot, bundle, request.TargetNamespace)
+	if err == nil || !strings.Contains(err.Error(), "deployment front failed") {
+		t.Fatalf("Expected promotion to fail at front, got %v", err)
+	}
+	// Each deployment was deployed before the next one was released
+	if last := db.lastRelease("api", "ppd"); last == nil || last.Status != Deployed || last.ImageTag != "1.1.0" {
+		t.Fatalf("Expected api to be deployed before front was released, got %+v", last)
+	}
+	expected := []string{
+		"Deployment front was not released in namespace ppd, nothing to roll back",
+		"Cannot rollback deployment api: no previous release in namespace ppd",
+		"Deployment db rolled back to image tag 1.0.0 in namespace ppd",
+	}
+	if !strings.Contains(strings.Join(reports, "\n"), strings.Join(expected, "\n")) {
+		t.Fatalf("Expected %v, got %v", expected, reports)
+	}
+	h.mutex.Lock()
+	rollbacks := strings.Join(h.rollbacks, ",")
+	h.mutex.Unlock()
+	if rollbacks != "db-ppd/2" {
+		t.Fatalf("Expected db only to be rolled back, got %s", rollbacks)
+	}
+
+	// The rollback follows the release recorded by the promotion
+	if last := waitRollback(t, db, "db"); last.Revision != 4 || last.Status != Deployed {
+		t.Fatalf("Expected rollback to be revision 4, got %+v", last)
+	}
+	// The failure of front is recorded as the step its release stopped at
+	db.mutex.Lock()
+	stopped := db.deployments["front"].Releases[0]
+	db.mutex.Unlock()
+	if stopped.Stage == nil || stopped.Stage.Name != StoppedStage || stopped.Namespace != "ppd" {
+		t.Fatalf("Expected the release of front to be recorded as stopped, got %+v", stopped)
+	}
+}
+
+func Test_PromoteSnapshotInBackground(t *testing.T) {
+	db := snapshotRepository()
+	h := &fakeHelm{fail: func(releaseName, values string) bool { return releaseName == "front-ppd" }}
+	e := &engine{db: db, helm: h}
+
+	reports, err := e.PromoteSnapshot(&PromoteSnapshotRequest{ApplicationName: "shop", SnapshotName: "v2", TargetNamespace: "ppd"})
+	if err != nil || len(reports) != 1 || !strings.Contains(reports[0], "releasing db, api, front one after the other") {
+		t.Fatalf("Expected promotion to start, got %v %v", reports, err)
+	}
+	waitRollback(t, db, "db")
+
+	// Nothing is released when the promotion cannot be planned
+	if _, err = e.PromoteSnapshot(&PromoteSnapshotRequest{ApplicationName: "shop", SnapshotName: "v2", TargetNamespace: "dev"}); err == nil {
+		t.Fatalf("Expected promotion to an unknown namespace to be refused")
+	}
+}
+
+func Test_DeleteDeploymentOfApplication(t *testing.T) {
+	db := snapshotRepository()
+	h := &fakeHelm{}
+	e := &engine{db: db, helm: h}
+
+	_, err := e.DeleteDeployment(&DeleteRequest{DeploymentName: "api", HardDelete: true, UninstallReleases: true})
+	if errors.Cause(err) != ErrDeploymentInUse || !strings.Contains(err.Error(), "part of applications shop") {
+		t.Fatalf("Expected deployment of an application not to be deleted, got %v", err)
+	}
+	if _, deletes := h.calls(); deletes != "" {
+		t.Fatalf("Expected no release to be uninstalled, got %s", deletes)
+	}
+}
@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_cronNext(t *testing.T) {
+	// Friday
+	now := time.Date(2017, 11, 3, 10, 17, 42, 0, time.UTC)
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2017, 11, 3, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2017, 11, 3, 10, 30, 0, 0, time.UTC)},
+		{"0 6 * * *", time.Date(2017, 11, 4, 6, 0, 0, 0, time.UTC)},
+		{"0 6 * * 1-5", time.Date(2017, 11, 6, 6, 0, 0, 0, time.UTC)},
+		{"30 2 1,15 * *", time.Date(2017, 11, 15, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2017, 11, 5, 12, 0, 0, 0, time.UTC)},
+		// Either day field matches when both are restricted
+		{"0 0 13 * 6", time.Date(2017, 11, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		c, err := parseCron(tt.expr)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %s: %v", tt.expr, err)
+			continue
+		}
+		if next := c.next(now); !next.Equal(tt.expected) {
+			t.Errorf("Expected %s to run next at %s, got %s", tt.expr, tt.expected, next)
+		}
+	}
+}
+
+func Test_parseCronInvalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *",
+		"5-1 * * * *", "a * * * *", "0 0 30 2 *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("Expected %q to be refused", expr)
+		}
+	}
+}
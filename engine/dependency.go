@@ -0,0 +1,186 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//DeploymentDependency is a deployment which must be released to the namespace of the step first.
+//Its last release there must be deployed successfully, with an image tag at least as new as MinVersion when set
+type DeploymentDependency struct {
+	Deployment string `json:"deployment" yaml:"deployment"`
+	MinVersion string `json:"min_version,omitempty" yaml:"min_version,omitempty"`
+}
+
+// valid checks the dependency declared in gennaker.yml
+func (dep *DeploymentDependency) valid() error {
+	if len(strings.TrimSpace(dep.Deployment)) == 0 {
+		return errors.New("Dependency requires a deployment")
+	}
+	if len(strings.TrimSpace(dep.MinVersion)) != 0 {
+		if _, err := parseVersion(dep.MinVersion); err != nil {
+			return errors.Wrap(err, "Invalid min_version")
+		}
+	}
+	return nil
+}
+
+// dependencies returns the deployments the step depends on
+func (s *PipelineStep) dependencies() []*DeploymentDependency {
+	if s == nil || s.Options == nil {
+		return nil
+	}
+	return s.Options.DependsOn
+}
+
+// checkDependencies refuses to release d to steps whose dependencies are not met.
+// pending holds the image tags released to the same namespaces earlier by the same request, by deployment name
+func (e *engine) checkDependencies(d *Deployment, steps []*PipelineStep, pending map[string]string) error {
+	var unmet []string
+	for _, step := range steps {
+		for _, dep := range step.dependencies() {
+			if dep.Deployment == d.Name {
+				return errors.Errorf("Deployment %s cannot depend on itself in namespace %s", d.Name, step.TargetNamespace)
+			}
+			imageTag, found := pending[dep.Deployment]
+			if !found {
+				dependency, err := e.db.GetDeployment(dep.Deployment)
+				if err != nil {
+					return errors.Wrap(err, fmt.Sprintf("Cannot get dependency %s", dep.Deployment))
+				}
+				last := getLastReleaseForNamespace(step.TargetNamespace, dependency)
+				if last == nil || last.Status != Deployed {
+					unmet = append(unmet, fmt.Sprintf("%s has no successful release in namespace %s",
+						dep.Deployment, step.TargetNamespace))
+					continue
+				}
+				imageTag = last.ImageTag
+			}
+			if err := dependencyMet(dep, imageTag); err != nil {
+				unmet = append(unmet, fmt.Sprintf("%s in namespace %s: %v", dep.Deployment, step.TargetNamespace, err))
+			}
+		}
+	}
+	if len(unmet) != 0 {
+		return errors.Errorf("Dependencies of deployment %s are not met: %s", d.Name, strings.Join(unmet, "; "))
+	}
+	return nil
+}
+
+// dependencyMet checks the image tag released for a dependency satisfies its min version
+func dependencyMet(dep *DeploymentDependency, imageTag string) error {
+	if len(strings.TrimSpace(dep.MinVersion)) == 0 {
+		return nil
+	}
+	compared, err := compareVersions(imageTag, dep.MinVersion)
+	if err != nil {
+		return err
+	}
+	if compared < 0 {
+		return errors.Errorf("image tag %s is older than %s", imageTag, dep.MinVersion)
+	}
+	return nil
+}
+
+// version is an image tag following semantic versioning, ex: v1.4.2-rc.1
+type version struct {
+	numbers    []int
+	prerelease string
+}
+
+// parseVersion reads an image tag made of dot separated numbers, optionally prefixed with v
+// and followed by a pre-release after a dash. Build metadata after a plus is ignored
+func parseVersion(tag string) (*version, error) {
+	s := strings.TrimPrefix(strings.TrimSpace(tag), "v")
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+	v := &version{}
+	if i := strings.Index(s, "-"); i >= 0 {
+		s, v.prerelease = s[:i], s[i+1:]
+	}
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, errors.Errorf("image tag %s is not a version", tag)
+		}
+		v.numbers = append(v.numbers, n)
+	}
+	return v, nil
+}
+
+// compareVersions returns -1, 0 or 1 when a is older than, the same as or newer than b.
+// Missing numbers count as 0, and a pre-release is older than its release
+func compareVersions(a, b string) (int, error) {
+	va, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(va.numbers) || i < len(vb.numbers); i++ {
+		var na, nb int
+		if i < len(va.numbers) {
+			na = va.numbers[i]
+		}
+		if i < len(vb.numbers) {
+			nb = vb.numbers[i]
+		}
+		if na != nb {
+			if na < nb {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	switch {
+	case va.prerelease == vb.prerelease:
+		return 0, nil
+	case len(va.prerelease) == 0:
+		return 1, nil
+	case len(vb.prerelease) == 0:
+		return -1, nil
+	default:
+		return comparePrereleases(va.prerelease, vb.prerelease), nil
+	}
+}
+
+// comparePrereleases compares the dot separated identifiers of pre-releases in turn, as semantic versioning does:
+// numerically when both are numbers, a number being older than text, lexically otherwise.
+// A pre-release is older than the longer ones it starts, ex: rc.2 < rc.10 < rc.10.1
+func comparePrereleases(a, b string) int {
+	ia, ib := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(ia) && i < len(ib); i++ {
+		na, errA := strconv.Atoi(ia[i])
+		nb, errB := strconv.Atoi(ib[i])
+		switch {
+		case errA == nil && errB == nil && na != nb:
+			if na < nb {
+				return -1
+			}
+			return 1
+		case errA == nil && errB != nil:
+			return -1
+		case errA != nil && errB == nil:
+			return 1
+		case errA != nil && ia[i] != ib[i]:
+			if ia[i] < ib[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case len(ia) < len(ib):
+		return -1
+	case len(ia) > len(ib):
+		return 1
+	default:
+		return 0
+	}
+}
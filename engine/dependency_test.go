@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+// dependencyRepository returns the deployments it holds
+type dependencyRepository struct {
+	fakeRepository
+	deployments map[string]*Deployment
+}
+
+func (r dependencyRepository) GetDeployment(name string) (*Deployment, error) {
+	d, found := r.deployments[name]
+	if !found {
+		return nil, ErrResourceNotFound
+	}
+	return d, nil
+}
+
+func Test_compareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.4.0", "1.4.0", 0},
+		{"v1.4", "1.4.0", 0},
+		{"1.10.0", "1.9.3", 1},
+		{"1.4.0-rc.1", "1.4.0", -1},
+		{"1.4.0-rc.2", "1.4.0-rc.1", 1},
+		{"1.4.0-rc.10", "1.4.0-rc.2", 1},
+		{"1.4.0-rc.2", "1.4.0-rc.10", -1},
+		{"1.4.0-rc.1", "1.4.0-rc.1.1", -1},
+		{"1.4.0-beta.11", "1.4.0-rc.1", -1},
+		{"1.4.0-1", "1.4.0-alpha", -1},
+		{"2.0.0+build.7", "2.0.0", 0},
+		{"0.9", "1", -1},
+	}
+	for _, tt := range tests {
+		compared, err := compareVersions(tt.a, tt.b)
+		if err != nil || compared != tt.expected {
+			t.Errorf("Expected %s compared to %s to be %d, got %d (%v)", tt.a, tt.b, tt.expected, compared, err)
+		}
+	}
+	if _, err := compareVersions("latest", "1.0.0"); err == nil {
+		t.Errorf("Expected latest not to be a version")
+	}
+}
+
+func Test_checkDependencies(t *testing.T) {
+	e := &engine{db: dependencyRepository{deployments: map[string]*Deployment{
+		"api": {Name: "api", Releases: []*Release{
+			{Namespace: "prod", ImageTag: "2.3.0", Status: Deployed},
+			{Namespace: "ppd", ImageTag: "2.5.0", Status: Failed},
+			{Namespace: "ppd", ImageTag: "2.4.1", Status: Deployed},
+		}},
+		"auth": {Name: "auth"},
+	}}}
+	d := &Deployment{Name: "front"}
+	step := func(namespace string, deps ...*DeploymentDependency) *PipelineStep {
+		return &PipelineStep{TargetNamespace: namespace, Options: &StepOptions{DependsOn: deps}}
+	}
+	api := &DeploymentDependency{Deployment: "api", MinVersion: "2.4.0"}
+
+	if err := e.checkDependencies(d, []*PipelineStep{step("prod", api)}, nil); err == nil ||
+		!strings.Contains(err.Error(), "api in namespace prod: image tag 2.3.0 is older than 2.4.0") {
+		t.Fatalf("Expected api to be too old in prod, got %v", err)
+	}
+	if err := e.checkDependencies(d, []*PipelineStep{step("prod", api)}, map[string]string{"api": "2.4.0"}); err != nil {
+		t.Fatalf("Expected api released by the same request to count, got %v", err)
+	}
+	if err := e.checkDependencies(d, []*PipelineStep{step("ppd", api)}, nil); err == nil ||
+		!strings.Contains(err.Error(), "api has no successful release in namespace ppd") {
+		t.Fatalf("Expected failed release of api to block the promotion, got %v", err)
+	}
+	if err := e.checkDependencies(d, []*PipelineStep{step("prod", &DeploymentDependency{Deployment: "api"})}, nil); err != nil {
+		t.Fatalf("Expected any successful release to be enough without min version, got %v", err)
+	}
+	if err := e.checkDependencies(d, []*PipelineStep{step("prod", &DeploymentDependency{Deployment: "auth"})}, nil); err == nil {
+		t.Fatalf("Expected dependency without release to block the promotion")
+	}
+	if err := e.checkDependencies(d, []*PipelineStep{step("prod", &DeploymentDependency{Deployment: "db"})}, nil); err == nil {
+		t.Fatalf("Expected unknown dependency to be refused")
+	}
+}
@@ -2,6 +2,8 @@ package engine
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path"
 	"strings"
 
@@ -13,36 +15,30 @@ func (e *engine) CreateDeployment(deployment *Deployment) (int, error) {
 	if err := deployment.valid(); err != nil {
 		return 0, errors.Wrap(err, "Deployment is invalid")
 	}
-	// 1. Get repository name or add it if non existent
-	repoName, err := helm.GetRepositoryName(deployment.RepositoryURL)
-	if err != nil {
-		return 0, errors.Wrap(err, "GetRepositoryName failed")
-	}
-	if repoName == "" {
-		repoName, err = helm.AddRepository(deployment.RepositoryURL)
-		if err != nil {
-			return 0, errors.Wrap(err, "AddRepository failed")
-		}
-	}
-	// 2. Retrieve the chart
+	// 1. Retrieve the chart
 	saveDir := path.Join(e.chartsDir, deployment.Name)
 	fmt.Printf("Save dir: %s\n", saveDir)
-	pathToChart, err := helm.Fetch(repoName, deployment.ChartName, "", saveDir)
+	pathToChart, err := fetchChart(deployment, saveDir)
 	if err != nil {
-		return 0, errors.Wrap(err, "Fetch chart failed")
+		return 0, err
 	}
-	// 3. Retrieve the gennaker.yml file
+	// 2. Retrieve the gennaker.yml file
 	pipeline, err := buildPipeline(pathToChart)
 	if err != nil {
 		return 0, errors.Wrap(err, "Build pipeline failed")
 	}
-	// 4. Populate the db
+	imagePaths, err := buildImagePaths(pathToChart)
+	if err != nil {
+		return 0, errors.Wrap(err, "Build image paths failed")
+	}
+	// 3. Populate the db
 	deployment.Pipeline = pipeline
+	deployment.ImagePaths = imagePaths
 	err = e.db.CreateDeployment(deployment)
 	if err != nil {
 		return 0, err
 	}
-	// 5. Remove the directory with the downloaded chart --> We need the chart so do not remove it
+	// 4. Remove the directory with the downloaded chart --> We need the chart so do not remove it
 	// err = os.RemoveAll(pathToChart)
 	// if err != nil {
 	// 	return 0, errors.Wrap(err, "Cannot remove chart folder")
@@ -50,12 +46,202 @@ func (e *engine) CreateDeployment(deployment *Deployment) (int, error) {
 	return deployment.ID, nil
 }
 
-func (e *engine) ListDeployments(limit, offset int) ([]*Deployment, error) {
-	return nil, nil
+// UpdateDeployment changes the chart version and/or the repository URL of an
+// existing deployment, then resyncs it
+func (e *engine) UpdateDeployment(deployment *Deployment) (*PipelineDiff, error) {
+	if deployment == nil {
+		return nil, ErrInvalidDeployment
+	}
+	d, err := e.GetDeployment(deployment.Name)
+	if err != nil {
+		return nil, err
+	}
+	if d.archived() {
+		return nil, ErrDeploymentArchived
+	}
+	if len(strings.TrimSpace(deployment.ChartVersion)) != 0 {
+		d.ChartVersion = deployment.ChartVersion
+	}
+	if len(strings.TrimSpace(deployment.RepositoryURL)) != 0 {
+		d.RepositoryURL = deployment.RepositoryURL
+	}
+	if err := d.valid(); err != nil {
+		return nil, errors.Wrap(err, "Deployment is invalid")
+	}
+	return e.resync(d)
+}
+
+// Resync fetches the chart of the deployment again and applies any change
+// made to its gennaker.yml to the stored pipeline
+func (e *engine) Resync(name string) (*PipelineDiff, error) {
+	d, err := e.GetDeployment(name)
+	if err != nil {
+		return nil, err
+	}
+	if d.archived() {
+		return nil, ErrDeploymentArchived
+	}
+	return e.resync(d)
+}
+
+func (e *engine) resync(d *Deployment) (*PipelineDiff, error) {
+	// 1. Retrieve the chart in a temporary folder, so that the chart in use
+	// is left untouched if anything goes wrong
+	if err := os.MkdirAll(e.chartsDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "Cannot create charts folder")
+	}
+	tmpDir, err := ioutil.TempDir(e.chartsDir, fmt.Sprintf("%s-resync-", d.Name))
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot create temporary folder")
+	}
+	defer os.RemoveAll(tmpDir)
+	pathToChart, err := fetchChart(d, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	// 2. Rebuild the pipeline and compare it with the stored one
+	pipeline, err := buildPipeline(pathToChart)
+	if err != nil {
+		return nil, errors.Wrap(err, "Build pipeline failed")
+	}
+	imagePaths, err := buildImagePaths(pathToChart)
+	if err != nil {
+		return nil, errors.Wrap(err, "Build image paths failed")
+	}
+	diff := diffPipeline(d.Pipeline, pipeline)
+	// 3. Update the db
+	d.Pipeline = pipeline
+	d.ImagePaths = imagePaths
+	if err = e.db.UpdateDeployment(d, diff); err != nil {
+		return nil, errors.Wrap(err, "Cannot update deployment")
+	}
+	// 4. Replace the chart in use with the new one
+	saveDir := path.Join(e.chartsDir, d.Name)
+	if err = os.MkdirAll(saveDir, 0755); err != nil {
+		return nil, errors.Wrap(err, "Cannot create chart folder")
+	}
+	chartDir := path.Join(saveDir, d.ChartName)
+	if err = os.RemoveAll(chartDir); err != nil {
+		return nil, errors.Wrap(err, "Cannot remove previous chart folder")
+	}
+	if err = os.Rename(pathToChart, chartDir); err != nil {
+		return nil, errors.Wrap(err, "Cannot move chart folder")
+	}
+	return diff, nil
+}
+
+// DeleteDeployment archives or, if requested, permanently deletes a deployment.
+// Returns the helm reports of uninstalled releases, if any
+func (e *engine) DeleteDeployment(request *DeleteRequest) ([]string, error) {
+	if request == nil {
+		return nil, ErrBadRequest
+	}
+	if err := request.valid(); err != nil {
+		return nil, errors.Wrap(err, "Delete request is invalid")
+	}
+	d, err := e.GetDeployment(request.DeploymentName)
+	if err != nil {
+		return nil, err
+	}
+	if d.archived() && !request.HardDelete {
+		return nil, ErrDeploymentArchived
+	}
+	// Applications would be left promoting a deployment which does not exist anymore
+	if request.HardDelete {
+		if err = e.checkNotInApplication(d.Name); err != nil {
+			return nil, err
+		}
+	}
+	var reports []string
+	if request.UninstallReleases {
+		// An archived deployment keeps its helm history, a deleted one does not
+		reports, err = e.uninstallReleases(d, request.HardDelete)
+		if err != nil {
+			return reports, err
+		}
+	}
+	if request.HardDelete {
+		err = e.db.DeleteDeployment(d.ID)
+	} else {
+		err = e.db.ArchiveDeployment(d.ID)
+	}
+	if err != nil {
+		return reports, errors.Wrap(err, "Cannot remove deployment")
+	}
+	if err = os.RemoveAll(path.Join(e.chartsDir, d.Name)); err != nil {
+		return reports, errors.Wrap(err, "Cannot remove chart folder")
+	}
+	return reports, nil
+}
+
+// checkNotInApplication returns ErrDeploymentInUse if an application groups the deployment
+func (e *engine) checkNotInApplication(deploymentName string) error {
+	applications, err := e.db.ListApplications()
+	if err != nil {
+		return errors.Wrap(err, "Cannot list applications")
+	}
+	var names []string
+	for _, application := range applications {
+		for _, ad := range application.Deployments {
+			if ad.Name == deploymentName {
+				names = append(names, application.Name)
+			}
+		}
+	}
+	if len(names) != 0 {
+		return errors.Wrap(ErrDeploymentInUse, fmt.Sprintf("Deployment %s is part of applications %s",
+			deploymentName, strings.Join(names, ", ")))
+	}
+	return nil
+}
+
+// uninstallReleases deletes the helm release of the deployment in every namespace of its pipeline
+func (e *engine) uninstallReleases(d *Deployment, purge bool) ([]string, error) {
+	var reports []string
+	for _, step := range flattenPipeline(d.Pipeline) {
+		release := getLastReleaseForNamespace(step.TargetNamespace, d)
+		if release == nil {
+			continue
+		}
+		report, err := e.helm.Delete(release.Name, purge)
+		if err != nil {
+			return reports, errors.Wrap(err,
+				fmt.Sprintf("Failed at deleting release %s in namespace %s", release.Name, step.TargetNamespace))
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// ListDeployments returns a page of non archived deployments
+func (e *engine) ListDeployments(request *ListDeploymentsRequest) ([]*Deployment, error) {
+	if request == nil {
+		request = &ListDeploymentsRequest{}
+	}
+	if err := request.valid(); err != nil {
+		return nil, errors.Wrap(err, "List request is invalid")
+	}
+	deployments, err := e.db.ListDeployments(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot list deployments")
+	}
+	return deployments, nil
 }
 
-func (e *engine) ListDeploymentsWithStatus(limit, offset int) ([]*Deployment, error) {
-	return nil, nil
+// ListDeploymentsWithStatus returns a page of non archived deployments, each one
+// with the image tag and the status of the last release in every namespace of its pipeline
+func (e *engine) ListDeploymentsWithStatus(request *ListDeploymentsRequest) ([]*Deployment, error) {
+	if request == nil {
+		request = &ListDeploymentsRequest{}
+	}
+	if err := request.valid(); err != nil {
+		return nil, errors.Wrap(err, "List request is invalid")
+	}
+	deployments, err := e.db.ListDeploymentsWithStatus(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot list deployments")
+	}
+	return deployments, nil
 }
 
 func (e *engine) GetDeployment(name string) (*Deployment, error) {
@@ -66,5 +252,32 @@ func (e *engine) GetDeployment(name string) (*Deployment, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "Cannot get deployment")
 	}
+	// Secret values are only exposed redacted, decrypting them reveals their keys
+	for _, r := range d.Releases {
+		if err = e.decryptSecretValues(r); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Cannot read secret values of release %d", r.ID))
+		}
+	}
 	return d, nil
 }
+
+// fetchChart downloads the chart of the deployment into saveDir, adding the
+// chart repository to helm if it is not known yet.
+// Returns the path to the chart
+func fetchChart(deployment *Deployment, saveDir string) (string, error) {
+	repoName, err := helm.GetRepositoryName(deployment.RepositoryURL)
+	if err != nil {
+		return "", errors.Wrap(err, "GetRepositoryName failed")
+	}
+	if repoName == "" {
+		repoName, err = helm.AddRepository(deployment.RepositoryURL)
+		if err != nil {
+			return "", errors.Wrap(err, "AddRepository failed")
+		}
+	}
+	pathToChart, err := helm.Fetch(repoName, deployment.ChartName, deployment.ChartVersion, saveDir)
+	if err != nil {
+		return "", errors.Wrap(err, "Fetch chart failed")
+	}
+	return pathToChart, nil
+}
@@ -5,6 +5,7 @@ import (
 	"path"
 	"strings"
 	"testing"
+	"time"
 )
 
 type fakeRepository struct{}
@@ -12,10 +13,13 @@ type fakeRepository struct{}
 var repository fakeRepository
 var testEngine DeploymentEngine
 
-func (r fakeRepository) ListDeployments(limit, offset int) ([]*Deployment, error) {
+func (r fakeRepository) ListDeployments(request *ListDeploymentsRequest) ([]*Deployment, error) {
 	return []*Deployment{}, nil
 }
-func (r fakeRepository) ListDeploymentsWithStatus(limit, offset int) ([]*Deployment, error) {
+func (r fakeRepository) ListDeploymentsWithStatus(request *ListDeploymentsRequest) ([]*Deployment, error) {
+	return []*Deployment{}, nil
+}
+func (r fakeRepository) ListDeploymentsWithReleases(request *ListDeploymentsRequest) ([]*Deployment, error) {
 	return []*Deployment{}, nil
 }
 func (r fakeRepository) GetDeployment(name string) (*Deployment, error) {
@@ -24,9 +28,106 @@ func (r fakeRepository) GetDeployment(name string) (*Deployment, error) {
 func (r fakeRepository) CreateDeployment(deployment *Deployment) error {
 	return nil
 }
+func (r fakeRepository) UpdateDeployment(deployment *Deployment, diff *PipelineDiff) error {
+	return nil
+}
+func (r fakeRepository) ArchiveDeployment(deploymentID int) error {
+	return nil
+}
+func (r fakeRepository) DeleteDeployment(deploymentID int) error {
+	return nil
+}
 func (r fakeRepository) CreateRelease(release *Release) (int, error) {
 	return 0, nil
 }
+func (r fakeRepository) GetReleaseDiagnostics(deploymentID, releaseID int) (*ReleaseDiagnostics, error) {
+	return nil, ErrResourceNotFound
+}
+
+func (r fakeRepository) GetReleaseNotification(deploymentID int, idempotencyKey, imageTag, valuesHash string, since time.Time) (*NotificationRecord, error) {
+	if idempotencyKey == duplicateIdempotencyKey {
+		return &NotificationRecord{ID: 1, IdempotencyKey: idempotencyKey, Status: NotificationDone,
+			Reports: []string{"deployed"}}, nil
+	}
+	return nil, ErrResourceNotFound
+}
+func (r fakeRepository) CreateReleaseNotification(record *NotificationRecord, since time.Time) error {
+	return nil
+}
+func (r fakeRepository) UpdateReleaseNotification(record *NotificationRecord) error {
+	return nil
+}
+func (r fakeRepository) GetValuesLayers(deploymentID int) ([]*ValuesLayer, error) {
+	return []*ValuesLayer{}, nil
+}
+func (r fakeRepository) GetValuesLayerHistory(deploymentID int, namespace string) ([]*ValuesLayer, error) {
+	return []*ValuesLayer{}, nil
+}
+func (r fakeRepository) CreateValuesLayer(layer *ValuesLayer) error {
+	return nil
+}
+func (r fakeRepository) ListVariableSets() ([]*VariableSet, error) {
+	return []*VariableSet{}, nil
+}
+func (r fakeRepository) GetVariableSet(name string) (*VariableSet, error) {
+	return nil, ErrResourceNotFound
+}
+func (r fakeRepository) CreateVariableSet(set *VariableSet) error {
+	return nil
+}
+func (r fakeRepository) UpdateVariableSet(set *VariableSet) error {
+	return nil
+}
+func (r fakeRepository) DeleteVariableSet(variableSetID int) error {
+	return nil
+}
+func (r fakeRepository) GetDeploymentVariableSets(deploymentID int) ([]*VariableSet, error) {
+	return []*VariableSet{}, nil
+}
+func (r fakeRepository) SetDeploymentVariableSets(deploymentID int, variableSetIDs []int) error {
+	return nil
+}
+func (r fakeRepository) ListApplications() ([]*Application, error) {
+	return []*Application{}, nil
+}
+func (r fakeRepository) GetApplication(name string) (*Application, error) {
+	return nil, ErrResourceNotFound
+}
+func (r fakeRepository) CreateApplication(application *Application) error {
+	return nil
+}
+func (r fakeRepository) CreateSnapshot(snapshot *ApplicationSnapshot) error {
+	return nil
+}
+func (r fakeRepository) GetSnapshots(applicationID int) ([]*ApplicationSnapshot, error) {
+	return []*ApplicationSnapshot{}, nil
+}
+func (r fakeRepository) CreateSchedule(schedule *Schedule) error {
+	return nil
+}
+func (r fakeRepository) ListSchedules() ([]*Schedule, error) {
+	return []*Schedule{}, nil
+}
+func (r fakeRepository) GetSchedule(id int) (*Schedule, error) {
+	return nil, ErrResourceNotFound
+}
+func (r fakeRepository) CancelSchedule(id int) error {
+	return nil
+}
+func (r fakeRepository) ClaimDueSchedules(limit int) ([]*Schedule, error) {
+	return []*Schedule{}, nil
+}
+func (r fakeRepository) RenewScheduleClaim(id int) error {
+	return nil
+}
+func (r fakeRepository) RecoverStaleSchedules(lease time.Duration) (int, error) {
+	return 0, nil
+}
+func (r fakeRepository) UpdateSchedule(schedule *Schedule) error {
+	return nil
+}
+
+const duplicateIdempotencyKey = "duplicate"
 
 func TestMain(m *testing.M) {
 	repository := &fakeRepository{}
@@ -75,6 +176,61 @@ func Test_CreateDeployment(t *testing.T) {
 	}
 }
 
+func Test_UpdateDeployment(t *testing.T) {
+	_, err := testEngine.UpdateDeployment(nil)
+	if err != ErrInvalidDeployment {
+		t.Fatalf("Expected ErrInvalidDeployment updating a nil deployment, got %v", err)
+	}
+	_, err = testEngine.UpdateDeployment(&Deployment{Name: " "})
+	if err == nil || !strings.Contains(err.Error(), "A non empty deployment name is mandatory") {
+		t.Fatalf("Expected invalid deployment name, got %v", err)
+	}
+}
+
+func Test_DeleteDeployment(t *testing.T) {
+	_, err := testEngine.DeleteDeployment(nil)
+	if err != ErrBadRequest {
+		t.Fatalf("Expected ErrBadRequest with a nil request, got %v", err)
+	}
+	_, err = testEngine.DeleteDeployment(&DeleteRequest{DeploymentName: " "})
+	if err == nil || !strings.HasPrefix(err.Error(), "Delete request is invalid") {
+		t.Fatalf("Expected invalid delete request, got %v", err)
+	}
+}
+
+func Test_ListDeployments(t *testing.T) {
+	tt := []struct {
+		testName  string
+		request   *ListDeploymentsRequest
+		shouldErr bool
+	}{
+		{testName: "Nil request uses defaults", request: nil},
+		{testName: "Sort by creation date desc", request: &ListDeploymentsRequest{SortBy: "creation_date", SortOrder: "DESC"}},
+		{testName: "Negative limit", request: &ListDeploymentsRequest{Limit: -1}, shouldErr: true},
+		{testName: "Limit too high", request: &ListDeploymentsRequest{Limit: MaxListLimit + 1}, shouldErr: true},
+		{testName: "Negative offset", request: &ListDeploymentsRequest{Offset: -1}, shouldErr: true},
+		{testName: "Invalid sort field", request: &ListDeploymentsRequest{SortBy: "id; DROP TABLE deployment"}, shouldErr: true},
+		{testName: "Invalid sort order", request: &ListDeploymentsRequest{SortOrder: "up"}, shouldErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.testName, func(t *testing.T) {
+			_, err := testEngine.ListDeployments(tc.request)
+			if tc.shouldErr {
+				if err == nil || !strings.HasPrefix(err.Error(), "List request is invalid") {
+					t.Fatalf("Expected invalid list request, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected test to succeed, got %v", err)
+			}
+			if tc.request != nil && (tc.request.Limit != DefaultListLimit || tc.request.SortOrder != "desc") {
+				t.Fatalf("Expected defaults to be set, got %+v", tc.request)
+			}
+		})
+	}
+}
+
 func Test_GetDeployment(t *testing.T) {
 	invalidDeploymentName := "   "
 	_, err := testEngine.GetDeployment(invalidDeploymentName)
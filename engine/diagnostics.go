@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vgheri/gennaker/kube"
+)
+
+const (
+	// diagnosticsLogLines is the number of lines of logs collected for each failing container
+	diagnosticsLogLines = 100
+	// diagnosticsEvents is the number of most recent events of the namespace collected
+	diagnosticsEvents = 50
+)
+
+//ReleaseDiagnostics is collected from Kubernetes when a release fails: the events of its namespace,
+//its failing pods and the logs of their containers which are not ready.
+//Errors lists what could not be collected
+type ReleaseDiagnostics struct {
+	CollectedAt time.Time        `json:"collected_at"`
+	Events      []*kube.Event    `json:"events"`
+	Pods        []*kube.Pod      `json:"pods"`
+	Logs        []*ContainerLogs `json:"logs"`
+	Errors      []string         `json:"errors,omitempty"`
+}
+
+//ContainerLogs are the last lines of logs of a container.
+//Previous is true when they come from the last terminated instance of a crashing container
+type ContainerLogs struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Previous  bool   `json:"previous"`
+	Lines     string `json:"lines"`
+}
+
+// GetReleaseDiagnostics returns the diagnostics collected when a release of the deployment failed
+func (e *engine) GetReleaseDiagnostics(deploymentName string, releaseID int) (*ReleaseDiagnostics, error) {
+	if len(strings.TrimSpace(deploymentName)) == 0 {
+		return nil, errors.New("A non empty deployment name is mandatory")
+	}
+	d, err := e.db.GetDeployment(deploymentName)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get deployment")
+	}
+	diagnostics, err := e.db.GetReleaseDiagnostics(d.ID, releaseID)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Cannot get diagnostics of release %d", releaseID))
+	}
+	if diagnostics == nil {
+		return nil, errors.Wrap(ErrResourceNotFound, fmt.Sprintf("No diagnostics collected for release %d", releaseID))
+	}
+	return diagnostics, nil
+}
+
+// collectDiagnostics gathers what explains the failure of a release.
+// It collects as much as it can, reporting what failed in Errors
+func collectDiagnostics(client kube.Client, release *Release) *ReleaseDiagnostics {
+	diagnostics := &ReleaseDiagnostics{
+		CollectedAt: time.Now(),
+		Events:      []*kube.Event{},
+		Pods:        []*kube.Pod{},
+		Logs:        []*ContainerLogs{},
+	}
+	events, err := client.Events(release.Namespace)
+	if err != nil {
+		diagnostics.Errors = append(diagnostics.Errors, err.Error())
+	}
+	if len(events) > diagnosticsEvents {
+		events = events[len(events)-diagnosticsEvents:]
+	}
+	diagnostics.Events = append(diagnostics.Events, events...)
+
+	pods, err := client.Pods(release.Namespace, release.Name)
+	if err != nil {
+		diagnostics.Errors = append(diagnostics.Errors, err.Error())
+	}
+	for _, pod := range pods {
+		if !pod.Failing() {
+			continue
+		}
+		diagnostics.Pods = append(diagnostics.Pods, pod)
+		for _, container := range pod.Containers {
+			if container.Ready {
+				continue
+			}
+			// Logs of a crashing container are those of its last instance, the current one being restarted
+			previous := container.Crashed() && container.State != "terminated"
+			lines, err := client.Logs(release.Namespace, pod.Name, container.Name, diagnosticsLogLines, previous)
+			if err != nil {
+				diagnostics.Errors = append(diagnostics.Errors, err.Error())
+				continue
+			}
+			diagnostics.Logs = append(diagnostics.Logs, &ContainerLogs{
+				Pod:       pod.Name,
+				Container: container.Name,
+				Previous:  previous,
+				Lines:     lines,
+			})
+		}
+	}
+	return diagnostics
+}
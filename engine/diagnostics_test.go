@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"testing"
+
+	"github.com/vgheri/gennaker/kube"
+)
+
+func Test_collectDiagnostics(t *testing.T) {
+	exitCode := 1
+	client := &fakeKube{
+		polls: [][]*kube.Workload{{{Kind: kube.DeploymentKind, Name: "api", Desired: 2, Ready: 1, Updated: 2,
+			Observed: true, Stuck: true}}},
+		pods: []*kube.Pod{
+			{Name: "api-1", Phase: "Running", Containers: []*kube.Container{
+				{Name: "api", Ready: true, State: "running"},
+			}},
+			{Name: "api-2", Phase: "Running", Containers: []*kube.Container{
+				{Name: "api", State: "waiting", Reason: "CrashLoopBackOff", RestartCount: 4, ExitCode: &exitCode},
+				{Name: "proxy", State: "waiting", Reason: "ContainerCreating"},
+				{Name: "sidecar", Ready: true, State: "running"},
+			}},
+		},
+		events: []*kube.Event{
+			{Type: "Normal", Reason: "Scheduled", Object: "Pod/api-2"},
+			{Type: "Warning", Reason: "BackOff", Object: "Pod/api-2"},
+		},
+		logs: map[string]string{"api-2/api/previous": "panic: cannot connect to db\n"},
+	}
+	e := &engine{db: repository, kube: client, watchRollouts: true}
+	release := &Release{Name: "app-prod", Namespace: "prod"}
+	if outcome := e.reconcileRelease(release, Deployed, &PipelineStep{TargetNamespace: "prod"}, nil); outcome != Failed {
+		t.Fatalf("Expected release to fail, got %v", outcome)
+	}
+	diagnostics := release.Diagnostics
+	if diagnostics == nil {
+		t.Fatal("Expected diagnostics to be collected")
+	}
+	if len(diagnostics.Events) != 2 || diagnostics.Events[1].Reason != "BackOff" {
+		t.Fatalf("Expected events of the namespace, got %+v", diagnostics.Events)
+	}
+	if len(diagnostics.Pods) != 1 || diagnostics.Pods[0].Name != "api-2" {
+		t.Fatalf("Expected only the failing pod, got %+v", diagnostics.Pods)
+	}
+	if len(diagnostics.Logs) != 1 || !diagnostics.Logs[0].Previous || diagnostics.Logs[0].Lines != "panic: cannot connect to db\n" {
+		t.Fatalf("Expected previous logs of the crashing container, got %+v", diagnostics.Logs)
+	}
+	// Missing logs of the container being created are reported, not fatal
+	if len(diagnostics.Errors) != 1 {
+		t.Fatalf("Expected 1 collection error, got %v", diagnostics.Errors)
+	}
+
+	// Nothing is collected for deployed releases
+	client.polls = [][]*kube.Workload{{{Kind: kube.DeploymentKind, Name: "api", Desired: 2, Ready: 2, Updated: 2, Observed: true}}}
+	release = &Release{Name: "app-prod", Namespace: "prod"}
+	if outcome := e.reconcileRelease(release, Deployed, &PipelineStep{TargetNamespace: "prod"}, nil); outcome != Deployed || release.Diagnostics != nil {
+		t.Fatalf("Expected release to be deployed without diagnostics, got %v %+v", outcome, release.Diagnostics)
+	}
+}
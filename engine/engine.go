@@ -1,13 +1,75 @@
 package engine
 
+import (
+	"time"
+
+	"github.com/vgheri/gennaker/kube"
+	"github.com/vgheri/gennaker/secret"
+)
+
+// DefaultDeduplicationWindow is the period during which a release notification
+// identical to a previous one is ignored
+const DefaultDeduplicationWindow = 10 * time.Minute
+
 type engine struct {
-	db        DeploymentRepository
-	chartsDir string
+	db                  DeploymentRepository
+	chartsDir           string
+	deduplicationWindow time.Duration
+	cipher              *secret.Cipher
+	secretProviders     map[string]secret.Provider
+	kube                kube.Client
+	watchRollouts       bool
+	commandHooks        bool
+	helm                helmClient
 }
 
-func New(repository DeploymentRepository, savedChartsDir string) DeploymentEngine {
-	return &engine{
-		db:        repository,
-		chartsDir: savedChartsDir,
+// Option configures optional behaviours of the engine
+type Option func(*engine)
+
+// WithDeduplicationWindow sets the period during which duplicate release
+// notifications are ignored. A zero or negative window disables deduplication
+// of notifications without an idempotency key.
+func WithDeduplicationWindow(window time.Duration) Option {
+	return func(e *engine) {
+		e.deduplicationWindow = window
+	}
+}
+
+// WithCipher sets the cipher encrypting the secret values stored with releases.
+// Without cipher, secret values are refused.
+func WithCipher(cipher *secret.Cipher) Option {
+	return func(e *engine) {
+		e.cipher = cipher
+	}
+}
+
+// WithSecretProvider registers a provider of secrets, which values
+// reference by name, ex: {{ secret "file" "db/password" }}
+func WithSecretProvider(provider secret.Provider) Option {
+	return func(e *engine) {
+		e.secretProviders[provider.Name()] = provider
+	}
+}
+
+// WithCommandHooks allows the hooks of gennaker.yml to run commands on the server.
+// Anyone able to publish a chart version can then run code on the server,
+// so only webhooks are allowed by default.
+func WithCommandHooks() Option {
+	return func(e *engine) {
+		e.commandHooks = true
+	}
+}
+
+func New(repository DeploymentRepository, savedChartsDir string, options ...Option) DeploymentEngine {
+	e := &engine{
+		db:                  repository,
+		chartsDir:           savedChartsDir,
+		deduplicationWindow: DefaultDeduplicationWindow,
+		secretProviders:     make(map[string]secret.Provider),
+		helm:                helmCLI{},
+	}
+	for _, option := range options {
+		option(e)
 	}
+	return e
 }
@@ -0,0 +1,127 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+//EnvironmentMatrix tells which version of each deployment is running in each namespace
+type EnvironmentMatrix struct {
+	Namespaces  []string          `json:"namespaces"`
+	Deployments []*EnvironmentRow `json:"deployments"`
+}
+
+//EnvironmentRow holds the state of every namespace of the pipeline of a deployment,
+//indexed by namespace
+type EnvironmentRow struct {
+	Deployment   string                      `json:"deployment"`
+	ChartName    string                      `json:"chart_name"`
+	Environments map[string]*EnvironmentCell `json:"environments"`
+}
+
+//EnvironmentCell describes the last release of a deployment in a namespace.
+//Behind is the number of image tags successfully deployed in the upstream
+//namespace which have not reached this namespace yet
+type EnvironmentCell struct {
+	Namespace         string                 `json:"namespace"`
+	UpstreamNamespace string                 `json:"upstream_namespace,omitempty"`
+	ImageTag          string                 `json:"image_tag"`
+	ChartVersion      string                 `json:"chart_version"`
+	Revision          int                    `json:"revision"`
+	Status            GennakerReleaseOutcome `json:"status"`
+	Date              *time.Time             `json:"date,omitempty"`
+	Behind            int                    `json:"behind"`
+}
+
+// GetEnvironmentMatrix returns, for the requested page of deployments,
+// what is deployed in each namespace of their pipeline
+func (e *engine) GetEnvironmentMatrix(request *ListDeploymentsRequest) (*EnvironmentMatrix, error) {
+	if request == nil {
+		request = &ListDeploymentsRequest{}
+	}
+	if err := request.valid(); err != nil {
+		return nil, errors.Wrap(err, "List request is invalid")
+	}
+	deployments, err := e.db.ListDeploymentsWithReleases(request)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot list deployments")
+	}
+	return buildEnvironmentMatrix(deployments), nil
+}
+
+func buildEnvironmentMatrix(deployments []*Deployment) *EnvironmentMatrix {
+	matrix := &EnvironmentMatrix{
+		Namespaces:  []string{},
+		Deployments: []*EnvironmentRow{},
+	}
+	knownNamespaces := make(map[string]bool)
+	for _, d := range deployments {
+		row := &EnvironmentRow{
+			Deployment:   d.Name,
+			ChartName:    d.ChartName,
+			Environments: make(map[string]*EnvironmentCell),
+		}
+		steps := flattenPipeline(d.Pipeline)
+		stepsByNumber := make(map[int]*PipelineStep)
+		for _, step := range steps {
+			stepsByNumber[step.StepNumber] = step
+		}
+		for _, step := range steps {
+			if !knownNamespaces[step.TargetNamespace] {
+				knownNamespaces[step.TargetNamespace] = true
+				matrix.Namespaces = append(matrix.Namespaces, step.TargetNamespace)
+			}
+			cell := &EnvironmentCell{Namespace: step.TargetNamespace}
+			current := getLastReleaseForNamespace(step.TargetNamespace, d)
+			if current != nil {
+				date := current.Date
+				cell.ImageTag = current.ImageTag
+				cell.ChartVersion = current.ChartVersion
+				cell.Revision = current.Revision
+				cell.Status = current.Status
+				cell.Date = &date
+			}
+			// Join steps are compared with their first parent
+			if parent, found := stepsByNumber[step.ParentStepNumber]; found {
+				cell.UpstreamNamespace = parent.TargetNamespace
+				cell.Behind = releasesBehind(current, getReleasesForNamespace(parent.TargetNamespace, d))
+			}
+			row.Environments[step.TargetNamespace] = cell
+		}
+		matrix.Deployments = append(matrix.Deployments, row)
+	}
+	return matrix
+}
+
+// releasesBehind counts the distinct image tags successfully deployed upstream
+// after the one currently deployed. Upstream releases are ordered from most
+// recent to less recent.
+// If the current image tag never went through the upstream namespace,
+// upstream releases more recent than the current one are counted.
+func releasesBehind(current *Release, upstream []*Release) int {
+	currentTagIsUpstream := false
+	if current != nil {
+		for _, r := range upstream {
+			if r.ImageTag == current.ImageTag && r.Status == Deployed {
+				currentTagIsUpstream = true
+				break
+			}
+		}
+	}
+	tags := make(map[string]bool)
+	for _, r := range upstream {
+		if current != nil {
+			if currentTagIsUpstream && r.ImageTag == current.ImageTag {
+				break
+			}
+			if !currentTagIsUpstream && !r.Date.After(current.Date) {
+				break
+			}
+		}
+		if r.Status == Deployed {
+			tags[r.ImageTag] = true
+		}
+	}
+	return len(tags)
+}
@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_buildEnvironmentMatrix(t *testing.T) {
+	now := time.Now()
+	d := &Deployment{
+		Name:      "test app",
+		ChartName: "test-chart",
+		Pipeline: []*PipelineStep{
+			&PipelineStep{
+				StepNumber:      1,
+				TargetNamespace: "int",
+				NextSteps: []*PipelineStep{
+					&PipelineStep{
+						StepNumber:       2,
+						ParentStepNumber: 1,
+						TargetNamespace:  "ppd",
+						NextSteps: []*PipelineStep{
+							&PipelineStep{
+								StepNumber:       3,
+								ParentStepNumber: 2,
+								TargetNamespace:  "prod",
+							},
+						},
+					},
+				},
+			},
+		},
+		// releases are ordered by most recent to less recent
+		Releases: []*Release{
+			&Release{ImageTag: "0.0.4", Namespace: "int", Status: Failed, Date: now.Add(-1 * time.Minute)},
+			&Release{ImageTag: "0.0.3", Namespace: "int", Status: Deployed, Date: now.Add(-2 * time.Minute)},
+			&Release{ImageTag: "0.0.1", Namespace: "ppd", Status: Deployed, Date: now.Add(-3 * time.Minute), Revision: 1, ChartVersion: "0.1.0"},
+			&Release{ImageTag: "0.0.2", Namespace: "int", Status: Deployed, Date: now.Add(-4 * time.Minute)},
+			&Release{ImageTag: "0.0.1", Namespace: "int", Status: Deployed, Date: now.Add(-5 * time.Minute)},
+		},
+	}
+	matrix := buildEnvironmentMatrix([]*Deployment{d})
+	if len(matrix.Namespaces) != 3 || matrix.Namespaces[0] != "int" ||
+		matrix.Namespaces[1] != "ppd" || matrix.Namespaces[2] != "prod" {
+		t.Fatalf("Expected namespaces int, ppd, prod, got %v", matrix.Namespaces)
+	}
+	if len(matrix.Deployments) != 1 {
+		t.Fatalf("Expected 1 deployment, got %d", len(matrix.Deployments))
+	}
+	envs := matrix.Deployments[0].Environments
+	if envs["int"].ImageTag != "0.0.4" || envs["int"].Status != Failed || envs["int"].Behind != 0 {
+		t.Fatalf("Malformed int cell %+v", envs["int"])
+	}
+	if envs["ppd"].ImageTag != "0.0.1" || envs["ppd"].Revision != 1 || envs["ppd"].ChartVersion != "0.1.0" ||
+		envs["ppd"].UpstreamNamespace != "int" || envs["ppd"].Behind != 2 {
+		t.Fatalf("Malformed ppd cell %+v", envs["ppd"])
+	}
+	if envs["prod"].ImageTag != "" || envs["prod"].Date != nil || envs["prod"].Behind != 1 {
+		t.Fatalf("Malformed prod cell %+v", envs["prod"])
+	}
+}
+
+func Test_releasesBehind(t *testing.T) {
+	now := time.Now()
+	upstream := []*Release{
+		&Release{ImageTag: "0.0.3", Status: Deployed, Date: now.Add(-1 * time.Minute)},
+		&Release{ImageTag: "0.0.2", Status: Deployed, Date: now.Add(-2 * time.Minute)},
+		&Release{ImageTag: "0.0.3", Status: Deployed, Date: now.Add(-3 * time.Minute)},
+		&Release{ImageTag: "0.0.1", Status: Deployed, Date: now.Add(-4 * time.Minute)},
+	}
+	tt := []struct {
+		testName string
+		current  *Release
+		expected int
+	}{
+		{testName: "Nothing deployed", current: nil, expected: 3},
+		{testName: "Up to date", current: &Release{ImageTag: "0.0.3", Date: now}, expected: 0},
+		{testName: "Tag redeployed upstream", current: &Release{ImageTag: "0.0.1", Date: now}, expected: 2},
+		{testName: "Tag unknown upstream", current: &Release{ImageTag: "hotfix", Date: now.Add(-150 * time.Second)}, expected: 2},
+	}
+	for _, tc := range tt {
+		t.Run(tc.testName, func(t *testing.T) {
+			if behind := releasesBehind(tc.current, upstream); behind != tc.expected {
+				t.Fatalf("Expected %d releases behind, got %d", tc.expected, behind)
+			}
+		})
+	}
+}
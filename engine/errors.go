@@ -14,4 +14,23 @@ var ErrInvalidPipeline error = fmt.Errorf("Invalid pipeline")
 
 var ErrInvalidReleaseNotification error = fmt.Errorf("Invalid release notification")
 
+//ErrDeploymentArchived is returned when an operation targets an archived deployment
+var ErrDeploymentArchived error = fmt.Errorf("Deployment is archived")
+
+//ErrDuplicateNotification is returned when a release notification with the same
+//idempotency key has already been received
+var ErrDuplicateNotification error = fmt.Errorf("Duplicate release notification")
+
+//ErrVariableSetInUse is returned when deleting a variable set attached to deployments
+var ErrVariableSetInUse error = fmt.Errorf("Variable set is attached to deployments")
+
+//ErrDeploymentInUse is returned when deleting a deployment which is part of an application
+var ErrDeploymentInUse error = fmt.Errorf("Deployment is part of an application")
+
+//ErrScheduleNotPending is returned when cancelling a scheduled job which already ran or was cancelled
+var ErrScheduleNotPending error = fmt.Errorf("Schedule is not pending")
+
+//ErrScheduleInterrupted is recorded as the error of a job whose scheduler stopped renewing its claim
+var ErrScheduleInterrupted error = fmt.Errorf("Schedule was interrupted: its scheduler stopped while running it")
+
 var ErrBadRequest error = fmt.Errorf("Invalid input parameter")
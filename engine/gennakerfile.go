@@ -0,0 +1,397 @@
+package engine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Versions of gennaker.yml:
+//   - 1: steps have a number, a namespace, an autodeploy flag and an optional parent step.
+//     Files without version are read as version 1
+//   - 2: declared with "version: 2" at the top of the file. Steps can also have several parents,
+//     listed in parent_steps, and options. Unknown fields are rejected
+//
+// See examples/v2_pipeline for a version 2 pipeline, and examples/v2_options for the options of the steps
+const (
+	gennakerFileName = "gennaker.yml"
+	gennakerFileV1   = 1
+	gennakerFileV2   = 2
+)
+
+// namespaceNameRegexp matches valid kubernetes namespace names (DNS-1123 labels)
+var namespaceNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// yamlErrorRegexp extracts the line of the errors returned by the yaml decoder
+var yamlErrorRegexp = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// unknownFieldRegexp matches the errors returned by the yaml decoder for unknown fields
+var unknownFieldRegexp = regexp.MustCompile(`^field (\S+) not found in (?:struct|type) \S+$`)
+
+// PipelineError reports a problem found in a gennaker.yml file.
+// Line is 0 when the problem cannot be located
+type PipelineError struct {
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+// PipelineValidationError lists every problem found in a gennaker.yml file
+type PipelineValidationError struct {
+	Errors []*PipelineError
+}
+
+func (e *PipelineValidationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, pipelineErr := range e.Errors {
+		if pipelineErr.Line > 0 {
+			messages = append(messages, fmt.Sprintf("line %d: %s", pipelineErr.Line, pipelineErr.Message))
+		} else {
+			messages = append(messages, pipelineErr.Message)
+		}
+	}
+	return fmt.Sprintf("Invalid gennaker.yml: %s", strings.Join(messages, "; "))
+}
+
+// ValidatePipeline checks the content of a gennaker.yml file and returns the pipeline it declares.
+// Problems are reported with a *PipelineValidationError
+func (e *engine) ValidatePipeline(content []byte) ([]*PipelineStep, error) {
+	yamlContent, err := parseGennakerFile(content)
+	if err != nil {
+		return nil, err
+	}
+	return yamlContent.pipeline(), nil
+}
+
+func readGennakerFile(pathToChartOnDisk string) (*YamlContent, error) {
+	fullPath := path.Join(pathToChartOnDisk, gennakerFileName)
+	gennakerContent, err := ioutil.ReadFile(fullPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Read file gennaker.yml failed")
+	}
+	return parseGennakerFile(gennakerContent)
+}
+
+// parseGennakerFile decodes and validates the content of a gennaker.yml file
+// according to its version
+func parseGennakerFile(content []byte) (*YamlContent, error) {
+	var header struct {
+		Version int
+	}
+	if err := yaml.Unmarshal(content, &header); err != nil {
+		return nil, yamlValidationError(err)
+	}
+	version := header.Version
+	if version == 0 {
+		version = gennakerFileV1
+	}
+	if version != gennakerFileV1 && version != gennakerFileV2 {
+		return nil, &PipelineValidationError{Errors: []*PipelineError{{
+			Line:    keyLine(content, "version"),
+			Message: fmt.Sprintf("Unsupported version %d, expected %d or %d", version, gennakerFileV1, gennakerFileV2),
+		}}}
+	}
+	yamlContent := &YamlContent{}
+	unmarshal := yaml.Unmarshal
+	if version >= gennakerFileV2 {
+		unmarshal = yaml.UnmarshalStrict
+	}
+	if err := unmarshal(content, yamlContent); err != nil {
+		return nil, yamlValidationError(err)
+	}
+	yamlContent.Version = version
+	if pipelineErrors := validateGennakerFile(yamlContent, content); len(pipelineErrors) != 0 {
+		return nil, &PipelineValidationError{Errors: pipelineErrors}
+	}
+	return yamlContent, nil
+}
+
+// validateGennakerFile returns every problem found in the decoded file, sorted by line
+func validateGennakerFile(yamlContent *YamlContent, content []byte) []*PipelineError {
+	var pipelineErrors []*PipelineError
+	addError := func(line int, format string, args ...interface{}) {
+		pipelineErrors = append(pipelineErrors, &PipelineError{Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	imageLines := sequenceItemLines(content, "images")
+	images := make(map[string]int)
+	for i, image := range yamlContent.Images {
+		line := itemLine(imageLines, i)
+		if image == nil || len(strings.TrimSpace(image.Name)) == 0 || len(strings.TrimSpace(image.Path)) == 0 {
+			addError(line, "Images must have a name and a path")
+			continue
+		}
+		if first, found := images[image.Name]; found {
+			addError(line, "Image %s is declared more than once, first at line %d", image.Name, first)
+			continue
+		}
+		images[image.Name] = line
+	}
+
+	if yamlContent.Pipeline == nil || len(yamlContent.Pipeline.Steps) == 0 {
+		addError(keyLine(content, "pipeline"), "Pipeline must declare at least one step")
+		return sortPipelineErrors(pipelineErrors)
+	}
+	stepLines := sequenceItemLines(content, "steps")
+	steps := make(map[int]*YamlPipelineStep)
+	lines := make(map[int]int)
+	namespaces := make(map[string]int)
+	for i, s := range yamlContent.Pipeline.Steps {
+		line := itemLine(stepLines, i)
+		if s == nil {
+			addError(line, "Steps cannot be empty")
+			continue
+		}
+		if s.Step <= 0 {
+			addError(line, "Step number must be greater than 0")
+		} else if _, found := steps[s.Step]; found {
+			addError(line, "Step %d is declared more than once, first at line %d", s.Step, lines[s.Step])
+		} else {
+			steps[s.Step] = s
+			lines[s.Step] = line
+		}
+		namespace := s.Namespace
+		switch {
+		case len(strings.TrimSpace(namespace)) == 0:
+			addError(line, "Step %d has no namespace", s.Step)
+		case len(namespace) > 63 || !namespaceNameRegexp.MatchString(namespace):
+			addError(line, "Namespace %s of step %d is not a valid namespace name", namespace, s.Step)
+		default:
+			if first, found := namespaces[namespace]; found {
+				addError(line, "Namespace %s is used by more than one step, first at line %d", namespace, first)
+			} else {
+				namespaces[namespace] = line
+			}
+		}
+		seen := make(map[int]bool)
+		for _, parent := range s.parents() {
+			switch {
+			case parent <= 0:
+				addError(line, "Parent step of step %d must be greater than 0", s.Step)
+			case parent == s.Step:
+				addError(line, "Step %d cannot be its own parent", s.Step)
+			case seen[parent]:
+				addError(line, "Parent step %d of step %d is listed more than once", parent, s.Step)
+			}
+			seen[parent] = true
+		}
+		if len(s.ParentSteps) != 0 && yamlContent.Version < gennakerFileV2 {
+			addError(line, "Parent steps of step %d require version %d", s.Step, gennakerFileV2)
+		}
+		if s.Options != nil {
+			if yamlContent.Version < gennakerFileV2 {
+				addError(line, "Options of step %d require version %d", s.Step, gennakerFileV2)
+			} else if s.Options.Timeout < 0 {
+				addError(line, "Timeout of step %d cannot be negative", s.Step)
+			}
+			if s.Options.Hooks != nil {
+				hooks := append(append([]*Hook{}, s.Options.Hooks.PreDeploy...), s.Options.Hooks.PostDeploy...)
+				hooks = append(hooks, s.Options.Hooks.OnFailure...)
+				for _, hook := range hooks {
+					if err := hook.valid(); err != nil {
+						addError(line, "Invalid hook in step %d: %v", s.Step, err)
+					}
+				}
+			}
+			if s.Options.Strategy != nil {
+				if err := s.Options.Strategy.valid(); err != nil {
+					addError(line, "Invalid strategy of step %d: %v", s.Step, err)
+				}
+			}
+			if s.Options.Namespace != nil {
+				if err := s.Options.Namespace.valid(); err != nil {
+					addError(line, "Invalid namespace of step %d: %v", s.Step, err)
+				}
+			}
+			for _, check := range s.Options.HealthChecks {
+				if err := check.valid(); err != nil {
+					addError(line, "Invalid health check in step %d: %v", s.Step, err)
+				}
+			}
+			for _, dep := range s.Options.DependsOn {
+				if err := dep.valid(); err != nil {
+					addError(line, "Invalid dependency of step %d: %v", s.Step, err)
+				}
+			}
+		}
+	}
+
+	stepNumbers := make([]int, 0, len(steps))
+	for number := range steps {
+		stepNumbers = append(stepNumbers, number)
+	}
+	sort.Ints(stepNumbers)
+	for _, number := range stepNumbers {
+		for _, parent := range steps[number].parents() {
+			if parent <= 0 || parent == number {
+				continue
+			}
+			if _, found := steps[parent]; !found {
+				addError(lines[number], "Parent step %d of step %d is not declared", parent, number)
+			}
+		}
+	}
+	for _, cycle := range findCycles(steps, stepNumbers) {
+		chain := make([]string, 0, len(cycle)+1)
+		for _, n := range append(cycle, cycle[0]) {
+			chain = append(chain, strconv.Itoa(n))
+		}
+		addError(lines[cycle[0]], "Steps %s form a cycle", strings.Join(chain, " -> "))
+	}
+	return sortPipelineErrors(pipelineErrors)
+}
+
+// findCycles walks the steps from child to parents and returns the cycles found,
+// each one starting from its step with the lowest number
+func findCycles(steps map[int]*YamlPipelineStep, stepNumbers []int) [][]int {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	var cycles [][]int
+	reported := make(map[string]bool)
+	state := make(map[int]int)
+	var stack []int
+	var visit func(number int)
+	visit = func(number int) {
+		state[number] = visiting
+		stack = append(stack, number)
+		for _, parent := range steps[number].parents() {
+			if _, found := steps[parent]; !found || parent == number {
+				continue
+			}
+			switch state[parent] {
+			case unvisited:
+				visit(parent)
+			case visiting:
+				start := 0
+				for stack[start] != parent {
+					start++
+				}
+				cycle := rotateToLowest(stack[start:])
+				key := fmt.Sprint(cycle)
+				if !reported[key] {
+					reported[key] = true
+					cycles = append(cycles, cycle)
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[number] = visited
+	}
+	for _, number := range stepNumbers {
+		if state[number] == unvisited {
+			visit(number)
+		}
+	}
+	return cycles
+}
+
+// rotateToLowest returns a copy of cycle starting from its lowest number
+func rotateToLowest(cycle []int) []int {
+	lowest := 0
+	for i, n := range cycle {
+		if n < cycle[lowest] {
+			lowest = i
+		}
+	}
+	return append(append([]int{}, cycle[lowest:]...), cycle[:lowest]...)
+}
+
+func sortPipelineErrors(pipelineErrors []*PipelineError) []*PipelineError {
+	sort.SliceStable(pipelineErrors, func(i, j int) bool {
+		return pipelineErrors[i].Line < pipelineErrors[j].Line
+	})
+	return pipelineErrors
+}
+
+// yamlValidationError converts the errors of the yaml decoder, keeping their line
+func yamlValidationError(err error) error {
+	var messages []string
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		messages = typeErr.Errors
+	} else {
+		messages = []string{err.Error()}
+	}
+	validationErr := &PipelineValidationError{}
+	for _, message := range messages {
+		pipelineErr := &PipelineError{Message: strings.TrimPrefix(message, "yaml: ")}
+		if match := yamlErrorRegexp.FindStringSubmatch(message); match != nil {
+			pipelineErr.Line, _ = strconv.Atoi(match[1])
+			pipelineErr.Message = match[2]
+		}
+		if match := unknownFieldRegexp.FindStringSubmatch(pipelineErr.Message); match != nil {
+			pipelineErr.Message = fmt.Sprintf("Unknown field %s", match[1])
+		}
+		validationErr.Errors = append(validationErr.Errors, pipelineErr)
+	}
+	return validationErr
+}
+
+// keyLine returns the line of a top level key, or 0 if it is not found
+func keyLine(content []byte, key string) int {
+	for i, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, key+":") {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// sequenceItemLines returns the line of each item of the first block sequence
+// found under key. Items written in flow style cannot be located
+func sequenceItemLines(content []byte, key string) []int {
+	var itemLines []int
+	keyIndent, itemIndent := -1, -1
+	for i, line := range strings.Split(string(content), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if keyIndent < 0 {
+			if isBlockKeyLine(trimmed, key) {
+				keyIndent = indent
+			}
+			continue
+		}
+		isItem := strings.HasPrefix(trimmed, "- ") || trimmed == "-"
+		if itemIndent < 0 {
+			if indent < keyIndent || !isItem {
+				break
+			}
+			itemIndent = indent
+		}
+		if indent < itemIndent || (indent == itemIndent && !isItem) {
+			break
+		}
+		if indent == itemIndent {
+			itemLines = append(itemLines, i+1)
+		}
+	}
+	return itemLines
+}
+
+// isBlockKeyLine tells if line starts with key and has no inline value
+func isBlockKeyLine(line, key string) bool {
+	if !strings.HasPrefix(line, key+":") {
+		return false
+	}
+	rest := strings.TrimSpace(strings.TrimPrefix(line, key+":"))
+	return len(rest) == 0 || strings.HasPrefix(rest, "#")
+}
+
+func itemLine(lines []int, i int) int {
+	if i < len(lines) {
+		return lines[i]
+	}
+	return 0
+}
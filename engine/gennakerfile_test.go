@@ -0,0 +1,214 @@
+package engine
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_parseGennakerFile(t *testing.T) {
+	tt := []struct {
+		name    string
+		content string
+		errors  []PipelineError
+	}{
+		{
+			name:    "file without version is read as version 1",
+			content: "pipeline:\n  steps:\n    - step: 1\n      namespace: int\n",
+		},
+		{
+			name:    "version 1 ignores unknown fields",
+			content: "version: 1\npipeline:\n  steps:\n    - step: 1\n      namespace: int\n      color: blue\n",
+		},
+		{
+			name:    "unsupported version",
+			content: "version: 3\npipeline:\n  steps:\n    - step: 1\n      namespace: int\n",
+			errors:  []PipelineError{{Line: 1, Message: "Unsupported version 3, expected 1 or 2"}},
+		},
+		{
+			name:    "malformed yaml",
+			content: "version: 1\npipeline:\n  steps:\n  - step: [1\n",
+			errors:  []PipelineError{{Line: 4, Message: "did not find expected ',' or ']'"}},
+		},
+		{
+			name:    "version 2 rejects unknown fields",
+			content: "version: 2\npipeline:\n  steps:\n    - step: 1\n      namespace: int\n      color: blue\n",
+			errors:  []PipelineError{{Line: 4, Message: "Unknown field color"}},
+		},
+		{
+			name:    "options require version 2",
+			content: "version: 1\npipeline:\n  steps:\n    - step: 1\n      namespace: int\n      options:\n        wait: true\n",
+			errors:  []PipelineError{{Line: 4, Message: "Options of step 1 require version 2"}},
+		},
+		{
+			name:    "invalid hook",
+			content: "version: 2\npipeline:\n  steps:\n    - step: 1\n      namespace: int\n      options:\n        hooks:\n          pre_deploy:\n            - name: migrate\n",
+			errors:  []PipelineError{{Line: 4, Message: "Invalid hook in step 1: Hook migrate must have either a webhook or a command"}},
+		},
+		{
+			name:    "invalid namespace",
+			content: "version: 2\npipeline:\n  steps:\n    - step: 1\n      namespace: int\n      options:\n        namespace:\n          limit_range:\n            limits:\n              - type: Node\n",
+			errors:  []PipelineError{{Line: 4, Message: "Invalid namespace of step 1: Limits of the limit range must have a type among Container, Pod and PersistentVolumeClaim"}},
+		},
+		{
+			name:    "invalid strategy",
+			content: "version: 2\npipeline:\n  steps:\n    - step: 1\n      namespace: int\n      options:\n        strategy:\n          type: bluegreen\n          color_path: color\n",
+			errors:  []PipelineError{{Line: 4, Message: "Invalid strategy of step 1: Blue/green strategy requires a color_path and a selector_path"}},
+		},
+		{
+			name:    "invalid dependency",
+			content: "version: 2\npipeline:\n  steps:\n    - step: 1\n      namespace: int\n      options:\n        depends_on:\n          - deployment: api\n            min_version: latest\n",
+			errors:  []PipelineError{{Line: 4, Message: "Invalid dependency of step 1: Invalid min_version: image tag latest is not a version"}},
+		},
+		{
+			name:    "missing pipeline",
+			content: "version: 2\n",
+			errors:  []PipelineError{{Message: "Pipeline must declare at least one step"}},
+		},
+		{
+			name: "invalid steps",
+			content: `version: 1
+pipeline:
+  steps:
+    - step: 1
+      namespace: int
+    # duplicate step number
+    - step: 1
+      namespace: ppd
+    - step: 2
+      namespace: int
+    - step: 3
+      namespace: Prod_1
+      parent_step: 7
+    - step: 4
+      namespace: qa
+      parent_step: 4
+`,
+			errors: []PipelineError{
+				{Line: 7, Message: "Step 1 is declared more than once, first at line 4"},
+				{Line: 9, Message: "Namespace int is used by more than one step, first at line 4"},
+				{Line: 11, Message: "Namespace Prod_1 of step 3 is not a valid namespace name"},
+				{Line: 11, Message: "Parent step 7 of step 3 is not declared"},
+				{Line: 14, Message: "Step 4 cannot be its own parent"},
+			},
+		},
+		{
+			name: "cycle",
+			content: `pipeline:
+  steps:
+    - step: 1
+      namespace: int
+    - step: 2
+      namespace: ppd
+      parent_step: 3
+    - step: 3
+      namespace: prod
+      parent_step: 2
+`,
+			errors: []PipelineError{{Line: 5, Message: "Steps 2 -> 3 -> 2 form a cycle"}},
+		},
+		{
+			name:    "parent steps require version 2",
+			content: "version: 1\npipeline:\n  steps:\n    - step: 1\n      namespace: int\n    - step: 2\n      namespace: ppd\n      parent_steps: [1]\n",
+			errors:  []PipelineError{{Line: 6, Message: "Parent steps of step 2 require version 2"}},
+		},
+		{
+			name: "cycle through a join step",
+			content: `version: 2
+pipeline:
+  steps:
+    - step: 1
+      namespace: int
+    - step: 2
+      namespace: ppd
+      parent_steps: [1, 3]
+    - step: 3
+      namespace: prod
+      parent_steps: [2, 2]
+`,
+			errors: []PipelineError{
+				{Line: 6, Message: "Steps 2 -> 3 -> 2 form a cycle"},
+				{Line: 9, Message: "Parent step 2 of step 3 is listed more than once"},
+			},
+		},
+		{
+			name:    "duplicate images",
+			content: "images:\n  - name: api\n    path: image.tag\n  - name: api\n    path: api.tag\npipeline:\n  steps:\n    - step: 1\n      namespace: int\n",
+			errors:  []PipelineError{{Line: 4, Message: "Image api is declared more than once, first at line 2"}},
+		},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseGennakerFile([]byte(tc.content))
+			if len(tc.errors) == 0 {
+				if err != nil {
+					t.Fatalf("Expected success, got %v", err)
+				}
+				return
+			}
+			validationErr, ok := err.(*PipelineValidationError)
+			if !ok {
+				t.Fatalf("Expected a validation error, got %v", err)
+			}
+			if len(validationErr.Errors) != len(tc.errors) {
+				t.Fatalf("Expected %d errors, got %v", len(tc.errors), validationErr)
+			}
+			for i, pipelineErr := range validationErr.Errors {
+				if *pipelineErr != tc.errors[i] {
+					t.Errorf("Expected %+v, got %+v", tc.errors[i], *pipelineErr)
+				}
+			}
+		})
+	}
+}
+
+func Test_buildPipelineV2(t *testing.T) {
+	gopath := os.Getenv("GOPATH")
+	destination := path.Join(gopath, "src", "github.com", "vgheri", "gennaker", "examples", "v2_pipeline")
+	pipeline, err := buildPipeline(destination)
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	steps := flattenPipeline(pipeline)
+	if len(pipeline) != 1 || len(steps) != 3 {
+		t.Fatalf("Malformed pipeline %+v", pipeline)
+	}
+	if steps[0].Options != nil {
+		t.Fatalf("Expected step 1 to have no options, got %+v", steps[0].Options)
+	}
+	if options := steps[2].Options; options == nil || !options.Wait || options.Timeout != 600 {
+		t.Fatalf("Expected options of step 3 to be read, got %+v", options)
+	}
+
+	// Every option of the steps is read
+	destination = path.Join(gopath, "src", "github.com", "vgheri", "gennaker", "examples", "v2_options")
+	pipeline, err = buildPipeline(destination)
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	steps = flattenPipeline(pipeline)
+	if len(steps) != 4 {
+		t.Fatalf("Malformed pipeline %+v", pipeline)
+	}
+	prod := getStepForNamespace("prod", pipeline)
+	if prod == nil || len(prod.parents()) != 2 || prod.Options == nil {
+		t.Fatalf("Expected prod to join ppd and load-test, got %+v", prod)
+	}
+	options := prod.Options
+	if options.Namespace == nil || options.Hooks == nil || len(options.Hooks.PostDeploy) != 1 ||
+		options.Strategy == nil || options.Strategy.Type != CanaryStrategy || len(options.HealthChecks) != 1 ||
+		len(options.DependsOn) != 1 {
+		t.Fatalf("Expected options of prod to be read, got %+v", options)
+	}
+
+	// Parents listed after their children are not dropped
+	content := "pipeline:\n  steps:\n    - step: 2\n      namespace: prod\n      parent_step: 1\n    - step: 1\n      namespace: int\n"
+	yamlContent, err := parseGennakerFile([]byte(content))
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	pipeline = yamlContent.pipeline()
+	if len(pipeline) != 1 || len(pipeline[0].NextSteps) != 1 || pipeline[0].NextSteps[0].TargetNamespace != "prod" {
+		t.Fatalf("Malformed pipeline %+v", pipeline)
+	}
+}
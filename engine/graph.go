@@ -0,0 +1,135 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//PipelineGraph is the pipeline of a deployment as a list of nodes, one per step,
+//and of edges going from parent steps to their next steps
+type PipelineGraph struct {
+	Deployment string          `json:"deployment"`
+	Nodes      []*PipelineNode `json:"nodes"`
+	Edges      []*PipelineEdge `json:"edges"`
+}
+
+//PipelineNode describes a step and the release currently deployed in its namespace, if any
+type PipelineNode struct {
+	StepNumber      int                    `json:"step_number"`
+	Namespace       string                 `json:"namespace"`
+	AutomaticDeploy bool                   `json:"automatic_deploy"`
+	ImageTag        string                 `json:"image_tag,omitempty"`
+	Status          GennakerReleaseOutcome `json:"status"`
+}
+
+//PipelineEdge links a step to one of its next steps
+type PipelineEdge struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// GetPipelineGraph returns the pipeline of a deployment annotated with the current release of each namespace
+func (e *engine) GetPipelineGraph(deploymentName string) (*PipelineGraph, error) {
+	if len(strings.TrimSpace(deploymentName)) == 0 {
+		return nil, errors.New("A non empty deployment name is mandatory")
+	}
+	d, err := e.db.GetDeployment(deploymentName)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get deployment")
+	}
+	return buildPipelineGraph(d), nil
+}
+
+func buildPipelineGraph(d *Deployment) *PipelineGraph {
+	graph := &PipelineGraph{
+		Deployment: d.Name,
+		Nodes:      []*PipelineNode{},
+		Edges:      []*PipelineEdge{},
+	}
+	for _, step := range flattenPipeline(d.Pipeline) {
+		node := &PipelineNode{
+			StepNumber:      step.StepNumber,
+			Namespace:       step.TargetNamespace,
+			AutomaticDeploy: step.AutomaticDeploy,
+		}
+		if current := getLastReleaseForNamespace(step.TargetNamespace, d); current != nil {
+			node.ImageTag = current.ImageTag
+			node.Status = current.Status
+		}
+		graph.Nodes = append(graph.Nodes, node)
+		for _, parent := range step.parents() {
+			graph.Edges = append(graph.Edges, &PipelineEdge{From: parent, To: step.StepNumber})
+		}
+	}
+	return graph
+}
+
+// label describes the node on several lines: namespace, autodeploy flag and current release
+func (n *PipelineNode) label() []string {
+	lines := []string{n.Namespace}
+	if n.AutomaticDeploy {
+		lines = append(lines, "autodeploy")
+	}
+	if len(n.ImageTag) != 0 {
+		lines = append(lines, fmt.Sprintf("%s (%s)", n.ImageTag, n.Status))
+	} else {
+		lines = append(lines, "not deployed")
+	}
+	return lines
+}
+
+// statusColors colors nodes according to the status of their current release
+var statusColors = map[GennakerReleaseOutcome]string{
+	Deployed: "#2e7d32",
+	Failed:   "#c62828",
+	Unknown:  "#757575",
+}
+
+// DOT renders the graph in the graphviz format
+func (g *PipelineGraph) DOT() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "digraph %s {\n", dotQuote(g.Deployment))
+	buf.WriteString("  rankdir=LR;\n")
+	buf.WriteString("  node [shape=box, style=rounded];\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&buf, "  step%d [label=%s, color=%s];\n", n.StepNumber,
+			dotQuote(strings.Join(n.label(), "\n")), dotQuote(statusColors[n.Status]))
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&buf, "  step%d -> step%d;\n", e.From, e.To)
+	}
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// Mermaid renders the graph as a mermaid flowchart
+func (g *PipelineGraph) Mermaid() string {
+	var buf bytes.Buffer
+	buf.WriteString("graph LR\n")
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&buf, "  step%d[\"%s\"]\n", n.StepNumber, mermaidEscape(strings.Join(n.label(), "<br/>")))
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(&buf, "  step%d --> step%d\n", e.From, e.To)
+	}
+	for _, n := range g.Nodes {
+		fmt.Fprintf(&buf, "  style step%d stroke:%s\n", n.StepNumber, statusColors[n.Status])
+	}
+	return buf.String()
+}
+
+// dotQuote returns s as a graphviz quoted string
+func dotQuote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
+
+// mermaidEscape escapes the characters which cannot appear in a quoted mermaid label
+func mermaidEscape(s string) string {
+	return strings.Replace(s, `"`, "#quot;", -1)
+}
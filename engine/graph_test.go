@@ -0,0 +1,59 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_buildPipelineGraph(t *testing.T) {
+	join := &PipelineStep{StepNumber: 4, ParentStepNumber: 2, ParentStepNumbers: []int{2, 3}, TargetNamespace: "prod"}
+	d := &Deployment{
+		Name: `my "app"`,
+		Pipeline: []*PipelineStep{
+			&PipelineStep{StepNumber: 1, TargetNamespace: "int", AutomaticDeploy: true, NextSteps: []*PipelineStep{
+				&PipelineStep{StepNumber: 2, ParentStepNumber: 1, TargetNamespace: "ppd", NextSteps: []*PipelineStep{join}},
+				&PipelineStep{StepNumber: 3, ParentStepNumber: 1, TargetNamespace: "load-test", NextSteps: []*PipelineStep{join}},
+			}},
+		},
+		// releases are ordered by most recent to less recent
+		Releases: []*Release{
+			&Release{ImageTag: "1.0.1", Namespace: "int", Status: Failed},
+			&Release{ImageTag: "1.0.0", Namespace: "ppd", Status: Deployed},
+			&Release{ImageTag: "1.0.0", Namespace: "int", Status: Deployed},
+		},
+	}
+	graph := buildPipelineGraph(d)
+	if len(graph.Nodes) != 4 || len(graph.Edges) != 4 {
+		t.Fatalf("Expected 4 nodes and 4 edges, got %+v", graph)
+	}
+	if n := graph.Nodes[0]; n.Namespace != "int" || !n.AutomaticDeploy || n.ImageTag != "1.0.1" || n.Status != Failed {
+		t.Fatalf("Expected int to be annotated with its current release, got %+v", n)
+	}
+	if n := graph.Nodes[3]; n.ImageTag != "" || n.Status != Unknown {
+		t.Fatalf("Expected prod to have no release, got %+v", n)
+	}
+
+	dot := graph.DOT()
+	for _, expected := range []string{
+		`digraph "my \"app\"" {`,
+		`step1 [label="int\nautodeploy\n1.0.1 (failed)", color="#c62828"];`,
+		`step4 [label="prod\nnot deployed", color="#757575"];`,
+		"step2 -> step4;",
+		"step3 -> step4;",
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Errorf("Expected DOT output to contain %s, got\n%s", expected, dot)
+		}
+	}
+	mermaid := graph.Mermaid()
+	for _, expected := range []string{
+		"graph LR\n",
+		`step2["ppd<br/>1.0.0 (deployed)"]`,
+		"step1 --> step3",
+		"style step2 stroke:#2e7d32",
+	} {
+		if !strings.Contains(mermaid, expected) {
+			t.Errorf("Expected mermaid output to contain %s, got\n%s", expected, mermaid)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultHealthCheckInterval is the time between two probes, in seconds
+	defaultHealthCheckInterval = 5
+	// defaultHealthCheckTimeout is the time given to a health check to pass, in seconds
+	defaultHealthCheckTimeout = 300
+	// maxHealthCheckBody is the number of bytes of the responses matched against BodyRegex
+	maxHealthCheckBody = 64 * 1024
+)
+
+//HealthCheck probes an HTTP endpoint once helm has released a step.
+//The check passes after Successes consecutive successful probes, made every Interval seconds,
+//and fails if this does not happen within Timeout seconds.
+//A probe is successful when the response has ExpectedStatus, any 2xx status when 0,
+//and a body matching BodyRegex, if set
+type HealthCheck struct {
+	Name           string `json:"name" yaml:"name"`
+	URL            string `json:"url" yaml:"url"`
+	ExpectedStatus int    `json:"expected_status,omitempty" yaml:"expected_status,omitempty"`
+	BodyRegex      string `json:"body_regex,omitempty" yaml:"body_regex,omitempty"`
+	Interval       int    `json:"interval,omitempty" yaml:"interval,omitempty"`
+	Successes      int    `json:"successes,omitempty" yaml:"successes,omitempty"`
+	Timeout        int    `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+//HealthCheckResult is the outcome of a health check, stored with the release
+type HealthCheckResult struct {
+	Name     string `json:"name"`
+	Success  bool   `json:"success"`
+	Probes   int    `json:"probes"`
+	Error    string `json:"error,omitempty"`
+	Duration int64  `json:"duration_ms"`
+}
+
+// valid checks the health check declared in gennaker.yml
+func (c *HealthCheck) valid() error {
+	if c == nil || len(strings.TrimSpace(c.Name)) == 0 {
+		return errors.New("Health checks must have a name")
+	}
+	if !strings.HasPrefix(c.URL, "http://") && !strings.HasPrefix(c.URL, "https://") {
+		return errors.Errorf("Health check %s must have an http or https url", c.Name)
+	}
+	if c.ExpectedStatus != 0 && (c.ExpectedStatus < 100 || c.ExpectedStatus > 599) {
+		return errors.Errorf("Expected status of health check %s is not a valid HTTP status", c.Name)
+	}
+	if _, err := regexp.Compile(c.BodyRegex); err != nil {
+		return errors.Errorf("Body regex of health check %s is invalid: %v", c.Name, err)
+	}
+	if c.Interval < 0 || c.Successes < 0 || c.Timeout < 0 {
+		return errors.Errorf("Interval, successes and timeout of health check %s cannot be negative", c.Name)
+	}
+	if c.interval()*time.Duration(c.successes()-1) >= c.timeout() {
+		return errors.Errorf("Timeout of health check %s is too short for %d successes", c.Name, c.successes())
+	}
+	return nil
+}
+
+func (c *HealthCheck) interval() time.Duration {
+	if c.Interval == 0 {
+		return defaultHealthCheckInterval * time.Second
+	}
+	return time.Duration(c.Interval) * time.Second
+}
+
+func (c *HealthCheck) successes() int {
+	if c.Successes == 0 {
+		return 1
+	}
+	return c.Successes
+}
+
+func (c *HealthCheck) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return defaultHealthCheckTimeout * time.Second
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
+// healthChecks returns the health checks of the step
+func (s *PipelineStep) healthChecks() []*HealthCheck {
+	if s == nil || s.Options == nil {
+		return nil
+	}
+	return s.Options.HealthChecks
+}
+
+// runHealthChecks runs the health checks in order, stopping at the first failure
+func runHealthChecks(checks []*HealthCheck) ([]*HealthCheckResult, error) {
+	var results []*HealthCheckResult
+	for _, check := range checks {
+		result := runHealthCheck(check)
+		results = append(results, result)
+		if !result.Success {
+			return results, errors.Errorf("Health check %s failed: %s", check.Name, result.Error)
+		}
+	}
+	return results, nil
+}
+
+// runHealthCheck probes the endpoint until it has enough consecutive successes or times out.
+// The error of the last failed probe is reported
+func runHealthCheck(check *HealthCheck) *HealthCheckResult {
+	start := time.Now()
+	result := &HealthCheckResult{Name: check.Name}
+	bodyRegexp := regexp.MustCompile(check.BodyRegex)
+	client := &http.Client{Timeout: check.interval()}
+	deadline := start.Add(check.timeout())
+	successes := 0
+	var lastErr error
+	for {
+		result.Probes++
+		if lastErr = probe(client, check, bodyRegexp); lastErr == nil {
+			successes++
+		} else {
+			successes = 0
+		}
+		if successes == check.successes() {
+			result.Success = true
+			break
+		}
+		if time.Now().Add(check.interval()).After(deadline) {
+			if lastErr == nil {
+				lastErr = errors.Errorf("%d consecutive successes out of %d", successes, check.successes())
+			}
+			result.Error = fmt.Sprintf("timed out after %s: %v", check.timeout(), lastErr)
+			break
+		}
+		time.Sleep(check.interval())
+	}
+	result.Duration = int64(time.Since(start) / time.Millisecond)
+	return result
+}
+
+func probe(client *http.Client, check *HealthCheck, bodyRegexp *regexp.Regexp) error {
+	resp, err := client.Get(check.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHealthCheckBody))
+	if err != nil {
+		return errors.Wrap(err, "Cannot read response")
+	}
+	if check.ExpectedStatus != 0 && resp.StatusCode != check.ExpectedStatus {
+		return errors.Errorf("expected status %d, got %d", check.ExpectedStatus, resp.StatusCode)
+	}
+	if check.ExpectedStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return errors.Errorf("expected a 2xx status, got %d", resp.StatusCode)
+	}
+	if !bodyRegexp.Match(body) {
+		return errors.Errorf("body does not match %s", check.BodyRegex)
+	}
+	return nil
+}
+
+// healthChecksFailed tells if a health check of the release did not pass
+func healthChecksFailed(results []*HealthCheckResult) bool {
+	for _, result := range results {
+		if !result.Success {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_runHealthChecks(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/starting":
+			// Not ready on the first probe
+			if requests == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte(`{"status": "ok"}`))
+		case "/degraded":
+			w.Write([]byte(`{"status": "degraded"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	check := &HealthCheck{Name: "api", URL: server.URL + "/starting", BodyRegex: `"status":\s*"ok"`,
+		Interval: 1, Successes: 2, Timeout: 10}
+	results, err := runHealthChecks([]*HealthCheck{check})
+	if err != nil || len(results) != 1 || !results[0].Success || results[0].Probes != 3 {
+		t.Fatalf("Expected health check to pass after 3 probes, got %v %+v", err, results[0])
+	}
+
+	checks := []*HealthCheck{
+		{Name: "degraded", URL: server.URL + "/degraded", BodyRegex: `"status":\s*"ok"`, Timeout: 1},
+		{Name: "never run", URL: server.URL + "/starting"},
+	}
+	results, err = runHealthChecks(checks)
+	if err == nil || len(results) != 1 || !healthChecksFailed(results) {
+		t.Fatalf("Expected first health check to fail, got %v %+v", err, results)
+	}
+	if !strings.Contains(results[0].Error, "body does not match") {
+		t.Fatalf("Expected body mismatch to be reported, got %s", results[0].Error)
+	}
+
+	check = &HealthCheck{Name: "missing", URL: server.URL + "/missing", ExpectedStatus: http.StatusOK, Timeout: 1}
+	results, _ = runHealthChecks([]*HealthCheck{check})
+	if !strings.Contains(results[0].Error, "expected status 200, got 404") {
+		t.Fatalf("Expected unexpected status to be reported, got %s", results[0].Error)
+	}
+}
+
+func Test_reconcileRelease(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	step := &PipelineStep{StepNumber: 1, TargetNamespace: "prod", Options: &StepOptions{
+		HealthChecks: []*HealthCheck{{Name: "api", URL: server.URL, Timeout: 1}},
+		Hooks:        &StepHooks{OnFailure: []*Hook{{Name: "notify", Command: []string{"true"}}}},
+	}}
+	d := &Deployment{Name: "app", ChartName: "chart"}
+	e := &engine{db: repository, commandHooks: true}
+	hc := e.newHookContext(d, step, "app-prod", &releaseInput{imageTag: "1.0.0"}, "")
+
+	release := &Release{Name: "app-prod", Namespace: "prod"}
+	if outcome := e.reconcileRelease(release, Deployed, step, hc); outcome != Failed {
+		t.Fatalf("Expected failing health check to fail the release, got %v", outcome)
+	}
+	if len(release.HealthChecks) != 1 || release.HealthChecks[0].Success {
+		t.Fatalf("Expected health check results on the release, got %+v", release.HealthChecks)
+	}
+	if len(release.Hooks) != 1 || release.Hooks[0].Phase != OnFailureHook || !release.Hooks[0].Success {
+		t.Fatalf("Expected on_failure hooks to run, got %+v", release.Hooks)
+	}
+
+	// Health checks are not run when helm reports a failure
+	release = &Release{Name: "app-prod", Namespace: "prod"}
+	if outcome := e.reconcileRelease(release, Failed, step, hc); outcome != Failed || len(release.HealthChecks) != 0 {
+		t.Fatalf("Expected release to fail without health checks, got %v %+v", outcome, release.HealthChecks)
+	}
+
+	// Steps without health checks are deployed as soon as helm says so
+	release = &Release{Name: "app-int", Namespace: "int"}
+	if outcome := e.reconcileRelease(release, Deployed, &PipelineStep{TargetNamespace: "int"}, nil); outcome != Deployed {
+		t.Fatalf("Expected release to be deployed, got %v", outcome)
+	}
+}
+
+func Test_HealthCheckValid(t *testing.T) {
+	tt := []struct {
+		check *HealthCheck
+		valid bool
+	}{
+		{check: &HealthCheck{Name: "api", URL: "https://api.example.com/health"}, valid: true},
+		{check: &HealthCheck{Name: "api", URL: "http://api", BodyRegex: "ok", Interval: 10, Successes: 3, Timeout: 60}, valid: true},
+		{check: &HealthCheck{URL: "https://api.example.com/health"}},
+		{check: &HealthCheck{Name: "api", URL: "api.example.com/health"}},
+		{check: &HealthCheck{Name: "api", URL: "http://api", ExpectedStatus: 1000}},
+		{check: &HealthCheck{Name: "api", URL: "http://api", BodyRegex: "(ok"}},
+		{check: &HealthCheck{Name: "api", URL: "http://api", Interval: -1}},
+		{check: &HealthCheck{Name: "api", URL: "http://api", Interval: 10, Successes: 3, Timeout: 20}},
+	}
+	for _, tc := range tt {
+		if err := tc.check.valid(); (err == nil) != tc.valid {
+			t.Errorf("Expected health check %+v valid to be %v, got %v", tc.check, tc.valid, err)
+		}
+	}
+}
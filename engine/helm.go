@@ -0,0 +1,37 @@
+package engine
+
+import "github.com/vgheri/gennaker/helm"
+
+// helmClient runs the helm operations releasing deployments
+type helmClient interface {
+	GetRepositoryName(repositoryURL string) (string, error)
+	InstallOrUpgrade(releaseName, namespace, repositoryName, chartName string, valuesFilePaths []string,
+		options *helm.InstallOptions) (string, error)
+	Status(releaseName string) (helm.ReleaseStatus, string, error)
+	Rollback(releaseName string, revision int) (string, error)
+	Delete(releaseName string, purge bool) (string, error)
+}
+
+// helmCLI is a helmClient running the helm command
+type helmCLI struct{}
+
+func (helmCLI) GetRepositoryName(repositoryURL string) (string, error) {
+	return helm.GetRepositoryName(repositoryURL)
+}
+
+func (helmCLI) InstallOrUpgrade(releaseName, namespace, repositoryName, chartName string, valuesFilePaths []string,
+	options *helm.InstallOptions) (string, error) {
+	return helm.InstallOrUpgrade(releaseName, namespace, repositoryName, chartName, valuesFilePaths, options)
+}
+
+func (helmCLI) Status(releaseName string) (helm.ReleaseStatus, string, error) {
+	return helm.Status(releaseName)
+}
+
+func (helmCLI) Rollback(releaseName string, revision int) (string, error) {
+	return helm.Rollback(releaseName, revision)
+}
+
+func (helmCLI) Delete(releaseName string, purge bool) (string, error) {
+	return helm.Delete(releaseName, purge)
+}
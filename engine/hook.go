@@ -0,0 +1,325 @@
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Phases of the hooks of a step
+const (
+	PreDeployHook  = "pre_deploy"
+	PostDeployHook = "post_deploy"
+	OnFailureHook  = "on_failure"
+)
+
+const (
+	// defaultHookTimeout applies to hooks without timeout, in seconds
+	defaultHookTimeout = 60
+	// maxHookOutput is the number of bytes of output kept for each hook
+	maxHookOutput = 64 * 1024
+	// commandWaitDelay is how long the output of a command is read once it exited or was killed
+	commandWaitDelay = 2 * time.Second
+)
+
+// errCommandHooksDisabled is the error of command hooks when the server does not allow them
+var errCommandHooksDisabled = errors.New("Command hooks are disabled on this server")
+
+// envNameRegexp matches the characters which cannot appear in environment variable names
+var envNameRegexp = regexp.MustCompile(`[^A-Z0-9_]`)
+
+//StepHooks are run before and after the helm operation of a step.
+//PostDeploy hooks run once the workloads of the release rolled out, before the health checks of the step.
+//OnFailure hooks run when the release of the step is found to have failed
+type StepHooks struct {
+	PreDeploy  []*Hook `json:"pre_deploy,omitempty" yaml:"pre_deploy,omitempty"`
+	PostDeploy []*Hook `json:"post_deploy,omitempty" yaml:"post_deploy,omitempty"`
+	OnFailure  []*Hook `json:"on_failure,omitempty" yaml:"on_failure,omitempty"`
+}
+
+//Hook is either an HTTP webhook or a local command, run from the folder of the chart.
+//Timeout is in seconds
+type Hook struct {
+	Name    string   `json:"name" yaml:"name"`
+	Webhook *Webhook `json:"webhook,omitempty" yaml:"webhook,omitempty"`
+	Command []string `json:"command,omitempty" yaml:"command,omitempty"`
+	Timeout int      `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+}
+
+//Webhook receives the release context as a JSON body.
+//Any 2xx status is a success when ExpectedStatus is 0
+type Webhook struct {
+	URL            string            `json:"url" yaml:"url"`
+	Method         string            `json:"method,omitempty" yaml:"method,omitempty"`
+	Headers        map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	ExpectedStatus int               `json:"expected_status,omitempty" yaml:"expected_status,omitempty"`
+}
+
+//HookResult is the outcome of a hook, stored with the release
+type HookResult struct {
+	Name     string `json:"name"`
+	Phase    string `json:"phase"`
+	Success  bool   `json:"success"`
+	Output   string `json:"output,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Duration int64  `json:"duration_ms"`
+}
+
+// valid checks the hook declared in gennaker.yml
+func (h *Hook) valid() error {
+	if h == nil || len(strings.TrimSpace(h.Name)) == 0 {
+		return errors.New("Hooks must have a name")
+	}
+	if (h.Webhook == nil) == (len(h.Command) == 0) {
+		return errors.Errorf("Hook %s must have either a webhook or a command", h.Name)
+	}
+	if h.Timeout < 0 {
+		return errors.Errorf("Timeout of hook %s cannot be negative", h.Name)
+	}
+	if h.Webhook != nil {
+		if !strings.HasPrefix(h.Webhook.URL, "http://") && !strings.HasPrefix(h.Webhook.URL, "https://") {
+			return errors.Errorf("Webhook of hook %s must have an http or https url", h.Name)
+		}
+		if h.Webhook.ExpectedStatus != 0 && (h.Webhook.ExpectedStatus < 100 || h.Webhook.ExpectedStatus > 599) {
+			return errors.Errorf("Expected status of hook %s is not a valid HTTP status", h.Name)
+		}
+	}
+	return nil
+}
+
+func (h *Hook) timeout() time.Duration {
+	if h.Timeout == 0 {
+		return defaultHookTimeout * time.Second
+	}
+	return time.Duration(h.Timeout) * time.Second
+}
+
+// hooks returns the hooks of the step for a phase
+func (s *PipelineStep) hooks(phase string) []*Hook {
+	if s == nil || s.Options == nil || s.Options.Hooks == nil {
+		return nil
+	}
+	switch phase {
+	case PreDeployHook:
+		return s.Options.Hooks.PreDeploy
+	case PostDeployHook:
+		return s.Options.Hooks.PostDeploy
+	default:
+		return s.Options.Hooks.OnFailure
+	}
+}
+
+// commandHook returns the name of the first command hook of the step, if any
+func (s *PipelineStep) commandHook() string {
+	for _, phase := range []string{PreDeployHook, PostDeployHook, OnFailureHook} {
+		for _, hook := range s.hooks(phase) {
+			if len(hook.Command) != 0 {
+				return hook.Name
+			}
+		}
+	}
+	return ""
+}
+
+// hookContext describes the release to the hooks, as a JSON body for webhooks
+// and as environment variables for commands
+type hookContext struct {
+	Deployment   string            `json:"deployment"`
+	Namespace    string            `json:"namespace"`
+	Step         int               `json:"step"`
+	Release      string            `json:"release"`
+	Chart        string            `json:"chart"`
+	ChartVersion string            `json:"chart_version,omitempty"`
+	ImageTag     string            `json:"image_tag"`
+	ImageTags    map[string]string `json:"image_tags,omitempty"`
+	Phase        string            `json:"phase"`
+	dir          string
+	// commands tells if command hooks may run
+	commands bool
+}
+
+func (e *engine) newHookContext(d *Deployment, step *PipelineStep, releaseName string, input *releaseInput,
+	chartDir string) *hookContext {
+	return &hookContext{
+		Deployment:   d.Name,
+		Namespace:    step.TargetNamespace,
+		Step:         step.StepNumber,
+		Release:      releaseName,
+		Chart:        d.ChartName,
+		ChartVersion: d.ChartVersion,
+		ImageTag:     input.imageTag,
+		ImageTags:    input.imageTags,
+		dir:          chartDir,
+		commands:     e.commandHooks,
+	}
+}
+
+// env returns the context as GENNAKER_* environment variables.
+// The image tag of each component is in GENNAKER_IMAGE_TAG_<COMPONENT>
+func (c *hookContext) env() []string {
+	env := []string{
+		"GENNAKER_DEPLOYMENT=" + c.Deployment,
+		"GENNAKER_NAMESPACE=" + c.Namespace,
+		"GENNAKER_STEP=" + strconv.Itoa(c.Step),
+		"GENNAKER_RELEASE=" + c.Release,
+		"GENNAKER_CHART=" + c.Chart,
+		"GENNAKER_CHART_VERSION=" + c.ChartVersion,
+		"GENNAKER_IMAGE_TAG=" + c.ImageTag,
+		"GENNAKER_HOOK_PHASE=" + c.Phase,
+	}
+	for _, component := range sortedKeys(c.ImageTags) {
+		name := envNameRegexp.ReplaceAllString(strings.ToUpper(component), "_")
+		env = append(env, fmt.Sprintf("GENNAKER_IMAGE_TAG_%s=%s", name, c.ImageTags[component]))
+	}
+	return env
+}
+
+// runHooks runs the hooks of a phase in order, stopping at the first failure
+func runHooks(hooks []*Hook, hc *hookContext, phase string) ([]*HookResult, error) {
+	phaseContext := *hc
+	phaseContext.Phase = phase
+	var results []*HookResult
+	for _, hook := range hooks {
+		result := runHook(hook, &phaseContext)
+		results = append(results, result)
+		if !result.Success {
+			return results, errors.Errorf("Hook %s failed: %s", hook.Name, result.Error)
+		}
+	}
+	return results, nil
+}
+
+func runHook(hook *Hook, hc *hookContext) *HookResult {
+	start := time.Now()
+	var output string
+	var err error
+	if hook.Webhook != nil {
+		output, err = callWebhook(hook.Webhook, hc, hook.timeout())
+	} else if !hc.commands {
+		err = errCommandHooksDisabled
+	} else {
+		output, err = runCommand(hook.Command, hc, hook.timeout())
+	}
+	result := &HookResult{
+		Name:     hook.Name,
+		Phase:    hc.Phase,
+		Success:  err == nil,
+		Output:   output,
+		Duration: int64(time.Since(start) / time.Millisecond),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func callWebhook(webhook *Webhook, hc *hookContext, timeout time.Duration) (string, error) {
+	body, err := json.Marshal(hc)
+	if err != nil {
+		return "", errors.Wrap(err, "Cannot encode release context")
+	}
+	method := webhook.Method
+	if len(method) == 0 {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequest(method, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", errors.Wrap(err, "Cannot create webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range webhook.Headers {
+		req.Header.Set(name, value)
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxHookOutput))
+	if err != nil {
+		return "", errors.Wrap(err, "Cannot read webhook response")
+	}
+	output := string(content)
+	if webhook.ExpectedStatus != 0 && resp.StatusCode != webhook.ExpectedStatus {
+		return output, errors.Errorf("expected status %d, got %d", webhook.ExpectedStatus, resp.StatusCode)
+	}
+	if webhook.ExpectedStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return output, errors.Errorf("expected a 2xx status, got %d", resp.StatusCode)
+	}
+	return output, nil
+}
+
+func runCommand(command []string, hc *hookContext, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Dir = hc.dir
+	cmd.Env = append(os.Environ(), hc.env()...)
+	output := &limitedBuffer{limit: maxHookOutput}
+	cmd.Stdout = output
+	cmd.Stderr = output
+	// Processes started by the command may keep its output open after it was killed
+	cmd.WaitDelay = commandWaitDelay
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return output.String(), errors.Errorf("timed out after %s", timeout)
+	}
+	return output.String(), err
+}
+
+// limitedBuffer keeps the first bytes written to it, discarding the rest
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - b.Len(); remaining > 0 {
+		if len(p) > remaining {
+			b.Buffer.Write(p[:remaining])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
+
+// hooksFailed tells if a hook of the release did not succeed
+func hooksFailed(results []*HookResult) bool {
+	for _, result := range results {
+		if !result.Success {
+			return true
+		}
+	}
+	return false
+}
+
+// formatHookResults summarizes hook results for reports
+func formatHookResults(namespace string, results []*HookResult) string {
+	lines := make([]string, 0, len(results))
+	for _, result := range results {
+		status := "succeeded"
+		if !result.Success {
+			status = fmt.Sprintf("failed: %s", result.Error)
+		}
+		line := fmt.Sprintf("%s hook %s in namespace %s %s", result.Phase, result.Name, namespace, status)
+		if len(result.Output) != 0 {
+			line = fmt.Sprintf("%s\n%s", line, result.Output)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
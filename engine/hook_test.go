@@ -0,0 +1,151 @@
+package engine
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vgheri/gennaker/kube"
+)
+
+func Test_runHooks(t *testing.T) {
+	d := &Deployment{Name: "app", ChartName: "chart", ChartVersion: "0.1.0"}
+	step := &PipelineStep{StepNumber: 2, TargetNamespace: "ppd"}
+	input := &releaseInput{imageTag: "1.0.0", imageTags: map[string]string{"api": "1.0.0", "queue-worker": "0.9.0"}}
+	hc := (&engine{commandHooks: true}).newHookContext(d, step, "app-ppd", input, "")
+
+	hooks := []*Hook{
+		{Name: "env", Command: []string{"sh", "-c", "echo $GENNAKER_NAMESPACE $GENNAKER_HOOK_PHASE $GENNAKER_IMAGE_TAG_QUEUE_WORKER"}},
+		{Name: "fail", Command: []string{"sh", "-c", "echo migration failed; exit 3"}},
+		{Name: "never run", Command: []string{"true"}},
+	}
+	results, err := runHooks(hooks, hc, PreDeployHook)
+	if err == nil {
+		t.Fatalf("Expected failing hook to stop the hooks")
+	}
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 hooks to run, got %d", len(results))
+	}
+	if !results[0].Success || results[0].Output != "ppd pre_deploy 0.9.0\n" || results[0].Phase != PreDeployHook {
+		t.Fatalf("Expected release context in environment, got %+v", results[0])
+	}
+	if results[1].Success || results[1].Output != "migration failed\n" || !hooksFailed(results) {
+		t.Fatalf("Expected hook to fail with its output, got %+v", results[1])
+	}
+
+	results, err = runHooks([]*Hook{{Name: "slow", Command: []string{"sleep", "5"}, Timeout: 1}}, hc, PreDeployHook)
+	if err == nil || !strings.Contains(results[0].Error, "timed out") {
+		t.Fatalf("Expected hook to time out, got %+v", results[0])
+	}
+
+	// Processes left by a command which timed out do not hold the hook
+	start := time.Now()
+	results, err = runHooks([]*Hook{{Name: "daemon", Command: []string{"sh", "-c", "sleep 30 & sleep 30"}, Timeout: 1}}, hc, PreDeployHook)
+	if err == nil || time.Since(start) > 10*time.Second {
+		t.Fatalf("Expected hook to stop once timed out, got %+v after %s", results[0], time.Since(start))
+	}
+
+	var received hookContext
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("smoke tests queued"))
+	}))
+	defer server.Close()
+	webhook := &Webhook{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer token"}}
+	results, err = runHooks([]*Hook{{Name: "smoke", Webhook: webhook}}, hc, PostDeployHook)
+	if err != nil || results[0].Output != "smoke tests queued" {
+		t.Fatalf("Expected webhook to succeed, got %v %+v", err, results)
+	}
+	if received.Release != "app-ppd" || received.Phase != PostDeployHook || received.ImageTags["api"] != "1.0.0" {
+		t.Fatalf("Expected release context in webhook body, got %+v", received)
+	}
+	webhook.ExpectedStatus = http.StatusOK
+	if _, err = runHooks([]*Hook{{Name: "smoke", Webhook: webhook}}, hc, PostDeployHook); err == nil {
+		t.Fatalf("Expected webhook to fail with unexpected status")
+	}
+}
+
+func Test_commandHooksDisabled(t *testing.T) {
+	d := &Deployment{Name: "app", ChartName: "chart"}
+	step := &PipelineStep{TargetNamespace: "prod", Options: &StepOptions{
+		Hooks: &StepHooks{OnFailure: []*Hook{{Name: "notify", Command: []string{"true"}}}},
+	}}
+	e := &engine{db: repository}
+	if _, err := e.planRelease(d, []*PipelineStep{step}, &releaseInput{imageTag: "1.0.0"}); err == nil ||
+		!strings.Contains(err.Error(), "Hook notify of namespace prod runs a command") {
+		t.Fatalf("Expected command hooks to be refused, got %v", err)
+	}
+
+	// Releases planned before the server disabled them do not run them either
+	hc := e.newHookContext(d, step, "app-prod", &releaseInput{imageTag: "1.0.0"}, "")
+	results, err := runHooks(step.hooks(OnFailureHook), hc, OnFailureHook)
+	if err == nil || results[0].Success || results[0].Error != errCommandHooksDisabled.Error() {
+		t.Fatalf("Expected command hook not to run, got %+v", results)
+	}
+}
+
+func Test_HookValid(t *testing.T) {
+	tt := []struct {
+		hook  *Hook
+		valid bool
+	}{
+		{hook: &Hook{Name: "migrate", Command: []string{"./migrate.sh"}}, valid: true},
+		{hook: &Hook{Name: "smoke", Webhook: &Webhook{URL: "https://ci.example.com", ExpectedStatus: 202}}, valid: true},
+		{hook: &Hook{Command: []string{"./migrate.sh"}}},
+		{hook: &Hook{Name: "both", Command: []string{"true"}, Webhook: &Webhook{URL: "https://ci.example.com"}}},
+		{hook: &Hook{Name: "none"}},
+		{hook: &Hook{Name: "ftp", Webhook: &Webhook{URL: "ftp://ci.example.com"}}},
+		{hook: &Hook{Name: "status", Webhook: &Webhook{URL: "http://ci.example.com", ExpectedStatus: 42}}},
+		{hook: &Hook{Name: "timeout", Command: []string{"true"}, Timeout: -1}},
+	}
+	for _, tc := range tt {
+		if err := tc.hook.valid(); (err == nil) != tc.valid {
+			t.Errorf("Expected hook %+v valid to be %v, got %v", tc.hook, tc.valid, err)
+		}
+	}
+}
+
+func Test_reconcileReleasePostDeployHooks(t *testing.T) {
+	defer func(interval time.Duration) { rolloutPollInterval = interval }(rolloutPollInterval)
+	rolloutPollInterval = time.Millisecond
+
+	step := &PipelineStep{StepNumber: 1, TargetNamespace: "prod", Options: &StepOptions{
+		Hooks: &StepHooks{
+			PostDeploy: []*Hook{{Name: "smoke", Command: []string{"sh", "-c", "exit 1"}}},
+			OnFailure:  []*Hook{{Name: "notify", Command: []string{"true"}}},
+		},
+	}}
+	d := &Deployment{Name: "app", ChartName: "chart"}
+	hc := (&engine{commandHooks: true}).newHookContext(d, step, "app-prod", &releaseInput{imageTag: "1.0.0"}, "")
+	progressing := &kube.Workload{Kind: kube.DeploymentKind, Name: "api", Desired: 2, Ready: 1, Updated: 2, Observed: true}
+	ready := &kube.Workload{Kind: kube.DeploymentKind, Name: "api", Desired: 2, Ready: 2, Updated: 2, Observed: true}
+	stuck := &kube.Workload{Kind: kube.DeploymentKind, Name: "api", Desired: 2, Ready: 1, Updated: 2, Observed: true, Stuck: true}
+
+	// Post-deploy hooks run once the workloads rolled out, a failing one fails the release
+	e := &engine{db: repository, kube: &fakeKube{polls: [][]*kube.Workload{{progressing}, {ready}}}, watchRollouts: true}
+	release := &Release{Name: "app-prod", Namespace: "prod"}
+	if outcome := e.reconcileRelease(release, Deployed, step, hc); outcome != Failed {
+		t.Fatalf("Expected failing post-deploy hook to fail the release, got %v", outcome)
+	}
+	if len(release.Hooks) != 2 || release.Hooks[0].Phase != PostDeployHook || release.Hooks[1].Phase != OnFailureHook {
+		t.Fatalf("Expected post-deploy then on_failure hooks, got %+v", release.Hooks)
+	}
+
+	// They do not run against workloads which did not roll out
+	e = &engine{db: repository, kube: &fakeKube{polls: [][]*kube.Workload{{stuck}}}, watchRollouts: true}
+	release = &Release{Name: "app-prod", Namespace: "prod"}
+	if outcome := e.reconcileRelease(release, Deployed, step, hc); outcome != Failed {
+		t.Fatalf("Expected release to fail, got %v", outcome)
+	}
+	if len(release.Hooks) != 1 || release.Hooks[0].Phase != OnFailureHook {
+		t.Fatalf("Expected on_failure hooks only, got %+v", release.Hooks)
+	}
+}
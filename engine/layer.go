@@ -0,0 +1,290 @@
+package engine
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The values of a release are merged from the following sources,
+// each one overriding the previous ones:
+//  1. values.yaml of the chart
+//  2. defaults of the deployment, managed by gennaker
+//  3. <namespace>-values.yaml of the chart
+//  4. namespace overrides, managed by gennaker
+//  5. values of the release
+//  6. secret values of the release
+//  7. image tags of the components
+// Templates found in the merged values are then executed,
+// using the variable sets attached to the deployment.
+
+// releaseInput holds what a release brings to its values
+type releaseInput struct {
+	imageTag     string
+	imageTags    map[string]string
+	values       Values
+	secretValues SecretValues
+	// pending holds the image tags released to the same namespaces earlier by the same request, by deployment name
+	pending map[string]string
+}
+
+// valueSources holds the values and the variables managed by gennaker for a deployment
+type valueSources struct {
+	layers       []*ValuesLayer
+	variableSets []*VariableSet
+}
+
+func (e *engine) getValueSources(d *Deployment) (*valueSources, error) {
+	layers, err := e.db.GetValuesLayers(d.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get values")
+	}
+	variableSets, err := e.db.GetDeploymentVariableSets(d.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get variable sets")
+	}
+	return &valueSources{layers: layers, variableSets: variableSets}, nil
+}
+
+// GetValuesLayer returns the current values managed by gennaker for the namespace
+// of a deployment, or its defaults if namespace is empty.
+// A layer with version 0 is returned if no values have been saved yet
+func (e *engine) GetValuesLayer(deploymentName, namespace string) (*ValuesLayer, error) {
+	d, err := e.getValuesLayerDeployment(deploymentName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := e.db.GetValuesLayers(d.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get values")
+	}
+	if layer := currentValuesLayer(layers, namespace); layer != nil {
+		return layer, nil
+	}
+	return &ValuesLayer{DeploymentID: d.ID, Namespace: namespace, Values: Values{}}, nil
+}
+
+// GetValuesLayerHistory returns every version of the values managed by gennaker
+// for the namespace of a deployment, or for its defaults if namespace is empty,
+// from most recent to less recent
+func (e *engine) GetValuesLayerHistory(deploymentName, namespace string) ([]*ValuesLayer, error) {
+	d, err := e.getValuesLayerDeployment(deploymentName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	layers, err := e.db.GetValuesLayerHistory(d.ID, namespace)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get values history")
+	}
+	return layers, nil
+}
+
+// UpdateValuesLayer saves a new version of the values managed by gennaker
+// for the namespace of a deployment, or of its defaults if namespace is empty
+func (e *engine) UpdateValuesLayer(deploymentName, namespace string, values Values) (*ValuesLayer, error) {
+	if err := values.valid(); err != nil {
+		return nil, errors.Wrap(err, "Invalid values")
+	}
+	d, err := e.getValuesLayerDeployment(deploymentName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	if d.archived() {
+		return nil, ErrDeploymentArchived
+	}
+	// Templates are checked against the variables of every namespace using the layer
+	variableSets, err := e.db.GetDeploymentVariableSets(d.ID)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get variable sets")
+	}
+	namespaces := []string{namespace}
+	if len(namespace) == 0 {
+		namespaces = namespaces[:0]
+		for _, step := range flattenPipeline(d.Pipeline) {
+			namespaces = append(namespaces, step.TargetNamespace)
+		}
+	}
+	for _, ns := range namespaces {
+		data := &templateData{Namespace: ns, Deployment: d}
+		_, err = renderValues(values, data, resolveVariables(variableSets, ns), e.resolveSecret(true))
+		if err != nil {
+			return nil, errors.Wrap(err, "Invalid values")
+		}
+	}
+	layer := &ValuesLayer{
+		DeploymentID: d.ID,
+		Namespace:    namespace,
+		Values:       values.copy(),
+	}
+	if err = e.db.CreateValuesLayer(layer); err != nil {
+		return nil, errors.Wrap(err, "Cannot save values")
+	}
+	return layer, nil
+}
+
+// GetMergedValues returns the values helm would receive when releasing again
+// the last release of the namespace
+func (e *engine) GetMergedValues(deploymentName, namespace string) (Values, error) {
+	if len(strings.TrimSpace(namespace)) == 0 {
+		return nil, errors.New("Namespace cannot be empty")
+	}
+	d, err := e.getValuesLayerDeployment(deploymentName, namespace)
+	if err != nil {
+		return nil, err
+	}
+	sources, err := e.getValueSources(d)
+	if err != nil {
+		return nil, err
+	}
+	input := &releaseInput{}
+	if lastRelease := getLastReleaseForNamespace(namespace, d); lastRelease != nil {
+		if err = e.decryptSecretValues(lastRelease); err != nil {
+			return nil, errors.Wrap(err, "Cannot read secret values of the last release")
+		}
+		input.imageTag = lastRelease.ImageTag
+		input.imageTags = lastRelease.ImageTags
+		input.values = lastRelease.Values
+		input.secretValues = lastRelease.SecretValues
+		if len(input.imageTags) == 0 {
+			if input.imageTags, err = resolveImageTags(d, lastRelease.ImageTag, nil); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// Secrets are redacted: the merged values are meant to be displayed
+	return e.mergeValues(d, sources, namespace, input, true)
+}
+
+// getValuesLayerDeployment returns the deployment whose values are managed,
+// checking that namespace is part of its pipeline
+func (e *engine) getValuesLayerDeployment(deploymentName, namespace string) (*Deployment, error) {
+	if len(strings.TrimSpace(deploymentName)) == 0 {
+		return nil, errors.New("Deployment name cannot be empty")
+	}
+	d, err := e.db.GetDeployment(deploymentName)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get deployment")
+	}
+	if len(namespace) == 0 {
+		return d, nil
+	}
+	for _, step := range flattenPipeline(d.Pipeline) {
+		if step.TargetNamespace == namespace {
+			return d, nil
+		}
+	}
+	return nil, errors.Errorf("Namespace %s is not part of the pipeline of deployment %s", namespace, deploymentName)
+}
+
+// mergeValues builds the values of a release in namespace, merging every source in order.
+// Templates are only executed in the values gennaker manages: the values files of the chart
+// are merged untouched, as they may hold templates for helm's tpl function, and so are secret values.
+// Image tags are only set for the components listed in input.imageTags.
+// When redact is set, secrets are redacted instead of being resolved
+func (e *engine) mergeValues(d *Deployment, sources *valueSources, namespace string,
+	input *releaseInput, redact bool) (Values, error) {
+	chartValues, err := readValuesFile(getChartValuesFilePath(e.chartsDir, d.Name, d.ChartName))
+	if err != nil {
+		return nil, err
+	}
+	namespaceValues, err := readValuesFile(getNamespaceValuesFilePath(e.chartsDir, d.Name, d.ChartName, namespace))
+	if err != nil {
+		return nil, err
+	}
+	var defaults, overrides Values
+	if layer := currentValuesLayer(sources.layers, ""); layer != nil {
+		defaults = layer.Values
+	}
+	if layer := currentValuesLayer(sources.layers, namespace); layer != nil {
+		overrides = layer.Values
+	}
+	secretValues := Values(input.secretValues)
+	if redact {
+		secretValues = input.secretValues.redacted()
+	}
+
+	data := &templateData{
+		Namespace:  namespace,
+		ImageTag:   input.imageTag,
+		ImageTags:  input.imageTags,
+		Deployment: d,
+	}
+	variables := resolveVariables(sources.variableSets, namespace)
+	layers := []struct {
+		values Values
+		render bool
+	}{
+		{values: chartValues},
+		{values: defaults, render: true},
+		{values: namespaceValues},
+		{values: overrides, render: true},
+		{values: input.values, render: true},
+		// Secret values are never parsed, so that errors cannot reveal them
+		{values: secretValues},
+	}
+	values := Values{}
+	for _, layer := range layers {
+		if layer.render {
+			if layer.values, err = renderValues(layer.values, data, variables, e.resolveSecret(redact)); err != nil {
+				return nil, err
+			}
+		}
+		values.merge(layer.values)
+	}
+
+	imagePaths := make(map[string]string)
+	for component, valuePath := range d.imagePaths() {
+		if _, found := input.imageTags[component]; found {
+			imagePaths[component] = valuePath
+		}
+	}
+	return buildReleaseValues(imagePaths, input.imageTags, values)
+}
+
+// mergeStepsValues builds the values of every step before anything is released,
+// so that unresolved variables or values not matching the values schema of the chart
+// in any namespace abort the whole release
+func (e *engine) mergeStepsValues(d *Deployment, steps []*PipelineStep, input *releaseInput) ([]Values, error) {
+	sources, err := e.getValueSources(d)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := loadValuesSchema(path.Join(e.chartsDir, d.Name, d.ChartName))
+	if err != nil {
+		return nil, err
+	}
+	stepsValues := make([]Values, 0, len(steps))
+	var fieldErrors []*FieldError
+	for _, step := range steps {
+		values, err := e.mergeValues(d, sources, step.TargetNamespace, input, false)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Invalid values for namespace %s", step.TargetNamespace))
+		}
+		if schema != nil {
+			for _, fieldErr := range schema.validate(values) {
+				fieldErr.Namespace = step.TargetNamespace
+				fieldErrors = append(fieldErrors, fieldErr)
+			}
+		}
+		stepsValues = append(stepsValues, values)
+	}
+	if len(fieldErrors) != 0 {
+		return nil, &ValuesValidationError{Errors: fieldErrors}
+	}
+	return stepsValues, nil
+}
+
+func currentValuesLayer(layers []*ValuesLayer, namespace string) *ValuesLayer {
+	for _, layer := range layers {
+		if layer.Namespace == namespace {
+			return layer
+		}
+	}
+	return nil
+}
+
+func getChartValuesFilePath(generalchartsDirPath, deploymentName, chartName string) string {
+	return path.Join(generalchartsDirPath, deploymentName, chartName, "values.yaml")
+}
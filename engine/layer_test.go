@@ -0,0 +1,145 @@
+package engine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func Test_mergeValues(t *testing.T) {
+	chartsDir, err := ioutil.TempDir("", "gennaker-charts-")
+	if err != nil {
+		t.Fatalf("Cannot create charts folder: %v", err)
+	}
+	defer os.RemoveAll(chartsDir)
+	chartDir := path.Join(chartsDir, "app", "chart")
+	if err = os.MkdirAll(chartDir, 0755); err != nil {
+		t.Fatalf("Cannot create chart folder: %v", err)
+	}
+	files := map[string]string{
+		"values.yaml":      "replicas: 1\ndb:\n  host: localhost\n  port: 5432\nlog: info\nenv: chart\n",
+		"prod-values.yaml": "replicas: 3\nenv: prod-file\n",
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(path.Join(chartDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Cannot write %s: %v", name, err)
+		}
+	}
+
+	e := &engine{db: repository, chartsDir: chartsDir}
+	d := &Deployment{Name: "app", ChartName: "chart"}
+	sources := &valueSources{layers: []*ValuesLayer{
+		{Namespace: "", Version: 2, Values: Values{"db": map[string]interface{}{"host": "pg"}, "env": "defaults", "replicas": 2}},
+		{Namespace: "prod", Version: 1, Values: Values{"env": "prod-layer"}},
+		{Namespace: "dev", Version: 4, Values: Values{"log": "debug"}},
+	}}
+	input := &releaseInput{
+		imageTag:     "1.0.0",
+		imageTags:    map[string]string{DefaultImageComponent: "1.0.0"},
+		values:       Values{"log": "warn", "db": map[string]interface{}{"password": "changeme"}},
+		secretValues: SecretValues{"db": map[string]interface{}{"password": "s3cr3t"}},
+	}
+	values, err := e.mergeValues(d, sources, "prod", input, false)
+	if err != nil {
+		t.Fatalf("Expected values to be merged, got %v", err)
+	}
+	encoded, _ := json.Marshal(values)
+	expected := `{"ImageTag":"1.0.0","db":{"host":"pg","password":"s3cr3t","port":5432},"env":"prod-layer","log":"warn","replicas":3}`
+	if string(encoded) != expected {
+		t.Fatalf("Expected %s, got %s", expected, encoded)
+	}
+
+	// Secret values are not templates
+	input.secretValues = SecretValues{"db": map[string]interface{}{"password": "p{{w}}d"}}
+	values, err = e.mergeValues(d, sources, "prod", input, false)
+	if err != nil {
+		t.Fatalf("Expected secret values to be merged untouched, got %v", err)
+	}
+	if password := values["db"].(map[string]interface{})["password"]; password != "p{{w}}d" {
+		t.Fatalf("Expected password to be merged untouched, got %v", password)
+	}
+
+	// Displayed values do not reveal secrets
+	values, err = e.mergeValues(d, sources, "prod", input, true)
+	if err != nil {
+		t.Fatalf("Expected values to be merged, got %v", err)
+	}
+	if password := values["db"].(map[string]interface{})["password"]; password != redactedValue {
+		t.Fatalf("Expected password to be redacted, got %v", password)
+	}
+
+	// Namespaces without values file nor overrides only get the chart values and the defaults
+	values, err = e.mergeValues(d, sources, "int", &releaseInput{}, false)
+	if err != nil {
+		t.Fatalf("Expected values to be merged, got %v", err)
+	}
+	encoded, _ = json.Marshal(values)
+	expected = `{"db":{"host":"pg","port":5432},"env":"defaults","log":"info","replicas":2}`
+	if string(encoded) != expected {
+		t.Fatalf("Expected %s, got %s", expected, encoded)
+	}
+}
+
+func Test_mergeValuesChartTemplates(t *testing.T) {
+	chartsDir, err := ioutil.TempDir("", "gennaker-charts-")
+	if err != nil {
+		t.Fatalf("Cannot create charts folder: %v", err)
+	}
+	defer os.RemoveAll(chartsDir)
+	chartDir := path.Join(chartsDir, "app", "chart")
+	if err = os.MkdirAll(chartDir, 0755); err != nil {
+		t.Fatalf("Cannot create chart folder: %v", err)
+	}
+	// Templates of the chart are rendered by helm's tpl function, not by gennaker
+	files := map[string]string{
+		"values.yaml":      "fullname: '{{ .Release.Name }}-app'\nhost: '{{ include \"app.host\" . }}'\n",
+		"prod-values.yaml": "ingress: '{{ .Values.host }}.{{ .Release.Namespace }}'\n",
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(path.Join(chartDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Cannot write %s: %v", name, err)
+		}
+	}
+
+	e := &engine{db: repository, chartsDir: chartsDir}
+	d := &Deployment{Name: "app", ChartName: "chart"}
+	sources := &valueSources{layers: []*ValuesLayer{
+		{Namespace: "", Version: 1, Values: Values{"env": "{{ .Namespace }}"}},
+	}}
+	input := &releaseInput{values: Values{"tag": "{{ .ImageTag }}"}, imageTag: "1.0.0"}
+	values, err := e.mergeValues(d, sources, "prod", input, false)
+	if err != nil {
+		t.Fatalf("Expected values to be merged, got %v", err)
+	}
+	encoded, _ := json.Marshal(values)
+	expected := `{"env":"prod","fullname":"{{ .Release.Name }}-app","host":"{{ include \"app.host\" . }}",` +
+		`"ingress":"{{ .Values.host }}.{{ .Release.Namespace }}","tag":"1.0.0"}`
+	if string(encoded) != expected {
+		t.Fatalf("Expected %s, got %s", expected, encoded)
+	}
+}
+
+func Test_UpdateValuesLayer(t *testing.T) {
+	_, err := testEngine.UpdateValuesLayer("", "", Values{"a": 1})
+	if err == nil || !strings.Contains(err.Error(), "Deployment name cannot be empty") {
+		t.Fatalf("Expected an error with an empty deployment name, got %v", err)
+	}
+	_, err = testEngine.UpdateValuesLayer("abc", "", Values{"": 1})
+	if err == nil || !strings.HasPrefix(err.Error(), "Invalid values") {
+		t.Fatalf("Expected invalid values, got %v", err)
+	}
+	_, err = testEngine.UpdateValuesLayer("abc", "prod", Values{"a": 1})
+	if err == nil || !strings.Contains(err.Error(), "is not part of the pipeline") {
+		t.Fatalf("Expected an error with a namespace outside of the pipeline, got %v", err)
+	}
+	layer, err := testEngine.UpdateValuesLayer("abc", "", Values{"a": 1})
+	if err != nil || layer.Namespace != "" || layer.Values["a"] != 1 {
+		t.Fatalf("Expected defaults to be saved, got %+v (%v)", layer, err)
+	}
+	if _, err = testEngine.GetMergedValues("abc", ""); err == nil {
+		t.Fatalf("Expected an error getting merged values without namespace")
+	}
+}
@@ -0,0 +1,181 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vgheri/gennaker/kube"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+//NamespaceSpec declares the namespace of a step. Gennaker creates the namespace or brings it
+//in line with its declaration before each release to it.
+//Labels and annotations which are not declared are left untouched
+type NamespaceSpec struct {
+	Labels        map[string]string   `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Annotations   map[string]string   `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	ResourceQuota *kube.ResourceQuota `json:"resource_quota,omitempty" yaml:"resource_quota,omitempty"`
+	LimitRange    *kube.LimitRange    `json:"limit_range,omitempty" yaml:"limit_range,omitempty"`
+}
+
+// limitRangeTypes are the kinds of objects a LimitRange applies to
+var limitRangeTypes = map[string]bool{"Container": true, "Pod": true, "PersistentVolumeClaim": true}
+
+// valid checks the namespace declared in gennaker.yml
+func (s *NamespaceSpec) valid() error {
+	if s.ResourceQuota != nil {
+		if len(s.ResourceQuota.Hard) == 0 {
+			return errors.New("Resource quota must have hard limits")
+		}
+		if err := validQuantities("resource quota", s.ResourceQuota.Hard); err != nil {
+			return err
+		}
+	}
+	if s.LimitRange != nil {
+		if len(s.LimitRange.Limits) == 0 {
+			return errors.New("Limit range must have limits")
+		}
+		for _, limit := range s.LimitRange.Limits {
+			if limit == nil || !limitRangeTypes[limit.Type] {
+				return errors.New("Limits of the limit range must have a type among Container, Pod and PersistentVolumeClaim")
+			}
+			for _, quantities := range []map[string]string{limit.Default, limit.DefaultRequest, limit.Max, limit.Min} {
+				if err := validQuantities("limit range", quantities); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validQuantities checks the quantities of a resource quota or limit range, ex: cpu: 500m
+func validQuantities(what string, quantities map[string]string) error {
+	for _, name := range sortedKeys(quantities) {
+		if _, err := resource.ParseQuantity(quantities[name]); err != nil {
+			return errors.Errorf("Invalid quantity %s of %s in %s", quantities[name], name, what)
+		}
+	}
+	return nil
+}
+
+// namespaceSpec returns the declaration of the namespace of the step, nil if gennaker does not provision it
+func (s *PipelineStep) namespaceSpec() *NamespaceSpec {
+	if s == nil || s.Options == nil {
+		return nil
+	}
+	return s.Options.Namespace
+}
+
+// provisionNamespace creates the namespace or brings it in line with its declaration,
+// returning what changed
+func provisionNamespace(client kube.Client, name string, spec *NamespaceSpec) ([]string, error) {
+	current, err := client.GetNamespace(name)
+	if err != nil {
+		return nil, err
+	}
+	desired := &kube.Namespace{
+		Name:          name,
+		Labels:        spec.Labels,
+		Annotations:   spec.Annotations,
+		ResourceQuota: spec.ResourceQuota,
+		LimitRange:    spec.LimitRange,
+	}
+	changes := namespaceChanges(current, desired)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	if err = client.ApplyNamespace(desired); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// namespaceChanges describes what must change for current to match desired.
+// Current is nil when the namespace does not exist
+func namespaceChanges(current, desired *kube.Namespace) []string {
+	var changes []string
+	if current == nil {
+		changes = append(changes, fmt.Sprintf("Created namespace %s", desired.Name))
+		current = &kube.Namespace{Name: desired.Name}
+	}
+	changes = append(changes, mapChanges(fmt.Sprintf("label of namespace %s", desired.Name),
+		current.Labels, desired.Labels, sameString)...)
+	changes = append(changes, mapChanges(fmt.Sprintf("annotation of namespace %s", desired.Name),
+		current.Annotations, desired.Annotations, sameString)...)
+	if desired.ResourceQuota != nil {
+		if current.ResourceQuota == nil {
+			changes = append(changes, fmt.Sprintf("Created resource quota of namespace %s", desired.Name))
+		} else {
+			changes = append(changes, mapChanges(fmt.Sprintf("resource quota of namespace %s", desired.Name),
+				current.ResourceQuota.Hard, desired.ResourceQuota.Hard, sameQuantity)...)
+		}
+	}
+	if desired.LimitRange != nil {
+		if current.LimitRange == nil {
+			changes = append(changes, fmt.Sprintf("Created limit range of namespace %s", desired.Name))
+		} else if !sameLimitRange(current.LimitRange, desired.LimitRange) {
+			changes = append(changes, fmt.Sprintf("Updated limit range of namespace %s", desired.Name))
+		}
+	}
+	return changes
+}
+
+// mapChanges describes the keys of desired which are missing or have another value in current,
+// values being compared with same
+func mapChanges(what string, current, desired map[string]string, same func(string, string) bool) []string {
+	var changes []string
+	for _, key := range sortedKeys(desired) {
+		value, found := current[key]
+		switch {
+		case !found:
+			changes = append(changes, fmt.Sprintf("Set %s %s to %s", what, key, desired[key]))
+		case !same(value, desired[key]):
+			changes = append(changes, fmt.Sprintf("Changed %s %s from %s to %s", what, key, value, desired[key]))
+		}
+	}
+	return changes
+}
+
+func sameString(a, b string) bool {
+	return a == b
+}
+
+// sameQuantity tells if two quantities are equal whatever their format, ex: 0.5 and 500m, 1Gi and 1024Mi
+func sameQuantity(a, b string) bool {
+	qa, err := resource.ParseQuantity(a)
+	if err != nil {
+		return a == b
+	}
+	qb, err := resource.ParseQuantity(b)
+	if err != nil {
+		return false
+	}
+	return qa.Cmp(qb) == 0
+}
+
+// sameQuantities tells if two sets of quantities have the same resources with equal quantities
+func sameQuantities(current, desired map[string]string) bool {
+	return len(current) == len(desired) && len(mapChanges("", current, desired, sameQuantity)) == 0
+}
+
+// sameLimitRange tells if two limit ranges have the same limits, in the same order
+func sameLimitRange(current, desired *kube.LimitRange) bool {
+	if len(current.Limits) != len(desired.Limits) {
+		return false
+	}
+	for i, c := range current.Limits {
+		d := desired.Limits[i]
+		if c.Type != d.Type || !sameQuantities(c.Default, d.Default) || !sameQuantities(c.DefaultRequest, d.DefaultRequest) ||
+			!sameQuantities(c.Max, d.Max) || !sameQuantities(c.Min, d.Min) {
+			return false
+		}
+	}
+	return true
+}
+
+// formatNamespaceChanges summarizes the changes made to a namespace for reports
+func formatNamespaceChanges(changes []string) string {
+	return strings.Join(changes, "\n")
+}
@@ -0,0 +1,116 @@
+package engine
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vgheri/gennaker/kube"
+)
+
+func Test_provisionNamespace(t *testing.T) {
+	client := &fakeKube{namespaces: map[string]*kube.Namespace{}}
+	spec := &NamespaceSpec{
+		Labels:        map[string]string{"team": "payments", "env": "prod"},
+		ResourceQuota: &kube.ResourceQuota{Hard: map[string]string{"pods": "40", "requests.cpu": "8"}},
+		LimitRange: &kube.LimitRange{Limits: []*kube.LimitRangeItem{
+			{Type: "Container", Default: map[string]string{"cpu": "500m"}},
+		}},
+	}
+	changes, err := provisionNamespace(client, "prod", spec)
+	expected := []string{
+		"Created namespace prod",
+		"Set label of namespace prod env to prod",
+		"Set label of namespace prod team to payments",
+		"Created resource quota of namespace prod",
+		"Created limit range of namespace prod",
+	}
+	if err != nil || !reflect.DeepEqual(changes, expected) || client.applied != 1 {
+		t.Fatalf("Expected namespace to be created, got %v %v", err, changes)
+	}
+
+	// Nothing is applied when the namespace is in line with its declaration
+	changes, err = provisionNamespace(client, "prod", spec)
+	if err != nil || len(changes) != 0 || client.applied != 1 {
+		t.Fatalf("Expected no change, got %v %v", err, changes)
+	}
+
+	// Undeclared labels are left untouched
+	client.namespaces["prod"] = &kube.Namespace{
+		Name:          "prod",
+		Labels:        map[string]string{"team": "payments", "env": "ppd", "owner": "ops"},
+		ResourceQuota: &kube.ResourceQuota{Hard: map[string]string{"pods": "40", "requests.cpu": "4"}},
+		LimitRange: &kube.LimitRange{Limits: []*kube.LimitRangeItem{
+			{Type: "Container", Default: map[string]string{"cpu": "250m"}},
+		}},
+	}
+	changes, err = provisionNamespace(client, "prod", spec)
+	expected = []string{
+		"Changed label of namespace prod env from ppd to prod",
+		"Changed resource quota of namespace prod requests.cpu from 4 to 8",
+		"Updated limit range of namespace prod",
+	}
+	if err != nil || !reflect.DeepEqual(changes, expected) || client.applied != 2 {
+		t.Fatalf("Expected namespace to be updated, got %v %v", err, changes)
+	}
+
+	// Quantities are compared whatever their format
+	spec = &NamespaceSpec{
+		ResourceQuota: &kube.ResourceQuota{Hard: map[string]string{"requests.cpu": "0.5", "requests.memory": "1Gi"}},
+		LimitRange: &kube.LimitRange{Limits: []*kube.LimitRangeItem{
+			{Type: "Container", Default: map[string]string{"cpu": "1", "memory": "512Mi"}},
+		}},
+	}
+	client.namespaces["prod"] = &kube.Namespace{
+		Name:          "prod",
+		ResourceQuota: &kube.ResourceQuota{Hard: map[string]string{"requests.cpu": "500m", "requests.memory": "1024Mi"}},
+		LimitRange: &kube.LimitRange{Limits: []*kube.LimitRangeItem{
+			{Type: "Container", Default: map[string]string{"cpu": "1000m", "memory": "0.5Gi"}},
+		}},
+	}
+	changes, err = provisionNamespace(client, "prod", spec)
+	if err != nil || len(changes) != 0 || client.applied != 2 {
+		t.Fatalf("Expected no change, got %v %v", err, changes)
+	}
+	spec.ResourceQuota.Hard["requests.memory"] = "2Gi"
+	spec.LimitRange.Limits[0].Default["memory"] = "256Mi"
+	changes, err = provisionNamespace(client, "prod", spec)
+	expected = []string{
+		"Changed resource quota of namespace prod requests.memory from 1024Mi to 2Gi",
+		"Updated limit range of namespace prod",
+	}
+	if err != nil || !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("Expected quantities to be updated, got %v %v", err, changes)
+	}
+}
+
+func Test_NamespaceSpecValid(t *testing.T) {
+	tt := []struct {
+		spec  *NamespaceSpec
+		valid bool
+	}{
+		{spec: &NamespaceSpec{ResourceQuota: &kube.ResourceQuota{Hard: map[string]string{"requests.cpu": "0.5"}}}, valid: true},
+		{spec: &NamespaceSpec{ResourceQuota: &kube.ResourceQuota{}}},
+		{spec: &NamespaceSpec{ResourceQuota: &kube.ResourceQuota{Hard: map[string]string{"pods": "many"}}}},
+		{spec: &NamespaceSpec{LimitRange: &kube.LimitRange{Limits: []*kube.LimitRangeItem{
+			{Type: "Container", Max: map[string]string{"memory": "1Gi"}}}}}, valid: true},
+		{spec: &NamespaceSpec{LimitRange: &kube.LimitRange{Limits: []*kube.LimitRangeItem{
+			{Type: "Container", Max: map[string]string{"memory": "1 GB"}}}}}},
+		{spec: &NamespaceSpec{LimitRange: &kube.LimitRange{Limits: []*kube.LimitRangeItem{{Type: "Node"}}}}},
+	}
+	for _, tc := range tt {
+		if err := tc.spec.valid(); (err == nil) != tc.valid {
+			t.Errorf("Expected namespace %+v valid to be %v, got %v", tc.spec, tc.valid, err)
+		}
+	}
+}
+
+func Test_planReleaseNamespaceProvisioning(t *testing.T) {
+	e := &engine{db: repository}
+	d := &Deployment{Name: "app", ChartName: "chart"}
+	steps := []*PipelineStep{{TargetNamespace: "prod", Options: &StepOptions{
+		Namespace: &NamespaceSpec{Labels: map[string]string{"team": "payments"}},
+	}}}
+	if _, err := e.planRelease(d, steps, &releaseInput{imageTag: "1.0.0"}); err == nil {
+		t.Fatal("Expected provisioning without access to Kubernetes to be refused")
+	}
+}
@@ -0,0 +1,118 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// NotificationStatus tracks the processing of a release notification
+type NotificationStatus string
+
+// Release notification statutes
+const (
+	NotificationPending NotificationStatus = "pending"
+	NotificationDone    NotificationStatus = "done"
+	NotificationFailed  NotificationStatus = "failed"
+)
+
+//NotificationRecord keeps track of a release notification and of its outcome,
+//so that duplicate notifications can be answered without deploying again.
+//Duplicate is set when the record is returned for a notification already received
+type NotificationRecord struct {
+	ID             int                `json:"id"`
+	DeploymentID   int                `json:"deployment_id"`
+	IdempotencyKey string             `json:"idempotency_key,omitempty"`
+	ImageTag       string             `json:"image_tag"`
+	ValuesHash     string             `json:"values_hash"`
+	Status         NotificationStatus `json:"status"`
+	Reports        []string           `json:"reports"`
+	Duplicate      bool               `json:"duplicate"`
+	CreationDate   time.Time          `json:"creation_date"`
+}
+
+// findDuplicateNotification returns the record of a previous notification matching
+// the idempotency key, or the image tag and the release values if no key is provided.
+// Returns nil if the notification has not been received yet
+func (e *engine) findDuplicateNotification(d *Deployment, notification *ReleaseNotification, valuesHash string) (*NotificationRecord, error) {
+	if len(notification.IdempotencyKey) == 0 && e.deduplicationWindow <= 0 {
+		return nil, nil
+	}
+	// An idempotency key identifies a notification for good, whatever the deduplication window
+	var since time.Time
+	if len(notification.IdempotencyKey) == 0 {
+		since = e.deduplicationStart()
+	}
+	record, err := e.db.GetReleaseNotification(d.ID, notification.IdempotencyKey,
+		notification.ImageTag, valuesHash, since)
+	if err == ErrResourceNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot look for duplicate notifications")
+	}
+	record.Duplicate = true
+	return record, nil
+}
+
+// registerNotification saves the notification as pending. If a notification with the same idempotency key,
+// or without key, the same content within the deduplication window, was registered first,
+// even by a concurrent request, its record is returned instead
+func (e *engine) registerNotification(d *Deployment, notification *ReleaseNotification, valuesHash string) (*NotificationRecord, error) {
+	record := &NotificationRecord{
+		DeploymentID:   d.ID,
+		IdempotencyKey: notification.IdempotencyKey,
+		ImageTag:       notification.ImageTag,
+		ValuesHash:     valuesHash,
+		Status:         NotificationPending,
+		Reports:        []string{},
+	}
+	var since time.Time
+	if len(notification.IdempotencyKey) == 0 {
+		since = e.deduplicationStart()
+	}
+	err := e.db.CreateReleaseNotification(record, since)
+	if err == ErrDuplicateNotification {
+		duplicate, err := e.findDuplicateNotification(d, notification, valuesHash)
+		if err == nil && duplicate == nil {
+			// The duplicate left the deduplication window in the meantime
+			err = ErrDuplicateNotification
+		}
+		return duplicate, err
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot save release notification")
+	}
+	return record, nil
+}
+
+// deduplicationStart returns the start of the deduplication window of notifications without idempotency key,
+// the zero time when they are not deduplicated
+func (e *engine) deduplicationStart() time.Time {
+	if e.deduplicationWindow <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(-e.deduplicationWindow)
+}
+
+// hashReleaseValues returns a digest identifying the release values, the digest
+// of the secret values and the component image tags of a notification
+func hashReleaseValues(releaseValues Values, secretDigest string, imageTags map[string]string) string {
+	hash := sha256.New()
+	// maps are encoded with sorted keys, so equal values share the same encoding
+	if len(releaseValues) != 0 {
+		encoded, _ := json.Marshal(releaseValues)
+		hash.Write(encoded)
+	}
+	if len(secretDigest) != 0 {
+		fmt.Fprintf(hash, "\nsecrets=%s", secretDigest)
+	}
+	for _, component := range sortedKeys(imageTags) {
+		fmt.Fprintf(hash, "\n%s=%s", component, imageTags[component])
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+// notificationRepository holds a notification registered with an idempotency key long ago
+type notificationRepository struct {
+	fakeRepository
+}
+
+func (r notificationRepository) GetReleaseNotification(deploymentID int, idempotencyKey, imageTag, valuesHash string,
+	since time.Time) (*NotificationRecord, error) {
+	if idempotencyKey == "build-42" && since.IsZero() {
+		return &NotificationRecord{ID: 7, IdempotencyKey: idempotencyKey, Status: NotificationDone,
+			Reports: []string{"deployed"}}, nil
+	}
+	return nil, ErrResourceNotFound
+}
+
+func (r notificationRepository) CreateReleaseNotification(record *NotificationRecord, since time.Time) error {
+	if record.IdempotencyKey == "build-42" {
+		return ErrDuplicateNotification
+	}
+	return nil
+}
+
+func Test_registerNotification(t *testing.T) {
+	e := &engine{db: notificationRepository{}, deduplicationWindow: time.Minute}
+	d := &Deployment{ID: 1}
+
+	// A key reused after the deduplication window still identifies the original notification
+	notification := &ReleaseNotification{DeploymentName: "app", ImageTag: "1.0.0", IdempotencyKey: "build-42"}
+	record, err := e.findDuplicateNotification(d, notification, "abc")
+	if err != nil || record == nil || !record.Duplicate || record.ID != 7 {
+		t.Fatalf("Expected the original notification, got %+v (%v)", record, err)
+	}
+	record, err = e.registerNotification(d, notification, "abc")
+	if err != nil || record == nil || !record.Duplicate || record.ID != 7 {
+		t.Fatalf("Expected the original notification instead of an error, got %+v (%v)", record, err)
+	}
+
+	notification.IdempotencyKey = "build-43"
+	record, err = e.registerNotification(d, notification, "abc")
+	if err != nil || record.Duplicate || record.Status != NotificationPending {
+		t.Fatalf("Expected a new pending notification, got %+v (%v)", record, err)
+	}
+}
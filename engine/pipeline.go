@@ -1,13 +1,11 @@
 package engine
 
 import (
-	"io/ioutil"
-	"path"
+	"reflect"
 	"sort"
+	"strings"
 
 	"github.com/pkg/errors"
-
-	"gopkg.in/yaml.v2"
 )
 
 type YamlPipelineStep struct {
@@ -15,15 +13,37 @@ type YamlPipelineStep struct {
 	Namespace  string
 	Autodeploy bool
 	ParentStep int `yaml:"parent_step,omitempty"`
+	// ParentSteps and Options are only accepted from version 2
+	ParentSteps []int        `yaml:"parent_steps,omitempty"`
+	Options     *StepOptions `yaml:"options,omitempty"`
+}
+
+// parents returns the step numbers of every parent of the step, sorted
+func (s *YamlPipelineStep) parents() []int {
+	parents := make([]int, 0, len(s.ParentSteps)+1)
+	if s.ParentStep != 0 {
+		parents = append(parents, s.ParentStep)
+	}
+	parents = append(parents, s.ParentSteps...)
+	sort.Ints(parents)
+	return parents
 }
 
 type YamlPipeline struct {
 	Steps []*YamlPipelineStep
 }
 
+// YamlImage maps a component of the chart to the path of the value
+// holding its image tag, ex: image.tag
+type YamlImage struct {
+	Name string
+	Path string
+}
+
 type YamlContent struct {
 	Version  int
 	Pipeline *YamlPipeline
+	Images   []*YamlImage `yaml:"images,omitempty"`
 }
 
 type ByOrder []*YamlPipelineStep
@@ -32,52 +52,217 @@ func (a ByOrder) Len() int           { return len(a) }
 func (a ByOrder) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ByOrder) Less(i, j int) bool { return a[i].Step < a[j].Step }
 
+// buildPipeline reads the pipeline declared in gennaker.yml and returns the steps which are root of the tree
 func buildPipeline(pathToChartOnDisk string) ([]*PipelineStep, error) {
-	yamlContent := YamlContent{}
-	fullPath := path.Join(pathToChartOnDisk, "gennaker.yml")
-	gennakerContent, err := ioutil.ReadFile(fullPath)
-	if err != nil {
-		return nil, errors.Wrap(err, "Read file gennaker.yml failed")
-	}
-	err = yaml.Unmarshal(gennakerContent, &yamlContent)
+	yamlContent, err := readGennakerFile(pathToChartOnDisk)
 	if err != nil {
-		return nil, errors.Wrap(err, "yaml file Umarshal failed")
+		return nil, err
 	}
-	sort.Sort(ByOrder(yamlContent.Pipeline.Steps))
+	return yamlContent.pipeline(), nil
+}
+
+// pipeline builds the tree of steps of a validated gennaker.yml file.
+// Every step is created before being linked to its parent, whatever the order they are listed in
+func (c *YamlContent) pipeline() []*PipelineStep {
+	yamlSteps := make([]*YamlPipelineStep, len(c.Pipeline.Steps))
+	copy(yamlSteps, c.Pipeline.Steps)
+	sort.Sort(ByOrder(yamlSteps))
 	stepsMap := make(map[int]*PipelineStep)
-	for _, s := range yamlContent.Pipeline.Steps {
+	steps := make([]*PipelineStep, 0, len(yamlSteps))
+	for _, s := range yamlSteps {
 		step := &PipelineStep{
-			StepNumber:       s.Step,
-			ParentStepNumber: s.ParentStep,
-			TargetNamespace:  s.Namespace,
-			AutomaticDeploy:  s.Autodeploy,
-			NextSteps:        []*PipelineStep{},
+			StepNumber:      s.Step,
+			TargetNamespace: s.Namespace,
+			AutomaticDeploy: s.Autodeploy,
+			Options:         s.Options,
+			NextSteps:       []*PipelineStep{},
+		}
+		if parents := s.parents(); len(parents) != 0 {
+			step.ParentStepNumber = parents[0]
+			step.ParentStepNumbers = parents
 		}
 		stepsMap[step.StepNumber] = step
-		// Add itself to list of nextsteps of parent step, if any
-		if step.ParentStepNumber > 0 {
-			if parent, found := stepsMap[step.ParentStepNumber]; found {
+		steps = append(steps, step)
+	}
+	return linkPipelineSteps(steps, stepsMap)
+}
+
+// linkPipelineSteps adds each step to the NextSteps of all its parents
+// and returns the steps which are root of the graph
+func linkPipelineSteps(steps []*PipelineStep, stepsMap map[int]*PipelineStep) []*PipelineStep {
+	pipeline := []*PipelineStep{}
+	for _, step := range steps {
+		parents := step.parents()
+		if len(parents) == 0 {
+			pipeline = append(pipeline, step)
+			continue
+		}
+		// Add itself to list of nextsteps of parent steps
+		for _, parentStepNumber := range parents {
+			if parent, found := stepsMap[parentStepNumber]; found {
 				parent.NextSteps = append(parent.NextSteps, step)
 			}
 		}
 	}
-	pipeline := []*PipelineStep{}
-	for _, s := range stepsMap {
-		if s.ParentStepNumber == 0 {
-			pipeline = append(pipeline, s)
-		}
+	return pipeline
+}
+
+// buildImagePaths reads the value paths of the image tags declared in gennaker.yml,
+// indexed by component name.
+// Charts which do not declare any use the top level ImageTag value
+func buildImagePaths(pathToChartOnDisk string) (map[string]string, error) {
+	yamlContent, err := readGennakerFile(pathToChartOnDisk)
+	if err != nil {
+		return nil, err
+	}
+	if len(yamlContent.Images) == 0 {
+		return defaultImagePaths(), nil
 	}
+	imagePaths := make(map[string]string)
+	for _, image := range yamlContent.Images {
+		imagePaths[image.Name] = image.Path
+	}
+	return imagePaths, nil
+}
 
-	return pipeline, nil
+func defaultImagePaths() map[string]string {
+	return map[string]string{DefaultImageComponent: imageTag}
 }
 
+// getPipelineForNamespace returns the steps following the step of namespace,
+// wherever it is in the pipeline
 func getPipelineForNamespace(namespace string, pipeline []*PipelineStep) []*PipelineStep {
-	for _, step := range pipeline {
+	if step := getStepForNamespace(namespace, pipeline); step != nil {
+		return step.NextSteps
+	}
+	return nil
+}
+
+// getStepForNamespace returns the step deploying to namespace, nil if there is none
+func getStepForNamespace(namespace string, pipeline []*PipelineStep) *PipelineStep {
+	for _, step := range flattenPipeline(pipeline) {
 		if step.TargetNamespace == namespace {
-			return step.NextSteps
-		} else {
-			return getPipelineForNamespace(namespace, step.NextSteps)
+			return step
+		}
+	}
+	return nil
+}
+
+// flattenPipeline walks the pipeline graph and returns every step it contains once,
+// ordered by step number
+func flattenPipeline(pipeline []*PipelineStep) []*PipelineStep {
+	steps := []*PipelineStep{}
+	visited := make(map[*PipelineStep]bool)
+	var walk func([]*PipelineStep)
+	walk = func(pipeline []*PipelineStep) {
+		for _, step := range pipeline {
+			if visited[step] {
+				continue
+			}
+			visited[step] = true
+			steps = append(steps, step)
+			walk(step.NextSteps)
+		}
+	}
+	walk(pipeline)
+	sort.Slice(steps, func(i, j int) bool { return steps[i].StepNumber < steps[j].StepNumber })
+	return steps
+}
+
+// checkJoinStep tells if a step with several parents can be released with imageTag:
+// every parent must have it deployed successfully. Steps with a single parent are always eligible
+func checkJoinStep(d *Deployment, step *PipelineStep, imageTag string) error {
+	parents := step.parents()
+	if len(parents) < 2 {
+		return nil
+	}
+	stepsByNumber := make(map[int]*PipelineStep)
+	for _, s := range flattenPipeline(d.Pipeline) {
+		stepsByNumber[s.StepNumber] = s
+	}
+	var missing []string
+	for _, parentStepNumber := range parents {
+		parent, found := stepsByNumber[parentStepNumber]
+		if !found {
+			continue
+		}
+		current := getLastReleaseForNamespace(parent.TargetNamespace, d)
+		if current == nil || current.ImageTag != imageTag || current.Status != Deployed {
+			missing = append(missing, parent.TargetNamespace)
 		}
 	}
+	if len(missing) != 0 {
+		return errors.Errorf("Namespace %s waits for image tag %s to be deployed in %s",
+			step.TargetNamespace, imageTag, strings.Join(missing, ", "))
+	}
 	return nil
 }
+
+// diffPipeline compares the current pipeline of a deployment with the desired one
+// and returns the steps to add, remove and update to go from one to the other
+func diffPipeline(current, desired []*PipelineStep) *PipelineDiff {
+	diff := &PipelineDiff{
+		Added:   []*PipelineStep{},
+		Removed: []*PipelineStep{},
+		Updated: []*PipelineStep{},
+	}
+	currentSteps := make(map[int]*PipelineStep)
+	for _, s := range flattenPipeline(current) {
+		currentSteps[s.StepNumber] = s
+	}
+	desiredSteps := make(map[int]*PipelineStep)
+	for _, s := range flattenPipeline(desired) {
+		desiredSteps[s.StepNumber] = s
+		existing, found := currentSteps[s.StepNumber]
+		if !found {
+			diff.Added = append(diff.Added, detachStep(s))
+			continue
+		}
+		if !sameParents(existing, s) ||
+			existing.TargetNamespace != s.TargetNamespace ||
+			existing.AutomaticDeploy != s.AutomaticDeploy ||
+			!sameStepOptions(existing.Options, s.Options) {
+			updated := detachStep(s)
+			updated.ID = existing.ID
+			updated.DeploymentID = existing.DeploymentID
+			diff.Updated = append(diff.Updated, updated)
+		}
+	}
+	for _, s := range flattenPipeline(current) {
+		if _, found := desiredSteps[s.StepNumber]; !found {
+			diff.Removed = append(diff.Removed, detachStep(s))
+		}
+	}
+	return diff
+}
+
+// detachStep returns a copy of the step without its children
+func detachStep(step *PipelineStep) *PipelineStep {
+	detached := *step
+	detached.NextSteps = nil
+	return &detached
+}
+
+// sameStepOptions compares options of steps, steps without options having the default ones
+func sameStepOptions(a, b *StepOptions) bool {
+	if a == nil {
+		a = &StepOptions{}
+	}
+	if b == nil {
+		b = &StepOptions{}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func sameParents(a, b *PipelineStep) bool {
+	aParents, bParents := a.parents(), b.parents()
+	if len(aParents) != len(bParents) {
+		return false
+	}
+	for i := range aParents {
+		if aParents[i] != bParents[i] {
+			return false
+		}
+	}
+	return true
+}
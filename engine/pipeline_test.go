@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"io/ioutil"
 	"os"
 	"path"
 	"testing"
@@ -50,3 +51,171 @@ func Test_buildPipeline(t *testing.T) {
 	}
 
 }
+
+func Test_diffPipeline(t *testing.T) {
+	current := []*PipelineStep{
+		&PipelineStep{
+			ID:              10,
+			StepNumber:      1,
+			TargetNamespace: "int",
+			AutomaticDeploy: true,
+			NextSteps: []*PipelineStep{
+				&PipelineStep{
+					ID:               11,
+					StepNumber:       2,
+					ParentStepNumber: 1,
+					TargetNamespace:  "ppd",
+					NextSteps: []*PipelineStep{
+						&PipelineStep{
+							ID:               12,
+							StepNumber:       3,
+							ParentStepNumber: 2,
+							TargetNamespace:  "prod",
+						},
+					},
+				},
+			},
+		},
+	}
+	desired := []*PipelineStep{
+		&PipelineStep{
+			StepNumber:      1,
+			TargetNamespace: "int",
+			AutomaticDeploy: true,
+			NextSteps: []*PipelineStep{
+				&PipelineStep{
+					StepNumber:       2,
+					ParentStepNumber: 1,
+					TargetNamespace:  "ppd",
+					AutomaticDeploy:  true,
+				},
+			},
+		},
+		&PipelineStep{
+			StepNumber:      4,
+			TargetNamespace: "load",
+			AutomaticDeploy: true,
+		},
+	}
+
+	diff := diffPipeline(current, desired)
+	if len(diff.Added) != 1 || diff.Added[0].StepNumber != 4 {
+		t.Fatalf("Expected step 4 to be added, got %+v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].StepNumber != 3 || diff.Removed[0].ID != 12 {
+		t.Fatalf("Expected step 3 to be removed, got %+v", diff.Removed)
+	}
+	if len(diff.Updated) != 1 || diff.Updated[0].StepNumber != 2 ||
+		diff.Updated[0].ID != 11 || !diff.Updated[0].AutomaticDeploy {
+		t.Fatalf("Expected step 2 to be updated, got %+v", diff.Updated)
+	}
+	if diff.Empty() {
+		t.Fatalf("Expected diff not to be empty")
+	}
+
+	diff = diffPipeline(desired, desired)
+	if !diff.Empty() {
+		t.Fatalf("Expected empty diff comparing a pipeline with itself, got %+v", diff)
+	}
+}
+
+func Test_buildImagePaths(t *testing.T) {
+	gopath := os.Getenv("GOPATH")
+	destination := path.Join(gopath, "src", "github.com", "vgheri", "gennaker", "examples", "simple_pipeline")
+	imagePaths, err := buildImagePaths(destination)
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if len(imagePaths) != 1 || imagePaths[DefaultImageComponent] != "ImageTag" {
+		t.Fatalf("Expected default image path, got %v", imagePaths)
+	}
+
+	destination = path.Join(gopath, "src", "github.com", "vgheri", "gennaker", "examples", "multi_image")
+	imagePaths, err = buildImagePaths(destination)
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if len(imagePaths) != 2 || imagePaths["api"] != "image.tag" || imagePaths["worker"] != "worker.image.tag" {
+		t.Fatalf("Malformed image paths %v", imagePaths)
+	}
+
+	dir, err := ioutil.TempDir("", "gennaker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := []byte("version: 1\nimages:\n  - name: api\n    path: image.tag\n  - name: api\n    path: api.tag\n")
+	if err = ioutil.WriteFile(path.Join(dir, "gennaker.yml"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = buildImagePaths(dir)
+	if err == nil {
+		t.Fatalf("Expected error with a component declared twice")
+	}
+}
+
+func Test_pipelineGraph(t *testing.T) {
+	content := `version: 2
+pipeline:
+  steps:
+    - step: 1
+      namespace: int
+    - step: 2
+      namespace: ppd
+      parent_step: 1
+    - step: 3
+      namespace: load-test
+      parent_step: 1
+    - step: 4
+      namespace: prod
+      parent_steps: [3, 2]
+    - step: 5
+      namespace: sandbox
+`
+	yamlContent, err := parseGennakerFile([]byte(content))
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	pipeline := yamlContent.pipeline()
+	if len(pipeline) != 2 {
+		t.Fatalf("Expected 2 roots, got %+v", pipeline)
+	}
+	steps := flattenPipeline(pipeline)
+	if len(steps) != 5 {
+		t.Fatalf("Expected join step to be listed once, got %d steps", len(steps))
+	}
+	prod := steps[3]
+	if prod.ParentStepNumber != 2 || len(prod.ParentStepNumbers) != 2 || prod.ParentStepNumbers[1] != 3 {
+		t.Fatalf("Malformed join step %+v", prod)
+	}
+	for _, namespace := range []string{"ppd", "load-test"} {
+		next := getPipelineForNamespace(namespace, pipeline)
+		if len(next) != 1 || next[0] != prod {
+			t.Fatalf("Expected prod to follow %s, got %+v", namespace, next)
+		}
+	}
+	if step := getStepForNamespace("sandbox", pipeline); step == nil || step.StepNumber != 5 {
+		t.Fatalf("Expected namespaces under every root to be found, got %+v", step)
+	}
+
+	d := &Deployment{
+		Pipeline: pipeline,
+		// releases are ordered by most recent to less recent
+		Releases: []*Release{
+			&Release{ImageTag: "1.0.1", Namespace: "ppd", Status: Deployed},
+			&Release{ImageTag: "1.0.1", Namespace: "load-test", Status: Failed},
+			&Release{ImageTag: "1.0.0", Namespace: "load-test", Status: Deployed},
+			&Release{ImageTag: "1.0.0", Namespace: "ppd", Status: Deployed},
+		},
+	}
+	if err = checkJoinStep(d, prod, "1.0.1"); err == nil {
+		t.Fatalf("Expected prod to wait for load-test")
+	}
+	if err = checkJoinStep(d, steps[1], "1.0.1"); err != nil {
+		t.Fatalf("Expected steps with a single parent to be eligible, got %v", err)
+	}
+	d.Releases = append([]*Release{&Release{ImageTag: "1.0.1", Namespace: "load-test", Status: Deployed}}, d.Releases...)
+	if err = checkJoinStep(d, prod, "1.0.1"); err != nil {
+		t.Fatalf("Expected prod to be eligible, got %v", err)
+	}
+}
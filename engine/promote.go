@@ -0,0 +1,174 @@
+package engine
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PromoteToTarget releases an image tag to the target namespace, and to the intermediate steps
+// to fill, once it checked the image tag is deployed upstream
+func (e *engine) PromoteToTarget(request *PromoteToTargetRequest) ([]string, error) {
+	if request == nil {
+		return nil, ErrBadRequest
+	}
+	if err := request.valid(); err != nil {
+		return nil, errors.Wrap(err, "Promote request is invalid")
+	}
+	d, err := e.db.GetDeployment(request.DeploymentName)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get deployment")
+	}
+	if d.archived() {
+		return nil, ErrDeploymentArchived
+	}
+	if err = e.checkSecretValues(request.SecretValues); err != nil {
+		return nil, err
+	}
+	steps, source, err := promotionPath(d, request.TargetNamespace, request.ImageTag, request.FillIntermediateSteps)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot promote")
+	}
+	imageTags, err := resolveImageTags(d, source.ImageTag, source.ImageTags)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot promote")
+	}
+	plan, err := e.planRelease(d, steps, &releaseInput{
+		imageTag:     source.ImageTag,
+		imageTags:    imageTags,
+		values:       request.ReleaseValues,
+		secretValues: request.SecretValues,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot promote")
+	}
+	// Steps are released from upstream to the target, each filled step being deployed
+	// before the next one is released: with filled steps, the promotion runs in the background
+	plan.awaited = len(steps) - 1
+	return e.deployPlan(d, plan)
+}
+
+// promotionPath returns the steps to release to promote imageTag to the target namespace,
+// the intermediate steps to fill first and the target last, and the release the image tag comes from.
+// Every upstream step which is not filled must have the image tag deployed successfully
+func promotionPath(d *Deployment, targetNamespace, imageTag string, fill bool) ([]*PipelineStep, *Release, error) {
+	target := getStepForNamespace(targetNamespace, d.Pipeline)
+	if target == nil {
+		return nil, nil, errors.Errorf("Namespace %s is not part of the pipeline", targetNamespace)
+	}
+	upstream := upstreamSteps(d, target)
+	if len(upstream) == 0 {
+		return nil, nil, errors.Errorf("Namespace %s is the first step of the pipeline, it only receives new releases",
+			targetNamespace)
+	}
+	var source *Release
+	var steps, missing []*PipelineStep
+	for _, step := range upstream {
+		current := getLastReleaseForNamespace(step.TargetNamespace, d)
+		if current != nil && current.ImageTag == imageTag && current.Status == Deployed {
+			if source == nil {
+				source = current
+			}
+			continue
+		}
+		if fill && fillable(step) {
+			steps = append(steps, step)
+			continue
+		}
+		missing = append(missing, step)
+	}
+	if len(missing) != 0 {
+		return nil, nil, errors.Errorf("Image tag %s is not deployed in %s", imageTag, describeMissingSteps(missing, fill))
+	}
+	if source == nil {
+		return nil, nil, errors.Errorf("Image tag %s is not deployed upstream of namespace %s", imageTag, targetNamespace)
+	}
+	return append(steps, target), source, nil
+}
+
+// fillable tells if a step can be released as an intermediate step of a promotion:
+// it must be a manual step, which is not the first of the pipeline, allowing it
+func fillable(step *PipelineStep) bool {
+	return !step.AutomaticDeploy && len(step.parents()) != 0 && step.Options != nil && step.Options.AllowFill
+}
+
+func describeMissingSteps(missing []*PipelineStep, fill bool) string {
+	namespaces := make([]string, 0, len(missing))
+	for _, step := range missing {
+		description := step.TargetNamespace
+		if fill {
+			if step.AutomaticDeploy || len(step.parents()) == 0 {
+				description += " (automatic steps cannot be filled)"
+			} else {
+				description += " (step does not allow fill)"
+			}
+		}
+		namespaces = append(namespaces, description)
+	}
+	return strings.Join(namespaces, ", ")
+}
+
+// upstreamSteps returns the ancestors of step in the pipeline, parents before their children
+func upstreamSteps(d *Deployment, step *PipelineStep) []*PipelineStep {
+	stepsByNumber := make(map[int]*PipelineStep)
+	for _, s := range flattenPipeline(d.Pipeline) {
+		stepsByNumber[s.StepNumber] = s
+	}
+	var ancestors []*PipelineStep
+	visited := map[int]bool{step.StepNumber: true}
+	queue := []*PipelineStep{step}
+	for len(queue) != 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, parentNumber := range current.parents() {
+			parent, found := stepsByNumber[parentNumber]
+			if !found || visited[parentNumber] {
+				continue
+			}
+			visited[parentNumber] = true
+			ancestors = append(ancestors, parent)
+			queue = append(queue, parent)
+		}
+	}
+	depths := stepDepths(d)
+	sort.Slice(ancestors, func(i, j int) bool {
+		di, dj := depths[ancestors[i].StepNumber], depths[ancestors[j].StepNumber]
+		if di != dj {
+			return di < dj
+		}
+		return ancestors[i].StepNumber < ancestors[j].StepNumber
+	})
+	return ancestors
+}
+
+// stepDepths returns the depth of each step of the pipeline, by step number:
+// the length of the longest path from a first step to it
+func stepDepths(d *Deployment) map[int]int {
+	stepsByNumber := make(map[int]*PipelineStep)
+	steps := flattenPipeline(d.Pipeline)
+	for _, s := range steps {
+		stepsByNumber[s.StepNumber] = s
+	}
+	depths := make(map[int]int)
+	var depth func(s *PipelineStep) int
+	depth = func(s *PipelineStep) int {
+		if dep, found := depths[s.StepNumber]; found {
+			return dep
+		}
+		dep := 0
+		for _, parentNumber := range s.parents() {
+			if parent, found := stepsByNumber[parentNumber]; found {
+				if parentDepth := depth(parent) + 1; parentDepth > dep {
+					dep = parentDepth
+				}
+			}
+		}
+		depths[s.StepNumber] = dep
+		return dep
+	}
+	for _, s := range steps {
+		depth(s)
+	}
+	return depths
+}
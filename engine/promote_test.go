@@ -0,0 +1,147 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+// promotionDeployment has the pipeline int (auto) -> qa -> ppd -> prod, and int -> load -> prod
+func promotionDeployment() *Deployment {
+	content := `version: 2
+pipeline:
+  steps:
+    - step: 1
+      namespace: int
+      autodeploy: true
+    - step: 2
+      namespace: qa
+      parent_step: 1
+      options:
+        allow_fill: true
+    - step: 3
+      namespace: ppd
+      parent_step: 2
+      options:
+        allow_fill: true
+    - step: 4
+      namespace: load
+      autodeploy: true
+      parent_step: 1
+    - step: 5
+      namespace: prod
+      parent_steps: [3, 4]
+`
+	yamlContent, err := parseGennakerFile([]byte(content))
+	if err != nil {
+		panic(err)
+	}
+	return &Deployment{Name: "app", Pipeline: yamlContent.pipeline()}
+}
+
+func Test_promotionPath(t *testing.T) {
+	d := promotionDeployment()
+	d.Releases = []*Release{
+		{Namespace: "load", ImageTag: "1.1.0", Status: Deployed},
+		{Namespace: "int", ImageTag: "1.1.0", Status: Deployed, ImageTags: map[string]string{"default": "1.1.0"}},
+		{Namespace: "ppd", ImageTag: "1.0.0", Status: Deployed},
+		{Namespace: "qa", ImageTag: "1.0.0", Status: Deployed},
+	}
+
+	if _, _, err := promotionPath(d, "prod", "1.1.0", false); err == nil ||
+		!strings.Contains(err.Error(), "Image tag 1.1.0 is not deployed in qa, ppd") {
+		t.Fatalf("Expected upstream steps to be reported, got %v", err)
+	}
+
+	steps, source, err := promotionPath(d, "prod", "1.1.0", true)
+	if err != nil {
+		t.Fatalf("Expected intermediate steps to be filled, got %v", err)
+	}
+	if namespaces := stepNamespaces(steps); namespaces != "qa,ppd,prod" {
+		t.Fatalf("Expected qa, ppd then prod to be released, got %s", namespaces)
+	}
+	if source.Namespace != "int" {
+		t.Fatalf("Expected image tag to come from int, got %s", source.Namespace)
+	}
+
+	// Automatic steps are never filled
+	d.Releases[0].ImageTag = "1.0.0"
+	if _, _, err = promotionPath(d, "prod", "1.1.0", true); err == nil ||
+		!strings.Contains(err.Error(), "load (automatic steps cannot be filled)") {
+		t.Fatalf("Expected load to block the promotion, got %v", err)
+	}
+
+	// Only the path to the target is checked
+	steps, _, err = promotionPath(d, "ppd", "1.1.0", true)
+	if err != nil || stepNamespaces(steps) != "qa,ppd" {
+		t.Fatalf("Expected qa then ppd to be released, got %v %s", err, stepNamespaces(steps))
+	}
+
+	if _, _, err = promotionPath(d, "int", "1.1.0", true); err == nil {
+		t.Fatalf("Expected promotion to the first step to be refused")
+	}
+	if _, _, err = promotionPath(d, "staging", "1.1.0", true); err == nil {
+		t.Fatalf("Expected unknown namespace to be refused")
+	}
+	if _, _, err = promotionPath(d, "qa", "2.0.0", true); err == nil {
+		t.Fatalf("Expected unknown image tag to be refused")
+	}
+}
+
+func stepNamespaces(steps []*PipelineStep) string {
+	namespaces := make([]string, 0, len(steps))
+	for _, step := range steps {
+		namespaces = append(namespaces, step.TargetNamespace)
+	}
+	return strings.Join(namespaces, ",")
+}
+
+func Test_deployPlanFilledSteps(t *testing.T) {
+	d := promotionDeployment()
+	d.ChartName = "chart"
+	d.Releases = []*Release{
+		{Name: "app-ppd", Namespace: "ppd", ImageTag: "1.0.0", Status: Deployed, Revision: 1},
+		{Name: "app-qa", Namespace: "qa", ImageTag: "1.0.0", Status: Deployed, Revision: 1},
+	}
+	qa := getStepForNamespace("qa", d.Pipeline)
+	ppd := getStepForNamespace("ppd", d.Pipeline)
+	plan := &releasePlan{input: &releaseInput{imageTag: "1.1.0"}, steps: []*PipelineStep{qa, ppd},
+		stepsValues: []Values{{}, {}}, awaited: 1}
+
+	// The filled step is deployed before the target is released, in the background
+	h := &fakeHelm{}
+	db := &releaseRecorder{}
+	e := &engine{db: db, helm: h}
+	reports, err := e.deployPlan(d, plan)
+	if err != nil || len(reports) != 1 || !strings.Contains(reports[0], "namespaces qa, ppd one after the other") {
+		t.Fatalf("Expected promotion to start, got %v %v", reports, err)
+	}
+	if recorded := db.waitRecorded(t, 2); !strings.HasPrefix(recorded, "app-qa/deployed") {
+		t.Fatalf("Expected qa to be recorded as deployed before ppd was released, got %s", recorded)
+	}
+	if installs, _ := h.calls(); installs != "app-qa,app-ppd" {
+		t.Fatalf("Expected qa then ppd to be released, got %s", installs)
+	}
+
+	// The target is not released when the filled step fails, the promotion stops at the filled step
+	qa.Options.Hooks = &StepHooks{PostDeploy: []*Hook{{Name: "smoke", Command: []string{"false"}}}}
+	defer func() { qa.Options.Hooks = nil }()
+	h = &fakeHelm{}
+	db = &releaseRecorder{}
+	e = &engine{db: db, helm: h, commandHooks: true}
+	if _, err = e.deployPlan(d, plan); err != nil {
+		t.Fatalf("Expected promotion to start, got %v", err)
+	}
+	if recorded := db.waitRecorded(t, 2); recorded != "app-qa/failed,stopped/failed" {
+		t.Fatalf("Unexpected releases %s", recorded)
+	}
+	if installs, _ := h.calls(); installs != "app-qa" {
+		t.Fatalf("Expected ppd not to be released, got %s", installs)
+	}
+	db.mutex.Lock()
+	stopped := db.releases[1]
+	db.mutex.Unlock()
+	if stopped.Namespace != "qa" || stopped.Diagnostics == nil ||
+		strings.Join(stopped.Diagnostics.Errors, "") != "Release app-qa in namespace qa is failed" {
+		t.Fatalf("Expected the cause of the stop to be recorded at qa, got %+v", stopped)
+	}
+}
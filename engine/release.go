@@ -2,12 +2,16 @@ package engine
 
 import (
 	"fmt"
+	"log"
+	"os"
 	"path"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/vgheri/gennaker/helm"
+	"github.com/vgheri/gennaker/kube"
 	"github.com/vgheri/gennaker/utils"
 )
 
@@ -17,52 +21,267 @@ type GennakerReleaseOutcome uint8
 
 const imageTag = "ImageTag"
 
+// releaseStatusPollInterval is the time between two checks of the status of a release in progress
+var releaseStatusPollInterval = 5 * time.Second
+
+// DefaultImageComponent names the only component of charts
+// which do not declare their images in gennaker.yml
+const DefaultImageComponent = "default"
+
 const (
 	Unknown  GennakerReleaseOutcome = 0
 	Deployed                        = 1
 	Failed                          = 2
 )
 
-func (e *engine) HandleNewReleaseNotification(notification *ReleaseNotification) ([]string, error) {
+func (o GennakerReleaseOutcome) String() string {
+	switch o {
+	case Deployed:
+		return "deployed"
+	case Failed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+func (e *engine) HandleNewReleaseNotification(notification *ReleaseNotification) (*NotificationRecord, error) {
 	if notification == nil {
 		return nil, ErrInvalidReleaseNotification
 	}
 	if err := notification.valid(); err != nil {
 		return nil, err
 	}
-	var reports []string
 	d, err := e.db.GetDeployment(notification.DeploymentName) // TODO: use e.GetDeployment when it's done
 	if err != nil {
 		return nil, err
 	}
-	repoName, err := helm.GetRepositoryName(d.RepositoryURL)
+	if d.archived() {
+		return nil, ErrDeploymentArchived
+	}
+	if err = e.checkSecretValues(notification.SecretValues); err != nil {
+		return nil, err
+	}
+	secretDigest, err := e.digestSecretValues(notification.SecretValues)
+	if err != nil {
+		return nil, err
+	}
+	// CI systems retry notifications: do not deploy the same release twice
+	valuesHash := hashReleaseValues(notification.ReleaseValues, secretDigest, notification.ImageTags)
+	record, err := e.findDuplicateNotification(d, notification, valuesHash)
+	if err != nil || record != nil {
+		return record, err
+	}
+	// Release values are checked before the notification is registered
+	imageTags, err := resolveImageTags(d, notification.ImageTag, notification.ImageTags)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := e.planRelease(d, d.Pipeline, &releaseInput{
+		imageTag:     mainImageTag(notification.ImageTag, imageTags),
+		imageTags:    imageTags,
+		values:       notification.ReleaseValues,
+		secretValues: notification.SecretValues,
+	})
 	if err != nil {
-		return reports, errors.Wrap(err, fmt.Sprintf("Cannot get repository name for url %s", d.RepositoryURL))
+		return nil, err
+	}
+	record, err = e.registerNotification(d, notification, valuesHash)
+	if err != nil || record.Duplicate {
+		return record, err
 	}
 
-	// TODO: factorize content of for loop into its own method
-	// and use it for Promote too.
-	// parallelize with goroutines and channel to collect reports
-	for _, step := range d.Pipeline {
-		releaseNameForNamespace := getReleaseName(d, step)
+	reports, err := e.deployPlan(d, plan)
+	if reports != nil {
+		record.Reports = reports
+	}
+	record.Status = NotificationDone
+	if err != nil {
+		record.Status = NotificationFailed
+	}
+	if updateErr := e.db.UpdateReleaseNotification(record); updateErr != nil && err == nil {
+		err = errors.Wrap(updateErr, "Cannot save release notification outcome")
+	}
+	return record, err
+}
+
+// releasePlan holds everything needed to release a deployment to some steps of its pipeline,
+// built before anything is released
+type releasePlan struct {
+	input                 *releaseInput
+	steps                 []*PipelineStep
+	stepsValues           []Values
+	encryptedSecretValues []byte
+	// awaited is the number of first steps whose release must be deployed before going on
+	awaited int
+}
 
-		// Namespace dependent configuration values are stored in $namespace-values.yml
-		// inside the chart located in engine.chartsDir
-		namespaceValuesFilePath := getNamespaceValuesFilePath(e.chartsDir, d.Name, d.ChartName, step.TargetNamespace)
-		releaseValues := buildReleaseValues(notification.ImageTag, notification.ReleaseValues)
-		report, err := helm.InstallOrUpgrade(releaseNameForNamespace, step.TargetNamespace,
-			repoName, d.ChartName, namespaceValuesFilePath, releaseValues)
+// planRelease merges and validates the values of every step
+func (e *engine) planRelease(d *Deployment, steps []*PipelineStep, input *releaseInput) (*releasePlan, error) {
+	for _, step := range steps {
+		if step.namespaceSpec() != nil && e.kube == nil {
+			return nil, errors.Errorf("Namespace %s cannot be provisioned without access to Kubernetes",
+				step.TargetNamespace)
+		}
+		if hook := step.commandHook(); len(hook) != 0 && !e.commandHooks {
+			return nil, errors.Errorf("Hook %s of namespace %s runs a command, but command hooks are disabled on this server",
+				hook, step.TargetNamespace)
+		}
+	}
+	if err := e.checkDependencies(d, steps, input.pending); err != nil {
+		return nil, err
+	}
+	stepsValues, err := e.mergeStepsValues(d, steps, input)
+	if err != nil {
+		return nil, err
+	}
+	encryptedSecretValues, err := e.encryptSecretValues(input.secretValues)
+	if err != nil {
+		return nil, err
+	}
+	return &releasePlan{
+		input:                 input,
+		steps:                 steps,
+		stepsValues:           stepsValues,
+		encryptedSecretValues: encryptedSecretValues,
+	}, nil
+}
+
+// deployPlan installs or upgrades the release of each step of the plan, in order.
+// When the first steps must be deployed before the next ones are released, which takes as long as
+// their rollouts and health checks, the steps are released in the background: their releases are recorded
+// as they go, and the step they stopped at as a release event
+func (e *engine) deployPlan(d *Deployment, plan *releasePlan) ([]string, error) {
+	if plan.awaited == 0 {
+		return e.runPlan(d, plan)
+	}
+	go e.runPlan(d, plan)
+	namespaces := make([]string, 0, len(plan.steps))
+	for _, step := range plan.steps {
+		namespaces = append(namespaces, step.TargetNamespace)
+	}
+	return []string{fmt.Sprintf("Started releasing image tag %s to namespaces %s one after the other, "+
+		"their releases are recorded as release events", plan.input.imageTag, strings.Join(namespaces, ", "))}, nil
+}
+
+// runPlan releases each step of the plan, in order, until one fails.
+// When the first steps are awaited, the step it stopped at is recorded as a release event
+func (e *engine) runPlan(d *Deployment, plan *releasePlan) ([]string, error) {
+	var reports []string
+	repoName, err := e.helm.GetRepositoryName(d.RepositoryURL)
+	if err != nil {
+		err = errors.Wrap(err, fmt.Sprintf("Cannot get repository name for url %s", d.RepositoryURL))
+		if plan.awaited != 0 {
+			e.recordStopped(d, plan.steps[0], plan.input, err)
+		}
+		return reports, err
+	}
+	chartDir := path.Join(e.chartsDir, d.Name, d.ChartName)
+	// TODO: parallelize with goroutines and channel to collect reports
+	for i, step := range plan.steps {
+		stepReports, err := e.deployStep(d, plan, i, repoName, chartDir)
+		reports = append(reports, stepReports...)
 		if err != nil {
-			return reports, errors.Wrap(err,
-				fmt.Sprintf("Failed at installing or upgrading release %s in namespace %s", releaseNameForNamespace, step.TargetNamespace))
+			if plan.awaited != 0 {
+				e.recordStopped(d, step, plan.input, err)
+			}
+			return reports, err
 		}
-		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// deployStep releases the i-th step of the plan, waiting for its release to be deployed if it is awaited
+func (e *engine) deployStep(d *Deployment, plan *releasePlan, i int, repoName, chartDir string) ([]string, error) {
+	var reports []string
+	step := plan.steps[i]
+	releaseNameForNamespace := getReleaseName(d, step)
+	hc := e.newHookContext(d, step, releaseNameForNamespace, plan.input, chartDir)
+	if spec := step.namespaceSpec(); spec != nil {
+		changes, err := provisionNamespace(e.kube, step.TargetNamespace, spec)
+		if err != nil {
+			return reports, errors.Wrap(err, fmt.Sprintf("Cannot provision namespace %s", step.TargetNamespace))
+		}
+		if len(changes) != 0 {
+			reports = append(reports, formatNamespaceChanges(changes))
+		}
+	}
+	// A failing pre-deploy hook aborts the step, nothing is released
+	hookResults, err := runHooks(step.hooks(PreDeployHook), hc, PreDeployHook)
+	if err != nil {
+		reports = append(reports, formatHookResults(step.TargetNamespace, hookResults))
+		return reports, errors.Wrap(err,
+			fmt.Sprintf("Release %s in namespace %s aborted", releaseNameForNamespace, step.TargetNamespace))
+	}
+	if len(hookResults) != 0 {
+		reports = append(reports, formatHookResults(step.TargetNamespace, hookResults))
+	}
+	sr := &stepRelease{
+		deployment: d,
+		step:       step,
+		name:       releaseNameForNamespace,
+		repository: repoName,
+		values:     plan.stepsValues[i],
+		input:      plan.input,
+		hooks:      hc,
+	}
+	lastRelease := getLastReleaseForNamespace(step.TargetNamespace, d)
+	revision := generateNextReleaseRevisionNumber(lastRelease)
+	release := newRelease(d, step.TargetNamespace, releaseNameForNamespace,
+		plan.input.imageTag, plan.input.imageTags, plan.input.values, revision)
+	release.EncryptedSecretValues = plan.encryptedSecretValues
+	release.Hooks = hookResults
+	if i < plan.awaited {
+		stepReports, err := e.awaitStep(sr, release, hc)
+		return append(reports, stepReports...), err
+	}
+	// The stages of canary and blue/green releases wait for pauses, rollouts and health checks:
+	// they run in the background, recorded as release events
+	if step.strategy().staged() {
+		go e.releaseStaged(sr, release, hc)
+		return append(reports, fmt.Sprintf("Started %s release %s in namespace %s, its stages are recorded as release events",
+			step.strategy().Type, releaseNameForNamespace, step.TargetNamespace)), nil
+	}
+	stepReports, err := e.releaseStep(sr)
+	reports = append(reports, stepReports...)
+	if err != nil {
+		return reports, errors.Wrap(err,
+			fmt.Sprintf("Failed at installing or upgrading release %s in namespace %s", releaseNameForNamespace, step.TargetNamespace))
+	}
+	go e.registerReleaseOutcome(release, step, hc)
+	return reports, nil
+}
 
-		lastRelease := getLastReleaseForNamespace(step.TargetNamespace, d)
-		revision := generateNextReleaseRevisionNumber(lastRelease)
-		go registerReleaseOutcome(e.db, d, step.TargetNamespace, releaseNameForNamespace,
-			notification.ImageTag, notification.ReleaseValues, revision)
+// recordStopped records the step at which the release of steps one after the other stopped,
+// as a failed release event holding the cause
+func (e *engine) recordStopped(d *Deployment, step *PipelineStep, input *releaseInput, cause error) {
+	event := newRelease(d, step.TargetNamespace, getReleaseName(d, step), input.imageTag, input.imageTags, input.values, 0)
+	event.Stage = &ReleaseStage{Strategy: step.strategy().Type, Name: StoppedStage}
+	event.Status = Failed
+	event.Date = time.Now()
+	event.Diagnostics = &ReleaseDiagnostics{
+		CollectedAt: event.Date,
+		Events:      []*kube.Event{},
+		Pods:        []*kube.Pod{},
+		Logs:        []*ContainerLogs{},
+		Errors:      []string{cause.Error()},
+	}
+	if _, err := e.db.CreateRelease(event); err != nil {
+		log.Printf("Cannot record that the release of %s stopped in namespace %s: %v", d.Name, step.TargetNamespace, err)
 	}
+}
+
+// awaitStep releases a step and waits for the outcome of its release, failing unless it is deployed
+func (e *engine) awaitStep(sr *stepRelease, release *Release, hc *hookContext) ([]string, error) {
+	reports, err := e.releaseStep(sr)
+	if err != nil {
+		return reports, errors.Wrap(err,
+			fmt.Sprintf("Failed at installing or upgrading release %s in namespace %s", sr.name, sr.step.TargetNamespace))
+	}
+	if outcome := e.registerStepOutcome(sr, release, hc); outcome != Deployed {
+		return reports, errors.Errorf("Release %s in namespace %s is %s", sr.name, sr.step.TargetNamespace, outcome)
+	}
+	reports = append(reports, fmt.Sprintf("Release %s in namespace %s is deployed", sr.name, sr.step.TargetNamespace))
 	return reports, nil
 }
 
@@ -73,14 +292,15 @@ func (e *engine) PromoteRelease(request *PromoteRequest) ([]string, error) {
 	if err := request.valid(); err != nil {
 		return nil, errors.Wrap(err, "Promote request is invalid")
 	}
-	var reports []string
 	d, err := e.db.GetDeployment(request.DeploymentName) // TODO: use e.GetDeployment when it's done
 	if err != nil {
 		return nil, errors.Wrap(err, "Cannot get deployment")
 	}
-	repoName, err := helm.GetRepositoryName(d.RepositoryURL)
-	if err != nil {
-		return reports, errors.Wrap(err, fmt.Sprintf("Cannot get repository name for url %s", d.RepositoryURL))
+	if d.archived() {
+		return nil, ErrDeploymentArchived
+	}
+	if err = e.checkSecretValues(request.SecretValues); err != nil {
+		return nil, err
 	}
 	pipeline := getPipelineForNamespace(request.FromNamespace, d.Pipeline)
 	if len(pipeline) == 0 {
@@ -103,80 +323,56 @@ func (e *engine) PromoteRelease(request *PromoteRequest) ([]string, error) {
 	if releaseToPromote == nil {
 		return nil, errors.Errorf("Cannot promote: no release found for namespace %s", request.FromNamespace)
 	}
-
-	// TODO: factorize content of for loop into its own method
-	// Perform promote for each next in line namespace
-	// TODO: parallelize with goroutines and channel to collect reports
+	// Join steps are only released once every parent has the image tag deployed successfully
+	var steps []*PipelineStep
+	var waiting []string
 	for _, step := range pipeline {
-		releaseNameForNamespace := getReleaseName(d, step)
-		// Namespace dependent configuration values are stored in $namespace-values.yml
-		// inside the chart located in engine.chartsDir
-		namespaceValuesFilePath := getNamespaceValuesFilePath(e.chartsDir, d.Name, d.ChartName, step.TargetNamespace)
-		releaseValues := buildReleaseValues(releaseToPromote.ImageTag, request.ReleaseValues)
-		report, err := helm.InstallOrUpgrade(releaseNameForNamespace, step.TargetNamespace,
-			repoName, d.ChartName, namespaceValuesFilePath, releaseValues)
-		if err != nil {
-			return reports, errors.Wrap(err,
-				fmt.Sprintf("Failed at installing or upgrading release %s in namespace %s", releaseNameForNamespace, step.TargetNamespace))
+		if err = checkJoinStep(d, step, releaseToPromote.ImageTag); err != nil {
+			waiting = append(waiting, err.Error())
+			continue
 		}
-		reports = append(reports, report)
-		lastReleaseForTargetNamespace := getLastReleaseForNamespace(step.TargetNamespace, d)
-		revision := generateNextReleaseRevisionNumber(lastReleaseForTargetNamespace)
-		go registerReleaseOutcome(e.db, d, step.TargetNamespace, releaseNameForNamespace,
-			releaseToPromote.ImageTag, request.ReleaseValues, revision)
+		steps = append(steps, step)
 	}
-
-	return reports, nil
-}
-
-func (e *engine) Rollback(request *RollbackRequest) (string, error) {
-	if request == nil {
-		return "", ErrBadRequest
+	if len(steps) == 0 {
+		return nil, errors.Errorf("Cannot promote: %s", strings.Join(waiting, "; "))
 	}
-	if err := request.valid(); err != nil {
-		return "", errors.Wrap(err, "Rollback request is invalid")
-	}
-	d, err := e.db.GetDeployment(request.DeploymentName) // TODO: use e.GetDeployment when it's done
+	imageTags, err := resolveImageTags(d, releaseToPromote.ImageTag, releaseToPromote.ImageTags)
 	if err != nil {
-		return "", errors.Wrap(err, "Cannot get deployment")
-	}
-	var targetRelease *Release
-	// releases are ordered by most recent to less recent
-	releases := getReleasesForNamespace(request.Namespace, d)
-	if len(releases) < 2 {
-		return "", errors.Errorf("Cannot rollback: at least 2 releases needed in namespace %s", request.Namespace)
-	}
-	lastRelease := releases[0]
-	beforeLastRelease := releases[1]
-	targetRelease = beforeLastRelease
-	// If a specific revision has been specified
-	if request.Revision != 0 {
-		// Check this revision exists
-		var found bool
-		for _, r := range releases {
-			if r.Revision == request.Revision {
-				targetRelease = r
-				found = true
-				break
-			}
-		}
-		if !found {
-			return "", errors.Errorf("Cannot rollback: revision %d does not exist", request.Revision)
-		}
+		return nil, errors.Wrap(err, "Cannot promote")
 	}
-	report, err := helm.Rollback(targetRelease.Name, targetRelease.Revision)
+	plan, err := e.planRelease(d, steps, &releaseInput{
+		imageTag:     releaseToPromote.ImageTag,
+		imageTags:    imageTags,
+		values:       request.ReleaseValues,
+		secretValues: request.SecretValues,
+	})
 	if err != nil {
-		return "", err
+		return nil, errors.Wrap(err, "Cannot promote")
 	}
-	go registerReleaseOutcome(e.db, d, request.Namespace, targetRelease.Name,
-		targetRelease.ImageTag, targetRelease.Values, lastRelease.Revision+1)
-	return report, nil
+	// Perform promote for each next in line namespace
+	return e.deployPlan(d, plan)
 }
 
-// registerReleaseOutcome loops for 5 minutes waiting to have a status != Unknown
-// to persist release status in db
-func registerReleaseOutcome(repository DeploymentRepository, deployment *Deployment,
-	namespace, releaseName, imageTag, releaseValues string, revision int) {
+// newRelease prepares the record of a release of the deployment, whose status is yet unknown
+func newRelease(deployment *Deployment, namespace, releaseName, imageTag string,
+	imageTags map[string]string, releaseValues Values, revision int) *Release {
+	return &Release{
+		Name:         releaseName,
+		DeploymentID: deployment.ID,
+		ImageTag:     imageTag,
+		ImageTags:    imageTags,
+		Namespace:    namespace,
+		Values:       releaseValues,
+		Chart:        deployment.ChartName,
+		ChartVersion: deployment.ChartVersion,
+		Revision:     revision,
+		Status:       Unknown,
+	}
+}
+
+// registerReleaseOutcome loops for 5 minutes waiting to have a status != Unknown,
+// reconciles it with the hooks and health checks of the step, persists release status in db and returns it
+func (e *engine) registerReleaseOutcome(release *Release, step *PipelineStep, hc *hookContext) GennakerReleaseOutcome {
 	// TODO
 	// loop for 5 minutes for status to report either success or failure
 	// once it's done, update the DB
@@ -188,9 +384,10 @@ func registerReleaseOutcome(repository DeploymentRepository, deployment *Deploym
 			releaseOutcome = Unknown
 			break
 		}
-		status, _, err := helm.Status(releaseName)
+		status, _, err := e.helm.Status(release.Name)
 		// Release in progress
 		if err == nil && status == helm.Unknown {
+			time.Sleep(releaseStatusPollInterval)
 			continue
 		}
 		switch status {
@@ -207,27 +404,60 @@ func registerReleaseOutcome(repository DeploymentRepository, deployment *Deploym
 		time.Sleep(20 * time.Second)
 	}
 
-	release := &Release{
-		Name:         releaseName,
-		DeploymentID: deployment.ID,
-		ImageTag:     imageTag,
-		Date:         time.Now(),
-		Namespace:    namespace,
-		Values:       releaseValues,
-		Chart:        deployment.ChartName,
-		ChartVersion: deployment.ChartVersion,
-		Revision:     revision,
-		Status:       releaseOutcome,
-	}
+	release.Date = time.Now()
+	release.Status = e.reconcileRelease(release, releaseOutcome, step, hc)
 	// TODO: log error
-	_, _ = repository.CreateRelease(release)
+	_, _ = e.db.CreateRelease(release)
+	return release.Status
+}
+
+// reconcileRelease returns the outcome of a release helm reports as releaseOutcome:
+// a release is deployed once its workloads rolled out, when the engine watches Kubernetes,
+// the post-deploy hooks of the step succeeded and its health checks passed.
+// Diagnostics are collected and the on_failure hooks of the step run when the release failed
+func (e *engine) reconcileRelease(release *Release, releaseOutcome GennakerReleaseOutcome, step *PipelineStep,
+	hc *hookContext) GennakerReleaseOutcome {
+	// Helm 2 reports releases as deployed before their pods are ready
+	if releaseOutcome == Deployed && e.kube != nil && e.watchRollouts {
+		workloads, err := watchRollout(e.kube, release.Namespace, release.Name, step.rolloutTimeout())
+		release.Workloads = workloads
+		switch {
+		case workloads == nil && err != nil:
+			// The rollout could not be checked
+			return Unknown
+		case rolloutFailed(workloads):
+			releaseOutcome = Failed
+		}
+	}
+	// Post-deploy hooks run against the rolled out release, a failing one fails it
+	if releaseOutcome == Deployed && hc != nil {
+		results, _ := runHooks(step.hooks(PostDeployHook), hc, PostDeployHook)
+		release.Hooks = append(release.Hooks, results...)
+		if hooksFailed(results) {
+			releaseOutcome = Failed
+		}
+	}
+	if releaseOutcome == Deployed {
+		release.HealthChecks, _ = runHealthChecks(step.healthChecks())
+		if healthChecksFailed(release.HealthChecks) {
+			releaseOutcome = Failed
+		}
+	}
+	if releaseOutcome == Failed && e.kube != nil {
+		release.Diagnostics = collectDiagnostics(e.kube, release)
+	}
+	if releaseOutcome == Failed && hc != nil {
+		results, _ := runHooks(step.hooks(OnFailureHook), hc, OnFailureHook)
+		release.Hooks = append(release.Hooks, results...)
+	}
+	return releaseOutcome
 }
 
 func getReleaseName(d *Deployment, step *PipelineStep) string {
 	var releaseNameForNamespace string
 	// releases are ordered by most recent to less recent
 	for _, r := range d.Releases {
-		if r.Namespace == step.TargetNamespace {
+		if r.Namespace == step.TargetNamespace && !r.stageEvent() {
 			releaseNameForNamespace = r.Name
 			break
 		}
@@ -238,19 +468,85 @@ func getReleaseName(d *Deployment, step *PipelineStep) string {
 	return releaseNameForNamespace
 }
 
+// getNamespaceValuesFilePath returns the path of the namespace dependent configuration values,
+// stored in $namespace-values.yaml inside the chart located in engine.chartsDir
 func getNamespaceValuesFilePath(generalchartsDirPath, deploymentName, chartName, namespace string) string {
 	chartPath := path.Join(generalchartsDirPath, deploymentName, chartName)
 	return path.Join(chartPath, fmt.Sprintf("%s-values.yaml", namespace))
 }
 
-func buildReleaseValues(tag, releaseValues string) string {
-	imageTagValue := fmt.Sprintf("%s=%s", imageTag, tag)
-	if len(strings.TrimSpace(releaseValues)) == 0 {
-		releaseValues = imageTagValue
-	} else {
-		releaseValues = fmt.Sprintf("%s,%s", releaseValues, imageTagValue)
+// buildReleaseValues sets the image tag of each component at its value path
+// in a copy of the release values
+func buildReleaseValues(imagePaths, imageTags map[string]string, releaseValues Values) (Values, error) {
+	values := releaseValues.copy()
+	for _, component := range sortedKeys(imagePaths) {
+		if err := values.setValue(imagePaths[component], imageTags[component]); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Cannot set image tag of component %s", component))
+		}
+	}
+	return values, nil
+}
+
+// installOrUpgrade passes the merged release values to helm in a generated values file,
+// with the options of the step
+func (e *engine) installOrUpgrade(releaseName, namespace, repositoryName, chartName string, releaseValues Values,
+	stepOptions *StepOptions) (string, error) {
+	releaseValuesFilePath, err := writeValuesFile(releaseValues)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(releaseValuesFilePath)
+	var options *helm.InstallOptions
+	if stepOptions != nil {
+		options = &helm.InstallOptions{Wait: stepOptions.Wait, Timeout: stepOptions.Timeout}
+	}
+	return e.helm.InstallOrUpgrade(releaseName, namespace, repositoryName, chartName,
+		[]string{releaseValuesFilePath}, options)
+}
+
+// resolveImageTags returns the image tag of every component of the deployment.
+// Components which are not listed in imageTags use imageTag
+func resolveImageTags(d *Deployment, imageTag string, imageTags map[string]string) (map[string]string, error) {
+	imagePaths := d.imagePaths()
+	for component := range imageTags {
+		if _, found := imagePaths[component]; !found {
+			return nil, errors.Errorf("Unknown component %s", component)
+		}
+	}
+	tags := make(map[string]string)
+	for component := range imagePaths {
+		tag := imageTags[component]
+		if len(strings.TrimSpace(tag)) == 0 {
+			tag = imageTag
+		}
+		if len(strings.TrimSpace(tag)) == 0 {
+			return nil, errors.Errorf("Missing image tag for component %s", component)
+		}
+		tags[component] = tag
+	}
+	return tags, nil
+}
+
+// mainImageTag returns the image tag identifying a release: imageTag if set,
+// the tag of the first component in alphabetical order otherwise
+func mainImageTag(imageTag string, imageTags map[string]string) string {
+	if len(strings.TrimSpace(imageTag)) != 0 {
+		return imageTag
+	}
+	components := sortedKeys(imageTags)
+	if len(components) == 0 {
+		return ""
+	}
+	return imageTags[components[0]]
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
-	return releaseValues
+	sort.Strings(keys)
+	return keys
 }
 
 func generateReleaseName(deploymentName, namespace string) string {
@@ -258,19 +554,21 @@ func generateReleaseName(deploymentName, namespace string) string {
 	return fmt.Sprintf("%s-%s", utils.GenerateRandomString(5), utils.GenerateRandomString(5))
 }
 
+// getLastReleaseForNamespace returns the current release of the namespace, ignoring stage events
 func getLastReleaseForNamespace(namespace string, d *Deployment) *Release {
 	for _, r := range d.Releases {
-		if r.Namespace == namespace {
+		if r.Namespace == namespace && !r.stageEvent() {
 			return r
 		}
 	}
 	return nil
 }
 
+// getReleasesForNamespace returns the releases of the namespace, ignoring stage events
 func getReleasesForNamespace(namespace string, d *Deployment) []*Release {
 	var releases []*Release
 	for _, r := range d.Releases {
-		if r.Namespace == namespace {
+		if r.Namespace == namespace && !r.stageEvent() {
 			releases = append(releases, r)
 		}
 	}
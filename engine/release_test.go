@@ -27,10 +27,120 @@ func Test_PromoteRelease(t *testing.T) {
 	invalidPromoteRequest = &PromoteRequest{
 		DeploymentName: "abc",
 		FromNamespace:  "int",
-		ReleaseValues:  "abc",
+		ReleaseValues:  Values{"": "abc"},
 	}
 	_, err = testEngine.PromoteRelease(invalidPromoteRequest)
 	if !strings.HasPrefix(err.Error(), "Promote request is invalid") {
 		t.Fatalf("Expected invalid promote request due to invalid release values, got %v instead", err)
 	}
 }
+
+func Test_HandleNewReleaseNotification(t *testing.T) {
+	_, err := testEngine.HandleNewReleaseNotification(nil)
+	if err != ErrInvalidReleaseNotification {
+		t.Fatalf("Expected ErrInvalidReleaseNotification with a nil notification, got %v", err)
+	}
+	_, err = testEngine.HandleNewReleaseNotification(&ReleaseNotification{DeploymentName: "abc"})
+	if err == nil {
+		t.Fatalf("Expected error with an empty image tag")
+	}
+	// A duplicate notification is answered with the original outcome, without calling helm
+	record, err := testEngine.HandleNewReleaseNotification(&ReleaseNotification{
+		DeploymentName: "abc",
+		ImageTag:       "0.0.1",
+		IdempotencyKey: duplicateIdempotencyKey,
+	})
+	if err != nil {
+		t.Fatalf("Expected duplicate notification to succeed, got %v", err)
+	}
+	if !record.Duplicate || record.ID != 1 || len(record.Reports) != 1 {
+		t.Fatalf("Expected the original notification record, got %+v", record)
+	}
+}
+
+func Test_hashReleaseValues(t *testing.T) {
+	tags := map[string]string{"api": "1.0.0", "worker": "1.0.1"}
+	if hashReleaseValues(Values{"a": 1, "b": 2}, "", tags) != hashReleaseValues(Values{"b": 2, "a": 1}, "", tags) {
+		t.Fatalf("Expected hash to be deterministic")
+	}
+	if hashReleaseValues(Values{"a": 1, "b": 2}, "", nil) == hashReleaseValues(Values{"a": 1, "b": 3}, "", nil) {
+		t.Fatalf("Expected different values to have different hashes")
+	}
+	if hashReleaseValues(Values{"a": 1}, "abc", tags) == hashReleaseValues(Values{"a": 1}, "def", tags) {
+		t.Fatalf("Expected different secret values to have different hashes")
+	}
+	if hashReleaseValues(Values{"a": 1}, "", tags) == hashReleaseValues(Values{"a": 1}, "", map[string]string{"api": "1.0.0"}) {
+		t.Fatalf("Expected different image tags to have different hashes")
+	}
+}
+
+func Test_resolveImageTags(t *testing.T) {
+	d := &Deployment{ImagePaths: map[string]string{"api": "image.tag", "worker": "worker.image.tag"}}
+	tt := []struct {
+		testName  string
+		imageTag  string
+		imageTags map[string]string
+		expected  map[string]string
+		shouldErr bool
+	}{
+		{testName: "Same tag for all components", imageTag: "1.0.0",
+			expected: map[string]string{"api": "1.0.0", "worker": "1.0.0"}},
+		{testName: "Tag per component", imageTags: map[string]string{"api": "1.0.0", "worker": "2.0.0"},
+			expected: map[string]string{"api": "1.0.0", "worker": "2.0.0"}},
+		{testName: "Override one component", imageTag: "1.0.0", imageTags: map[string]string{"worker": "2.0.0"},
+			expected: map[string]string{"api": "1.0.0", "worker": "2.0.0"}},
+		{testName: "Missing component tag", imageTags: map[string]string{"api": "1.0.0"}, shouldErr: true},
+		{testName: "Unknown component", imageTag: "1.0.0", imageTags: map[string]string{"cron": "1.0.0"}, shouldErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.testName, func(t *testing.T) {
+			tags, err := resolveImageTags(d, tc.imageTag, tc.imageTags)
+			if tc.shouldErr {
+				if err == nil {
+					t.Fatalf("Expected test to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected test to succeed, got %v", err)
+			}
+			if len(tags) != len(tc.expected) {
+				t.Fatalf("Expected %v, got %v", tc.expected, tags)
+			}
+			for component, tag := range tc.expected {
+				if tags[component] != tag {
+					t.Fatalf("Expected %v, got %v", tc.expected, tags)
+				}
+			}
+		})
+	}
+}
+
+func Test_buildReleaseValues(t *testing.T) {
+	tags := map[string]string{DefaultImageComponent: "0.0.1"}
+	values, err := buildReleaseValues(defaultImagePaths(), tags, nil)
+	if err != nil || values["ImageTag"] != "0.0.1" {
+		t.Fatalf("Expected ImageTag 0.0.1, got %v (%v)", values, err)
+	}
+	paths := map[string]string{"api": "image.tag", "worker": "worker.image.tag"}
+	tags = map[string]string{"api": "1.0.0", "worker": "2.0.0"}
+	releaseValues := Values{"replicas": 2, "image": map[string]interface{}{"pullPolicy": "Always"}}
+	values, err = buildReleaseValues(paths, tags, releaseValues)
+	if err != nil {
+		t.Fatalf("Expected release values to be built, got %v", err)
+	}
+	image := values["image"].(map[string]interface{})
+	worker := values["worker"].(map[string]interface{})["image"].(map[string]interface{})
+	if values["replicas"] != 2 || image["pullPolicy"] != "Always" || image["tag"] != "1.0.0" || worker["tag"] != "2.0.0" {
+		t.Fatalf("Unexpected release values %v", values)
+	}
+	if _, found := releaseValues["image"].(map[string]interface{})["tag"]; found {
+		t.Fatalf("Expected release values of the request not to be modified")
+	}
+	if _, err = buildReleaseValues(paths, tags, Values{"image": "nginx"}); err == nil {
+		t.Fatalf("Expected an error when an image path crosses a scalar value")
+	}
+	if tag := mainImageTag("", tags); tag != "1.0.0" {
+		t.Fatalf("Expected main image tag to be the one of the first component, got %s", tag)
+	}
+}
@@ -0,0 +1,171 @@
+package engine
+
+import (
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Rollback rolls the release of a namespace back to the release before the last one,
+// or to the release identified by its revision, its ID or its image tag
+func (e *engine) Rollback(request *RollbackRequest) (string, error) {
+	if request == nil {
+		return "", ErrBadRequest
+	}
+	if err := request.valid(); err != nil {
+		return "", errors.Wrap(err, "Rollback request is invalid")
+	}
+	d, err := e.db.GetDeployment(request.DeploymentName) // TODO: use e.GetDeployment when it's done
+	if err != nil {
+		return "", errors.Wrap(err, "Cannot get deployment")
+	}
+	if d.archived() {
+		return "", ErrDeploymentArchived
+	}
+	// releases are ordered by most recent to less recent
+	releases := getReleasesForNamespace(request.Namespace, d)
+	if len(releases) < 2 {
+		return "", errors.Errorf("Cannot rollback: at least 2 releases needed in namespace %s", request.Namespace)
+	}
+	targetRelease, err := rollbackTarget(releases, request)
+	if err != nil {
+		return "", errors.Wrap(err, "Cannot rollback")
+	}
+	return e.rollbackNamespace(d, request.Namespace, targetRelease, releases[0].Revision+1)
+}
+
+// RollbackImageTag rolls back every namespace of the pipeline running the image tag
+// to the last release of another image tag deployed there, from the last steps of the pipeline to the first ones.
+// A namespace failing to roll back does not stop the others: the reports tell which ones failed
+func (e *engine) RollbackImageTag(request *PipelineRollbackRequest) ([]string, error) {
+	if request == nil {
+		return nil, ErrBadRequest
+	}
+	if err := request.valid(); err != nil {
+		return nil, errors.Wrap(err, "Rollback request is invalid")
+	}
+	d, err := e.db.GetDeployment(request.DeploymentName)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get deployment")
+	}
+	if d.archived() {
+		return nil, ErrDeploymentArchived
+	}
+	rollbacks := pipelineRollbacks(d, request.ImageTag)
+	if len(rollbacks) == 0 {
+		return nil, errors.Errorf("Cannot rollback: image tag %s is not released in any namespace of the pipeline",
+			request.ImageTag)
+	}
+	var reports []string
+	for _, rollback := range rollbacks {
+		if rollback.target == nil {
+			reports = append(reports, fmt.Sprintf("Cannot rollback namespace %s: no previous release of another image tag",
+				rollback.namespace))
+			continue
+		}
+		report, err := e.rollbackNamespace(d, rollback.namespace, rollback.target, rollback.current.Revision+1)
+		if err != nil {
+			reports = append(reports, fmt.Sprintf("Cannot rollback namespace %s: %v", rollback.namespace, err))
+			continue
+		}
+		reports = append(reports, fmt.Sprintf("Namespace %s rolled back from image tag %s to %s",
+			rollback.namespace, request.ImageTag, rollback.target.ImageTag), report)
+	}
+	return reports, nil
+}
+
+// rollbackNamespace rolls the release of a namespace back to target, recording it as a new release with revision
+func (e *engine) rollbackNamespace(d *Deployment, namespace string, targetRelease *Release, revision int) (string, error) {
+	report, err := e.helm.Rollback(targetRelease.Name, targetRelease.Revision)
+	if err != nil {
+		return "", err
+	}
+	release := newRelease(d, namespace, targetRelease.Name,
+		targetRelease.ImageTag, targetRelease.ImageTags, targetRelease.Values, revision)
+	release.EncryptedSecretValues = targetRelease.EncryptedSecretValues
+	// The rolled back release goes through the post-deploy hooks and health checks of the step too
+	var hc *hookContext
+	step := getStepForNamespace(namespace, d.Pipeline)
+	if step != nil {
+		hc = e.newHookContext(d, step, release.Name,
+			&releaseInput{imageTag: release.ImageTag, imageTags: release.ImageTags},
+			path.Join(e.chartsDir, d.Name, d.ChartName))
+	}
+	go e.registerReleaseOutcome(release, step, hc)
+	return report, nil
+}
+
+// rollbackTarget returns the release to roll back to among the releases of a namespace,
+// ordered by most recent to less recent: the release before the last one by default
+func rollbackTarget(releases []*Release, request *RollbackRequest) (*Release, error) {
+	switch {
+	case request.Revision != 0:
+		for _, r := range releases {
+			if r.Revision == request.Revision {
+				return r, nil
+			}
+		}
+		return nil, errors.Errorf("revision %d does not exist", request.Revision)
+	case request.ReleaseID != 0:
+		for _, r := range releases {
+			if r.ID == request.ReleaseID {
+				return r, nil
+			}
+		}
+		return nil, errors.Errorf("release %d does not exist in namespace %s", request.ReleaseID, request.Namespace)
+	case len(request.ImageTag) != 0:
+		if releases[0].ImageTag == request.ImageTag && releases[0].Status == Deployed {
+			return nil, errors.Errorf("image tag %s is already deployed in namespace %s", request.ImageTag, request.Namespace)
+		}
+		for _, r := range releases[1:] {
+			if r.ImageTag == request.ImageTag && r.Status == Deployed {
+				return r, nil
+			}
+		}
+		return nil, errors.Errorf("image tag %s was never deployed successfully in namespace %s",
+			request.ImageTag, request.Namespace)
+	default:
+		return releases[1], nil
+	}
+}
+
+// namespaceRollback is the rollback of a namespace whose last release runs the image tag to revert.
+// target is nil when no release of another image tag was deployed successfully before
+type namespaceRollback struct {
+	namespace string
+	current   *Release
+	target    *Release
+}
+
+// pipelineRollbacks returns the rollbacks reverting imageTag in the namespaces of the pipeline,
+// children before their parents
+func pipelineRollbacks(d *Deployment, imageTag string) []*namespaceRollback {
+	steps := flattenPipeline(d.Pipeline)
+	depths := stepDepths(d)
+	sort.Slice(steps, func(i, j int) bool {
+		di, dj := depths[steps[i].StepNumber], depths[steps[j].StepNumber]
+		if di != dj {
+			return di > dj
+		}
+		return steps[i].StepNumber > steps[j].StepNumber
+	})
+	var rollbacks []*namespaceRollback
+	for _, step := range steps {
+		// releases are ordered by most recent to less recent
+		releases := getReleasesForNamespace(step.TargetNamespace, d)
+		if len(releases) == 0 || releases[0].ImageTag != imageTag {
+			continue
+		}
+		rollback := &namespaceRollback{namespace: step.TargetNamespace, current: releases[0]}
+		for _, r := range releases[1:] {
+			if r.ImageTag != imageTag && r.Status == Deployed {
+				rollback.target = r
+				break
+			}
+		}
+		rollbacks = append(rollbacks, rollback)
+	}
+	return rollbacks
+}
@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_rollbackTarget(t *testing.T) {
+	// releases are ordered by most recent to less recent
+	releases := []*Release{
+		{ID: 14, Namespace: "ppd", ImageTag: "1.2.0", Revision: 4, Status: Failed},
+		{ID: 11, Namespace: "ppd", ImageTag: "1.1.0", Revision: 3, Status: Deployed},
+		{ID: 7, Namespace: "ppd", ImageTag: "1.0.0", Revision: 2, Status: Failed},
+		{ID: 3, Namespace: "ppd", ImageTag: "1.0.0", Revision: 1, Status: Deployed},
+	}
+	tests := []struct {
+		name     string
+		request  *RollbackRequest
+		revision int
+		err      string
+	}{
+		{"default", &RollbackRequest{Namespace: "ppd"}, 3, ""},
+		{"revision", &RollbackRequest{Namespace: "ppd", Revision: 2}, 2, ""},
+		{"unknown revision", &RollbackRequest{Namespace: "ppd", Revision: 9}, 0, "revision 9 does not exist"},
+		{"release ID", &RollbackRequest{Namespace: "ppd", ReleaseID: 7}, 2, ""},
+		{"unknown release ID", &RollbackRequest{Namespace: "ppd", ReleaseID: 8}, 0, "release 8 does not exist"},
+		{"image tag", &RollbackRequest{Namespace: "ppd", ImageTag: "1.0.0"}, 1, ""},
+		{"image tag never deployed", &RollbackRequest{Namespace: "ppd", ImageTag: "0.9.0"}, 0, "never deployed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, err := rollbackTarget(releases, tt.request)
+			if len(tt.err) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("Expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			if target.Revision != tt.revision {
+				t.Fatalf("Expected revision %d, got %d", tt.revision, target.Revision)
+			}
+		})
+	}
+
+	releases[0].Status = Deployed
+	if _, err := rollbackTarget(releases, &RollbackRequest{Namespace: "ppd", ImageTag: "1.2.0"}); err == nil {
+		t.Fatalf("Expected rollback to the image tag deployed to be refused")
+	}
+}
+
+func Test_RollbackRequestValid(t *testing.T) {
+	request := &RollbackRequest{DeploymentName: "app", Namespace: "ppd", Revision: 2, ImageTag: "1.0.0"}
+	if err := request.valid(); err == nil {
+		t.Fatalf("Expected a revision and an image tag to be refused")
+	}
+	request.Revision = 0
+	if err := request.valid(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+}
+
+func Test_pipelineRollbacks(t *testing.T) {
+	// int (auto) -> qa -> ppd -> prod, and int -> load -> prod
+	d := promotionDeployment()
+	d.Releases = []*Release{
+		{Namespace: "ppd", ImageTag: "1.1.0", Status: Failed},
+		{Namespace: "load", ImageTag: "1.1.0", Status: Deployed},
+		{Namespace: "qa", ImageTag: "1.1.0", Status: Deployed},
+		{Namespace: "int", ImageTag: "1.1.0", Status: Deployed},
+		{Namespace: "ppd", ImageTag: "1.0.1", Status: Failed},
+		{Namespace: "load", ImageTag: "1.0.0", Status: Deployed},
+		{Namespace: "ppd", ImageTag: "1.0.0", Status: Deployed},
+		{Namespace: "prod", ImageTag: "1.0.0", Status: Deployed},
+		{Namespace: "qa", ImageTag: "1.0.0", Status: Deployed},
+		{Namespace: "int", ImageTag: "1.0.0", Status: Deployed},
+		// Stage events are not releases of their namespace
+		{Namespace: "prod", ImageTag: "1.1.0", Status: Deployed, Stage: &ReleaseStage{Strategy: CanaryStrategy, Name: CanaryStage}},
+	}
+	rollbacks := pipelineRollbacks(d, "1.1.0")
+	var namespaces []string
+	for _, rollback := range rollbacks {
+		namespaces = append(namespaces, rollback.namespace)
+		if rollback.target == nil || rollback.target.ImageTag != "1.0.0" {
+			t.Fatalf("Expected namespace %s to roll back to 1.0.0, got %+v", rollback.namespace, rollback.target)
+		}
+	}
+	if strings.Join(namespaces, ",") != "ppd,load,qa,int" {
+		t.Fatalf("Expected namespaces to roll back in reverse pipeline order, got %v", namespaces)
+	}
+
+	d.Releases = []*Release{{Namespace: "int", ImageTag: "1.1.0", Status: Deployed}}
+	rollbacks = pipelineRollbacks(d, "1.1.0")
+	if len(rollbacks) != 1 || rollbacks[0].target != nil {
+		t.Fatalf("Expected int to have no release to roll back to, got %+v", rollbacks)
+	}
+}
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vgheri/gennaker/kube"
+)
+
+// Rollout statutes of a workload
+const (
+	RolloutReady       = "ready"
+	RolloutProgressing = "progressing"
+	RolloutStuck       = "stuck"
+	RolloutTimedOut    = "timed_out"
+)
+
+const (
+	// defaultRolloutTimeout applies to steps without timeout, in seconds
+	defaultRolloutTimeout = 300
+)
+
+// rolloutPollInterval is the time between two checks of the workloads of a release
+var rolloutPollInterval = 5 * time.Second
+
+//WorkloadStatus is the rollout of a Deployment, StatefulSet or DaemonSet of a release,
+//stored with the release
+type WorkloadStatus struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Ready   int    `json:"ready"`
+	Desired int    `json:"desired"`
+	Status  string `json:"status"`
+}
+
+// WithKubernetes gives the engine access to the cluster, to provision the namespaces of the steps
+// and collect diagnostics when releases fail
+func WithKubernetes(client kube.Client) Option {
+	return func(e *engine) {
+		e.kube = client
+	}
+}
+
+// WithRolloutWatching makes the engine watch the rollout of the workloads of each release
+// before declaring it deployed. It requires WithKubernetes
+func WithRolloutWatching() Option {
+	return func(e *engine) {
+		e.watchRollouts = true
+	}
+}
+
+// rolloutTimeout is the time given to the workloads of a step to roll out
+func (s *PipelineStep) rolloutTimeout() time.Duration {
+	if s == nil || s.Options == nil || s.Options.Timeout == 0 {
+		return defaultRolloutTimeout * time.Second
+	}
+	return time.Duration(s.Options.Timeout) * time.Second
+}
+
+// watchRollout waits until every workload of the release is ready, one of them is stuck, or timeout expires
+func watchRollout(client kube.Client, namespace, releaseName string, timeout time.Duration) ([]*WorkloadStatus, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		workloads, err := client.Workloads(namespace, releaseName)
+		if err != nil {
+			return nil, err
+		}
+		statuses, rolledOut, stuck := rolloutStatuses(workloads)
+		switch {
+		case stuck != nil:
+			return statuses, errors.Errorf("%s %s exceeded its progress deadline", stuck.Kind, stuck.Name)
+		case rolledOut:
+			return statuses, nil
+		case time.Now().Add(rolloutPollInterval).After(deadline):
+			for _, status := range statuses {
+				if status.Status != RolloutReady {
+					status.Status = RolloutTimedOut
+				}
+			}
+			return statuses, errors.Errorf("Rollout of release %s timed out after %s", releaseName, timeout)
+		}
+		time.Sleep(rolloutPollInterval)
+	}
+}
+
+// rolloutStatuses reports the status of each workload, if they are all rolled out,
+// and the first stuck workload, if any
+func rolloutStatuses(workloads []*kube.Workload) ([]*WorkloadStatus, bool, *kube.Workload) {
+	statuses := make([]*WorkloadStatus, 0, len(workloads))
+	rolledOut := true
+	var stuck *kube.Workload
+	for _, w := range workloads {
+		status := &WorkloadStatus{Kind: w.Kind, Name: w.Name, Ready: w.Ready, Desired: w.Desired,
+			Status: RolloutProgressing}
+		switch {
+		case w.RolledOut():
+			status.Status = RolloutReady
+		case w.Stuck:
+			status.Status = RolloutStuck
+			if stuck == nil {
+				stuck = w
+			}
+			rolledOut = false
+		default:
+			rolledOut = false
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, rolledOut, stuck
+}
+
+// rolloutFailed tells if a workload of the release did not roll out
+func rolloutFailed(statuses []*WorkloadStatus) bool {
+	for _, status := range statuses {
+		if status.Status != RolloutReady {
+			return true
+		}
+	}
+	return false
+}
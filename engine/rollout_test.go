@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vgheri/gennaker/kube"
+)
+
+// fakeKube returns the workloads of each poll in turn, the last ones once exhausted
+type fakeKube struct {
+	polls  [][]*kube.Workload
+	calls  int
+	pods   []*kube.Pod
+	events []*kube.Event
+	logs   map[string]string
+	// namespaces are read and applied by name
+	namespaces map[string]*kube.Namespace
+	applied    int
+}
+
+func (k *fakeKube) Workloads(namespace, releaseName string) ([]*kube.Workload, error) {
+	poll := k.polls[k.calls]
+	if k.calls < len(k.polls)-1 {
+		k.calls++
+	}
+	return poll, nil
+}
+
+func (k *fakeKube) Pods(namespace, releaseName string) ([]*kube.Pod, error) {
+	return k.pods, nil
+}
+
+func (k *fakeKube) Events(namespace string) ([]*kube.Event, error) {
+	return k.events, nil
+}
+
+func (k *fakeKube) GetNamespace(name string) (*kube.Namespace, error) {
+	return k.namespaces[name], nil
+}
+
+func (k *fakeKube) ApplyNamespace(namespace *kube.Namespace) error {
+	k.namespaces[namespace.Name] = namespace
+	k.applied++
+	return nil
+}
+
+// Logs are found by pod/container, previous logs by pod/container/previous
+func (k *fakeKube) Logs(namespace, pod, container string, lines int, previous bool) (string, error) {
+	key := fmt.Sprintf("%s/%s", pod, container)
+	if previous {
+		key += "/previous"
+	}
+	if logs, found := k.logs[key]; found {
+		return logs, nil
+	}
+	return "", fmt.Errorf("No logs for %s", key)
+}
+
+func Test_watchRollout(t *testing.T) {
+	defer func(interval time.Duration) { rolloutPollInterval = interval }(rolloutPollInterval)
+	rolloutPollInterval = time.Millisecond
+
+	progressing := &kube.Workload{Kind: kube.DeploymentKind, Name: "api", Desired: 3, Ready: 1, Updated: 3, Observed: true}
+	ready := &kube.Workload{Kind: kube.DeploymentKind, Name: "api", Desired: 3, Ready: 3, Updated: 3, Observed: true}
+	stuck := &kube.Workload{Kind: kube.DeploymentKind, Name: "api", Desired: 3, Ready: 2, Updated: 3, Observed: true, Stuck: true}
+	db := &kube.Workload{Kind: kube.StatefulSetKind, Name: "db", Desired: 1, Ready: 1, Updated: 1, Observed: true}
+
+	statuses, err := watchRollout(&fakeKube{polls: [][]*kube.Workload{{progressing, db}, {ready, db}}},
+		"prod", "app-prod", time.Second)
+	if err != nil || rolloutFailed(statuses) || len(statuses) != 2 || statuses[0].Ready != 3 {
+		t.Fatalf("Expected rollout to complete, got %v %+v", err, statuses)
+	}
+
+	statuses, err = watchRollout(&fakeKube{polls: [][]*kube.Workload{{progressing, db}, {stuck, db}}},
+		"prod", "app-prod", time.Second)
+	if err == nil || statuses[0].Status != RolloutStuck || statuses[1].Status != RolloutReady {
+		t.Fatalf("Expected rollout to be stuck, got %v %+v", err, statuses)
+	}
+
+	statuses, err = watchRollout(&fakeKube{polls: [][]*kube.Workload{{progressing}}}, "prod", "app-prod", 20*time.Millisecond)
+	if err == nil || statuses[0].Status != RolloutTimedOut || statuses[0].Ready != 1 || statuses[0].Desired != 3 {
+		t.Fatalf("Expected rollout to time out, got %v %+v", err, statuses)
+	}
+
+	// The release fails when its workloads do not roll out
+	e := &engine{db: repository, kube: &fakeKube{polls: [][]*kube.Workload{{stuck}}}, watchRollouts: true}
+	release := &Release{Name: "app-prod", Namespace: "prod"}
+	if outcome := e.reconcileRelease(release, Deployed, &PipelineStep{TargetNamespace: "prod"}, nil); outcome != Failed {
+		t.Fatalf("Expected release to fail, got %v", outcome)
+	}
+	if len(release.Workloads) != 1 || release.Workloads[0].Status != RolloutStuck {
+		t.Fatalf("Expected workloads to be stored with the release, got %+v", release.Workloads)
+	}
+}
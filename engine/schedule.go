@@ -0,0 +1,327 @@
+package engine
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Kinds of scheduled jobs
+const (
+	// PromoteJob promotes a release, as PromoteRelease
+	PromoteJob = "promote"
+	// NewReleaseJob releases a new image tag, as HandleNewReleaseNotification
+	NewReleaseJob = "newrelease"
+)
+
+// ScheduleStatus tracks a scheduled job
+type ScheduleStatus string
+
+// Scheduled job statutes. Jobs following a cron expression go back to pending after each run
+const (
+	SchedulePending   ScheduleStatus = "pending"
+	ScheduleRunning   ScheduleStatus = "running"
+	ScheduleDone      ScheduleStatus = "done"
+	ScheduleFailed    ScheduleStatus = "failed"
+	ScheduleCancelled ScheduleStatus = "cancelled"
+)
+
+// DefaultSchedulerInterval is how often the scheduler looks for due jobs
+const DefaultSchedulerInterval = 30 * time.Second
+
+// schedulerBatchSize is the maximum number of jobs a scheduler claims at once
+const schedulerBatchSize = 10
+
+// scheduleLease is how long a job stays claimed by its scheduler, which renews the claim while it runs the job.
+// A job still running after its lease expired is recovered: one-off jobs fail, cron jobs go back to pending
+var scheduleLease = 2 * time.Minute
+
+//Schedule is a promotion or a new release queued for a future time: once at RunAt,
+//or at every time matching Cron, RunAt being the next one.
+//ClaimedAt is the last time the scheduler running the job renewed its claim.
+//Reports and Error are those of the last run
+type Schedule struct {
+	ID             int                  `json:"id"`
+	Kind           string               `json:"kind"`
+	DeploymentName string               `json:"deployment_name"`
+	Promote        *PromoteRequest      `json:"promote,omitempty"`
+	NewRelease     *ReleaseNotification `json:"new_release,omitempty"`
+	RunAt          time.Time            `json:"run_at"`
+	Cron           string               `json:"cron,omitempty"`
+	Status         ScheduleStatus       `json:"status"`
+	LastRun        *time.Time           `json:"last_run,omitempty"`
+	ClaimedAt      *time.Time           `json:"claimed_at,omitempty"`
+	Reports        []string             `json:"reports"`
+	Error          string               `json:"error,omitempty"`
+	CreationDate   time.Time            `json:"creation_date"`
+	// EncryptedSecretValues is the stored form of the secret values of the job
+	EncryptedSecretValues []byte `json:"-"`
+}
+
+// CreateSchedule queues a job. Its secret values are encrypted until it runs
+func (e *engine) CreateSchedule(schedule *Schedule) (int, error) {
+	if schedule == nil {
+		return 0, ErrBadRequest
+	}
+	if err := schedule.valid(time.Now()); err != nil {
+		return 0, errors.Wrap(err, "Schedule is invalid")
+	}
+	d, err := e.db.GetDeployment(schedule.DeploymentName)
+	if err != nil {
+		return 0, errors.Wrap(err, "Cannot get deployment")
+	}
+	if d.archived() {
+		return 0, ErrDeploymentArchived
+	}
+	secretValues := schedule.secretValues()
+	if err = e.checkSecretValues(secretValues); err != nil {
+		return 0, err
+	}
+	if schedule.EncryptedSecretValues, err = e.encryptSecretValues(secretValues); err != nil {
+		return 0, err
+	}
+	schedule.setSecretValues(nil)
+	schedule.Status = SchedulePending
+	schedule.Reports = []string{}
+	if err = e.db.CreateSchedule(schedule); err != nil {
+		return 0, errors.Wrap(err, "Cannot save schedule")
+	}
+	return schedule.ID, nil
+}
+
+func (e *engine) ListSchedules() ([]*Schedule, error) {
+	schedules, err := e.db.ListSchedules()
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot list schedules")
+	}
+	return schedules, nil
+}
+
+func (e *engine) GetSchedule(id int) (*Schedule, error) {
+	return e.db.GetSchedule(id)
+}
+
+// CancelSchedule cancels a job which has not started yet
+func (e *engine) CancelSchedule(id int) (*Schedule, error) {
+	schedule, err := e.db.GetSchedule(id)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get schedule")
+	}
+	if schedule.Status != SchedulePending {
+		return nil, errors.Wrap(ErrScheduleNotPending, fmt.Sprintf("Schedule %d is %s", id, schedule.Status))
+	}
+	if err = e.db.CancelSchedule(id); err != nil {
+		return nil, errors.Wrap(err, "Cannot cancel schedule")
+	}
+	schedule.Status = ScheduleCancelled
+	return schedule, nil
+}
+
+// RunScheduler runs the due jobs every interval, until stop is closed.
+// Each job is claimed by a single scheduler, even when several gennaker servers share the database.
+// Once stop is closed, the jobs already claimed are run before returning
+func (e *engine) RunScheduler(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			e.runDueSchedules(stop)
+		}
+	}
+}
+
+// runDueSchedules recovers the jobs of stopped schedulers, then claims the due jobs and runs them,
+// until none is due or stop is closed
+func (e *engine) runDueSchedules(stop <-chan struct{}) {
+	if recovered, err := e.db.RecoverStaleSchedules(scheduleLease); err != nil {
+		log.Printf("Scheduler: cannot recover stale schedules: %v", err)
+	} else if recovered != 0 {
+		log.Printf("Scheduler: recovered %d schedules whose scheduler stopped", recovered)
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		schedules, err := e.db.ClaimDueSchedules(schedulerBatchSize)
+		if err != nil {
+			log.Printf("Scheduler: cannot claim due schedules: %v", err)
+			return
+		}
+		if len(schedules) == 0 {
+			return
+		}
+		for _, schedule := range schedules {
+			e.runSchedule(schedule)
+		}
+	}
+}
+
+// runSchedule runs a claimed job and records its outcome.
+// Jobs following a cron expression are queued again for their next time
+func (e *engine) runSchedule(schedule *Schedule) {
+	done := make(chan struct{})
+	claim := make(chan error, 1)
+	go func() {
+		claim <- e.renewScheduleClaim(schedule.ID, scheduleLease, done)
+	}()
+	reports, err := e.runJob(schedule)
+	close(done)
+	schedule.Reports = reports
+	if schedule.Reports == nil {
+		schedule.Reports = []string{}
+	}
+	// The job cannot be interrupted: the loss of its claim is recorded with its outcome
+	if claimErr := <-claim; claimErr != nil {
+		log.Printf("Scheduler: schedule %d lost its claim while running, it may have run twice: %v", schedule.ID, claimErr)
+		schedule.Reports = append(schedule.Reports,
+			fmt.Sprintf("The claim of the schedule was lost while running it, it may have run twice: %v", claimErr))
+	}
+	schedule.Error = ""
+	schedule.Status = ScheduleDone
+	if err != nil {
+		schedule.Error = err.Error()
+		schedule.Status = ScheduleFailed
+	}
+	if len(schedule.Cron) != 0 {
+		if next, cronErr := nextRun(schedule.Cron, time.Now()); cronErr == nil {
+			schedule.RunAt = next
+			schedule.Status = SchedulePending
+		}
+	}
+	if err = e.db.UpdateSchedule(schedule); err != nil {
+		log.Printf("Scheduler: cannot save the outcome of schedule %d: %v", schedule.ID, err)
+	}
+}
+
+// renewScheduleClaim renews the claim of a job several times per lease, until done is closed.
+// It returns an error as soon as the claim is lost: the job is not running anymore, having been recovered
+// by another scheduler, or the claim could not be renewed for longer than the lease
+func (e *engine) renewScheduleClaim(id int, lease time.Duration, done <-chan struct{}) error {
+	ticker := time.NewTicker(lease / 4)
+	defer ticker.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-done:
+			return nil
+		case <-ticker.C:
+			err := e.db.RenewScheduleClaim(id)
+			switch {
+			case err == nil:
+				renewed = time.Now()
+			case err == ErrResourceNotFound:
+				return errors.New("Schedule is not running anymore")
+			case time.Since(renewed) > lease:
+				return errors.Wrap(err, fmt.Sprintf("Claim was not renewed for %s", lease))
+			default:
+				log.Printf("Scheduler: cannot renew the claim of schedule %d: %v", id, err)
+			}
+		}
+	}
+}
+
+func (e *engine) runJob(schedule *Schedule) ([]string, error) {
+	secretValues, err := e.decrypt(schedule.EncryptedSecretValues)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot decrypt secret values")
+	}
+	switch schedule.Kind {
+	case PromoteJob:
+		request := *schedule.Promote
+		request.SecretValues = secretValues
+		return e.PromoteRelease(&request)
+	case NewReleaseJob:
+		notification := *schedule.NewRelease
+		notification.SecretValues = secretValues
+		// A run is never notified twice, even if its outcome could not be recorded.
+		// Each run of a cron job gets its own key, whatever key was stored before cron jobs refused them
+		if len(notification.IdempotencyKey) == 0 || len(schedule.Cron) != 0 {
+			notification.IdempotencyKey = fmt.Sprintf("schedule-%d-%d", schedule.ID, schedule.RunAt.Unix())
+		}
+		record, err := e.HandleNewReleaseNotification(&notification)
+		if record == nil {
+			return nil, err
+		}
+		return record.Reports, err
+	default:
+		return nil, errors.Errorf("Unknown kind of job %s", schedule.Kind)
+	}
+}
+
+// valid checks the job and sets its deployment name and, for cron jobs, its first run
+func (s *Schedule) valid(now time.Time) error {
+	switch s.Kind {
+	case PromoteJob:
+		if s.Promote == nil || s.NewRelease != nil {
+			return errors.New("Promote jobs require a promote request only")
+		}
+		if err := s.Promote.valid(); err != nil {
+			return errors.Wrap(err, "Promote request is invalid")
+		}
+		s.DeploymentName = s.Promote.DeploymentName
+	case NewReleaseJob:
+		if s.NewRelease == nil || s.Promote != nil {
+			return errors.New("New release jobs require a new release only")
+		}
+		if err := s.NewRelease.valid(); err != nil {
+			return errors.Wrap(err, "New release is invalid")
+		}
+		s.DeploymentName = s.NewRelease.DeploymentName
+	default:
+		return errors.Errorf("Unknown kind of job %s, expected %s or %s", s.Kind, PromoteJob, NewReleaseJob)
+	}
+	hasCron := len(strings.TrimSpace(s.Cron)) != 0
+	if hasCron == !s.RunAt.IsZero() {
+		return errors.New("Exactly one of run_at and cron must be set")
+	}
+	if hasCron {
+		// Runs would be deduplicated against the first one
+		if s.NewRelease != nil && len(s.NewRelease.IdempotencyKey) != 0 {
+			return errors.New("Cron jobs cannot set an idempotency key, each run gets its own")
+		}
+		next, err := nextRun(s.Cron, now)
+		if err != nil {
+			return err
+		}
+		s.RunAt = next
+	} else if !s.RunAt.After(now) {
+		return errors.Errorf("run_at %s is not in the future", s.RunAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (s *Schedule) secretValues() SecretValues {
+	if s.Promote != nil {
+		return s.Promote.SecretValues
+	}
+	if s.NewRelease != nil {
+		return s.NewRelease.SecretValues
+	}
+	return nil
+}
+
+func (s *Schedule) setSecretValues(secretValues SecretValues) {
+	if s.Promote != nil {
+		s.Promote.SecretValues = secretValues
+	}
+	if s.NewRelease != nil {
+		s.NewRelease.SecretValues = secretValues
+	}
+}
+
+// nextRun returns the first time after now matching the cron expression
+func nextRun(cron string, now time.Time) (time.Time, error) {
+	c, err := parseCron(cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return c.next(now), nil
+}
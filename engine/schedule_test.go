@@ -0,0 +1,194 @@
+package engine
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scheduleRepository records the scheduled jobs updated
+type scheduleRepository struct {
+	fakeRepository
+	updated *[]*Schedule
+}
+
+func (r scheduleRepository) UpdateSchedule(schedule *Schedule) error {
+	*r.updated = append(*r.updated, schedule)
+	return nil
+}
+
+// leaseRepository hands out a single slow job and records how the scheduler leases it
+type leaseRepository struct {
+	fakeRepository
+	mu        sync.Mutex
+	due       []*Schedule
+	claims    int
+	renewals  int
+	renewErr  error
+	recovered []time.Duration
+	updated   []*Schedule
+}
+
+// GetDeployment is slow enough for the claim of the job to be renewed
+func (r *leaseRepository) GetDeployment(name string) (*Deployment, error) {
+	time.Sleep(20 * time.Millisecond)
+	return r.fakeRepository.GetDeployment(name)
+}
+
+func (r *leaseRepository) ClaimDueSchedules(limit int) ([]*Schedule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.claims++
+	due := r.due
+	r.due = nil
+	return due, nil
+}
+
+func (r *leaseRepository) RenewScheduleClaim(id int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.renewals++
+	return r.renewErr
+}
+
+func (r *leaseRepository) RecoverStaleSchedules(lease time.Duration) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recovered = append(r.recovered, lease)
+	return 0, nil
+}
+
+func (r *leaseRepository) UpdateSchedule(schedule *Schedule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updated = append(r.updated, schedule)
+	return nil
+}
+
+func Test_ScheduleValid(t *testing.T) {
+	now := time.Date(2017, 11, 3, 10, 17, 0, 0, time.UTC)
+	promote := &PromoteRequest{DeploymentName: "app", FromNamespace: "ppd", ImageTag: "1.4.2"}
+
+	schedule := &Schedule{Kind: PromoteJob, Promote: promote, RunAt: now.Add(20 * time.Hour)}
+	if err := schedule.valid(now); err != nil || schedule.DeploymentName != "app" {
+		t.Fatalf("Expected promotion at a future time to be valid, got %v", err)
+	}
+	schedule = &Schedule{Kind: PromoteJob, Promote: promote, Cron: "0 6 * * *"}
+	if err := schedule.valid(now); err != nil || !schedule.RunAt.Equal(time.Date(2017, 11, 4, 6, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Expected first run of the cron job to be set, got %v %s", err, schedule.RunAt)
+	}
+
+	invalid := []struct {
+		schedule *Schedule
+		err      string
+	}{
+		{&Schedule{Kind: "deploy", Promote: promote, RunAt: now.Add(time.Hour)}, "Unknown kind of job"},
+		{&Schedule{Kind: NewReleaseJob, Promote: promote, RunAt: now.Add(time.Hour)}, "require a new release"},
+		{&Schedule{Kind: PromoteJob, Promote: &PromoteRequest{DeploymentName: "app"}, RunAt: now.Add(time.Hour)}, "FromNamespace"},
+		{&Schedule{Kind: PromoteJob, Promote: promote}, "Exactly one of run_at and cron"},
+		{&Schedule{Kind: PromoteJob, Promote: promote, RunAt: now.Add(time.Hour), Cron: "0 6 * * *"}, "Exactly one of run_at and cron"},
+		{&Schedule{Kind: PromoteJob, Promote: promote, RunAt: now.Add(-time.Hour)}, "not in the future"},
+		{&Schedule{Kind: PromoteJob, Promote: promote, Cron: "0 6 * *"}, "5 fields"},
+		{&Schedule{Kind: NewReleaseJob, NewRelease: &ReleaseNotification{DeploymentName: "app", ImageTag: "1.4.2",
+			IdempotencyKey: "nightly"}, Cron: "0 6 * * *"}, "idempotency key"},
+	}
+	for _, tt := range invalid {
+		if err := tt.schedule.valid(now); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Expected error %q, got %v", tt.err, err)
+		}
+	}
+}
+
+func Test_runSchedule(t *testing.T) {
+	var updated []*Schedule
+	e := &engine{db: scheduleRepository{updated: &updated}}
+	promote := &PromoteRequest{DeploymentName: "app", FromNamespace: "ppd", ImageTag: "1.4.2"}
+
+	// The fake deployment has no pipeline: the promotion fails
+	e.runSchedule(&Schedule{ID: 1, Kind: PromoteJob, Promote: promote, Status: ScheduleRunning})
+	if len(updated) != 1 || updated[0].Status != ScheduleFailed || len(updated[0].Error) == 0 ||
+		updated[0].Reports == nil {
+		t.Fatalf("Expected failed run to be recorded, got %+v", updated)
+	}
+
+	runAt := time.Now().Add(-time.Minute)
+	e.runSchedule(&Schedule{ID: 2, Kind: PromoteJob, Promote: promote, Cron: "*/5 * * * *", RunAt: runAt,
+		Status: ScheduleRunning})
+	if len(updated) != 2 || updated[1].Status != SchedulePending || !updated[1].RunAt.After(time.Now()) ||
+		len(updated[1].Error) == 0 {
+		t.Fatalf("Expected cron job to be queued again with the error of its last run, got %+v", updated[1])
+	}
+}
+
+func Test_runJobIdempotencyKey(t *testing.T) {
+	e := &engine{db: repository, helm: &fakeHelm{}}
+	notification := &ReleaseNotification{DeploymentName: "app", ImageTag: "1.4.2", IdempotencyKey: duplicateIdempotencyKey}
+
+	// One-off jobs keep their key, and are deduplicated against the notifications sharing it
+	reports, err := e.runJob(&Schedule{ID: 1, Kind: NewReleaseJob, NewRelease: notification})
+	if err != nil || len(reports) != 1 || reports[0] != "deployed" {
+		t.Fatalf("Expected the job to be deduplicated, got %v %v", reports, err)
+	}
+	// Each run of a cron job gets its own key, so that runs are not taken for duplicates of the first one
+	reports, err = e.runJob(&Schedule{ID: 2, Kind: NewReleaseJob, NewRelease: notification, Cron: "0 6 * * *",
+		RunAt: time.Now()})
+	if err != nil || len(reports) == 1 && reports[0] == "deployed" {
+		t.Fatalf("Expected the run of the cron job not to be deduplicated, got %v %v", reports, err)
+	}
+}
+
+func Test_runDueSchedules(t *testing.T) {
+	defer func(lease time.Duration) { scheduleLease = lease }(scheduleLease)
+	scheduleLease = 4 * time.Millisecond
+	promote := &PromoteRequest{DeploymentName: "app", FromNamespace: "ppd", ImageTag: "1.4.2"}
+	repository := &leaseRepository{due: []*Schedule{{ID: 1, Kind: PromoteJob, Promote: promote, Status: ScheduleRunning}}}
+	e := &engine{db: repository}
+
+	e.runDueSchedules(make(chan struct{}))
+	repository.mu.Lock()
+	if len(repository.recovered) != 1 || repository.recovered[0] != scheduleLease {
+		t.Fatalf("Expected stale schedules to be recovered first, got %v", repository.recovered)
+	}
+	if repository.claims != 2 || len(repository.updated) != 1 {
+		t.Fatalf("Expected the due schedule to be run until none is due, got %d claims %d updates",
+			repository.claims, len(repository.updated))
+	}
+	if repository.renewals == 0 {
+		t.Fatalf("Expected the claim of the running schedule to be renewed")
+	}
+	repository.mu.Unlock()
+
+	// Once stopped, no schedule is claimed anymore
+	stop := make(chan struct{})
+	close(stop)
+	e.runDueSchedules(stop)
+	repository.mu.Lock()
+	defer repository.mu.Unlock()
+	if repository.claims != 2 {
+		t.Fatalf("Expected no schedule to be claimed after stop, got %d claims", repository.claims)
+	}
+}
+
+func Test_runScheduleLostClaim(t *testing.T) {
+	defer func(lease time.Duration) { scheduleLease = lease }(scheduleLease)
+	scheduleLease = 4 * time.Millisecond
+	promote := &PromoteRequest{DeploymentName: "app", FromNamespace: "ppd", ImageTag: "1.4.2"}
+
+	// Another scheduler recovered the job, or the database could not be reached for longer than the lease
+	for _, renewErr := range []error{ErrResourceNotFound, errors.New("connection refused")} {
+		repository := &leaseRepository{renewErr: renewErr}
+		e := &engine{db: repository}
+		e.runSchedule(&Schedule{ID: 1, Kind: PromoteJob, Promote: promote, Status: ScheduleRunning})
+		repository.mu.Lock()
+		if len(repository.updated) != 1 {
+			t.Fatalf("Expected the outcome to be recorded, got %+v", repository.updated)
+		}
+		reports := repository.updated[0].Reports
+		if len(reports) == 0 || !strings.Contains(reports[len(reports)-1], "claim of the schedule was lost") {
+			t.Errorf("Expected the loss of the claim to be reported, got %v", reports)
+		}
+		repository.mu.Unlock()
+	}
+}
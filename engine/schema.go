@@ -0,0 +1,378 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// valuesSchemaFile is the JSON schema of the values, optionally shipped with charts
+const valuesSchemaFile = "values.schema.json"
+
+//FieldError reports a value which does not match the values schema of the chart
+type FieldError struct {
+	Namespace string `json:"namespace,omitempty"`
+	Field     string `json:"field"`
+	Message   string `json:"message"`
+}
+
+//ValuesValidationError lists every value which does not match the values schema of the chart
+type ValuesValidationError struct {
+	Errors []*FieldError
+}
+
+func (e *ValuesValidationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, fieldErr := range e.Errors {
+		message := fmt.Sprintf("%s: %s", fieldErr.Field, fieldErr.Message)
+		if len(fieldErr.Namespace) != 0 {
+			message = fmt.Sprintf("%s: %s", fieldErr.Namespace, message)
+		}
+		messages = append(messages, message)
+	}
+	return fmt.Sprintf("Values do not match the chart schema: %s", strings.Join(messages, "; "))
+}
+
+// valuesSchema validates values against the subset of JSON schema (draft 7) used by charts:
+// type, enum, const, properties, required, additionalProperties, items, bounds of numbers,
+// strings and arrays, pattern, allOf, anyOf, oneOf, not and local $ref.
+// Other keywords are ignored
+type valuesSchema struct {
+	root map[string]interface{}
+}
+
+// loadValuesSchema reads the values schema of a chart. Charts without schema return nil
+func loadValuesSchema(chartDir string) (*valuesSchema, error) {
+	content, err := ioutil.ReadFile(path.Join(chartDir, valuesSchemaFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "Cannot read values schema")
+	}
+	var root map[string]interface{}
+	if err = json.Unmarshal(content, &root); err != nil {
+		return nil, errors.Wrap(err, "Invalid values schema")
+	}
+	return &valuesSchema{root: root}, nil
+}
+
+// validate returns the values which do not match the schema, sorted by field
+func (s *valuesSchema) validate(values Values) []*FieldError {
+	var fieldErrors []*FieldError
+	s.validateValue(s.root, normalizeValue(map[string]interface{}(values)), "", nil, &fieldErrors)
+	sort.SliceStable(fieldErrors, func(i, j int) bool {
+		return fieldErrors[i].Field < fieldErrors[j].Field
+	})
+	return fieldErrors
+}
+
+// validateValue validates value against schema. refs are the references already followed to validate value:
+// following one of them again would not go any deeper in the values, so it is reported as circular
+func (s *valuesSchema) validateValue(schema map[string]interface{}, value interface{}, field string,
+	refs map[string]bool, fieldErrors *[]*FieldError) {
+	addError := func(format string, args ...interface{}) {
+		name := field
+		if len(name) == 0 {
+			name = "(root)"
+		}
+		*fieldErrors = append(*fieldErrors, &FieldError{Field: name, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		if refs[ref] {
+			addError("schema reference %s is circular", ref)
+			return
+		}
+		resolved, err := s.resolveRef(ref)
+		if err != nil {
+			addError("%v", err)
+			return
+		}
+		followed := map[string]bool{ref: true}
+		for r := range refs {
+			followed[r] = true
+		}
+		s.validateValue(resolved, value, field, followed, fieldErrors)
+		return
+	}
+
+	if types, found := schema["type"]; found && !matchesType(types, value) {
+		addError("expected %s, got %s", describeTypes(types), jsonType(value))
+		return
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && !containsValue(enum, value) {
+		addError("must be one of %s", encodeJSON(enum))
+	}
+	if constant, found := schema["const"]; found && !equalValues(constant, value) {
+		addError("must be %s", encodeJSON(constant))
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(schema, v, field, fieldErrors, addError)
+	case []interface{}:
+		if min, ok := number(schema["minItems"]); ok && float64(len(v)) < min {
+			addError("must have at least %v items", min)
+		}
+		if max, ok := number(schema["maxItems"]); ok && float64(len(v)) > max {
+			addError("must have at most %v items", max)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				s.validateValue(items, item, fmt.Sprintf("%s[%d]", field, i), nil, fieldErrors)
+			}
+		}
+	case string:
+		length := float64(len([]rune(v)))
+		if min, ok := number(schema["minLength"]); ok && length < min {
+			addError("must be at least %v characters long", min)
+		}
+		if max, ok := number(schema["maxLength"]); ok && length > max {
+			addError("must be at most %v characters long", max)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				addError("invalid pattern %s in schema", pattern)
+			} else if !re.MatchString(v) {
+				addError("must match %s", pattern)
+			}
+		}
+	default:
+		if n, ok := number(v); ok {
+			if min, ok := number(schema["minimum"]); ok && n < min {
+				addError("must be greater than or equal to %v", min)
+			}
+			if max, ok := number(schema["maximum"]); ok && n > max {
+				addError("must be less than or equal to %v", max)
+			}
+			if min, ok := number(schema["exclusiveMinimum"]); ok && n <= min {
+				addError("must be greater than %v", min)
+			}
+			if max, ok := number(schema["exclusiveMaximum"]); ok && n >= max {
+				addError("must be less than %v", max)
+			}
+		}
+	}
+
+	s.validateCombinations(schema, value, field, refs, fieldErrors, addError)
+}
+
+func (s *valuesSchema) validateObject(schema map[string]interface{}, object map[string]interface{}, field string,
+	fieldErrors *[]*FieldError, addError func(string, ...interface{})) {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			if name, ok := r.(string); ok {
+				if _, found := object[name]; !found {
+					*fieldErrors = append(*fieldErrors, &FieldError{Field: childField(field, name), Message: "is required"})
+				}
+			}
+		}
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+	for _, key := range sortedValueKeys(object) {
+		if propertySchema, ok := properties[key].(map[string]interface{}); ok {
+			s.validateValue(propertySchema, object[key], childField(field, key), nil, fieldErrors)
+			continue
+		}
+		if _, declared := properties[key]; declared {
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				*fieldErrors = append(*fieldErrors, &FieldError{Field: childField(field, key), Message: "is not allowed"})
+			}
+		case map[string]interface{}:
+			s.validateValue(additional, object[key], childField(field, key), nil, fieldErrors)
+		}
+	}
+}
+
+func (s *valuesSchema) validateCombinations(schema map[string]interface{}, value interface{}, field string,
+	refs map[string]bool, fieldErrors *[]*FieldError, addError func(string, ...interface{})) {
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			if subSchema, ok := sub.(map[string]interface{}); ok {
+				s.validateValue(subSchema, value, field, refs, fieldErrors)
+			}
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok && s.countMatches(anyOf, value, field, refs) == 0 {
+		addError("must match at least one schema of anyOf")
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok && s.countMatches(oneOf, value, field, refs) != 1 {
+		addError("must match exactly one schema of oneOf")
+	}
+	if not, ok := schema["not"].(map[string]interface{}); ok && s.countMatches([]interface{}{not}, value, field, refs) == 1 {
+		addError("must not match the schema of not")
+	}
+}
+
+func (s *valuesSchema) countMatches(schemas []interface{}, value interface{}, field string, refs map[string]bool) int {
+	matches := 0
+	for _, sub := range schemas {
+		subSchema, ok := sub.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		var subErrors []*FieldError
+		s.validateValue(subSchema, value, field, refs, &subErrors)
+		if len(subErrors) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+// resolveRef resolves references local to the schema, ex: #/definitions/port
+func (s *valuesSchema) resolveRef(ref string) (map[string]interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, errors.Errorf("unsupported schema reference %s", ref)
+	}
+	current := s.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+		if len(token) == 0 {
+			continue
+		}
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		next, ok := current[token].(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("cannot resolve schema reference %s", ref)
+		}
+		current = next
+	}
+	return current, nil
+}
+
+func matchesType(types interface{}, value interface{}) bool {
+	switch t := types.(type) {
+	case string:
+		return matchesSingleType(t, value)
+	case []interface{}:
+		for _, item := range t {
+			if name, ok := item.(string); ok && matchesSingleType(name, value) {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+func matchesSingleType(name string, value interface{}) bool {
+	actual := jsonType(value)
+	if name == "number" && actual == "integer" {
+		return true
+	}
+	return name == actual
+}
+
+// jsonType returns the JSON schema type of a decoded value
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	default:
+		if n, ok := number(v); ok {
+			if n == math.Trunc(n) {
+				return "integer"
+			}
+			return "number"
+		}
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func describeTypes(types interface{}) string {
+	if list, ok := types.([]interface{}); ok {
+		names := make([]string, 0, len(list))
+		for _, item := range list {
+			names = append(names, fmt.Sprint(item))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(types)
+}
+
+// number converts the numbers produced by the JSON and YAML decoders
+func number(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
+
+func containsValue(list []interface{}, value interface{}) bool {
+	for _, item := range list {
+		if equalValues(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalValues compares values, whatever the decoder which produced their numbers
+func equalValues(a, b interface{}) bool {
+	na, aIsNumber := number(a)
+	nb, bIsNumber := number(b)
+	if aIsNumber || bIsNumber {
+		return aIsNumber && bIsNumber && na == nb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func encodeJSON(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+func childField(parent, key string) string {
+	if len(parent) == 0 {
+		return key
+	}
+	return parent + "." + key
+}
+
+func sortedValueKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
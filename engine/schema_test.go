@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+const testValuesSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "type": "object",
+  "required": ["replicas", "db"],
+  "properties": {
+    "replicas": {"type": "integer", "minimum": 1},
+    "log": {"enum": ["debug", "info", "warn"]},
+    "db": {
+      "type": "object",
+      "additionalProperties": false,
+      "properties": {
+        "host": {"type": "string", "minLength": 1},
+        "port": {"$ref": "#/definitions/port"}
+      }
+    },
+    "hosts": {"type": "array", "items": {"type": "string", "pattern": "^[a-z.]+$"}}
+  },
+  "definitions": {
+    "port": {"type": "integer", "minimum": 1, "maximum": 65535}
+  }
+}`
+
+func Test_valuesSchemaValidate(t *testing.T) {
+	chartDir, err := ioutil.TempDir("", "gennaker-chart-")
+	if err != nil {
+		t.Fatalf("Cannot create chart folder: %v", err)
+	}
+	defer os.RemoveAll(chartDir)
+
+	schema, err := loadValuesSchema(chartDir)
+	if err != nil || schema != nil {
+		t.Fatalf("Expected no schema, got %v, %v", schema, err)
+	}
+	if err = ioutil.WriteFile(path.Join(chartDir, valuesSchemaFile), []byte(testValuesSchema), 0644); err != nil {
+		t.Fatalf("Cannot write schema: %v", err)
+	}
+	schema, err = loadValuesSchema(chartDir)
+	if err != nil {
+		t.Fatalf("Expected schema to be loaded, got %v", err)
+	}
+
+	valid, err := ParseYAMLValues([]byte("replicas: 2\nlog: info\ndb:\n  host: pg\n  port: 5432\nhosts: [a.example.com]\n"))
+	if err != nil {
+		t.Fatalf("Cannot parse values: %v", err)
+	}
+	if fieldErrors := schema.validate(valid); len(fieldErrors) != 0 {
+		t.Fatalf("Expected valid values, got %v", (&ValuesValidationError{Errors: fieldErrors}).Error())
+	}
+
+	invalid := Values{
+		"replicas": 1.5,
+		"log":      "trace",
+		"db":       map[string]interface{}{"port": float64(70000), "user": "app"},
+		"hosts":    []interface{}{"a.example.com", "B"},
+	}
+	expected := []FieldError{
+		{Field: "db.port", Message: "must be less than or equal to 65535"},
+		{Field: "db.user", Message: "is not allowed"},
+		{Field: "hosts[1]", Message: "must match ^[a-z.]+$"},
+		{Field: "log", Message: `must be one of ["debug","info","warn"]`},
+		{Field: "replicas", Message: "expected integer, got number"},
+	}
+	fieldErrors := schema.validate(invalid)
+	if len(fieldErrors) != len(expected) {
+		t.Fatalf("Expected %d errors, got %v", len(expected), (&ValuesValidationError{Errors: fieldErrors}).Error())
+	}
+	for i, fieldErr := range fieldErrors {
+		if *fieldErr != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], *fieldErr)
+		}
+	}
+
+	fieldErrors = schema.validate(Values{})
+	if len(fieldErrors) != 2 || fieldErrors[0].Field != "db" || fieldErrors[1].Field != "replicas" ||
+		fieldErrors[0].Message != "is required" {
+		t.Fatalf("Expected required fields to be reported, got %v", (&ValuesValidationError{Errors: fieldErrors}).Error())
+	}
+}
+
+func Test_valuesSchemaCircularRef(t *testing.T) {
+	circular := []string{
+		`{"$ref": "#"}`,
+		`{"properties": {"a": {"$ref": "#/definitions/a"}}, "definitions": {"a": {"$ref": "#/definitions/a"}}}`,
+		`{"properties": {"a": {"$ref": "#/definitions/a"}},
+		  "definitions": {"a": {"$ref": "#/definitions/b"}, "b": {"anyOf": [{"$ref": "#/definitions/a"}]}}}`,
+		`{"allOf": [{"$ref": "#"}]}`,
+	}
+	for _, content := range circular {
+		schema := &valuesSchema{}
+		if err := json.Unmarshal([]byte(content), &schema.root); err != nil {
+			t.Fatalf("Cannot decode schema %s: %v", content, err)
+		}
+		fieldErrors := schema.validate(Values{"a": 1})
+		if len(fieldErrors) == 0 {
+			t.Fatalf("Expected circular reference of %s to be reported", content)
+		}
+	}
+
+	// A recursive schema describing nested values is not circular
+	schema := &valuesSchema{}
+	content := `{"type": "object", "properties": {"name": {"type": "string"},
+	  "children": {"type": "array", "items": {"$ref": "#"}}}}`
+	if err := json.Unmarshal([]byte(content), &schema.root); err != nil {
+		t.Fatalf("Cannot decode schema: %v", err)
+	}
+	values := Values{"name": "root", "children": []interface{}{
+		map[string]interface{}{"name": "a", "children": []interface{}{map[string]interface{}{"name": 1}}},
+	}}
+	fieldErrors := schema.validate(values)
+	if len(fieldErrors) != 1 || fieldErrors[0].Field != "children[0].children[0].name" {
+		t.Fatalf("Expected nested name to be rejected, got %v", (&ValuesValidationError{Errors: fieldErrors}).Error())
+	}
+}
+
+func Test_mergeStepsValuesSchema(t *testing.T) {
+	chartsDir, err := ioutil.TempDir("", "gennaker-charts-")
+	if err != nil {
+		t.Fatalf("Cannot create charts folder: %v", err)
+	}
+	defer os.RemoveAll(chartsDir)
+	chartDir := path.Join(chartsDir, "app", "chart")
+	if err = os.MkdirAll(chartDir, 0755); err != nil {
+		t.Fatalf("Cannot create chart folder: %v", err)
+	}
+	files := map[string]string{
+		valuesSchemaFile:   testValuesSchema,
+		"values.yaml":      "replicas: 1\ndb:\n  host: localhost\n  port: 5432\n",
+		"prod-values.yaml": "replicas: 0\n",
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(path.Join(chartDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Cannot write %s: %v", name, err)
+		}
+	}
+
+	e := &engine{db: repository, chartsDir: chartsDir}
+	d := &Deployment{Name: "app", ChartName: "chart"}
+	steps := []*PipelineStep{{TargetNamespace: "dev"}, {TargetNamespace: "prod"}}
+	input := &releaseInput{values: Values{"log": "debug"}}
+	if _, err = e.mergeStepsValues(d, steps, input); err == nil {
+		t.Fatal("Expected values of namespace prod to be rejected")
+	}
+	validationErr, ok := err.(*ValuesValidationError)
+	if !ok {
+		t.Fatalf("Expected a validation error, got %v", err)
+	}
+	if len(validationErr.Errors) != 1 || validationErr.Errors[0].Namespace != "prod" ||
+		validationErr.Errors[0].Field != "replicas" {
+		t.Fatalf("Expected replicas to be rejected in prod, got %v", validationErr)
+	}
+
+	input.values = Values{"replicas": 2}
+	stepsValues, err := e.mergeStepsValues(d, steps, input)
+	if err != nil {
+		t.Fatalf("Expected values to be valid, got %v", err)
+	}
+	if len(stepsValues) != 2 {
+		t.Fatalf("Expected values for 2 steps, got %d", len(stepsValues))
+	}
+}
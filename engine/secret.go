@@ -0,0 +1,142 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// redactedValue replaces secrets in API responses and logs
+const redactedValue = "******"
+
+//SecretValues are values, such as passwords, which are encrypted when stored
+//and redacted when encoded to JSON or printed
+type SecretValues Values
+
+// MarshalJSON only exposes the keys of the secret values
+func (s SecretValues) MarshalJSON() ([]byte, error) {
+	if s == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(s.redacted())
+}
+
+// UnmarshalJSON accepts either a JSON object or a string containing a YAML document
+func (s *SecretValues) UnmarshalJSON(data []byte) error {
+	var values Values
+	if err := values.UnmarshalJSON(data); err != nil {
+		return err
+	}
+	*s = SecretValues(values)
+	return nil
+}
+
+func (s SecretValues) String() string {
+	return fmt.Sprint(map[string]interface{}(s.redacted()))
+}
+
+func (s SecretValues) GoString() string {
+	return s.String()
+}
+
+// redacted returns a copy of the secret values where every value which is not a map is redacted
+func (s SecretValues) redacted() Values {
+	return Values(redactValue(map[string]interface{}(s)).(map[string]interface{}))
+}
+
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = redactValue(item)
+		}
+		return m
+	default:
+		return redactedValue
+	}
+}
+
+// secretResolver returns the secret stored under key by the named provider
+type secretResolver func(provider, key string) (string, error)
+
+// resolveSecret fetches secrets from the registered providers.
+// Secrets are not fetched when redact is set: only the provider is checked
+func (e *engine) resolveSecret(redact bool) secretResolver {
+	return func(providerName, key string) (string, error) {
+		provider, found := e.secretProviders[providerName]
+		if !found {
+			return "", errors.Errorf("Unknown secret provider %s", providerName)
+		}
+		if redact {
+			return redactedValue, nil
+		}
+		return provider.GetSecret(key)
+	}
+}
+
+// checkSecretValues refuses secret values which cannot be encrypted
+func (e *engine) checkSecretValues(secretValues SecretValues) error {
+	if len(secretValues) == 0 {
+		return nil
+	}
+	if e.cipher == nil {
+		return errors.New("Secret values require an encryption key")
+	}
+	return Values(secretValues).valid()
+}
+
+// digestSecretValues returns a keyed digest of the secret values, used to detect duplicate notifications
+func (e *engine) digestSecretValues(secretValues SecretValues) (string, error) {
+	if len(secretValues) == 0 || e.cipher == nil {
+		return "", nil
+	}
+	encoded, err := json.Marshal(map[string]interface{}(secretValues))
+	if err != nil {
+		return "", errors.Wrap(err, "Cannot encode secret values")
+	}
+	return e.cipher.Digest(encoded), nil
+}
+
+// encryptSecretValues returns the secret values encrypted, to be stored with releases
+func (e *engine) encryptSecretValues(secretValues SecretValues) ([]byte, error) {
+	if len(secretValues) == 0 {
+		return nil, nil
+	}
+	if e.cipher == nil {
+		return nil, errors.New("Secret values require an encryption key")
+	}
+	encoded, err := json.Marshal(map[string]interface{}(secretValues))
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot encode secret values")
+	}
+	return e.cipher.Encrypt(encoded)
+}
+
+// decryptSecretValues sets the secret values of a release from their encrypted form.
+// They are left empty when no cipher is configured
+func (e *engine) decryptSecretValues(release *Release) error {
+	values, err := e.decrypt(release.EncryptedSecretValues)
+	if err != nil {
+		return err
+	}
+	release.SecretValues = values
+	return nil
+}
+
+// decrypt returns the secret values encrypted by encryptSecretValues, nil when no cipher is configured
+func (e *engine) decrypt(encrypted []byte) (SecretValues, error) {
+	if len(encrypted) == 0 || e.cipher == nil {
+		return nil, nil
+	}
+	encoded, err := e.cipher.Decrypt(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	var values SecretValues
+	if err = json.Unmarshal(encoded, (*map[string]interface{})(&values)); err != nil {
+		return nil, errors.Wrap(err, "Cannot decode secret values")
+	}
+	return values, nil
+}
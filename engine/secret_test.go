@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/vgheri/gennaker/secret"
+)
+
+func Test_SecretValuesRedaction(t *testing.T) {
+	secrets := SecretValues{"db": map[string]interface{}{"password": "s3cr3t", "ports": []interface{}{1}}, "token": "abc"}
+	encoded, err := json.Marshal(&Release{SecretValues: secrets})
+	if err != nil {
+		t.Fatalf("Expected release to be encoded, got %v", err)
+	}
+	if strings.Contains(string(encoded), "s3cr3t") || strings.Contains(string(encoded), "abc") ||
+		!strings.Contains(string(encoded), `"secret_values":{"db":{"password":"******","ports":"******"},"token":"******"}`) {
+		t.Fatalf("Expected secret values to be redacted, got %s", encoded)
+	}
+	for _, printed := range []string{fmt.Sprint(secrets), fmt.Sprintf("%v", secrets), fmt.Sprintf("%#v", secrets)} {
+		if strings.Contains(printed, "s3cr3t") {
+			t.Fatalf("Expected printed secret values to be redacted, got %s", printed)
+		}
+	}
+
+	var request struct {
+		SecretValues SecretValues `json:"secret_values"`
+	}
+	if err = json.Unmarshal([]byte(`{"secret_values": "password: s3cr3t"}`), &request); err != nil {
+		t.Fatalf("Expected secret values to be decoded, got %v", err)
+	}
+	if request.SecretValues["password"] != "s3cr3t" {
+		t.Fatalf("Unexpected secret values %v", map[string]interface{}(request.SecretValues))
+	}
+}
+
+func Test_encryptSecretValues(t *testing.T) {
+	e := &engine{db: repository}
+	secrets := SecretValues{"password": "s3cr3t"}
+	if err := e.checkSecretValues(secrets); err == nil {
+		t.Fatalf("Expected secret values to be refused without cipher")
+	}
+	if _, err := e.encryptSecretValues(secrets); err == nil {
+		t.Fatalf("Expected encryption to fail without cipher")
+	}
+
+	e.cipher, _ = secret.NewCipher([]byte("0123456789abcdef0123456789abcdef"))
+	if err := e.checkSecretValues(secrets); err != nil {
+		t.Fatalf("Expected secret values to be accepted, got %v", err)
+	}
+	encrypted, err := e.encryptSecretValues(secrets)
+	if err != nil || strings.Contains(string(encrypted), "s3cr3t") {
+		t.Fatalf("Expected secret values to be encrypted, got %s (%v)", encrypted, err)
+	}
+	release := &Release{EncryptedSecretValues: encrypted}
+	if err = e.decryptSecretValues(release); err != nil {
+		t.Fatalf("Expected secret values to be decrypted, got %v", err)
+	}
+	if release.SecretValues["password"] != "s3cr3t" {
+		t.Fatalf("Unexpected secret values %v", map[string]interface{}(release.SecretValues))
+	}
+	if encrypted, err = e.encryptSecretValues(nil); err != nil || encrypted != nil {
+		t.Fatalf("Expected nothing to encrypt, got %v (%v)", encrypted, err)
+	}
+}
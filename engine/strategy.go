@@ -0,0 +1,340 @@
+package engine
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Strategies releasing a step
+const (
+	// RollingStrategy upgrades the release of the step in place
+	RollingStrategy = "rolling"
+	// CanaryStrategy sends an increasing share of traffic to a canary release before upgrading the release of the step
+	CanaryStrategy = "canary"
+	// BlueGreenStrategy releases the other color than the live one, then switches traffic to it
+	BlueGreenStrategy = "bluegreen"
+)
+
+// Stages recorded as release events by the canary and blue/green strategies,
+// and by releases of steps one after the other
+const (
+	// CanaryStage is a weight of the canary release
+	CanaryStage = "canary"
+	// AbortStage removes a canary release which failed
+	AbortStage = "abort"
+	// ColorStage releases a color
+	ColorStage = "color"
+	// SwitchStage sends traffic to a color
+	SwitchStage = "switch"
+	// StoppedStage is the step at which the release of steps one after the other stopped
+	StoppedStage = "stopped"
+)
+
+// Colors of the blue/green strategy
+const (
+	blue  = "blue"
+	green = "green"
+)
+
+//StepStrategy is how a step is released.
+//
+//Canary installs a second release, named after the release of the step with a -canary suffix,
+//setting the weight of each stage, in percent, at WeightPath in its values. Each stage must pass
+//the rollout checks and the health checks of the step, after Pause seconds, for the next one to start.
+//Once every stage passed, the release of the step is upgraded and the canary release deleted.
+//The canary release is deleted as soon as a stage fails.
+//
+//BlueGreen releases the color, blue or green, which is not live to a second release,
+//named after the release of the step with a -blue or -green suffix, setting the color at ColorPath.
+//Once it is healthy, the release of the step is upgraded with the color at SelectorPath,
+//for its service to select the pods of the new color. The previous color is kept for a quick switch back.
+//
+//Canary and blue/green releases run in the background: each stage is recorded as a release event,
+//with its rollout, health checks and diagnostics, and the release of the step once every stage passed.
+//A failed stage runs the on_failure hooks of the step. The switch of a blue/green release is recorded
+//with the outcome of the release of the step
+type StepStrategy struct {
+	Type         string `json:"type" yaml:"type"`
+	WeightPath   string `json:"weight_path,omitempty" yaml:"weight_path,omitempty"`
+	Stages       []int  `json:"stages,omitempty" yaml:"stages,omitempty"`
+	Pause        int    `json:"pause,omitempty" yaml:"pause,omitempty"`
+	ColorPath    string `json:"color_path,omitempty" yaml:"color_path,omitempty"`
+	SelectorPath string `json:"selector_path,omitempty" yaml:"selector_path,omitempty"`
+}
+
+//ReleaseStage identifies the releases recorded for each stage of the canary and blue/green strategies,
+//and for the step at which a release of steps one after the other stopped, the cause being in its diagnostics.
+//Stages are release events: they are not the current release of their namespace
+type ReleaseStage struct {
+	Strategy string `json:"strategy"`
+	Name     string `json:"name"`
+	Weight   int    `json:"weight,omitempty"`
+	Color    string `json:"color,omitempty"`
+}
+
+// valid checks the strategy declared in gennaker.yml
+func (s *StepStrategy) valid() error {
+	switch s.Type {
+	case RollingStrategy:
+	case CanaryStrategy:
+		if len(strings.TrimSpace(s.WeightPath)) == 0 {
+			return errors.New("Canary strategy requires a weight_path")
+		}
+		if len(s.Stages) == 0 {
+			return errors.New("Canary strategy requires stages")
+		}
+		previous := 0
+		for _, weight := range s.Stages {
+			if weight <= previous || weight > 100 {
+				return errors.New("Stages of the canary strategy must be increasing weights between 1 and 100")
+			}
+			previous = weight
+		}
+	case BlueGreenStrategy:
+		if len(strings.TrimSpace(s.ColorPath)) == 0 || len(strings.TrimSpace(s.SelectorPath)) == 0 {
+			return errors.New("Blue/green strategy requires a color_path and a selector_path")
+		}
+	default:
+		return errors.Errorf("Unknown strategy %s, expected %s, %s or %s", s.Type,
+			RollingStrategy, CanaryStrategy, BlueGreenStrategy)
+	}
+	if s.Pause < 0 {
+		return errors.New("Pause of the strategy cannot be negative")
+	}
+	return nil
+}
+
+// strategy returns the strategy of the step, rolling by default
+func (s *PipelineStep) strategy() *StepStrategy {
+	if s == nil || s.Options == nil || s.Options.Strategy == nil {
+		return &StepStrategy{Type: RollingStrategy}
+	}
+	return s.Options.Strategy
+}
+
+// stageEvent tells if the release was recorded for a stage of a strategy
+func (r *Release) stageEvent() bool {
+	return r.Stage != nil
+}
+
+// staged tells if the strategy releases the step in stages
+func (s *StepStrategy) staged() bool {
+	return s.Type == CanaryStrategy || s.Type == BlueGreenStrategy
+}
+
+// stepRelease is the release of a deployment to a step of its pipeline
+type stepRelease struct {
+	deployment *Deployment
+	step       *PipelineStep
+	name       string
+	repository string
+	values     Values
+	input      *releaseInput
+	// hooks is the context of the on_failure hooks run when a stage fails
+	hooks *hookContext
+	// switched is the switch stage of a blue/green release, recorded once the outcome of the release of the step is known
+	switched *Release
+}
+
+// releaseStep releases a step with its strategy, returning the reports of helm and of each stage
+func (e *engine) releaseStep(sr *stepRelease) ([]string, error) {
+	switch sr.step.strategy().Type {
+	case CanaryStrategy:
+		return e.releaseCanary(sr)
+	case BlueGreenStrategy:
+		return e.releaseBlueGreen(sr)
+	default:
+		report, err := e.install(sr, sr.name, sr.values)
+		if err != nil {
+			return nil, err
+		}
+		return []string{report}, nil
+	}
+}
+
+// releaseStaged releases a step in stages, then records the outcome of the release of the step.
+// Nothing but the stage events is recorded when a stage fails
+func (e *engine) releaseStaged(sr *stepRelease, release *Release, hc *hookContext) GennakerReleaseOutcome {
+	if _, err := e.releaseStep(sr); err != nil {
+		return Failed
+	}
+	return e.registerStepOutcome(sr, release, hc)
+}
+
+// registerStepOutcome records the outcome of the release of the step, then the switch of a blue/green release
+// with the same status: the color it switched to is only live once the release of the step is deployed
+func (e *engine) registerStepOutcome(sr *stepRelease, release *Release, hc *hookContext) GennakerReleaseOutcome {
+	outcome := e.registerReleaseOutcome(release, sr.step, hc)
+	if sr.switched != nil {
+		e.recordStage(sr.switched, outcome)
+	}
+	return outcome
+}
+
+func (e *engine) releaseCanary(sr *stepRelease) ([]string, error) {
+	var reports []string
+	strategy := sr.step.strategy()
+	canaryName := fmt.Sprintf("%s-canary", sr.name)
+	for _, weight := range strategy.Stages {
+		event := newStageEvent(sr, canaryName, &ReleaseStage{Strategy: CanaryStrategy, Name: CanaryStage, Weight: weight})
+		values := sr.values.copy()
+		err := values.setValue(strategy.WeightPath, weight)
+		if err == nil {
+			var report string
+			if report, err = e.install(sr, canaryName, values); err == nil {
+				reports = append(reports, report)
+				err = e.checkStage(sr, event)
+			}
+		}
+		if err != nil {
+			e.failStage(sr, event)
+			return reports, e.abortCanary(sr, canaryName, weight, err)
+		}
+		e.recordStage(event, Deployed)
+		reports = append(reports, fmt.Sprintf("Canary release %s is healthy with a weight of %d%%", canaryName, weight))
+	}
+	report, err := e.install(sr, sr.name, sr.values)
+	if err != nil {
+		return reports, e.abortCanary(sr, canaryName, 100, err)
+	}
+	reports = append(reports, report)
+	if _, err = e.helm.Delete(canaryName, true); err != nil {
+		reports = append(reports, fmt.Sprintf("Cannot delete canary release %s: %v", canaryName, err))
+	}
+	return reports, nil
+}
+
+// abortCanary deletes the canary release, returning the cause of the abort
+func (e *engine) abortCanary(sr *stepRelease, canaryName string, weight int, cause error) error {
+	if _, err := e.helm.Delete(canaryName, true); err != nil {
+		cause = errors.Wrap(cause, fmt.Sprintf("cannot delete canary release %s: %v", canaryName, err))
+	}
+	e.recordStage(newStageEvent(sr, canaryName, &ReleaseStage{Strategy: CanaryStrategy, Name: AbortStage, Weight: weight}), Failed)
+	return errors.Wrap(cause, fmt.Sprintf("Canary release %s aborted at a weight of %d%%", canaryName, weight))
+}
+
+func (e *engine) releaseBlueGreen(sr *stepRelease) ([]string, error) {
+	var reports []string
+	strategy := sr.step.strategy()
+	live := liveColor(sr.deployment, sr.step.TargetNamespace)
+	color := nextColor(live)
+	colorName := fmt.Sprintf("%s-%s", sr.name, color)
+	event := newStageEvent(sr, colorName, &ReleaseStage{Strategy: BlueGreenStrategy, Name: ColorStage, Color: color})
+	values := sr.values.copy()
+	err := values.setValue(strategy.ColorPath, color)
+	if err == nil {
+		var report string
+		if report, err = e.install(sr, colorName, values); err == nil {
+			reports = append(reports, report)
+			err = e.checkStage(sr, event)
+		}
+	}
+	if err != nil {
+		e.failStage(sr, event)
+		return reports, errors.Wrap(err, fmt.Sprintf("Blue/green release %s aborted, traffic stays on %s",
+			colorName, colorOrNone(live)))
+	}
+	e.recordStage(event, Deployed)
+
+	event = newStageEvent(sr, sr.name, &ReleaseStage{Strategy: BlueGreenStrategy, Name: SwitchStage, Color: color})
+	values = sr.values.copy()
+	err = values.setValue(strategy.SelectorPath, color)
+	if err == nil {
+		var report string
+		if report, err = e.install(sr, sr.name, values); err == nil {
+			reports = append(reports, report)
+		}
+	}
+	if err != nil {
+		e.failStage(sr, event)
+		return reports, errors.Wrap(err, fmt.Sprintf("Cannot switch traffic of release %s to %s", sr.name, color))
+	}
+	sr.switched = event
+	reports = append(reports, fmt.Sprintf("Traffic of release %s switches from %s to %s once it is deployed",
+		sr.name, colorOrNone(live), color))
+	return reports, nil
+}
+
+// liveColor returns the color traffic was last switched to in the namespace, empty if none
+func liveColor(d *Deployment, namespace string) string {
+	// releases are ordered by most recent to less recent
+	for _, r := range d.Releases {
+		if r.Namespace == namespace && r.stageEvent() && r.Stage.Name == SwitchStage && r.Status == Deployed {
+			return r.Stage.Color
+		}
+	}
+	return ""
+}
+
+// nextColor returns the color to release when live is the live one
+func nextColor(live string) string {
+	if live == blue {
+		return green
+	}
+	return blue
+}
+
+func colorOrNone(color string) string {
+	if len(color) == 0 {
+		return "no color"
+	}
+	return color
+}
+
+// install installs or upgrades a release of the step with values
+func (e *engine) install(sr *stepRelease, releaseName string, values Values) (string, error) {
+	return e.installOrUpgrade(releaseName, sr.step.TargetNamespace, sr.repository, sr.deployment.ChartName,
+		values, sr.step.Options)
+}
+
+// checkStage waits for the pause of the strategy, then for the workloads of the release of the stage to roll out,
+// when the engine watches rollouts, and runs the health checks of the step.
+// Their outcome is kept on the stage event
+func (e *engine) checkStage(sr *stepRelease, event *Release) error {
+	time.Sleep(time.Duration(sr.step.strategy().Pause) * time.Second)
+	if e.kube != nil && e.watchRollouts {
+		workloads, err := watchRollout(e.kube, sr.step.TargetNamespace, event.Name, sr.step.rolloutTimeout())
+		event.Workloads = workloads
+		if err != nil {
+			return err
+		}
+		if rolloutFailed(workloads) {
+			return errors.Errorf("Workloads of release %s did not roll out", event.Name)
+		}
+	}
+	var err error
+	event.HealthChecks, err = runHealthChecks(sr.step.healthChecks())
+	return err
+}
+
+// newStageEvent prepares the release event of a stage
+func newStageEvent(sr *stepRelease, releaseName string, stage *ReleaseStage) *Release {
+	event := newRelease(sr.deployment, sr.step.TargetNamespace, releaseName,
+		sr.input.imageTag, sr.input.imageTags, sr.input.values, 0)
+	event.Stage = stage
+	return event
+}
+
+// recordStage records a stage as a release event, with diagnostics when it failed
+func (e *engine) recordStage(event *Release, status GennakerReleaseOutcome) {
+	event.Status = status
+	event.Date = time.Now()
+	if status == Failed && e.kube != nil && event.Stage.Name != AbortStage {
+		event.Diagnostics = collectDiagnostics(e.kube, event)
+	}
+	if _, err := e.db.CreateRelease(event); err != nil {
+		log.Printf("Cannot record %s stage of release %s in namespace %s: %v", event.Stage.Name, event.Name, event.Namespace, err)
+	}
+}
+
+// failStage runs the on_failure hooks of the step, as for the release of the step, and records the stage as failed
+func (e *engine) failStage(sr *stepRelease, event *Release) {
+	if sr.hooks != nil {
+		event.Hooks, _ = runHooks(sr.step.hooks(OnFailureHook), sr.hooks, OnFailureHook)
+	}
+	e.recordStage(event, Failed)
+}
@@ -0,0 +1,328 @@
+package engine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/vgheri/gennaker/helm"
+)
+
+// fakeHelm records the releases it installs, with the content of their values, and deletes.
+// Installs fail when fail returns true, helm reports every release as deployed unless failedStatus is set
+type fakeHelm struct {
+	mutex        sync.Mutex
+	installs     []string
+	values       []string
+	deletes      []string
+	rollbacks    []string
+	fail         func(releaseName, values string) bool
+	failedStatus bool
+}
+
+func (h *fakeHelm) GetRepositoryName(repositoryURL string) (string, error) {
+	return "repo", nil
+}
+
+func (h *fakeHelm) InstallOrUpgrade(releaseName, namespace, repositoryName, chartName string, valuesFilePaths []string,
+	options *helm.InstallOptions) (string, error) {
+	content, err := ioutil.ReadFile(valuesFilePaths[0])
+	if err != nil {
+		return "", err
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.fail != nil && h.fail(releaseName, string(content)) {
+		return "", errors.Errorf("Release %s failed", releaseName)
+	}
+	h.installs = append(h.installs, releaseName)
+	h.values = append(h.values, string(content))
+	return "installed " + releaseName, nil
+}
+
+func (h *fakeHelm) Status(releaseName string) (helm.ReleaseStatus, string, error) {
+	if h.failedStatus {
+		return helm.Failed, "", nil
+	}
+	return helm.Deployed, "", nil
+}
+
+func (h *fakeHelm) Rollback(releaseName string, revision int) (string, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.rollbacks = append(h.rollbacks, fmt.Sprintf("%s/%d", releaseName, revision))
+	return "rolled back " + releaseName, nil
+}
+
+func (h *fakeHelm) Delete(releaseName string, purge bool) (string, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.deletes = append(h.deletes, releaseName)
+	return "deleted " + releaseName, nil
+}
+
+func (h *fakeHelm) calls() (installs, deletes string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return strings.Join(h.installs, ","), strings.Join(h.deletes, ",")
+}
+
+// releaseRecorder records the releases and stage events created
+type releaseRecorder struct {
+	fakeRepository
+	mutex    sync.Mutex
+	releases []*Release
+}
+
+func (r *releaseRecorder) CreateRelease(release *Release) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.releases = append(r.releases, release)
+	return len(r.releases), nil
+}
+
+// recorded describes the releases recorded, stage events as stage/weight or color/status,
+// the stop of releases of steps one after the other as stopped/status
+func (r *releaseRecorder) recorded() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var recorded []string
+	for _, release := range r.releases {
+		switch {
+		case release.Stage == nil:
+			recorded = append(recorded, fmt.Sprintf("%s/%s", release.Name, release.Status))
+		case release.Stage.Name == StoppedStage:
+			recorded = append(recorded, fmt.Sprintf("%s/%s", release.Stage.Name, release.Status))
+		case release.Stage.Color != "":
+			recorded = append(recorded, fmt.Sprintf("%s/%s/%s", release.Stage.Name, release.Stage.Color, release.Status))
+		default:
+			recorded = append(recorded, fmt.Sprintf("%s/%d/%s", release.Stage.Name, release.Stage.Weight, release.Status))
+		}
+	}
+	return strings.Join(recorded, ",")
+}
+
+// waitRecorded waits for count releases to be recorded
+func (r *releaseRecorder) waitRecorded(t *testing.T, count int) string {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r.mutex.Lock()
+		recorded := len(r.releases)
+		r.mutex.Unlock()
+		if recorded >= count {
+			return r.recorded()
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d releases to be recorded, got %s", count, r.recorded())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func stagedStep(strategy *StepStrategy) *PipelineStep {
+	return &PipelineStep{StepNumber: 1, TargetNamespace: "prod", Options: &StepOptions{Strategy: strategy}}
+}
+
+func stagedRelease(d *Deployment, step *PipelineStep) *stepRelease {
+	return &stepRelease{deployment: d, step: step, name: "app-prod", repository: "repo", values: Values{},
+		input: &releaseInput{imageTag: "1.1.0"}}
+}
+
+func Test_StepStrategyValid(t *testing.T) {
+	tt := []struct {
+		strategy *StepStrategy
+		valid    bool
+	}{
+		{strategy: &StepStrategy{Type: RollingStrategy}, valid: true},
+		{strategy: &StepStrategy{Type: CanaryStrategy, WeightPath: "canary.weight", Stages: []int{10, 50, 100}}, valid: true},
+		{strategy: &StepStrategy{Type: BlueGreenStrategy, ColorPath: "color", SelectorPath: "service.color", Pause: 30}, valid: true},
+		{strategy: &StepStrategy{Type: "recreate"}},
+		{strategy: &StepStrategy{Type: CanaryStrategy, Stages: []int{10}}},
+		{strategy: &StepStrategy{Type: CanaryStrategy, WeightPath: "canary.weight"}},
+		{strategy: &StepStrategy{Type: CanaryStrategy, WeightPath: "canary.weight", Stages: []int{50, 10}}},
+		{strategy: &StepStrategy{Type: CanaryStrategy, WeightPath: "canary.weight", Stages: []int{0, 10}}},
+		{strategy: &StepStrategy{Type: CanaryStrategy, WeightPath: "canary.weight", Stages: []int{50, 150}}},
+		{strategy: &StepStrategy{Type: BlueGreenStrategy, ColorPath: "color"}},
+		{strategy: &StepStrategy{Type: RollingStrategy, Pause: -1}},
+	}
+	for _, tc := range tt {
+		if err := tc.strategy.valid(); (err == nil) != tc.valid {
+			t.Errorf("Expected strategy %+v valid to be %v, got %v", tc.strategy, tc.valid, err)
+		}
+	}
+}
+
+func Test_stageEvents(t *testing.T) {
+	// releases are ordered by most recent to less recent
+	d := &Deployment{Releases: []*Release{
+		{Name: "app-prod", Namespace: "prod", ImageTag: "1.1.0", Status: Deployed,
+			Stage: &ReleaseStage{Strategy: BlueGreenStrategy, Name: SwitchStage, Color: green}},
+		{Name: "app-prod-green", Namespace: "prod", ImageTag: "1.1.0", Status: Deployed,
+			Stage: &ReleaseStage{Strategy: BlueGreenStrategy, Name: ColorStage, Color: green}},
+		{Name: "app-prod", Namespace: "prod", ImageTag: "1.0.0", Status: Deployed, Revision: 1},
+		{Name: "app-ppd-canary", Namespace: "ppd", ImageTag: "1.1.0", Status: Failed,
+			Stage: &ReleaseStage{Strategy: CanaryStrategy, Name: AbortStage, Weight: 50}},
+		{Name: "app-ppd", Namespace: "ppd", ImageTag: "1.0.0", Status: Deployed, Revision: 1},
+	}}
+	if r := getLastReleaseForNamespace("ppd", d); r == nil || r.Name != "app-ppd" {
+		t.Fatalf("Expected stage events to be ignored, got %+v", r)
+	}
+	if releases := getReleasesForNamespace("prod", d); len(releases) != 1 || releases[0].ImageTag != "1.0.0" {
+		t.Fatalf("Expected a single release in prod, got %d", len(releases))
+	}
+	if name := getReleaseName(d, &PipelineStep{TargetNamespace: "ppd"}); name != "app-ppd" {
+		t.Fatalf("Expected release of the step to keep its name, got %s", name)
+	}
+
+	if live := liveColor(d, "prod"); live != green || nextColor(live) != blue {
+		t.Fatalf("Expected green to be live, got %s", live)
+	}
+	if live := liveColor(d, "ppd"); live != "" || nextColor(live) != blue {
+		t.Fatalf("Expected no live color, got %s", live)
+	}
+}
+
+func Test_releaseCanary(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	d := &Deployment{Name: "app", ChartName: "chart"}
+	step := stagedStep(&StepStrategy{Type: CanaryStrategy, WeightPath: "canary.weight", Stages: []int{10, 50}})
+
+	h := &fakeHelm{}
+	db := &releaseRecorder{}
+	e := &engine{db: db, helm: h}
+	if _, err := e.releaseStep(stagedRelease(d, step)); err != nil {
+		t.Fatalf("Expected canary release to succeed, got %v", err)
+	}
+	if installs, deletes := h.calls(); installs != "app-prod-canary,app-prod-canary,app-prod" || deletes != "app-prod-canary" {
+		t.Fatalf("Expected canary stages then the release of the step, got %s and deleted %s", installs, deletes)
+	}
+	if !strings.Contains(h.values[1], "weight: 50") || strings.Contains(h.values[2], "weight") {
+		t.Fatalf("Expected weight in canary values only, got %v", h.values)
+	}
+	if recorded := db.recorded(); recorded != "canary/10/deployed,canary/50/deployed" {
+		t.Fatalf("Unexpected stage events %s", recorded)
+	}
+
+	// A failing stage aborts the canary release, the release of the step is not upgraded
+	h = &fakeHelm{fail: func(releaseName, values string) bool { return strings.Contains(values, "weight: 50") }}
+	db = &releaseRecorder{}
+	e = &engine{db: db, helm: h}
+	step.Options.Hooks = &StepHooks{OnFailure: []*Hook{{Name: "notify", Webhook: &Webhook{URL: server.URL}}}}
+	sr := stagedRelease(d, step)
+	sr.hooks = e.newHookContext(d, step, sr.name, sr.input, "")
+	_, err := e.releaseStep(sr)
+	if err == nil || !strings.Contains(err.Error(), "Canary release app-prod-canary aborted at a weight of 50%") {
+		t.Fatalf("Expected canary release to be aborted, got %v", err)
+	}
+	if installs, deletes := h.calls(); installs != "app-prod-canary" || deletes != "app-prod-canary" {
+		t.Fatalf("Expected canary release to be deleted, got %s and deleted %s", installs, deletes)
+	}
+	if recorded := db.recorded(); recorded != "canary/10/deployed,canary/50/failed,abort/50/failed" {
+		t.Fatalf("Unexpected stage events %s", recorded)
+	}
+	if hooks := db.releases[1].Hooks; len(hooks) != 1 || hooks[0].Phase != OnFailureHook || !hooks[0].Success {
+		t.Fatalf("Expected on_failure hooks to run for the failed stage, got %+v", hooks)
+	}
+}
+
+func Test_releaseBlueGreen(t *testing.T) {
+	d := &Deployment{Name: "app", ChartName: "chart", Releases: []*Release{
+		{Name: "app-prod", Namespace: "prod", Status: Deployed,
+			Stage: &ReleaseStage{Strategy: BlueGreenStrategy, Name: SwitchStage, Color: blue}},
+	}}
+	step := stagedStep(&StepStrategy{Type: BlueGreenStrategy, ColorPath: "color", SelectorPath: "service.color"})
+
+	h := &fakeHelm{}
+	db := &releaseRecorder{}
+	e := &engine{db: db, helm: h}
+	sr := stagedRelease(d, step)
+	reports, err := e.releaseStep(sr)
+	if err != nil {
+		t.Fatalf("Expected blue/green release to succeed, got %v", err)
+	}
+	if installs, _ := h.calls(); installs != "app-prod-green,app-prod" {
+		t.Fatalf("Expected green then the switch, got %s", installs)
+	}
+	if !strings.Contains(h.values[0], "color: green") || !strings.Contains(h.values[1], "color: green") ||
+		!strings.Contains(h.values[1], "service:") {
+		t.Fatalf("Expected color then selector to be set, got %v", h.values)
+	}
+	if report := reports[len(reports)-1]; report != "Traffic of release app-prod switches from blue to green once it is deployed" {
+		t.Fatalf("Unexpected report %s", report)
+	}
+	// The switch is recorded once the release of the step is deployed
+	if recorded := db.recorded(); recorded != "color/green/deployed" {
+		t.Fatalf("Expected switch not to be recorded before the outcome of the release, got %s", recorded)
+	}
+	if outcome := e.registerStepOutcome(sr, &Release{Name: "app-prod", Namespace: "prod"}, nil); outcome != Deployed {
+		t.Fatalf("Expected release to be deployed, got %v", outcome)
+	}
+	if recorded := db.recorded(); recorded != "color/green/deployed,app-prod/deployed,switch/green/deployed" {
+		t.Fatalf("Unexpected stage events %s", recorded)
+	}
+
+	// Traffic stays on the live color when the release of the step fails after the switch
+	h = &fakeHelm{failedStatus: true}
+	db = &releaseRecorder{}
+	e = &engine{db: db, helm: h}
+	sr = stagedRelease(d, step)
+	if _, err = e.releaseStep(sr); err != nil {
+		t.Fatalf("Expected switch to be installed, got %v", err)
+	}
+	if outcome := e.registerStepOutcome(sr, &Release{Name: "app-prod", Namespace: "prod"}, nil); outcome != Failed {
+		t.Fatalf("Expected release to fail, got %v", outcome)
+	}
+	if recorded := db.recorded(); recorded != "color/green/deployed,app-prod/failed,switch/green/failed" {
+		t.Fatalf("Unexpected stage events %s", recorded)
+	}
+	if live := liveColor(&Deployment{Releases: append(db.releases, d.Releases...)}, "prod"); live != blue {
+		t.Fatalf("Expected blue to stay live, got %s", live)
+	}
+
+	// Traffic stays on the live color when the switch fails
+	h = &fakeHelm{fail: func(releaseName, values string) bool { return releaseName == "app-prod" }}
+	db = &releaseRecorder{}
+	e = &engine{db: db, helm: h}
+	if _, err = e.releaseStep(stagedRelease(d, step)); err == nil ||
+		!strings.Contains(err.Error(), "Cannot switch traffic of release app-prod to green") {
+		t.Fatalf("Expected switch to fail, got %v", err)
+	}
+	if recorded := db.recorded(); recorded != "color/green/deployed,switch/green/failed" {
+		t.Fatalf("Unexpected stage events %s", recorded)
+	}
+	d.Releases = append(db.releases, d.Releases...)
+	if live := liveColor(d, "prod"); live != blue {
+		t.Fatalf("Expected blue to stay live, got %s", live)
+	}
+}
+
+func Test_deployPlanStaged(t *testing.T) {
+	d := &Deployment{Name: "app", ChartName: "chart", Releases: []*Release{
+		{Name: "app-prod", Namespace: "prod", Status: Deployed, Revision: 3},
+	}}
+	step := stagedStep(&StepStrategy{Type: CanaryStrategy, WeightPath: "canary.weight", Stages: []int{50}})
+	h := &fakeHelm{}
+	db := &releaseRecorder{}
+	e := &engine{db: db, helm: h}
+	plan := &releasePlan{input: &releaseInput{imageTag: "1.1.0"}, steps: []*PipelineStep{step}, stepsValues: []Values{{}}}
+
+	// The request returns once the stages started, they are recorded as they pass
+	reports, err := e.deployPlan(d, plan)
+	if err != nil || len(reports) != 1 ||
+		reports[0] != "Started canary release app-prod in namespace prod, its stages are recorded as release events" {
+		t.Fatalf("Expected staged release to start, got %v %v", err, reports)
+	}
+	if recorded := db.waitRecorded(t, 2); recorded != "canary/50/deployed,app-prod/deployed" {
+		t.Fatalf("Unexpected releases %s", recorded)
+	}
+	if db.releases[1].Revision != 4 || db.releases[1].ImageTag != "1.1.0" {
+		t.Fatalf("Expected revision 4 of the release of the step, got %+v", db.releases[1])
+	}
+}
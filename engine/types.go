@@ -14,15 +14,28 @@ import (
 //Ex: new pushes must be automatically deployed to dev and load namespaces,
 //then a manual promotion can happen from dev to staging and from staging to prod
 type Deployment struct {
-	ID            int             `json:"id"`
-	Name          string          `json:"name"`
-	ChartName     string          `json:"chart_name"`
-	ChartVersion  string          `json:"chart_version"`
-	RepositoryURL string          `json:"repository_url"`
-	Releases      []*Release      `json:"releases"`
-	Pipeline      []*PipelineStep `json:"pipeline"`
-	CreationDate  time.Time       `json:"creation_date"`
-	LastUpdate    time.Time       `json:"last_update"`
+	ID              int                `json:"id"`
+	Name            string             `json:"name"`
+	ChartName       string             `json:"chart_name"`
+	ChartVersion    string             `json:"chart_version"`
+	RepositoryURL   string             `json:"repository_url"`
+	Releases        []*Release         `json:"releases"`
+	Pipeline        []*PipelineStep    `json:"pipeline"`
+	PipelineVersion int                `json:"pipeline_version"`
+	ImagePaths      map[string]string  `json:"image_paths"`
+	CreationDate    time.Time          `json:"creation_date"`
+	LastUpdate      time.Time          `json:"last_update"`
+	ArchiveDate     *time.Time         `json:"archive_date,omitempty"`
+	Status          []*NamespaceStatus `json:"status,omitempty"`
+}
+
+//NamespaceStatus reports the release currently deployed in a namespace of the pipeline
+//ImageTag is empty if nothing has been released in the namespace yet
+type NamespaceStatus struct {
+	Namespace   string                 `json:"namespace"`
+	ImageTag    string                 `json:"image_tag"`
+	Status      GennakerReleaseOutcome `json:"status"`
+	ReleaseDate *time.Time             `json:"release_date,omitempty"`
 }
 
 //Release models a versioned release of the content of an helm chart
@@ -31,64 +44,313 @@ type Release struct {
 	Name         string                 `json:"name"`
 	DeploymentID int                    `json:"deployment_id"`
 	ImageTag     string                 `json:"image_tag"`
+	ImageTags    map[string]string      `json:"image_tags"`
 	Date         time.Time              `json:"date"`
 	Namespace    string                 `json:"namespace"`
-	Values       string                 `json:"values"`
+	Values       Values                 `json:"values"`
+	SecretValues SecretValues           `json:"secret_values,omitempty"`
 	Chart        string                 `json:"chart"`
 	ChartVersion string                 `json:"chart_version"`
 	Revision     int                    `json:"revision"`
 	Status       GennakerReleaseOutcome `json:"status"`
+	Hooks        []*HookResult          `json:"hooks,omitempty"`
+	HealthChecks []*HealthCheckResult   `json:"health_checks,omitempty"`
+	Workloads    []*WorkloadStatus      `json:"workloads,omitempty"`
+	// Stage is set on the release events recorded for each stage of the canary and blue/green strategies,
+	// and for the step at which a release of steps one after the other stopped
+	Stage *ReleaseStage `json:"stage,omitempty"`
+	// Diagnostics are collected when the release fails. They are not loaded with the releases of deployments
+	Diagnostics *ReleaseDiagnostics `json:"diagnostics,omitempty"`
+	// EncryptedSecretValues is the stored form of SecretValues
+	EncryptedSecretValues []byte `json:"-"`
+}
+
+//ValuesLayer is a version of the values managed by gennaker for a deployment.
+//Layers without Namespace hold the defaults of the deployment, the others
+//override them for a single namespace of the pipeline
+type ValuesLayer struct {
+	ID           int       `json:"id"`
+	DeploymentID int       `json:"deployment_id"`
+	Namespace    string    `json:"namespace,omitempty"`
+	Version      int       `json:"version"`
+	Values       Values    `json:"values"`
+	CreationDate time.Time `json:"creation_date"`
+}
+
+//VariableSet is a reusable, named set of variables which can be attached to many deployments.
+//Values of the deployments reference its variables with {{ var "name" }}
+type VariableSet struct {
+	ID           int         `json:"id"`
+	Name         string      `json:"name"`
+	Description  string      `json:"description"`
+	Variables    []*Variable `json:"variables"`
+	CreationDate time.Time   `json:"creation_date"`
+	LastUpdate   time.Time   `json:"last_update"`
+}
+
+//Application groups deployments sharing the same pipeline shape, which move through the pipeline together
+type Application struct {
+	ID           int                      `json:"id"`
+	Name         string                   `json:"name"`
+	Deployments  []*ApplicationDeployment `json:"deployments"`
+	CreationDate time.Time                `json:"creation_date"`
+	LastUpdate   time.Time                `json:"last_update"`
 }
 
-//PipelineStep models a specific step in the deployment lifecycle
+//ApplicationDeployment is a deployment of an application.
+//It is released after the deployments of the application it depends on
+type ApplicationDeployment struct {
+	Name      string   `json:"name"`
+	DependsOn []string `json:"depends_on,omitempty"`
+}
+
+//ApplicationSnapshot records the image tag of each deployment of an application, by deployment name
+type ApplicationSnapshot struct {
+	ID            int               `json:"id"`
+	ApplicationID int               `json:"application_id"`
+	Name          string            `json:"name"`
+	ImageTags     map[string]string `json:"image_tags"`
+	CreationDate  time.Time         `json:"creation_date"`
+}
+
+//Variable is a named value of a variable set.
+//A variable scoped to Namespaces is only defined in those namespaces,
+//a variable without scope is defined in every namespace
+type Variable struct {
+	Name       string   `json:"name"`
+	Value      string   `json:"value"`
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+//PipelineStep models a specific step in the deployment lifecycle.
+//Pipelines are directed acyclic graphs: a step with several parents, a join step,
+//is listed in the NextSteps of each of them.
+//ParentStepNumber is the first of ParentStepNumbers, kept for steps with a single parent
 type PipelineStep struct {
-	ID               int             `json:"id"`
-	StepNumber       int             `json:"step_number"`
-	ParentStepNumber int             `json:"parent_step_number"`
-	DeploymentID     int             `json:"deployment_id"`
-	TargetNamespace  string          `json:"target_namespace"`
-	AutomaticDeploy  bool            `json:"automatic_deploy"`
-	NextSteps        []*PipelineStep `json:"next_steps"`
+	ID                int             `json:"id"`
+	StepNumber        int             `json:"step_number"`
+	ParentStepNumber  int             `json:"parent_step_number"`
+	ParentStepNumbers []int           `json:"parent_step_numbers,omitempty"`
+	DeploymentID      int             `json:"deployment_id"`
+	TargetNamespace   string          `json:"target_namespace"`
+	AutomaticDeploy   bool            `json:"automatic_deploy"`
+	Options           *StepOptions    `json:"options,omitempty"`
+	NextSteps         []*PipelineStep `json:"next_steps"`
+}
+
+//parents returns the step numbers of every parent of the step
+func (s *PipelineStep) parents() []int {
+	if len(s.ParentStepNumbers) != 0 {
+		return s.ParentStepNumbers
+	}
+	if s.ParentStepNumber != 0 {
+		return []int{s.ParentStepNumber}
+	}
+	return nil
+}
+
+//StepOptions are the settings of a pipeline step, available from version 2 of gennaker.yml
+type StepOptions struct {
+	// Wait makes helm wait until the resources of the release are ready
+	Wait bool `json:"wait" yaml:"wait"`
+	// Timeout of helm operations, in seconds. Helm default is used when 0
+	Timeout int `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// Hooks run before and after the helm operation, and when the release fails
+	Hooks *StepHooks `json:"hooks,omitempty" yaml:"hooks,omitempty"`
+	// Namespace declares the namespace gennaker provisions before releasing to it
+	Namespace *NamespaceSpec `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	// AllowFill lets promotions to a downstream namespace release this manual step along the way
+	AllowFill bool `json:"allow_fill,omitempty" yaml:"allow_fill,omitempty"`
+	// Strategy releases the step, in place by default
+	Strategy *StepStrategy `json:"strategy,omitempty" yaml:"strategy,omitempty"`
+	// HealthChecks must pass for the release to be deployed
+	HealthChecks []*HealthCheck `json:"health_checks,omitempty" yaml:"health_checks,omitempty"`
+	// DependsOn lists the deployments which must be released to the namespace first
+	DependsOn []*DeploymentDependency `json:"depends_on,omitempty" yaml:"depends_on,omitempty"`
+}
+
+//PipelineDiff lists the steps that changed between the stored pipeline
+//of a deployment and the one built from a freshly fetched chart.
+//Steps are matched by step number and are flattened, so NextSteps is not set.
+type PipelineDiff struct {
+	Added   []*PipelineStep `json:"added"`
+	Removed []*PipelineStep `json:"removed"`
+	Updated []*PipelineStep `json:"updated"`
 }
 
+//ListDeploymentsRequest describes a page of deployments, sorted by SortBy
+//(name, chart, creation_date or last_update) in SortOrder (asc or desc).
+//Non empty ChartName, RepositoryURL and Namespace restrict the results
+//to deployments using that chart, that repository or deploying to that namespace
+type ListDeploymentsRequest struct {
+	Limit         int
+	Offset        int
+	SortBy        string
+	SortOrder     string
+	ChartName     string
+	RepositoryURL string
+	Namespace     string
+}
+
+//ReleaseNotification announces a new image tag for a deployment.
+//ImageTags sets the image tag of specific components of the chart, ImageTag
+//is used for every component not listed there.
+//Notifications sharing the same IdempotencyKey are only processed once
 type ReleaseNotification struct {
-	DeploymentName string
-	ImageTag       string
-	ReleaseValues  string
+	DeploymentName string            `json:"deployment_name"`
+	ImageTag       string            `json:"image_tag"`
+	ImageTags      map[string]string `json:"image_tags,omitempty"`
+	ReleaseValues  Values            `json:"release_values,omitempty"`
+	SecretValues   SecretValues      `json:"secret_values,omitempty"`
+	IdempotencyKey string            `json:"idempotency_key,omitempty"`
 }
 
 type PromoteRequest struct {
-	DeploymentName string
-	FromNamespace  string
-	ImageTag       string
-	ReleaseValues  string
+	DeploymentName string       `json:"deployment_name"`
+	FromNamespace  string       `json:"from_namespace"`
+	ImageTag       string       `json:"image_tag,omitempty"`
+	ReleaseValues  Values       `json:"release_values,omitempty"`
+	SecretValues   SecretValues `json:"secret_values,omitempty"`
 }
 
+//PromoteToTargetRequest promotes an image tag to a namespace of the pipeline, whatever its distance
+//to the namespaces the image tag is deployed to.
+//The image tag must be deployed successfully at every upstream step. With FillIntermediateSteps,
+//upstream manual steps which allow it are released along the way instead, each of them being
+//deployed, its rollout and health checks included, before the next step is released
+type PromoteToTargetRequest struct {
+	DeploymentName        string
+	TargetNamespace       string
+	ImageTag              string
+	FillIntermediateSteps bool
+	ReleaseValues         Values
+	SecretValues          SecretValues
+}
+
+//DeleteRequest describes the removal of a deployment.
+//Unless HardDelete is set, the deployment is only archived: it is hidden
+//from listings but its release history is kept.
+//If UninstallReleases is set, the helm releases of every namespace
+//of the pipeline are deleted as well
+type DeleteRequest struct {
+	DeploymentName    string
+	HardDelete        bool
+	UninstallReleases bool
+}
+
+//RollbackRequest rolls a namespace back to the release before the last one, or to the release
+//identified by one of Revision, ReleaseID or ImageTag
 type RollbackRequest struct {
 	DeploymentName string
 	Namespace      string
 	Revision       int
+	ReleaseID      int
+	ImageTag       string
+}
+
+//PipelineRollbackRequest reverts an image tag in every namespace of the pipeline running it
+type PipelineRollbackRequest struct {
+	DeploymentName string
+	ImageTag       string
+}
+
+//SnapshotRequest records a snapshot of an application.
+//Deployments missing from ImageTags take the image tag of their last successful release in FromNamespace
+type SnapshotRequest struct {
+	ApplicationName string
+	Name            string
+	ImageTags       map[string]string
+	FromNamespace   string
+}
+
+//PromoteSnapshotRequest releases the image tags of a snapshot to a namespace of the pipeline of the application
+type PromoteSnapshotRequest struct {
+	ApplicationName string
+	SnapshotName    string
+	TargetNamespace string
 }
 
 //DeploymentService describes all functionalities exposed by gennaker
 type DeploymentEngine interface {
-	ListDeployments(limit, offset int) ([]*Deployment, error)
-	ListDeploymentsWithStatus(limit, offset int) ([]*Deployment, error)
+	ListDeployments(request *ListDeploymentsRequest) ([]*Deployment, error)
+	ListDeploymentsWithStatus(request *ListDeploymentsRequest) ([]*Deployment, error)
 	GetDeployment(name string) (*Deployment, error)
 	CreateDeployment(deployment *Deployment) (int, error)
-	HandleNewReleaseNotification(notification *ReleaseNotification) ([]string, error)
+	UpdateDeployment(deployment *Deployment) (*PipelineDiff, error)
+	Resync(name string) (*PipelineDiff, error)
+	ValidatePipeline(content []byte) ([]*PipelineStep, error)
+	DeleteDeployment(request *DeleteRequest) ([]string, error)
+	HandleNewReleaseNotification(notification *ReleaseNotification) (*NotificationRecord, error)
 	PromoteRelease(request *PromoteRequest) ([]string, error)
 	Rollback(request *RollbackRequest) (string, error)
+	RollbackImageTag(request *PipelineRollbackRequest) ([]string, error)
+	GetEnvironmentMatrix(request *ListDeploymentsRequest) (*EnvironmentMatrix, error)
+	GetPipelineGraph(deploymentName string) (*PipelineGraph, error)
+	GetReleaseDiagnostics(deploymentName string, releaseID int) (*ReleaseDiagnostics, error)
+	PromoteToTarget(request *PromoteToTargetRequest) ([]string, error)
+	GetValuesLayer(deploymentName, namespace string) (*ValuesLayer, error)
+	GetValuesLayerHistory(deploymentName, namespace string) ([]*ValuesLayer, error)
+	UpdateValuesLayer(deploymentName, namespace string, values Values) (*ValuesLayer, error)
+	GetMergedValues(deploymentName, namespace string) (Values, error)
+	ListVariableSets() ([]*VariableSet, error)
+	GetVariableSet(name string) (*VariableSet, error)
+	CreateVariableSet(set *VariableSet) (int, error)
+	UpdateVariableSet(set *VariableSet) error
+	DeleteVariableSet(name string) error
+	GetDeploymentVariableSets(deploymentName string) ([]*VariableSet, error)
+	SetDeploymentVariableSets(deploymentName string, variableSetNames []string) ([]*VariableSet, error)
+	ListApplications() ([]*Application, error)
+	GetApplication(name string) (*Application, error)
+	CreateApplication(application *Application) (int, error)
+	CreateSnapshot(request *SnapshotRequest) (*ApplicationSnapshot, error)
+	ListSnapshots(applicationName string) ([]*ApplicationSnapshot, error)
+	PromoteSnapshot(request *PromoteSnapshotRequest) ([]string, error)
+	CreateSchedule(schedule *Schedule) (int, error)
+	ListSchedules() ([]*Schedule, error)
+	GetSchedule(id int) (*Schedule, error)
+	CancelSchedule(id int) (*Schedule, error)
+	RunScheduler(interval time.Duration, stop <-chan struct{})
 }
 
 //DeploymentRepository contains all necessary database support methods
 //needed by the DeploymentService
 type DeploymentRepository interface {
-	ListDeployments(limit, offset int) ([]*Deployment, error)
-	ListDeploymentsWithStatus(limit, offset int) ([]*Deployment, error)
+	ListDeployments(request *ListDeploymentsRequest) ([]*Deployment, error)
+	ListDeploymentsWithStatus(request *ListDeploymentsRequest) ([]*Deployment, error)
+	ListDeploymentsWithReleases(request *ListDeploymentsRequest) ([]*Deployment, error)
 	GetDeployment(name string) (*Deployment, error)
 	CreateDeployment(deployment *Deployment) error
+	UpdateDeployment(deployment *Deployment, diff *PipelineDiff) error
+	ArchiveDeployment(deploymentID int) error
+	DeleteDeployment(deploymentID int) error
 	CreateRelease(release *Release) (int, error)
+	GetReleaseDiagnostics(deploymentID, releaseID int) (*ReleaseDiagnostics, error)
+	GetReleaseNotification(deploymentID int, idempotencyKey, imageTag, valuesHash string, since time.Time) (*NotificationRecord, error)
+	CreateReleaseNotification(record *NotificationRecord, since time.Time) error
+	UpdateReleaseNotification(record *NotificationRecord) error
+	GetValuesLayers(deploymentID int) ([]*ValuesLayer, error)
+	GetValuesLayerHistory(deploymentID int, namespace string) ([]*ValuesLayer, error)
+	CreateValuesLayer(layer *ValuesLayer) error
+	ListVariableSets() ([]*VariableSet, error)
+	GetVariableSet(name string) (*VariableSet, error)
+	CreateVariableSet(set *VariableSet) error
+	UpdateVariableSet(set *VariableSet) error
+	DeleteVariableSet(variableSetID int) error
+	GetDeploymentVariableSets(deploymentID int) ([]*VariableSet, error)
+	SetDeploymentVariableSets(deploymentID int, variableSetIDs []int) error
+	ListApplications() ([]*Application, error)
+	GetApplication(name string) (*Application, error)
+	CreateApplication(application *Application) error
+	CreateSnapshot(snapshot *ApplicationSnapshot) error
+	GetSnapshots(applicationID int) ([]*ApplicationSnapshot, error)
+	CreateSchedule(schedule *Schedule) error
+	ListSchedules() ([]*Schedule, error)
+	GetSchedule(id int) (*Schedule, error)
+	CancelSchedule(id int) error
+	ClaimDueSchedules(limit int) ([]*Schedule, error)
+	RenewScheduleClaim(id int) error
+	RecoverStaleSchedules(lease time.Duration) (int, error)
+	UpdateSchedule(schedule *Schedule) error
 }
 
 func (d *Deployment) valid() error {
@@ -109,16 +371,75 @@ func (d *Deployment) valid() error {
 	return nil
 }
 
+// Default and maximum page size when listing deployments
+const (
+	DefaultListLimit = 20
+	MaxListLimit     = 100
+)
+
+var deploymentSortFields = map[string]bool{
+	"name":          true,
+	"chart":         true,
+	"creation_date": true,
+	"last_update":   true,
+}
+
+// valid checks the request, setting default values where needed
+func (r *ListDeploymentsRequest) valid() error {
+	if r.Limit == 0 {
+		r.Limit = DefaultListLimit
+	}
+	if r.Limit < 0 || r.Limit > MaxListLimit {
+		return errors.Errorf("Limit must be between 1 and %d", MaxListLimit)
+	}
+	if r.Offset < 0 {
+		return errors.New("Offset cannot be negative")
+	}
+	if len(strings.TrimSpace(r.SortBy)) == 0 {
+		r.SortBy = "name"
+	}
+	if !deploymentSortFields[r.SortBy] {
+		return errors.Errorf("Cannot sort by %s", r.SortBy)
+	}
+	r.SortOrder = strings.ToLower(r.SortOrder)
+	if len(strings.TrimSpace(r.SortOrder)) == 0 {
+		r.SortOrder = "asc"
+	}
+	if r.SortOrder != "asc" && r.SortOrder != "desc" {
+		return errors.New("Sort order must be either asc or desc")
+	}
+	return nil
+}
+
+func (d *Deployment) archived() bool {
+	return d.ArchiveDate != nil
+}
+
+// imagePaths returns the value paths of the image tags of the deployment, indexed by component
+func (d *Deployment) imagePaths() map[string]string {
+	if len(d.ImagePaths) == 0 {
+		return defaultImagePaths()
+	}
+	return d.ImagePaths
+}
+
 func (r *ReleaseNotification) valid() error {
 	if len(strings.TrimSpace(r.DeploymentName)) == 0 {
 		return errors.New("Deployment name cannot be empty")
 	}
-	if len(strings.TrimSpace(r.ImageTag)) == 0 {
+	if len(strings.TrimSpace(r.ImageTag)) == 0 && len(r.ImageTags) == 0 {
 		return errors.New("ImageTag cannot be empty")
 	}
-	if len(strings.TrimSpace(r.ReleaseValues)) != 0 && // TODO use regex
-		!strings.Contains(r.ReleaseValues, "=") {
-		return errors.New("Invalid ReleaseValues")
+	for component, tag := range r.ImageTags {
+		if len(strings.TrimSpace(tag)) == 0 {
+			return errors.Errorf("ImageTag of component %s cannot be empty", component)
+		}
+	}
+	if err := r.ReleaseValues.valid(); err != nil {
+		return errors.Wrap(err, "Invalid ReleaseValues")
+	}
+	if err := Values(r.SecretValues).valid(); err != nil {
+		return errors.Wrap(err, "Invalid SecretValues")
 	}
 	return nil
 }
@@ -130,9 +451,65 @@ func (r *PromoteRequest) valid() error {
 	if len(strings.TrimSpace(r.FromNamespace)) == 0 {
 		return errors.New("FromNamespace cannot be empty")
 	}
-	if len(strings.TrimSpace(r.ReleaseValues)) != 0 && // TODO use regex
-		!strings.Contains(r.ReleaseValues, "=") {
-		return errors.New("Invalid ReleaseValues")
+	if err := r.ReleaseValues.valid(); err != nil {
+		return errors.Wrap(err, "Invalid ReleaseValues")
+	}
+	if err := Values(r.SecretValues).valid(); err != nil {
+		return errors.Wrap(err, "Invalid SecretValues")
+	}
+	return nil
+}
+
+func (r *PromoteToTargetRequest) valid() error {
+	if len(strings.TrimSpace(r.DeploymentName)) == 0 {
+		return errors.New("Deployment name cannot be empty")
+	}
+	if len(strings.TrimSpace(r.TargetNamespace)) == 0 {
+		return errors.New("TargetNamespace cannot be empty")
+	}
+	if len(strings.TrimSpace(r.ImageTag)) == 0 {
+		return errors.New("ImageTag cannot be empty")
+	}
+	if err := r.ReleaseValues.valid(); err != nil {
+		return errors.Wrap(err, "Invalid ReleaseValues")
+	}
+	if err := Values(r.SecretValues).valid(); err != nil {
+		return errors.Wrap(err, "Invalid SecretValues")
+	}
+	return nil
+}
+
+func (s *VariableSet) valid() error {
+	if len(strings.TrimSpace(s.Name)) == 0 {
+		return errors.New("Variable set name cannot be empty")
+	}
+	// A variable can be defined once without scope and once per namespace
+	scopes := make(map[string]bool)
+	for _, v := range s.Variables {
+		if v == nil || len(strings.TrimSpace(v.Name)) == 0 {
+			return errors.New("Variable name cannot be empty")
+		}
+		namespaces := v.Namespaces
+		if len(namespaces) == 0 {
+			namespaces = []string{""}
+		}
+		for _, namespace := range namespaces {
+			scope := v.Name + "/" + namespace
+			if scopes[scope] {
+				if len(namespace) == 0 {
+					return errors.Errorf("Variable %s is defined twice", v.Name)
+				}
+				return errors.Errorf("Variable %s is defined twice for namespace %s", v.Name, namespace)
+			}
+			scopes[scope] = true
+		}
+	}
+	return nil
+}
+
+func (r *DeleteRequest) valid() error {
+	if len(strings.TrimSpace(r.DeploymentName)) == 0 {
+		return errors.New("Deployment name cannot be empty")
 	}
 	return nil
 }
@@ -144,5 +521,56 @@ func (r *RollbackRequest) valid() error {
 	if len(strings.TrimSpace(r.Namespace)) == 0 {
 		return errors.New("Namespace cannot be empty")
 	}
+	targets := 0
+	if r.Revision != 0 {
+		targets++
+	}
+	if r.ReleaseID != 0 {
+		targets++
+	}
+	if len(strings.TrimSpace(r.ImageTag)) != 0 {
+		targets++
+	}
+	if targets > 1 {
+		return errors.New("Only one of revision, release ID and image tag can be set")
+	}
 	return nil
 }
+
+func (r *SnapshotRequest) valid() error {
+	if len(strings.TrimSpace(r.ApplicationName)) == 0 {
+		return errors.New("Application name cannot be empty")
+	}
+	if len(strings.TrimSpace(r.Name)) == 0 {
+		return errors.New("Snapshot name cannot be empty")
+	}
+	return nil
+}
+
+func (r *PromoteSnapshotRequest) valid() error {
+	if len(strings.TrimSpace(r.ApplicationName)) == 0 {
+		return errors.New("Application name cannot be empty")
+	}
+	if len(strings.TrimSpace(r.SnapshotName)) == 0 {
+		return errors.New("Snapshot name cannot be empty")
+	}
+	if len(strings.TrimSpace(r.TargetNamespace)) == 0 {
+		return errors.New("TargetNamespace cannot be empty")
+	}
+	return nil
+}
+
+func (r *PipelineRollbackRequest) valid() error {
+	if len(strings.TrimSpace(r.DeploymentName)) == 0 {
+		return errors.New("Deployment name cannot be empty")
+	}
+	if len(strings.TrimSpace(r.ImageTag)) == 0 {
+		return errors.New("Image tag cannot be empty")
+	}
+	return nil
+}
+
+//Empty reports whether the diff contains no change at all
+func (d *PipelineDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Updated) == 0
+}
@@ -0,0 +1,193 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// Values holds structured chart values, as they would appear in a helm values file
+type Values map[string]interface{}
+
+// UnmarshalJSON accepts either a JSON object or a string containing a YAML document
+func (v *Values) UnmarshalJSON(data []byte) error {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || bytes.Equal(trimmed, []byte("null")) {
+		*v = nil
+		return nil
+	}
+	if trimmed[0] == '"' {
+		var document string
+		if err := json.Unmarshal(trimmed, &document); err != nil {
+			return err
+		}
+		values, err := ParseYAMLValues([]byte(document))
+		if err != nil {
+			return err
+		}
+		*v = values
+		return nil
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(trimmed, &values); err != nil {
+		return errors.Wrap(err, "Values must be an object")
+	}
+	*v = Values(values)
+	return nil
+}
+
+// ParseYAMLValues parses a YAML document into values
+func ParseYAMLValues(document []byte) (Values, error) {
+	if len(bytes.TrimSpace(document)) == 0 {
+		return nil, nil
+	}
+	var raw interface{}
+	if err := yaml.Unmarshal(document, &raw); err != nil {
+		return nil, errors.Wrap(err, "Invalid YAML values")
+	}
+	if raw == nil {
+		return nil, nil
+	}
+	values, ok := normalizeValue(raw).(map[string]interface{})
+	if !ok {
+		return nil, errors.New("Values must be a map")
+	}
+	return Values(values), nil
+}
+
+// normalizeValue converts the maps produced by the YAML decoder into
+// maps with string keys, which can be encoded to JSON
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normalizeValue(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[key] = normalizeValue(item)
+		}
+		return m
+	case Values:
+		return normalizeValue(map[string]interface{}(v))
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = normalizeValue(item)
+		}
+		return l
+	default:
+		return v
+	}
+}
+
+func (v Values) valid() error {
+	for key := range v {
+		if len(strings.TrimSpace(key)) == 0 {
+			return errors.New("Value keys cannot be empty")
+		}
+	}
+	return nil
+}
+
+// copy returns a deep copy of the values
+func (v Values) copy() Values {
+	if v == nil {
+		return Values{}
+	}
+	return Values(normalizeValue(map[string]interface{}(v)).(map[string]interface{}))
+}
+
+// merge overrides the values with src: nested maps are merged recursively,
+// any other value of src replaces the existing one
+func (v Values) merge(src Values) {
+	if len(src) == 0 {
+		return
+	}
+	mergeMaps(v, src.copy())
+}
+
+func mergeMaps(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]interface{})
+		dstMap, dstIsMap := dst[key].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeMaps(dstMap, srcMap)
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+// setValue sets the value found at a dotted path, ex: image.tag,
+// creating intermediate maps when needed
+func (v Values) setValue(valuePath string, value interface{}) error {
+	keys := strings.Split(valuePath, ".")
+	current := map[string]interface{}(v)
+	for i, key := range keys {
+		if len(key) == 0 {
+			return errors.Errorf("Invalid value path %s", valuePath)
+		}
+		if i == len(keys)-1 {
+			current[key] = value
+			return nil
+		}
+		next, found := current[key]
+		if !found || next == nil {
+			m := make(map[string]interface{})
+			current[key] = m
+			current = m
+			continue
+		}
+		m, ok := next.(map[string]interface{})
+		if !ok {
+			return errors.Errorf("Cannot set %s: %s is not a map", valuePath, strings.Join(keys[:i+1], "."))
+		}
+		current = m
+	}
+	return nil
+}
+
+// readValuesFile parses a YAML values file. A missing file holds no values
+func readValuesFile(valuesFilePath string) (Values, error) {
+	content, err := ioutil.ReadFile(valuesFilePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, fmt.Sprintf("Cannot read values file %s", valuesFilePath))
+	}
+	values, err := ParseYAMLValues(content)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Cannot parse values file %s", valuesFilePath))
+	}
+	return values, nil
+}
+
+// writeValuesFile saves the values into a temporary YAML file, to be passed to helm.
+// The caller must remove the file
+func writeValuesFile(values Values) (string, error) {
+	content, err := yaml.Marshal(map[string]interface{}(values))
+	if err != nil {
+		return "", errors.Wrap(err, "Cannot encode values")
+	}
+	f, err := ioutil.TempFile("", "gennaker-values-")
+	if err != nil {
+		return "", errors.Wrap(err, "Cannot create values file")
+	}
+	defer f.Close()
+	if _, err = f.Write(content); err != nil {
+		os.Remove(f.Name())
+		return "", errors.Wrap(err, "Cannot write values file")
+	}
+	return f.Name(), nil
+}
@@ -0,0 +1,69 @@
+package engine
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_ValuesUnmarshalJSON(t *testing.T) {
+	tt := []struct {
+		testName  string
+		body      string
+		expected  string
+		shouldErr bool
+	}{
+		{testName: "JSON object", body: `{"release_values": {"replicas": 2, "db": {"host": "pg"}}}`,
+			expected: `{"db":{"host":"pg"},"replicas":2}`},
+		{testName: "YAML document", body: `{"release_values": "replicas: 2\ndb:\n  host: pg\n  ports: [5432]"}`,
+			expected: `{"db":{"host":"pg","ports":[5432]},"replicas":2}`},
+		{testName: "Values containing commas", body: `{"release_values": {"hosts": "a,b,c"}}`,
+			expected: `{"hosts":"a,b,c"}`},
+		{testName: "Missing values", body: `{}`, expected: `null`},
+		{testName: "Null values", body: `{"release_values": null}`, expected: `null`},
+		{testName: "Scalar YAML document", body: `{"release_values": "abc"}`, shouldErr: true},
+		{testName: "Invalid YAML document", body: `{"release_values": "a: [b"}`, shouldErr: true},
+		{testName: "JSON array", body: `{"release_values": [1, 2]}`, shouldErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.testName, func(t *testing.T) {
+			var request struct {
+				ReleaseValues Values `json:"release_values"`
+			}
+			err := json.Unmarshal([]byte(tc.body), &request)
+			if tc.shouldErr {
+				if err == nil {
+					t.Fatalf("Expected test to fail, got %v", request.ReleaseValues)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected test to succeed, got %v", err)
+			}
+			encoded, _ := json.Marshal(request.ReleaseValues)
+			if string(encoded) != tc.expected {
+				t.Fatalf("Expected %s, got %s", tc.expected, encoded)
+			}
+		})
+	}
+}
+
+func Test_setValue(t *testing.T) {
+	values := Values{"image": map[string]interface{}{"repository": "nginx"}}
+	if err := values.setValue("image.tag", "1.0.0"); err != nil {
+		t.Fatalf("Expected value to be set, got %v", err)
+	}
+	if err := values.setValue("sidecar.image.tag", "2.0.0"); err != nil {
+		t.Fatalf("Expected intermediate maps to be created, got %v", err)
+	}
+	encoded, _ := json.Marshal(values)
+	expected := `{"image":{"repository":"nginx","tag":"1.0.0"},"sidecar":{"image":{"tag":"2.0.0"}}}`
+	if string(encoded) != expected {
+		t.Fatalf("Expected %s, got %s", expected, encoded)
+	}
+	if err := values.setValue("image.repository.tag", "1.0.0"); err == nil {
+		t.Fatalf("Expected an error when setting a value below a scalar")
+	}
+	if err := values.setValue("image..tag", "1.0.0"); err == nil {
+		t.Fatalf("Expected an error with an empty key")
+	}
+}
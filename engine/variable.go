@@ -0,0 +1,213 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+func (e *engine) ListVariableSets() ([]*VariableSet, error) {
+	sets, err := e.db.ListVariableSets()
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot list variable sets")
+	}
+	return sets, nil
+}
+
+func (e *engine) GetVariableSet(name string) (*VariableSet, error) {
+	if len(strings.TrimSpace(name)) == 0 {
+		return nil, errors.New("Variable set name cannot be empty")
+	}
+	return e.db.GetVariableSet(name)
+}
+
+func (e *engine) CreateVariableSet(set *VariableSet) (int, error) {
+	if set == nil {
+		return 0, ErrBadRequest
+	}
+	if err := set.valid(); err != nil {
+		return 0, errors.Wrap(err, "Variable set is invalid")
+	}
+	if err := e.db.CreateVariableSet(set); err != nil {
+		return 0, errors.Wrap(err, "Cannot save variable set")
+	}
+	return set.ID, nil
+}
+
+// UpdateVariableSet replaces the description and the variables of an existing variable set
+func (e *engine) UpdateVariableSet(set *VariableSet) error {
+	if set == nil {
+		return ErrBadRequest
+	}
+	if err := set.valid(); err != nil {
+		return errors.Wrap(err, "Variable set is invalid")
+	}
+	current, err := e.db.GetVariableSet(set.Name)
+	if err != nil {
+		return errors.Wrap(err, "Cannot get variable set")
+	}
+	set.ID = current.ID
+	if err = e.db.UpdateVariableSet(set); err != nil {
+		return errors.Wrap(err, "Cannot update variable set")
+	}
+	return nil
+}
+
+// DeleteVariableSet deletes a variable set which is not attached to any deployment
+func (e *engine) DeleteVariableSet(name string) error {
+	set, err := e.GetVariableSet(name)
+	if err != nil {
+		return errors.Wrap(err, "Cannot get variable set")
+	}
+	return e.db.DeleteVariableSet(set.ID)
+}
+
+// GetDeploymentVariableSets returns the variable sets attached to a deployment, in order
+func (e *engine) GetDeploymentVariableSets(deploymentName string) ([]*VariableSet, error) {
+	d, err := e.db.GetDeployment(deploymentName)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get deployment")
+	}
+	return e.db.GetDeploymentVariableSets(d.ID)
+}
+
+// SetDeploymentVariableSets replaces the variable sets attached to a deployment.
+// When several sets define the same variable, the last one wins
+func (e *engine) SetDeploymentVariableSets(deploymentName string, variableSetNames []string) ([]*VariableSet, error) {
+	d, err := e.db.GetDeployment(deploymentName)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get deployment")
+	}
+	if d.archived() {
+		return nil, ErrDeploymentArchived
+	}
+	sets := make([]*VariableSet, 0, len(variableSetNames))
+	ids := make([]int, 0, len(variableSetNames))
+	attached := make(map[string]bool)
+	for _, name := range variableSetNames {
+		if attached[name] {
+			return nil, errors.Errorf("Variable set %s is listed twice", name)
+		}
+		attached[name] = true
+		set, err := e.db.GetVariableSet(name)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Cannot get variable set %s", name))
+		}
+		sets = append(sets, set)
+		ids = append(ids, set.ID)
+	}
+	if err = e.db.SetDeploymentVariableSets(d.ID, ids); err != nil {
+		return nil, errors.Wrap(err, "Cannot attach variable sets")
+	}
+	return sets, nil
+}
+
+// resolveVariables returns the variables defined in namespace by the variable sets.
+// Variables scoped to the namespace take precedence over the ones without scope,
+// then the last variable set wins
+func resolveVariables(sets []*VariableSet, namespace string) map[string]string {
+	variables := make(map[string]string)
+	scoped := make(map[string]bool)
+	for _, set := range sets {
+		for _, v := range set.Variables {
+			if len(v.Namespaces) == 0 {
+				if !scoped[v.Name] {
+					variables[v.Name] = v.Value
+				}
+				continue
+			}
+			for _, ns := range v.Namespaces {
+				if ns == namespace {
+					variables[v.Name] = v.Value
+					scoped[v.Name] = true
+					break
+				}
+			}
+		}
+	}
+	return variables
+}
+
+// templateData is available to the templates found in values,
+// ex: {{ .Namespace }} or {{ .Deployment.Name }}
+type templateData struct {
+	Namespace  string
+	ImageTag   string
+	ImageTags  map[string]string
+	Deployment *Deployment
+}
+
+// renderValues executes every string value containing a template.
+// Undefined variables, referenced with {{ var "name" }}, make the rendering fail.
+// Secrets of external providers are referenced with {{ secret "provider" "key" }}
+func renderValues(values Values, data *templateData, variables map[string]string, secrets secretResolver) (Values, error) {
+	funcs := template.FuncMap{
+		"var": func(name string) (string, error) {
+			value, found := variables[name]
+			if !found {
+				return "", errors.Errorf("Variable %s is not defined in namespace %s", name, data.Namespace)
+			}
+			return value, nil
+		},
+		"secret": func(provider, key string) (string, error) {
+			if secrets == nil {
+				return "", errors.New("No secret provider is configured")
+			}
+			return secrets(provider, key)
+		},
+	}
+	rendered, err := renderValue(values, "", data, funcs)
+	if err != nil {
+		return nil, err
+	}
+	return Values(rendered.(map[string]interface{})), nil
+}
+
+func renderValue(value interface{}, valuePath string, data *templateData, funcs template.FuncMap) (interface{}, error) {
+	switch v := value.(type) {
+	case Values:
+		return renderValue(map[string]interface{}(v), valuePath, data, funcs)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			itemPath := key
+			if len(valuePath) != 0 {
+				itemPath = valuePath + "." + key
+			}
+			rendered, err := renderValue(item, itemPath, data, funcs)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = rendered
+		}
+		return m, nil
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			rendered, err := renderValue(item, fmt.Sprintf("%s[%d]", valuePath, i), data, funcs)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = rendered
+		}
+		return l, nil
+	case string:
+		if !strings.Contains(v, "{{") {
+			return v, nil
+		}
+		tmpl, err := template.New(valuePath).Option("missingkey=error").Funcs(funcs).Parse(v)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Invalid template in value %s", valuePath))
+		}
+		var buf bytes.Buffer
+		if err = tmpl.Execute(&buf, data); err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Cannot resolve value %s", valuePath))
+		}
+		return buf.String(), nil
+	default:
+		return v, nil
+	}
+}
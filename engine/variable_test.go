@@ -0,0 +1,113 @@
+package engine
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func Test_resolveVariables(t *testing.T) {
+	sets := []*VariableSet{
+		{Name: "common", Variables: []*Variable{
+			{Name: "db_host", Value: "db.local"},
+			{Name: "db_host", Value: "db.prod", Namespaces: []string{"prod"}},
+			{Name: "region", Value: "eu"},
+		}},
+		{Name: "us", Variables: []*Variable{
+			{Name: "region", Value: "us"},
+			{Name: "db_host", Value: "db.us"},
+		}},
+	}
+	variables := resolveVariables(sets, "prod")
+	if variables["db_host"] != "db.prod" || variables["region"] != "us" {
+		t.Fatalf("Unexpected variables for prod %v", variables)
+	}
+	variables = resolveVariables(sets, "dev")
+	if variables["db_host"] != "db.us" || variables["region"] != "us" {
+		t.Fatalf("Unexpected variables for dev %v", variables)
+	}
+}
+
+func Test_renderValues(t *testing.T) {
+	data := &templateData{
+		Namespace:  "prod",
+		ImageTag:   "1.0.0",
+		ImageTags:  map[string]string{"api": "1.0.0"},
+		Deployment: &Deployment{Name: "app"},
+	}
+	variables := map[string]string{"db_host": "db.prod"}
+	secrets := func(provider, key string) (string, error) {
+		if provider != "file" || key != "db/password" {
+			return "", errors.Errorf("Secret %s not found", key)
+		}
+		return "s3cr3t", nil
+	}
+	tt := []struct {
+		testName  string
+		values    Values
+		expected  string
+		shouldErr string
+	}{
+		{testName: "Built-in fields", values: Values{"host": "{{ .Deployment.Name }}.{{ .Namespace }}.svc", "tag": "{{ .ImageTag }}"},
+			expected: `{"host":"app.prod.svc","tag":"1.0.0"}`},
+		{testName: "Variables in nested values", values: Values{"db": map[string]interface{}{"hosts": []interface{}{`{{ var "db_host" }}`, 5432}}},
+			expected: `{"db":{"hosts":["db.prod",5432]}}`},
+		{testName: "Component image tag", values: Values{"tag": `{{ index .ImageTags "api" }}`}, expected: `{"tag":"1.0.0"}`},
+		{testName: "Secret reference", values: Values{"password": `{{ secret "file" "db/password" }}`}, expected: `{"password":"s3cr3t"}`},
+		{testName: "Unknown secret", values: Values{"password": `{{ secret "file" "db/user" }}`}, shouldErr: "Cannot resolve value password"},
+		{testName: "Values without templates", values: Values{"a": "plain", "b": true}, expected: `{"a":"plain","b":true}`},
+		{testName: "Undefined variable", values: Values{"db": map[string]interface{}{"host": `{{ var "db_port" }}`}},
+			shouldErr: "Cannot resolve value db.host"},
+		{testName: "Unknown field", values: Values{"a": "{{ .Cluster }}"}, shouldErr: "Cannot resolve value a"},
+		{testName: "Invalid template", values: Values{"a": "{{ .Namespace "}, shouldErr: "Invalid template in value a"},
+	}
+	for _, tc := range tt {
+		t.Run(tc.testName, func(t *testing.T) {
+			values, err := renderValues(tc.values, data, variables, secrets)
+			if len(tc.shouldErr) != 0 {
+				if err == nil || !strings.HasPrefix(err.Error(), tc.shouldErr) {
+					t.Fatalf("Expected error %s, got %v", tc.shouldErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected test to succeed, got %v", err)
+			}
+			encoded, _ := json.Marshal(values)
+			if string(encoded) != tc.expected {
+				t.Fatalf("Expected %s, got %s", tc.expected, encoded)
+			}
+		})
+	}
+}
+
+func Test_VariableSetValid(t *testing.T) {
+	tt := []struct {
+		testName  string
+		set       *VariableSet
+		shouldErr bool
+	}{
+		{testName: "Valid set", set: &VariableSet{Name: "eu", Variables: []*Variable{
+			{Name: "db_host", Value: "db"}, {Name: "db_host", Value: "db.prod", Namespaces: []string{"prod"}}}}},
+		{testName: "Empty name", set: &VariableSet{Name: " "}, shouldErr: true},
+		{testName: "Empty variable name", set: &VariableSet{Name: "eu", Variables: []*Variable{{Value: "db"}}}, shouldErr: true},
+		{testName: "Duplicate variable", set: &VariableSet{Name: "eu", Variables: []*Variable{
+			{Name: "db_host", Value: "a"}, {Name: "db_host", Value: "b"}}}, shouldErr: true},
+		{testName: "Duplicate variable in namespace", set: &VariableSet{Name: "eu", Variables: []*Variable{
+			{Name: "db_host", Value: "a", Namespaces: []string{"int", "prod"}},
+			{Name: "db_host", Value: "b", Namespaces: []string{"prod"}}}}, shouldErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.testName, func(t *testing.T) {
+			err := tc.set.valid()
+			if tc.shouldErr && err == nil {
+				t.Fatalf("Expected test to fail")
+			}
+			if !tc.shouldErr && err != nil {
+				t.Fatalf("Expected test to succeed, got %v", err)
+			}
+		})
+	}
+}
@@ -176,9 +176,20 @@ func AddRepository(url string) (string, error) {
 	return name, nil
 }
 
+// InstallOptions are the optional settings of InstallOrUpgrade
+type InstallOptions struct {
+	// Wait until the resources of the release are ready
+	Wait bool
+	// Timeout of the operation in seconds, helm default is used when 0
+	Timeout int
+}
+
 // InstallOrUpgrade installs or upgrades a given release name for the specified chart into the desired namespace.
 // If no prior release with the given releaseName is found, an install will be performed, an upgrade otherwise.
-func InstallOrUpgrade(releaseName, namespace, repositoryName, chartName, valuesFilePath, releaseValues string) (string, error) {
+// Values files are passed to helm in order, values in the last file taking precedence.
+// options can be nil
+func InstallOrUpgrade(releaseName, namespace, repositoryName, chartName string, valuesFilePaths []string,
+	options *InstallOptions) (string, error) {
 	if len(strings.TrimSpace(repositoryName)) == 0 || len(chartName) == 0 {
 		return "", errors.New("Repository name and chart name are mandatory")
 	}
@@ -190,11 +201,18 @@ func InstallOrUpgrade(releaseName, namespace, repositoryName, chartName, valuesF
 	if len(strings.TrimSpace(namespace)) != 0 {
 		cmdArgs = append(cmdArgs, "--namespace", namespace)
 	}
-	if len(strings.TrimSpace(valuesFilePath)) != 0 {
-		cmdArgs = append(cmdArgs, "-f", valuesFilePath)
+	for _, valuesFilePath := range valuesFilePaths {
+		if len(strings.TrimSpace(valuesFilePath)) != 0 {
+			cmdArgs = append(cmdArgs, "-f", valuesFilePath)
+		}
 	}
-	if len(strings.TrimSpace(releaseValues)) != 0 {
-		cmdArgs = append(cmdArgs, "--set", releaseValues)
+	if options != nil {
+		if options.Wait {
+			cmdArgs = append(cmdArgs, "--wait")
+		}
+		if options.Timeout > 0 {
+			cmdArgs = append(cmdArgs, "--timeout", strconv.Itoa(options.Timeout))
+		}
 	}
 	cmdArgs = append(cmdArgs, releaseName)
 	pkg := fmt.Sprintf("%s/%s", repositoryName, chartName)
@@ -365,6 +383,63 @@ func Rollback(releaseName string, revision int) (string, error) {
 	return output, nil
 }
 
+// Delete uninstalls a release, issuing helm delete command.
+// If purge is true, the release is also removed from helm history
+func Delete(releaseName string, purge bool) (string, error) {
+	if len(strings.TrimSpace(releaseName)) == 0 {
+		return "", errors.New("Release name is mandatory")
+	}
+	cmdName := helmCmd
+	var cmdArgs = []string{"delete"}
+	if purge {
+		cmdArgs = append(cmdArgs, "--purge")
+	}
+	cmdArgs = append(cmdArgs, releaseName)
+	cmd := exec.Command(cmdName, cmdArgs...)
+	cmdReader, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", errors.Wrap(err, "Error creating StdoutPipe for helm delete")
+	}
+	scanner := bufio.NewScanner(cmdReader)
+	var output string
+	go func() {
+		for scanner.Scan() {
+			line := scanner.Text()
+			output = strings.Join([]string{output, line}, "\n")
+		}
+	}()
+	cmdErrReader, err := cmd.StderrPipe()
+	if err != nil {
+		return "", errors.Wrap(err, "Error creating StderrPipe for helm delete")
+	}
+	errScanner := bufio.NewScanner(cmdErrReader)
+	deleteSuccess := true
+	var helmErrorMsg string
+	go func() {
+		for errScanner.Scan() {
+			line := errScanner.Text()
+			if strings.HasPrefix(line, "ERROR:") || strings.HasPrefix(line, "Error:") {
+				deleteSuccess = false
+				helmErrorMsg = line
+				break
+			}
+		}
+	}()
+	fmt.Printf("%s %s\n", cmdName, cmdArgs)
+	err = cmd.Start()
+	if err != nil {
+		return "", errors.Wrap(err, "Could not start command helm delete:")
+	}
+	err = cmd.Wait()
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("Error waiting for command helm delete: %s", helmErrorMsg))
+	}
+	if !deleteSuccess {
+		return output, errors.Errorf("Failed at deleting release: %s", helmErrorMsg)
+	}
+	return output, nil
+}
+
 func generateRandomRepoName() string {
 	return utils.GenerateRandomString(6)
 }
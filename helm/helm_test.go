@@ -69,23 +69,22 @@ func Test_Fetch(t *testing.T) {
 
 func Test_InstallOrUpgrade(t *testing.T) {
 	var tt = []struct {
-		testName       string
-		releaseName    string
-		namespace      string
-		repositoryName string
-		chartName      string
-		valuesFilePath string
-		releaseValues  string
-		shouldErr      bool
+		testName        string
+		releaseName     string
+		namespace       string
+		repositoryName  string
+		chartName       string
+		valuesFilePaths []string
+		shouldErr       bool
 	}{
 		{testName: "Successfull install", releaseName: /*utils.GenerateRandomString(10)*/ "happy-panda",
-			repositoryName: "stable", chartName: "consul", valuesFilePath: "", releaseValues: "", shouldErr: false},
+			repositoryName: "stable", chartName: "consul", valuesFilePaths: nil, shouldErr: false},
 		{testName: "Successfull upgrade", releaseName: /*utils.GenerateRandomString(10)*/ "happy-panda",
-			repositoryName: "stable", chartName: "consul", valuesFilePath: "", releaseValues: "", shouldErr: false},
+			repositoryName: "stable", chartName: "consul", valuesFilePaths: nil, shouldErr: false},
 	}
 	for _, tc := range tt {
 		t.Run(tc.testName, func(t *testing.T) {
-			output, err := InstallOrUpgrade(tc.releaseName, tc.namespace, tc.repositoryName, tc.chartName, tc.valuesFilePath, tc.releaseValues)
+			output, err := InstallOrUpgrade(tc.releaseName, tc.namespace, tc.repositoryName, tc.chartName, tc.valuesFilePaths, nil)
 			if tc.shouldErr {
 				if err == nil {
 					t.Fatalf("Expected test to fail. Install output %s", output)
@@ -116,7 +115,7 @@ func Test_Status(t *testing.T) {
 	for _, tc := range tt {
 		t.Run(tc.testName, func(t *testing.T) {
 			if tc.shouldInstall {
-				_, err := InstallOrUpgrade(tc.releaseName, "default", "stable", "consul", "", "")
+				_, err := InstallOrUpgrade(tc.releaseName, "default", "stable", "consul", nil, nil)
 				if err != nil {
 					t.Fatalf("Could not setup the test by installing a chart. Error details: %v", err)
 				}
@@ -0,0 +1,198 @@
+// Package kube reads the workloads, pods and events of helm releases from Kubernetes.
+// It uses client-go, configured from a kubeconfig or, running in a pod, from its service account.
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Kinds of workloads whose rollout is watched
+const (
+	DeploymentKind  = "Deployment"
+	StatefulSetKind = "StatefulSet"
+	DaemonSetKind   = "DaemonSet"
+)
+
+// releaseLabel is set by helm 2 charts on the resources of a release
+const releaseLabel = "release"
+
+// Client reads the workloads, pods and events of helm releases and provisions their namespaces
+type Client interface {
+	// Workloads returns the Deployments, StatefulSets and DaemonSets of a release
+	Workloads(namespace, releaseName string) ([]*Workload, error)
+	// Pods returns the pods of a release
+	Pods(namespace, releaseName string) ([]*Pod, error)
+	// Events returns the events of a namespace, from the oldest to the most recent
+	Events(namespace string) ([]*Event, error)
+	// Logs returns the last lines of logs of a container.
+	// Previous asks for the logs of the last terminated instance of the container
+	Logs(namespace, pod, container string, lines int, previous bool) (string, error)
+	// GetNamespace returns the namespace, with the ResourceQuota and LimitRange gennaker manages in it.
+	// It returns nil if the namespace does not exist
+	GetNamespace(name string) (*Namespace, error)
+	// ApplyNamespace creates the namespace or brings it in line with its declaration
+	ApplyNamespace(namespace *Namespace) error
+}
+
+// Workload is the rollout state of a Deployment, StatefulSet or DaemonSet
+type Workload struct {
+	Kind    string
+	Name    string
+	Desired int
+	Ready   int
+	Updated int
+	// Observed is false until the controller has seen the last update of the workload
+	Observed bool
+	// Stuck is true when a Deployment exceeded its progress deadline
+	Stuck bool
+}
+
+// RolledOut tells if every desired replica runs the last version of the workload and is ready
+func (w *Workload) RolledOut() bool {
+	return w.Observed && !w.Stuck && w.Updated >= w.Desired && w.Ready >= w.Desired
+}
+
+// Cluster is a Client using the Kubernetes API
+type Cluster struct {
+	client kubernetes.Interface
+}
+
+// NewCluster returns a client using kubeconfig and kubeContext. When they are empty,
+// it uses the KUBECONFIG environment variable, ~/.kube/config or the service account of the pod it runs in
+func NewCluster(kubeconfig, kubeContext string) (*Cluster, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: kubeContext}
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot load Kubernetes client configuration")
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot create Kubernetes client")
+	}
+	return &Cluster{client: client}, nil
+}
+
+func (k *Cluster) Workloads(namespace, releaseName string) ([]*Workload, error) {
+	apps := k.client.AppsV1()
+	options := releaseSelector(releaseName)
+	deployments, err := apps.Deployments(namespace).List(context.Background(), options)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Cannot get deployments of release %s", releaseName))
+	}
+	statefulSets, err := apps.StatefulSets(namespace).List(context.Background(), options)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Cannot get stateful sets of release %s", releaseName))
+	}
+	daemonSets, err := apps.DaemonSets(namespace).List(context.Background(), options)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Cannot get daemon sets of release %s", releaseName))
+	}
+	workloads := make([]*Workload, 0, len(deployments.Items)+len(statefulSets.Items)+len(daemonSets.Items))
+	for i := range deployments.Items {
+		workloads = append(workloads, deploymentWorkload(&deployments.Items[i]))
+	}
+	for i := range statefulSets.Items {
+		workloads = append(workloads, statefulSetWorkload(&statefulSets.Items[i]))
+	}
+	for i := range daemonSets.Items {
+		workloads = append(workloads, daemonSetWorkload(&daemonSets.Items[i]))
+	}
+	return workloads, nil
+}
+
+func (k *Cluster) Pods(namespace, releaseName string) ([]*Pod, error) {
+	list, err := k.client.CoreV1().Pods(namespace).List(context.Background(), releaseSelector(releaseName))
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Cannot get pods of release %s", releaseName))
+	}
+	return podStatuses(list.Items), nil
+}
+
+func (k *Cluster) Events(namespace string) ([]*Event, error) {
+	list, err := k.client.CoreV1().Events(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Cannot get events of namespace %s", namespace))
+	}
+	return namespaceEvents(list.Items), nil
+}
+
+func (k *Cluster) Logs(namespace, pod, container string, lines int, previous bool) (string, error) {
+	tail := int64(lines)
+	request := k.client.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{
+		Container: container,
+		TailLines: &tail,
+		Previous:  previous,
+	})
+	output, err := request.DoRaw(context.Background())
+	if err != nil {
+		return "", errors.Wrap(err, fmt.Sprintf("Cannot get logs of container %s of pod %s", container, pod))
+	}
+	return string(output), nil
+}
+
+// releaseSelector selects the resources of a release
+func releaseSelector(releaseName string) metav1.ListOptions {
+	return metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", releaseLabel, releaseName)}
+}
+
+func deploymentWorkload(d *appsv1.Deployment) *Workload {
+	w := &Workload{
+		Kind:     DeploymentKind,
+		Name:     d.Name,
+		Desired:  replicas(d.Spec.Replicas),
+		Ready:    int(d.Status.ReadyReplicas),
+		Updated:  int(d.Status.UpdatedReplicas),
+		Observed: d.Status.ObservedGeneration >= d.Generation,
+	}
+	for _, c := range d.Status.Conditions {
+		if c.Type == appsv1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+			w.Stuck = true
+		}
+	}
+	return w
+}
+
+func statefulSetWorkload(s *appsv1.StatefulSet) *Workload {
+	w := &Workload{
+		Kind:     StatefulSetKind,
+		Name:     s.Name,
+		Desired:  replicas(s.Spec.Replicas),
+		Ready:    int(s.Status.ReadyReplicas),
+		Updated:  int(s.Status.UpdatedReplicas),
+		Observed: s.Status.ObservedGeneration >= s.Generation,
+	}
+	// Every replica runs the last revision once the update revision became the current one
+	if s.Status.UpdateRevision == s.Status.CurrentRevision {
+		w.Updated = w.Desired
+	}
+	return w
+}
+
+func daemonSetWorkload(d *appsv1.DaemonSet) *Workload {
+	return &Workload{
+		Kind:     DaemonSetKind,
+		Name:     d.Name,
+		Desired:  int(d.Status.DesiredNumberScheduled),
+		Ready:    int(d.Status.NumberReady),
+		Updated:  int(d.Status.UpdatedNumberScheduled),
+		Observed: d.Status.ObservedGeneration >= d.Generation,
+	}
+}
+
+// replicas returns the desired replicas of a Deployment or StatefulSet, 1 when unset
+func replicas(desired *int32) int {
+	if desired == nil {
+		return 1
+	}
+	return int(*desired)
+}
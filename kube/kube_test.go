@@ -0,0 +1,207 @@
+package kube
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newCluster uses the simple fake clientset: the field managed one looks up types
+// by their package path, which the vendor directory changes
+func newCluster(objects ...runtime.Object) *Cluster {
+	return &Cluster{client: fake.NewSimpleClientset(objects...)}
+}
+
+func meta(name string, generation int64, release string) metav1.ObjectMeta {
+	return metav1.ObjectMeta{Name: name, Namespace: "prod", Generation: generation,
+		Labels: map[string]string{releaseLabel: release}}
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func Test_Workloads(t *testing.T) {
+	k := newCluster(
+		&appsv1.Deployment{
+			ObjectMeta: meta("api", 3, "shop"),
+			Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(3)},
+			Status:     appsv1.DeploymentStatus{ObservedGeneration: 3, ReadyReplicas: 3, UpdatedReplicas: 3},
+		},
+		&appsv1.Deployment{
+			ObjectMeta: meta("worker", 2, "shop"),
+			Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(2)},
+			Status: appsv1.DeploymentStatus{ObservedGeneration: 2, ReadyReplicas: 1, UpdatedReplicas: 1,
+				Conditions: []appsv1.DeploymentCondition{
+					{Type: appsv1.DeploymentAvailable, Reason: "MinimumReplicasAvailable"},
+					{Type: appsv1.DeploymentProgressing, Reason: "ProgressDeadlineExceeded"},
+				}},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: meta("db", 5, "shop"),
+			Status: appsv1.StatefulSetStatus{ObservedGeneration: 4, ReadyReplicas: 1,
+				CurrentRevision: "db-1", UpdateRevision: "db-1"},
+		},
+		&appsv1.DaemonSet{
+			ObjectMeta: meta("agent", 1, "shop"),
+			Status: appsv1.DaemonSetStatus{ObservedGeneration: 1, DesiredNumberScheduled: 4, NumberReady: 4,
+				UpdatedNumberScheduled: 4},
+		},
+		// Workloads of other releases are ignored
+		&appsv1.Deployment{ObjectMeta: meta("blog", 1, "blog")},
+	)
+	workloads, err := k.Workloads("prod", "shop")
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	expected := []struct {
+		workload  Workload
+		rolledOut bool
+	}{
+		{workload: Workload{Kind: DeploymentKind, Name: "api", Desired: 3, Ready: 3, Updated: 3, Observed: true}, rolledOut: true},
+		{workload: Workload{Kind: DeploymentKind, Name: "worker", Desired: 2, Ready: 1, Updated: 1, Observed: true, Stuck: true}},
+		{workload: Workload{Kind: StatefulSetKind, Name: "db", Desired: 1, Ready: 1, Updated: 1}},
+		{workload: Workload{Kind: DaemonSetKind, Name: "agent", Desired: 4, Ready: 4, Updated: 4, Observed: true}, rolledOut: true},
+	}
+	if len(workloads) != len(expected) {
+		t.Fatalf("Expected %d workloads, got %d", len(expected), len(workloads))
+	}
+	for i, w := range workloads {
+		if *w != expected[i].workload {
+			t.Errorf("Expected %+v, got %+v", expected[i].workload, *w)
+		}
+		if w.RolledOut() != expected[i].rolledOut {
+			t.Errorf("Expected rollout of %s to be %v", w.Name, expected[i].rolledOut)
+		}
+	}
+}
+
+func Test_Pods(t *testing.T) {
+	k := newCluster(
+		&corev1.Pod{
+			ObjectMeta: meta("api-7d9f-x2", 0, "shop"),
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "api", RestartCount: 3,
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+						Reason: "CrashLoopBackOff", Message: "back-off 40s restarting failed container"}},
+					LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+						Reason: "Error", ExitCode: 2}}},
+				{Name: "proxy", Ready: true,
+					State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}},
+			}},
+		},
+		&corev1.Pod{
+			ObjectMeta: meta("migrate-q8", 0, "shop"),
+			Status: corev1.PodStatus{Phase: corev1.PodSucceeded, ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "migrate", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+					Reason: "Completed"}}},
+			}},
+		},
+		&corev1.Pod{ObjectMeta: meta("blog-1", 0, "blog")},
+	)
+	pods, err := k.Pods("prod", "shop")
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if len(pods) != 2 || !pods[0].Failing() || pods[1].Failing() {
+		t.Fatalf("Expected first pod only to be failing, got %+v", pods)
+	}
+	api := pods[0].Containers[0]
+	if api.State != "waiting" || api.Reason != "CrashLoopBackOff" || !api.Crashed() || api.ExitCode == nil || *api.ExitCode != 2 {
+		t.Fatalf("Expected crashing container, got %+v", api)
+	}
+	if proxy := pods[0].Containers[1]; proxy.State != "running" || proxy.Crashed() || proxy.ExitCode != nil {
+		t.Fatalf("Expected running container, got %+v", proxy)
+	}
+	if migrate := pods[1].Containers[0]; migrate.ExitCode == nil || *migrate.ExitCode != 0 {
+		t.Fatalf("Expected exit code of terminated container, got %+v", migrate)
+	}
+
+	logs, err := k.Logs("prod", "api-7d9f-x2", "api", 50, true)
+	if err != nil || len(logs) == 0 {
+		t.Fatalf("Expected logs, got %q %v", logs, err)
+	}
+}
+
+func Test_Events(t *testing.T) {
+	scheduled := time.Date(2018, 3, 1, 10, 0, 0, 0, time.UTC)
+	k := newCluster(
+		&corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: "backoff", Namespace: "prod"},
+			Type:       corev1.EventTypeWarning, Reason: "BackOff", Message: "Back-off restarting failed container",
+			Count: 7, LastTimestamp: metav1.NewTime(scheduled.Add(5 * time.Minute)),
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "api-7d9f-x2"},
+		},
+		&corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{Name: "scheduled", Namespace: "prod"},
+			Type:       corev1.EventTypeNormal, Reason: "Scheduled", Message: "Successfully assigned api-7d9f-x2",
+			Count: 1, FirstTimestamp: metav1.NewTime(scheduled),
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "api-7d9f-x2"},
+		},
+	)
+	events, err := k.Events("prod")
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if len(events) != 2 || events[0].Reason != "Scheduled" || events[1].Reason != "BackOff" {
+		t.Fatalf("Expected events from the oldest to the most recent, got %+v", events)
+	}
+	if events[1].Object != "Pod/api-7d9f-x2" || events[1].Count != 7 || !events[0].LastSeen.Equal(scheduled) {
+		t.Fatalf("Unexpected events %+v %+v", events[0], events[1])
+	}
+}
+
+func Test_ApplyNamespace(t *testing.T) {
+	k := newCluster()
+	if ns, err := k.GetNamespace("prod"); err != nil || ns != nil {
+		t.Fatalf("Expected missing namespace, got %+v %v", ns, err)
+	}
+	namespace := &Namespace{
+		Name:          "prod",
+		Labels:        map[string]string{"team": "payments"},
+		ResourceQuota: &ResourceQuota{Hard: map[string]string{"pods": "40"}},
+		LimitRange: &LimitRange{Limits: []*LimitRangeItem{
+			{Type: "Container", DefaultRequest: map[string]string{"cpu": "100m"}},
+		}},
+	}
+	if err := k.ApplyNamespace(namespace); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	current, err := k.GetNamespace("prod")
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if !reflect.DeepEqual(current, namespace) {
+		t.Fatalf("Expected %+v, got %+v", namespace, current)
+	}
+
+	// Labels which are not declared are left untouched, the quota is replaced
+	update := &Namespace{
+		Name:          "prod",
+		Labels:        map[string]string{"tier": "front"},
+		ResourceQuota: &ResourceQuota{Hard: map[string]string{"pods": "20", "requests.cpu": "4"}},
+	}
+	if err = k.ApplyNamespace(update); err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	current, err = k.GetNamespace("prod")
+	if err != nil {
+		t.Fatalf("Expected success, got %v", err)
+	}
+	if !reflect.DeepEqual(current.Labels, map[string]string{"team": "payments", "tier": "front"}) ||
+		!reflect.DeepEqual(current.ResourceQuota, update.ResourceQuota) ||
+		!reflect.DeepEqual(current.LimitRange, namespace.LimitRange) {
+		t.Fatalf("Unexpected namespace %+v", current)
+	}
+
+	invalid := &Namespace{Name: "prod", ResourceQuota: &ResourceQuota{Hard: map[string]string{"pods": "many"}}}
+	if err = k.ApplyNamespace(invalid); err == nil {
+		t.Fatalf("Expected invalid quantity to be rejected")
+	}
+}
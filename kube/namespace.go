@@ -0,0 +1,233 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ManagedName names the ResourceQuota and LimitRange gennaker manages in each namespace
+const ManagedName = "gennaker"
+
+// Namespace is the declaration of a namespace, its ResourceQuota and its LimitRange
+type Namespace struct {
+	Name          string
+	Labels        map[string]string
+	Annotations   map[string]string
+	ResourceQuota *ResourceQuota
+	LimitRange    *LimitRange
+}
+
+// ResourceQuota limits the total resources of a namespace, ex: requests.cpu: "4"
+type ResourceQuota struct {
+	Hard map[string]string `json:"hard" yaml:"hard"`
+}
+
+// LimitRange sets the default and allowed resources of the containers or pods of a namespace
+type LimitRange struct {
+	Limits []*LimitRangeItem `json:"limits" yaml:"limits"`
+}
+
+// LimitRangeItem applies to a type of object, Container or Pod
+type LimitRangeItem struct {
+	Type           string            `json:"type" yaml:"type"`
+	Default        map[string]string `json:"default,omitempty" yaml:"default,omitempty"`
+	DefaultRequest map[string]string `json:"default_request,omitempty" yaml:"default_request,omitempty"`
+	Max            map[string]string `json:"max,omitempty" yaml:"max,omitempty"`
+	Min            map[string]string `json:"min,omitempty" yaml:"min,omitempty"`
+}
+
+func (k *Cluster) GetNamespace(name string) (*Namespace, error) {
+	core := k.client.CoreV1()
+	ns, err := core.Namespaces().Get(context.Background(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Cannot get namespace %s", name))
+	}
+	namespace := &Namespace{Name: ns.Name, Labels: ns.Labels, Annotations: ns.Annotations}
+	quota, err := core.ResourceQuotas(name).Get(context.Background(), ManagedName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return nil, errors.Wrap(err, fmt.Sprintf("Cannot get resource quota of namespace %s", name))
+	default:
+		namespace.ResourceQuota = &ResourceQuota{Hard: quantityStrings(quota.Spec.Hard)}
+	}
+	limitRange, err := core.LimitRanges(name).Get(context.Background(), ManagedName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return nil, errors.Wrap(err, fmt.Sprintf("Cannot get limit range of namespace %s", name))
+	default:
+		namespace.LimitRange = limitRangeOf(&limitRange.Spec)
+	}
+	return namespace, nil
+}
+
+// ApplyNamespace creates the namespace, its ResourceQuota and its LimitRange, or updates them.
+// Labels and annotations which are not declared are left untouched
+func (k *Cluster) ApplyNamespace(namespace *Namespace) error {
+	if err := k.applyNamespace(namespace); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("Cannot apply namespace %s", namespace.Name))
+	}
+	if namespace.ResourceQuota != nil {
+		if err := k.applyResourceQuota(namespace.Name, namespace.ResourceQuota); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("Cannot apply resource quota of namespace %s", namespace.Name))
+		}
+	}
+	if namespace.LimitRange != nil {
+		if err := k.applyLimitRange(namespace.Name, namespace.LimitRange); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("Cannot apply limit range of namespace %s", namespace.Name))
+		}
+	}
+	return nil
+}
+
+func (k *Cluster) applyNamespace(namespace *Namespace) error {
+	namespaces := k.client.CoreV1().Namespaces()
+	current, err := namespaces.Get(context.Background(), namespace.Name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = namespaces.Create(context.Background(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        namespace.Name,
+			Labels:      namespace.Labels,
+			Annotations: namespace.Annotations,
+		}}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	current.Labels = merge(current.Labels, namespace.Labels)
+	current.Annotations = merge(current.Annotations, namespace.Annotations)
+	_, err = namespaces.Update(context.Background(), current, metav1.UpdateOptions{})
+	return err
+}
+
+func (k *Cluster) applyResourceQuota(namespace string, quota *ResourceQuota) error {
+	hard, err := resourceList(quota.Hard)
+	if err != nil {
+		return err
+	}
+	quotas := k.client.CoreV1().ResourceQuotas(namespace)
+	current, err := quotas.Get(context.Background(), ManagedName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = quotas.Create(context.Background(), &corev1.ResourceQuota{
+			ObjectMeta: metav1.ObjectMeta{Name: ManagedName, Namespace: namespace},
+			Spec:       corev1.ResourceQuotaSpec{Hard: hard},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	current.Spec.Hard = hard
+	_, err = quotas.Update(context.Background(), current, metav1.UpdateOptions{})
+	return err
+}
+
+func (k *Cluster) applyLimitRange(namespace string, limitRange *LimitRange) error {
+	spec, err := limitRangeSpec(limitRange)
+	if err != nil {
+		return err
+	}
+	limitRanges := k.client.CoreV1().LimitRanges(namespace)
+	current, err := limitRanges.Get(context.Background(), ManagedName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = limitRanges.Create(context.Background(), &corev1.LimitRange{
+			ObjectMeta: metav1.ObjectMeta{Name: ManagedName, Namespace: namespace},
+			Spec:       *spec,
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	current.Spec = *spec
+	_, err = limitRanges.Update(context.Background(), current, metav1.UpdateOptions{})
+	return err
+}
+
+// merge sets the declared keys in current, keeping the others
+func merge(current, declared map[string]string) map[string]string {
+	if len(declared) == 0 {
+		return current
+	}
+	if current == nil {
+		current = make(map[string]string, len(declared))
+	}
+	for key, value := range declared {
+		current[key] = value
+	}
+	return current
+}
+
+// resourceList parses quantities, ex: cpu: 500m
+func resourceList(quantities map[string]string) (corev1.ResourceList, error) {
+	if len(quantities) == 0 {
+		return nil, nil
+	}
+	list := make(corev1.ResourceList, len(quantities))
+	for name, value := range quantities {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return nil, errors.Wrap(err, fmt.Sprintf("Invalid quantity %s of %s", value, name))
+		}
+		list[corev1.ResourceName(name)] = quantity
+	}
+	return list, nil
+}
+
+// quantityStrings formats quantities, nil when there are none
+func quantityStrings(list corev1.ResourceList) map[string]string {
+	if len(list) == 0 {
+		return nil
+	}
+	quantities := make(map[string]string, len(list))
+	for name, quantity := range list {
+		quantities[string(name)] = quantity.String()
+	}
+	return quantities
+}
+
+func limitRangeSpec(limitRange *LimitRange) (*corev1.LimitRangeSpec, error) {
+	spec := &corev1.LimitRangeSpec{}
+	for _, l := range limitRange.Limits {
+		item := corev1.LimitRangeItem{Type: corev1.LimitType(l.Type)}
+		var err error
+		if item.Default, err = resourceList(l.Default); err != nil {
+			return nil, err
+		}
+		if item.DefaultRequest, err = resourceList(l.DefaultRequest); err != nil {
+			return nil, err
+		}
+		if item.Max, err = resourceList(l.Max); err != nil {
+			return nil, err
+		}
+		if item.Min, err = resourceList(l.Min); err != nil {
+			return nil, err
+		}
+		spec.Limits = append(spec.Limits, item)
+	}
+	return spec, nil
+}
+
+func limitRangeOf(spec *corev1.LimitRangeSpec) *LimitRange {
+	limitRange := &LimitRange{}
+	for _, l := range spec.Limits {
+		limitRange.Limits = append(limitRange.Limits, &LimitRangeItem{
+			Type:           string(l.Type),
+			Default:        quantityStrings(l.Default),
+			DefaultRequest: quantityStrings(l.DefaultRequest),
+			Max:            quantityStrings(l.Max),
+			Min:            quantityStrings(l.Min),
+		})
+	}
+	return limitRange
+}
@@ -0,0 +1,126 @@
+package kube
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Pod is the status of a pod and of its containers
+type Pod struct {
+	Name       string       `json:"name"`
+	Phase      string       `json:"phase"`
+	Reason     string       `json:"reason,omitempty"`
+	Message    string       `json:"message,omitempty"`
+	Containers []*Container `json:"containers"`
+}
+
+// Container is the status of a container of a pod.
+// State is waiting, running or terminated, Reason and Message explaining it, ex: waiting because of CrashLoopBackOff
+type Container struct {
+	Name         string `json:"name"`
+	Ready        bool   `json:"ready"`
+	RestartCount int    `json:"restart_count"`
+	State        string `json:"state"`
+	Reason       string `json:"reason,omitempty"`
+	Message      string `json:"message,omitempty"`
+	// ExitCode of the last terminated instance of the container, if any
+	ExitCode *int `json:"exit_code,omitempty"`
+}
+
+// Failing tells if the pod did not succeed and has containers which are not ready
+func (p *Pod) Failing() bool {
+	if p.Phase == "Succeeded" {
+		return false
+	}
+	if p.Phase == "Failed" {
+		return true
+	}
+	for _, c := range p.Containers {
+		if !c.Ready {
+			return true
+		}
+	}
+	return false
+}
+
+// Crashed tells if the container has terminated at least once, its previous logs explaining why
+func (c *Container) Crashed() bool {
+	return c.RestartCount > 0 || c.State == "terminated"
+}
+
+// Event is a Kubernetes event, ex: a pod could not be scheduled or an image could not be pulled
+type Event struct {
+	Type     string    `json:"type"`
+	Reason   string    `json:"reason"`
+	Object   string    `json:"object"`
+	Message  string    `json:"message"`
+	Count    int       `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// podStatuses returns the status of pods and of their containers
+func podStatuses(items []corev1.Pod) []*Pod {
+	pods := make([]*Pod, 0, len(items))
+	for _, item := range items {
+		p := &Pod{
+			Name:       item.Name,
+			Phase:      string(item.Status.Phase),
+			Reason:     item.Status.Reason,
+			Message:    item.Status.Message,
+			Containers: []*Container{},
+		}
+		for _, status := range item.Status.ContainerStatuses {
+			c := &Container{Name: status.Name, Ready: status.Ready, RestartCount: int(status.RestartCount)}
+			switch state := status.State; {
+			case state.Waiting != nil:
+				c.State, c.Reason, c.Message = "waiting", state.Waiting.Reason, state.Waiting.Message
+			case state.Terminated != nil:
+				c.State, c.Reason, c.Message = "terminated", state.Terminated.Reason, state.Terminated.Message
+			default:
+				c.State = "running"
+			}
+			if terminated := status.State.Terminated; terminated != nil {
+				c.ExitCode = exitCode(terminated)
+			} else if last := status.LastTerminationState.Terminated; last != nil {
+				c.ExitCode = exitCode(last)
+			}
+			p.Containers = append(p.Containers, c)
+		}
+		pods = append(pods, p)
+	}
+	return pods
+}
+
+func exitCode(terminated *corev1.ContainerStateTerminated) *int {
+	code := int(terminated.ExitCode)
+	return &code
+}
+
+// namespaceEvents returns events sorted from the oldest to the most recent
+func namespaceEvents(items []corev1.Event) []*Event {
+	events := make([]*Event, 0, len(items))
+	for _, item := range items {
+		lastSeen := item.LastTimestamp.Time
+		if lastSeen.IsZero() {
+			lastSeen = item.EventTime.Time
+		}
+		if lastSeen.IsZero() {
+			lastSeen = item.FirstTimestamp.Time
+		}
+		events = append(events, &Event{
+			Type:     item.Type,
+			Reason:   item.Reason,
+			Object:   fmt.Sprintf("%s/%s", item.InvolvedObject.Kind, item.InvolvedObject.Name),
+			Message:  item.Message,
+			Count:    int(item.Count),
+			LastSeen: lastSeen,
+		})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].LastSeen.Before(events[j].LastSeen)
+	})
+	return events
+}
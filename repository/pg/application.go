@@ -0,0 +1,135 @@
+package pg
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/vgheri/gennaker/engine"
+)
+
+func (r *pgRepository) ListApplications() ([]*engine.Application, error) {
+	query := `SELECT id, name, deployments, creation_date, last_update
+	FROM application
+	ORDER BY name;`
+	return r.queryApplications(query)
+}
+
+func (r *pgRepository) GetApplication(name string) (*engine.Application, error) {
+	query := `SELECT id, name, deployments, creation_date, last_update
+	FROM application
+	WHERE name = $1;`
+	applications, err := r.queryApplications(query, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(applications) == 0 {
+		return nil, engine.ErrResourceNotFound
+	}
+	return applications[0], nil
+}
+
+func (r *pgRepository) queryApplications(query string, args ...interface{}) ([]*engine.Application, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applications := []*engine.Application{}
+	for rows.Next() {
+		var id int
+		var name string
+		var deployments []byte
+		var creationDate, lastUpdate time.Time
+		if err = rows.Scan(&id, &name, &deployments, &creationDate, &lastUpdate); err != nil {
+			return nil, err
+		}
+		application := &engine.Application{
+			ID:           id,
+			Name:         name,
+			Deployments:  []*engine.ApplicationDeployment{},
+			CreationDate: creationDate,
+			LastUpdate:   lastUpdate,
+		}
+		if err = json.Unmarshal(deployments, &application.Deployments); err != nil {
+			return nil, errors.Wrap(err, "Cannot decode deployments")
+		}
+		applications = append(applications, application)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return applications, nil
+}
+
+func (r *pgRepository) CreateApplication(application *engine.Application) error {
+	if application == nil {
+		return engine.ErrBadRequest
+	}
+	deployments, err := json.Marshal(application.Deployments)
+	if err != nil {
+		return errors.Wrap(err, "Cannot encode deployments")
+	}
+	query := `INSERT INTO application(name, deployments)
+  VALUES($1, $2) RETURNING id, creation_date, last_update`
+	err = r.db.QueryRow(query, application.Name, deployments).Scan(&application.ID,
+		&application.CreationDate, &application.LastUpdate)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+			return errors.Errorf("Application %s already exists", application.Name)
+		}
+		return errors.Wrap(err, "Cannot insert application")
+	}
+	return nil
+}
+
+func (r *pgRepository) CreateSnapshot(snapshot *engine.ApplicationSnapshot) error {
+	if snapshot == nil {
+		return engine.ErrBadRequest
+	}
+	imageTags, err := json.Marshal(snapshot.ImageTags)
+	if err != nil {
+		return errors.Wrap(err, "Cannot encode image tags")
+	}
+	query := `INSERT INTO application_snapshot(application_id, name, image_tags)
+  VALUES($1, $2, $3) RETURNING id, creation_date`
+	err = r.db.QueryRow(query, snapshot.ApplicationID, snapshot.Name, imageTags).Scan(&snapshot.ID,
+		&snapshot.CreationDate)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+			return errors.Errorf("Snapshot %s already exists", snapshot.Name)
+		}
+		return errors.Wrap(err, "Cannot insert snapshot")
+	}
+	return nil
+}
+
+// GetSnapshots returns the snapshots of an application, from the most recent to the oldest
+func (r *pgRepository) GetSnapshots(applicationID int) ([]*engine.ApplicationSnapshot, error) {
+	query := `SELECT id, name, image_tags, creation_date
+	FROM application_snapshot
+	WHERE application_id = $1
+	ORDER BY creation_date DESC, id DESC;`
+	rows, err := r.db.Query(query, applicationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	snapshots := []*engine.ApplicationSnapshot{}
+	for rows.Next() {
+		snapshot := &engine.ApplicationSnapshot{ApplicationID: applicationID}
+		var imageTags []byte
+		if err = rows.Scan(&snapshot.ID, &snapshot.Name, &imageTags, &snapshot.CreationDate); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(imageTags, &snapshot.ImageTags); err != nil {
+			return nil, errors.Wrap(err, "Cannot decode image tags")
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
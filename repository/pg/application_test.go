@@ -0,0 +1,54 @@
+package pg
+
+import (
+	"testing"
+
+	"github.com/vgheri/gennaker/engine"
+)
+
+func TestApplications(t *testing.T) {
+	teardown(db)
+	insertDummyData(db)
+
+	application := &engine.Application{Name: "shop", Deployments: []*engine.ApplicationDeployment{
+		{Name: firstTestDeploymentName},
+		{Name: secondTestDeploymentName, DependsOn: []string{firstTestDeploymentName}},
+	}}
+	if err := pg.CreateApplication(application); err != nil {
+		t.Fatalf("Expected application to be saved, got %v", err)
+	}
+	if err := pg.CreateApplication(&engine.Application{Name: "shop"}); err == nil {
+		t.Fatalf("Expected an error creating an application with an existing name")
+	}
+	saved, err := pg.GetApplication("shop")
+	if err != nil {
+		t.Fatalf("Expected application, got %v", err)
+	}
+	if saved.ID != application.ID || len(saved.Deployments) != 2 ||
+		saved.Deployments[1].DependsOn[0] != firstTestDeploymentName {
+		t.Fatalf("Unexpected application %+v", saved)
+	}
+	if _, err = pg.GetApplication("blog"); err != engine.ErrResourceNotFound {
+		t.Fatalf("Expected ErrResourceNotFound, got %v", err)
+	}
+	applications, err := pg.ListApplications()
+	if err != nil || len(applications) != 1 {
+		t.Fatalf("Unexpected applications %+v (%v)", applications, err)
+	}
+
+	for _, name := range []string{"1.0", "1.1"} {
+		snapshot := &engine.ApplicationSnapshot{ApplicationID: application.ID, Name: name,
+			ImageTags: map[string]string{firstTestDeploymentName: "0.0.2", secondTestDeploymentName: name}}
+		if err = pg.CreateSnapshot(snapshot); err != nil {
+			t.Fatalf("Expected snapshot %s to be saved, got %v", name, err)
+		}
+	}
+	if err = pg.CreateSnapshot(&engine.ApplicationSnapshot{ApplicationID: application.ID, Name: "1.0"}); err == nil {
+		t.Fatalf("Expected an error creating a snapshot with an existing name")
+	}
+	snapshots, err := pg.GetSnapshots(application.ID)
+	if err != nil || len(snapshots) != 2 || snapshots[0].Name != "1.1" ||
+		snapshots[0].ImageTags[secondTestDeploymentName] != "1.1" {
+		t.Fatalf("Expected snapshots from the most recent, got %+v (%v)", snapshots, err)
+	}
+}
@@ -2,6 +2,7 @@ package pg
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -53,3 +54,148 @@ func NewClient(host, port, username, password, dbname string, maxconn int) (engi
 		db: conn,
 	}, nil
 }
+
+// marshalStringMap encodes a map into a JSON object, to be stored in a JSONB column
+func marshalStringMap(m map[string]string) ([]byte, error) {
+	if m == nil {
+		m = map[string]string{}
+	}
+	return json.Marshal(m)
+}
+
+func unmarshalStringMap(b []byte) (map[string]string, error) {
+	m := map[string]string{}
+	if len(b) == 0 {
+		return m, nil
+	}
+	err := json.Unmarshal(b, &m)
+	return m, err
+}
+
+// marshalValues encodes release values into a JSON object, to be stored in a JSONB column
+func marshalValues(values engine.Values) ([]byte, error) {
+	if values == nil {
+		values = engine.Values{}
+	}
+	return json.Marshal(values)
+}
+
+func unmarshalValues(b []byte) (engine.Values, error) {
+	values := engine.Values{}
+	if len(b) == 0 {
+		return values, nil
+	}
+	err := json.Unmarshal(b, &values)
+	return values, err
+}
+
+// marshalStepOptions encodes the options of a pipeline step, NULL when the step has none
+func marshalStepOptions(options *engine.StepOptions) ([]byte, error) {
+	if options == nil {
+		return nil, nil
+	}
+	return json.Marshal(options)
+}
+
+func unmarshalStepOptions(b []byte) (*engine.StepOptions, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	options := &engine.StepOptions{}
+	err := json.Unmarshal(b, options)
+	return options, err
+}
+
+func marshalHookResults(results []*engine.HookResult) ([]byte, error) {
+	if results == nil {
+		results = []*engine.HookResult{}
+	}
+	return json.Marshal(results)
+}
+
+func unmarshalHookResults(b []byte) ([]*engine.HookResult, error) {
+	var results []*engine.HookResult
+	if len(b) == 0 {
+		return results, nil
+	}
+	err := json.Unmarshal(b, &results)
+	return results, err
+}
+
+func marshalHealthCheckResults(results []*engine.HealthCheckResult) ([]byte, error) {
+	if results == nil {
+		results = []*engine.HealthCheckResult{}
+	}
+	return json.Marshal(results)
+}
+
+func unmarshalHealthCheckResults(b []byte) ([]*engine.HealthCheckResult, error) {
+	var results []*engine.HealthCheckResult
+	if len(b) == 0 {
+		return results, nil
+	}
+	err := json.Unmarshal(b, &results)
+	return results, err
+}
+
+func marshalWorkloadStatuses(statuses []*engine.WorkloadStatus) ([]byte, error) {
+	if statuses == nil {
+		statuses = []*engine.WorkloadStatus{}
+	}
+	return json.Marshal(statuses)
+}
+
+func unmarshalWorkloadStatuses(b []byte) ([]*engine.WorkloadStatus, error) {
+	var statuses []*engine.WorkloadStatus
+	if len(b) == 0 {
+		return statuses, nil
+	}
+	err := json.Unmarshal(b, &statuses)
+	return statuses, err
+}
+
+// marshalDiagnostics encodes diagnostics, nil being stored as NULL
+func marshalDiagnostics(diagnostics *engine.ReleaseDiagnostics) (sql.NullString, error) {
+	if diagnostics == nil {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(diagnostics)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+func unmarshalDiagnostics(b []byte) (*engine.ReleaseDiagnostics, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var diagnostics engine.ReleaseDiagnostics
+	if err := json.Unmarshal(b, &diagnostics); err != nil {
+		return nil, err
+	}
+	return &diagnostics, nil
+}
+
+// marshalStage encodes the stage of a release, releases which are not stage events storing NULL
+func marshalStage(stage *engine.ReleaseStage) (sql.NullString, error) {
+	if stage == nil {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(stage)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+func unmarshalStage(b []byte) (*engine.ReleaseStage, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	var stage engine.ReleaseStage
+	if err := json.Unmarshal(b, &stage); err != nil {
+		return nil, err
+	}
+	return &stage, nil
+}
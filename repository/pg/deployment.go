@@ -2,39 +2,55 @@ package pg
 
 import (
 	"database/sql"
+	"fmt"
 	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	"github.com/vgheri/gennaker/engine"
 )
 
-func (r *pgRepository) ListDeployments(limit, offset int) ([]*engine.Deployment, error) {
-	query := `SELECT id, name, chart, repository_url, creation_date, last_update
-  FROM deployment
-  ORDER BY chart LIMIT $1 OFFSET $2;`
+func (r *pgRepository) ListDeployments(request *engine.ListDeploymentsRequest) ([]*engine.Deployment, error) {
+	where, args, err := buildDeploymentFilter(request)
+	if err != nil {
+		return nil, err
+	}
+	orderBy, err := buildDeploymentOrderBy(request)
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf(`SELECT id, name, chart, chart_version, repository_url, pipeline_version, creation_date, last_update
+  FROM deployment d
+  WHERE %s
+  ORDER BY %s LIMIT $%d OFFSET $%d;`, where, orderBy, len(args)+1, len(args)+2)
+	args = append(args, request.Limit, request.Offset)
 
-	rows, err := r.db.Query(query, limit, offset)
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	deployments := []*engine.Deployment{}
 	for rows.Next() {
-		var id int
+		var id, pipelineVersion int
 		var name, chart, repositoryURL string
+		var chartVersion sql.NullString
 		var creationDate, lastUpdate time.Time
-		err = rows.Scan(&id, &name, &chart, &repositoryURL, &creationDate, &lastUpdate)
+		err = rows.Scan(&id, &name, &chart, &chartVersion, &repositoryURL, &pipelineVersion,
+			&creationDate, &lastUpdate)
 		if err != nil {
 			return nil, err
 		}
 		deployment := &engine.Deployment{
-			ID:            id,
-			Name:          name,
-			ChartName:     chart,
-			RepositoryURL: repositoryURL,
-			CreationDate:  creationDate,
-			LastUpdate:    lastUpdate,
+			ID:              id,
+			Name:            name,
+			ChartName:       chart,
+			ChartVersion:    chartVersion.String,
+			RepositoryURL:   repositoryURL,
+			PipelineVersion: pipelineVersion,
+			CreationDate:    creationDate,
+			LastUpdate:      lastUpdate,
 		}
 		deployments = append(deployments, deployment)
 	}
@@ -46,28 +62,192 @@ func (r *pgRepository) ListDeployments(limit, offset int) ([]*engine.Deployment,
 	return deployments, nil
 }
 
-func (r *pgRepository) ListDeploymentsWithStatus(limit, offset int) ([]*engine.Deployment, error) {
-	return nil, nil
+// ListDeploymentsWithStatus returns the requested page of deployments along with
+// the last release of each namespace of their pipeline, using a single query
+func (r *pgRepository) ListDeploymentsWithStatus(request *engine.ListDeploymentsRequest) ([]*engine.Deployment, error) {
+	where, args, err := buildDeploymentFilter(request)
+	if err != nil {
+		return nil, err
+	}
+	orderBy, err := buildDeploymentOrderBy(request)
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf(`SELECT d.id, d.name, d.chart, d.chart_version, d.repository_url, d.pipeline_version,
+  d.creation_date, d.last_update, ps.target_namespace, lr.image_tag, lr.status, lr.timestamp
+  FROM (
+    SELECT id, name, chart, chart_version, repository_url, pipeline_version, creation_date, last_update
+    FROM deployment d
+    WHERE %s
+    ORDER BY %s LIMIT $%d OFFSET $%d
+  ) d
+  LEFT JOIN pipeline_step ps ON ps.deployment_id = d.id
+  LEFT JOIN LATERAL (
+    SELECT image_tag, status, timestamp
+    FROM release
+    WHERE deployment_id = d.id AND namespace = ps.target_namespace AND stage IS NULL
+    ORDER BY timestamp DESC LIMIT 1
+  ) lr ON true
+  ORDER BY %s, ps.step_number;`, where, orderBy, len(args)+1, len(args)+2, orderBy)
+	args = append(args, request.Limit, request.Offset)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deployments := []*engine.Deployment{}
+	var deployment *engine.Deployment
+	for rows.Next() {
+		var id, pipelineVersion int
+		var name, chart, repositoryURL string
+		var chartVersion, namespace, imageTag sql.NullString
+		var status sql.NullInt64
+		var creationDate, lastUpdate time.Time
+		var releaseDate pq.NullTime
+		err = rows.Scan(&id, &name, &chart, &chartVersion, &repositoryURL, &pipelineVersion,
+			&creationDate, &lastUpdate, &namespace, &imageTag, &status, &releaseDate)
+		if err != nil {
+			return nil, err
+		}
+		// Rows are grouped by deployment
+		if deployment == nil || deployment.ID != id {
+			deployment = &engine.Deployment{
+				ID:              id,
+				Name:            name,
+				ChartName:       chart,
+				ChartVersion:    chartVersion.String,
+				RepositoryURL:   repositoryURL,
+				PipelineVersion: pipelineVersion,
+				CreationDate:    creationDate,
+				LastUpdate:      lastUpdate,
+				Status:          []*engine.NamespaceStatus{},
+			}
+			deployments = append(deployments, deployment)
+		}
+		if !namespace.Valid {
+			continue
+		}
+		namespaceStatus := &engine.NamespaceStatus{
+			Namespace: namespace.String,
+			ImageTag:  imageTag.String,
+			Status:    engine.GennakerReleaseOutcome(status.Int64),
+		}
+		if releaseDate.Valid {
+			namespaceStatus.ReleaseDate = &releaseDate.Time
+		}
+		deployment.Status = append(deployment.Status, namespaceStatus)
+	}
+	// get any error encountered during iteration
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	return deployments, nil
+}
+
+// ListDeploymentsWithReleases returns the requested page of deployments along with
+// their pipeline and their whole release history
+func (r *pgRepository) ListDeploymentsWithReleases(request *engine.ListDeploymentsRequest) ([]*engine.Deployment, error) {
+	deployments, err := r.ListDeployments(request)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, len(deployments))
+	for i, d := range deployments {
+		ids[i] = d.ID
+	}
+	pipelines, err := r.getDeploymentsPipelines(ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get deployments pipelines")
+	}
+	releases, err := r.getDeploymentsReleases(ids)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot get releases for deployments")
+	}
+	for _, d := range deployments {
+		d.Pipeline = pipelines[d.ID]
+		d.Releases = releases[d.ID]
+		if d.Pipeline == nil {
+			d.Pipeline = []*engine.PipelineStep{}
+		}
+		if d.Releases == nil {
+			d.Releases = []*engine.Release{}
+		}
+	}
+	return deployments, nil
+}
+
+// buildDeploymentFilter returns the WHERE clause matching the filters of the request
+// for a deployment table aliased as d, along with its arguments
+func buildDeploymentFilter(request *engine.ListDeploymentsRequest) (string, []interface{}, error) {
+	if request == nil {
+		return "", nil, engine.ErrBadRequest
+	}
+	conditions := []string{"d.archive_date IS NULL"}
+	args := []interface{}{}
+	if len(request.ChartName) != 0 {
+		args = append(args, request.ChartName)
+		conditions = append(conditions, fmt.Sprintf("d.chart = $%d", len(args)))
+	}
+	if len(request.RepositoryURL) != 0 {
+		args = append(args, request.RepositoryURL)
+		conditions = append(conditions, fmt.Sprintf("d.repository_url = $%d", len(args)))
+	}
+	if len(request.Namespace) != 0 {
+		args = append(args, request.Namespace)
+		conditions = append(conditions, fmt.Sprintf(
+			"EXISTS (SELECT 1 FROM pipeline_step WHERE deployment_id = d.id AND target_namespace = $%d)", len(args)))
+	}
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// Columns deployments can be sorted by
+var deploymentSortColumns = map[string]string{
+	"name":          "d.name",
+	"chart":         "d.chart",
+	"creation_date": "d.creation_date",
+	"last_update":   "d.last_update",
+}
+
+func buildDeploymentOrderBy(request *engine.ListDeploymentsRequest) (string, error) {
+	column, found := deploymentSortColumns[request.SortBy]
+	if !found {
+		return "", errors.Errorf("Cannot sort by %s", request.SortBy)
+	}
+	order := "ASC"
+	if strings.ToLower(request.SortOrder) == "desc" {
+		order = "DESC"
+	}
+	// id guarantees a stable order across pages
+	return fmt.Sprintf("%s %s, d.id", column, order), nil
 }
 
 func (r *pgRepository) GetDeployment(name string) (*engine.Deployment, error) {
-	query := `SELECT id, chart, chart_version, repository_url, creation_date, last_update
+	query := `SELECT id, chart, chart_version, repository_url, pipeline_version, image_paths, creation_date,
+  last_update, archive_date
   FROM deployment
   WHERE name = $1`
 
 	row := r.db.QueryRow(query, name)
-	var id int
+	var id, pipelineVersion int
 	var chart, repositoryURL string
 	var chartVersion sql.NullString
+	var imagePaths []byte
 	var creationDate, lastUpdate time.Time
-	err := row.Scan(&id, &chart, &chartVersion, &repositoryURL, &creationDate,
-		&lastUpdate)
+	var archiveDate pq.NullTime
+	err := row.Scan(&id, &chart, &chartVersion, &repositoryURL, &pipelineVersion, &imagePaths, &creationDate,
+		&lastUpdate, &archiveDate)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, engine.ErrResourceNotFound
 		}
 		return nil, err
 	}
+	paths, err := unmarshalStringMap(imagePaths)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot decode image paths")
+	}
 
 	releases, err := r.GetDeploymentReleases(id)
 	if err != nil {
@@ -80,15 +260,20 @@ func (r *pgRepository) GetDeployment(name string) (*engine.Deployment, error) {
 	}
 
 	deployment := &engine.Deployment{
-		ID:            id,
-		Name:          name,
-		ChartName:     chart,
-		ChartVersion:  chartVersion.String,
-		RepositoryURL: repositoryURL,
-		CreationDate:  creationDate,
-		LastUpdate:    lastUpdate,
-		Pipeline:      pipeline,
-		Releases:      releases,
+		ID:              id,
+		Name:            name,
+		ChartName:       chart,
+		ChartVersion:    chartVersion.String,
+		RepositoryURL:   repositoryURL,
+		CreationDate:    creationDate,
+		LastUpdate:      lastUpdate,
+		Pipeline:        pipeline,
+		PipelineVersion: pipelineVersion,
+		ImagePaths:      paths,
+		Releases:        releases,
+	}
+	if archiveDate.Valid {
+		deployment.ArchiveDate = &archiveDate.Time
 	}
 	return deployment, nil
 }
@@ -102,15 +287,20 @@ func (r *pgRepository) CreateDeployment(deployment *engine.Deployment) error {
 		chartVersion.Valid = true
 		chartVersion.String = deployment.ChartVersion
 	}
+	imagePaths, err := marshalStringMap(deployment.ImagePaths)
+	if err != nil {
+		return errors.Wrap(err, "Cannot encode image paths")
+	}
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 	// Create the deployment
-	query := `INSERT INTO deployment(name, chart, chart_version, repository_url)
-	VALUES($1, $2, $3, $4) RETURNING id, creation_date, last_update`
-	row := r.db.QueryRow(query, deployment.Name, deployment.ChartName, chartVersion, deployment.RepositoryURL)
+	query := `INSERT INTO deployment(name, chart, chart_version, repository_url, image_paths)
+	VALUES($1, $2, $3, $4, $5) RETURNING id, creation_date, last_update`
+	row := r.db.QueryRow(query, deployment.Name, deployment.ChartName, chartVersion, deployment.RepositoryURL,
+		imagePaths)
 	var id int
 	var creationDate, lastUpdate time.Time
 	err = row.Scan(&id, &creationDate, &lastUpdate)
@@ -120,14 +310,155 @@ func (r *pgRepository) CreateDeployment(deployment *engine.Deployment) error {
 	if deployment.Pipeline == nil || len(deployment.Pipeline) == 0 {
 		return engine.ErrInvalidPipeline
 	}
-	for _, step := range deployment.Pipeline {
-		err = createPipelineStep(tx, id, step)
-		if err != nil {
-			return err
-		}
+	_, err = tx.Exec(`SET CONSTRAINTS FK_PIPELINE_STEP_PARENT_STEP_NUMBER DEFERRED`)
+	if err != nil {
+		return errors.Wrap(err, "Cannot defer pipeline constraints")
+	}
+	if err = createPipeline(tx, id, deployment.Pipeline); err != nil {
+		return err
 	}
 	deployment.ID = id
+	deployment.PipelineVersion = 1
 	deployment.CreationDate = creationDate
 	deployment.LastUpdate = lastUpdate
 	return tx.Commit()
 }
+
+// UpdateDeployment persists the chart version and repository URL of an existing deployment
+// and applies the pipeline diff. If the pipeline changes, the previous one is
+// saved as a numbered version before being modified.
+func (r *pgRepository) UpdateDeployment(deployment *engine.Deployment, diff *engine.PipelineDiff) error {
+	if deployment == nil || deployment.ID == 0 {
+		return engine.ErrInvalidDeployment
+	}
+	if diff == nil {
+		return engine.ErrInvalidPipeline
+	}
+	var chartVersion sql.NullString
+	if len(strings.TrimSpace(deployment.ChartVersion)) != 0 {
+		chartVersion.Valid = true
+		chartVersion.String = deployment.ChartVersion
+	}
+	imagePaths, err := marshalStringMap(deployment.ImagePaths)
+	if err != nil {
+		return errors.Wrap(err, "Cannot encode image paths")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Cannot init transaction")
+	}
+	defer tx.Rollback()
+	pipelineVersion := deployment.PipelineVersion
+	if !diff.Empty() {
+		// Parent steps can be removed or added in the same transaction as their children
+		_, err = tx.Exec(`SET CONSTRAINTS FK_PIPELINE_STEP_PARENT_STEP_NUMBER DEFERRED`)
+		if err != nil {
+			return errors.Wrap(err, "Cannot defer pipeline constraints")
+		}
+		err = createPipelineVersion(tx, deployment.ID, deployment.PipelineVersion)
+		if err != nil {
+			return errors.Wrap(err, "Cannot save pipeline version")
+		}
+		for _, step := range diff.Removed {
+			if err = deletePipelineStep(tx, deployment.ID, step); err != nil {
+				return errors.Wrap(err, "Cannot remove pipeline step")
+			}
+		}
+		for _, step := range diff.Updated {
+			if err = updatePipelineStep(tx, deployment.ID, step); err != nil {
+				return errors.Wrap(err, "Cannot update pipeline step")
+			}
+		}
+		for _, step := range diff.Added {
+			if err = createPipelineStep(tx, deployment.ID, step); err != nil {
+				return errors.Wrap(err, "Cannot add pipeline step")
+			}
+		}
+		pipelineVersion++
+	}
+	query := `UPDATE deployment SET chart_version = $1, repository_url = $2, pipeline_version = $3, image_paths = $4,
+	last_update = NOW()
+	WHERE id = $5 RETURNING last_update`
+	var lastUpdate time.Time
+	err = tx.QueryRow(query, chartVersion, deployment.RepositoryURL, pipelineVersion, imagePaths,
+		deployment.ID).Scan(&lastUpdate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return engine.ErrResourceNotFound
+		}
+		return errors.Wrap(err, "Cannot update deployment")
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "Cannot commit transaction")
+	}
+	deployment.PipelineVersion = pipelineVersion
+	deployment.LastUpdate = lastUpdate
+	return nil
+}
+
+// ArchiveDeployment hides a deployment from listings, keeping its release history
+func (r *pgRepository) ArchiveDeployment(deploymentID int) error {
+	query := `UPDATE deployment SET archive_date = NOW(), last_update = NOW()
+	WHERE id = $1 AND archive_date IS NULL`
+	res, err := r.db.Exec(query, deploymentID)
+	if err != nil {
+		return errors.Wrap(err, "Cannot archive deployment")
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return engine.ErrResourceNotFound
+	}
+	return nil
+}
+
+// DeleteDeployment removes a deployment and everything related to it.
+// Returns engine.ErrDeploymentInUse if an application groups the deployment
+func (r *pgRepository) DeleteDeployment(deploymentID int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Cannot init transaction")
+	}
+	defer tx.Rollback()
+	// Applications reference their deployments by name, without foreign key
+	var inUse bool
+	query := `SELECT EXISTS (SELECT 1 FROM application a JOIN deployment d ON d.id = $1
+	WHERE a.deployments @> jsonb_build_array(jsonb_build_object('name', d.name)))`
+	if err = tx.QueryRow(query, deploymentID).Scan(&inUse); err != nil {
+		return errors.Wrap(err, "Cannot look for applications of deployment")
+	}
+	if inUse {
+		return engine.ErrDeploymentInUse
+	}
+	queries := []string{
+		`DELETE FROM release WHERE deployment_id = $1`,
+		`DELETE FROM release_notification WHERE deployment_id = $1`,
+		`DELETE FROM scheduled_job WHERE deployment_id = $1`,
+		`DELETE FROM values_layer WHERE deployment_id = $1`,
+		`DELETE FROM deployment_variable_set WHERE deployment_id = $1`,
+		`DELETE FROM pipeline_version WHERE deployment_id = $1`,
+		`DELETE FROM pipeline_step WHERE deployment_id = $1`,
+	}
+	for _, q := range queries {
+		if _, err = tx.Exec(q, deploymentID); err != nil {
+			return errors.Wrap(err, "Cannot delete deployment data")
+		}
+	}
+	res, err := tx.Exec(`DELETE FROM deployment WHERE id = $1`, deploymentID)
+	if err != nil {
+		return errors.Wrap(err, "Cannot delete deployment")
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return engine.ErrResourceNotFound
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "Cannot commit transaction")
+	}
+	return nil
+}
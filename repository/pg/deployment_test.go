@@ -48,7 +48,7 @@ func TestMain(m *testing.M) {
 
 func Test_ListDeployments(t *testing.T) {
 	teardown(db)
-	deployments, err := pg.ListDeployments(10, 0)
+	deployments, err := pg.ListDeployments(&engine.ListDeploymentsRequest{Limit: 10, SortBy: "name"})
 	if err != nil {
 		t.Fatalf("Error getting deployments. Error details: %v", err)
 	}
@@ -56,7 +56,7 @@ func Test_ListDeployments(t *testing.T) {
 		t.Fatalf("Expected to have 0 deployments with an empty db")
 	}
 	insertDummyData(db)
-	deployments, err = pg.ListDeployments(10, 0)
+	deployments, err = pg.ListDeployments(&engine.ListDeploymentsRequest{Limit: 10, SortBy: "name"})
 	if err != nil {
 		t.Fatalf("Error getting deployments. Error details: %v", err)
 	}
@@ -65,6 +65,66 @@ func Test_ListDeployments(t *testing.T) {
 	}
 }
 
+func Test_ListDeploymentsFilters(t *testing.T) {
+	teardown(db)
+	insertDummyData(db)
+	tt := []struct {
+		testName string
+		request  engine.ListDeploymentsRequest
+		expected []string
+	}{
+		{testName: "Sort by name desc", request: engine.ListDeploymentsRequest{Limit: 10, SortBy: "name", SortOrder: "desc"},
+			expected: []string{secondTestDeploymentName, firstTestDeploymentName}},
+		{testName: "Paginate", request: engine.ListDeploymentsRequest{Limit: 1, Offset: 1, SortBy: "name"},
+			expected: []string{secondTestDeploymentName}},
+		{testName: "Filter by chart", request: engine.ListDeploymentsRequest{Limit: 10, SortBy: "name", ChartName: "test-new-chart"},
+			expected: []string{secondTestDeploymentName}},
+		{testName: "Filter by repository", request: engine.ListDeploymentsRequest{Limit: 10, SortBy: "name", RepositoryURL: "https://test.com/helm/charts"},
+			expected: []string{firstTestDeploymentName, secondTestDeploymentName}},
+		{testName: "Filter by namespace", request: engine.ListDeploymentsRequest{Limit: 10, SortBy: "name", Namespace: "prod"},
+			expected: []string{firstTestDeploymentName, secondTestDeploymentName}},
+		{testName: "Filter by unknown namespace", request: engine.ListDeploymentsRequest{Limit: 10, SortBy: "name", Namespace: "qa"},
+			expected: []string{}},
+	}
+	for _, tc := range tt {
+		t.Run(tc.testName, func(t *testing.T) {
+			deployments, err := pg.ListDeployments(&tc.request)
+			if err != nil {
+				t.Fatalf("Error getting deployments. Error details: %v", err)
+			}
+			if len(deployments) != len(tc.expected) {
+				t.Fatalf("Expected %d deployments, got %d", len(tc.expected), len(deployments))
+			}
+			for i, name := range tc.expected {
+				if deployments[i].Name != name {
+					t.Fatalf("Expected deployment %s at position %d, got %s", name, i, deployments[i].Name)
+				}
+			}
+		})
+	}
+}
+
+func Test_ListDeploymentsWithStatus(t *testing.T) {
+	teardown(db)
+	insertDummyData(db)
+	deployments, err := pg.ListDeploymentsWithStatus(&engine.ListDeploymentsRequest{Limit: 10, SortBy: "name"})
+	if err != nil {
+		t.Fatalf("Error getting deployments. Error details: %v", err)
+	}
+	if len(deployments) != 2 {
+		t.Fatalf("Expected 2 deployments, got %d", len(deployments))
+	}
+	status := deployments[0].Status
+	if len(status) != 4 {
+		t.Fatalf("Expected a status for each of the 4 namespaces, got %+v", status)
+	}
+	if status[0].Namespace != "dev" || status[0].ImageTag != "0.0.2" || status[0].Status != engine.Deployed ||
+		status[2].Namespace != "ppd" || status[2].ImageTag != "0.0.1" ||
+		status[3].Namespace != "prod" || status[3].ImageTag != "" || status[3].ReleaseDate != nil {
+		t.Fatalf("Malformed status %+v %+v %+v %+v", status[0], status[1], status[2], status[3])
+	}
+}
+
 func Test_GetDeployment(t *testing.T) {
 
 	teardown(db)
@@ -127,14 +187,14 @@ func Test_GetDeployment(t *testing.T) {
 		deployment.Releases[0].Name != "happy-panda" ||
 		deployment.Releases[0].ImageTag != "0.0.1" ||
 		deployment.Releases[0].Namespace != "ppd" ||
-		deployment.Releases[0].Values != "a=1" ||
+		deployment.Releases[0].Values["a"] != float64(1) ||
 		deployment.Releases[0].Chart != "test-chart" ||
 		deployment.Releases[0].Status != engine.Deployed ||
 		deployment.Releases[4].ID == 0 ||
 		deployment.Releases[4].DeploymentID == 0 ||
 		deployment.Releases[4].ImageTag != "0.0.1" ||
 		deployment.Releases[4].Namespace != "dev" ||
-		deployment.Releases[4].Values != "a=1" {
+		deployment.Releases[4].Values["a"] != float64(1) {
 		t.Fatalf("Malformed pipeline %+v", deployment.Releases[0])
 	}
 }
@@ -204,4 +264,152 @@ func Test_CreateDeployment(t *testing.T) {
 	if deployment.ID == 0 {
 		t.Fatalf("Expected deployment ID > 0, got %v", deployment.ID)
 	}
+
+	// Join steps are listed under each of their parents but saved once
+	teardown(db)
+	join := &engine.PipelineStep{StepNumber: 4, ParentStepNumber: 2, ParentStepNumbers: []int{2, 3},
+		TargetNamespace: "prod"}
+	deployment = &engine.Deployment{
+		Name:          "unit test app",
+		ChartName:     "test",
+		RepositoryURL: "http://test.com/charts",
+		Pipeline: []*engine.PipelineStep{
+			&engine.PipelineStep{StepNumber: 1, TargetNamespace: "dev", NextSteps: []*engine.PipelineStep{
+				&engine.PipelineStep{StepNumber: 3, ParentStepNumber: 1, TargetNamespace: "load",
+					NextSteps: []*engine.PipelineStep{join}},
+				&engine.PipelineStep{StepNumber: 2, ParentStepNumber: 1, TargetNamespace: "ppd",
+					NextSteps: []*engine.PipelineStep{join}},
+			}},
+		},
+	}
+	if err = pg.CreateDeployment(deployment); err != nil {
+		t.Fatalf("Expected deployment with a join step to be created, got %v", err)
+	}
+	deployment, err = pg.GetDeployment("unit test app")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(deployment.Pipeline) != 1 || len(deployment.Pipeline[0].NextSteps) != 2 {
+		t.Fatalf("Malformed pipeline %+v", deployment.Pipeline)
+	}
+	for _, parent := range deployment.Pipeline[0].NextSteps {
+		if len(parent.NextSteps) != 1 || parent.NextSteps[0].StepNumber != 4 ||
+			len(parent.NextSteps[0].ParentStepNumbers) != 2 {
+			t.Fatalf("Expected join step under step %d, got %+v", parent.StepNumber, parent.NextSteps)
+		}
+	}
+}
+
+func Test_UpdateDeployment(t *testing.T) {
+	teardown(db)
+	insertDummyData(db)
+	deployment, err := pg.GetDeployment(firstTestDeploymentName)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if deployment.PipelineVersion != 1 {
+		t.Fatalf("Expected pipeline version 1, got %d", deployment.PipelineVersion)
+	}
+	diff := &engine.PipelineDiff{
+		Added: []*engine.PipelineStep{
+			&engine.PipelineStep{StepNumber: 5, ParentStepNumber: 3, TargetNamespace: "load"},
+			&engine.PipelineStep{StepNumber: 6, ParentStepNumber: 5, TargetNamespace: "prod-eu"},
+		},
+		Removed: []*engine.PipelineStep{
+			&engine.PipelineStep{StepNumber: 2, TargetNamespace: "int"},
+		},
+		Updated: []*engine.PipelineStep{
+			&engine.PipelineStep{StepNumber: 4, ParentStepNumber: 1, TargetNamespace: "prod"},
+		},
+	}
+	deployment.ChartVersion = "0.2.0"
+	err = pg.UpdateDeployment(deployment, diff)
+	if err != nil {
+		t.Fatalf("Expected update to succeed, got %v", err)
+	}
+	if deployment.PipelineVersion != 2 {
+		t.Fatalf("Expected pipeline version 2, got %d", deployment.PipelineVersion)
+	}
+	deployment, err = pg.GetDeployment(firstTestDeploymentName)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if deployment.ChartVersion != "0.2.0" || deployment.PipelineVersion != 2 {
+		t.Fatalf("Expected chart version 0.2.0 and pipeline version 2, got %+v", deployment)
+	}
+	if len(deployment.Pipeline) != 1 || deployment.Pipeline[0].TargetNamespace != "dev" ||
+		len(deployment.Pipeline[0].NextSteps) != 2 {
+		t.Fatalf("Malformed pipeline after update %+v", deployment.Pipeline)
+	}
+	var versions int
+	err = db.QueryRow(`SELECT COUNT(*) FROM pipeline_version WHERE deployment_id = $1 AND version = 1`,
+		deployment.ID).Scan(&versions)
+	if err != nil || versions != 1 {
+		t.Fatalf("Expected previous pipeline to be saved as version 1, got %d (%v)", versions, err)
+	}
+
+	err = pg.UpdateDeployment(&engine.Deployment{Name: "abc"}, &engine.PipelineDiff{})
+	if err != engine.ErrInvalidDeployment {
+		t.Fatalf("Expected ErrInvalidDeployment, got %v", err)
+	}
+}
+
+func Test_ArchiveDeployment(t *testing.T) {
+	teardown(db)
+	insertDummyData(db)
+	err := pg.ArchiveDeployment(firstTestDeploymentID)
+	if err != nil {
+		t.Fatalf("Expected archive to succeed, got %v", err)
+	}
+	deployments, err := pg.ListDeployments(&engine.ListDeploymentsRequest{Limit: 10, SortBy: "name"})
+	if err != nil {
+		t.Fatalf("Error getting deployments. Error details: %v", err)
+	}
+	if len(deployments) != 1 || deployments[0].Name != secondTestDeploymentName {
+		t.Fatalf("Expected archived deployment to be hidden, got %+v", deployments)
+	}
+	deployment, err := pg.GetDeployment(firstTestDeploymentName)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if deployment.ArchiveDate == nil || len(deployment.Releases) != 5 {
+		t.Fatalf("Expected archived deployment to keep its releases, got %+v", deployment)
+	}
+	err = pg.ArchiveDeployment(firstTestDeploymentID)
+	if err != engine.ErrResourceNotFound {
+		t.Fatalf("Expected ErrResourceNotFound archiving twice, got %v", err)
+	}
+}
+
+func Test_DeleteDeployment(t *testing.T) {
+	teardown(db)
+	insertDummyData(db)
+	application := &engine.Application{Name: "shop", Deployments: []*engine.ApplicationDeployment{
+		{Name: secondTestDeploymentName}}}
+	if err := pg.CreateApplication(application); err != nil {
+		t.Fatalf("Expected application to be saved, got %v", err)
+	}
+	if err := pg.DeleteDeployment(secondTestDeploymentID); err != engine.ErrDeploymentInUse {
+		t.Fatalf("Expected ErrDeploymentInUse deleting a deployment of an application, got %v", err)
+	}
+	if _, err := pg.GetDeployment(secondTestDeploymentName); err != nil {
+		t.Fatalf("Expected deployment of an application to be kept, got %v", err)
+	}
+	err := pg.DeleteDeployment(firstTestDeploymentID)
+	if err != nil {
+		t.Fatalf("Expected delete to succeed, got %v", err)
+	}
+	_, err = pg.GetDeployment(firstTestDeploymentName)
+	if err != engine.ErrResourceNotFound {
+		t.Fatalf("Expected resource not found, got %v", err)
+	}
+	var releases int
+	err = db.QueryRow(`SELECT COUNT(*) FROM release WHERE deployment_id = $1`, firstTestDeploymentID).Scan(&releases)
+	if err != nil || releases != 0 {
+		t.Fatalf("Expected releases to be deleted, got %d (%v)", releases, err)
+	}
+	err = pg.DeleteDeployment(firstTestDeploymentID)
+	if err != engine.ErrResourceNotFound {
+		t.Fatalf("Expected ErrResourceNotFound deleting twice, got %v", err)
+	}
 }
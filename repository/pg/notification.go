@@ -0,0 +1,141 @@
+package pg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/vgheri/gennaker/engine"
+)
+
+const uniqueViolation = "23505"
+
+// notificationLock is the first key of the advisory locks serializing the notifications
+// without idempotency key of a deployment, the second one being the deployment ID
+const notificationLock = 1
+
+// GetReleaseNotification returns the most recent notification received since the given date
+// which did not fail, matching the idempotency key if not empty, the image tag and values hash otherwise
+func (r *pgRepository) GetReleaseNotification(deploymentID int, idempotencyKey, imageTag, valuesHash string,
+	since time.Time) (*engine.NotificationRecord, error) {
+	var row *sql.Row
+	if len(idempotencyKey) != 0 {
+		query := `SELECT id, idempotency_key, image_tag, values_hash, status, reports, creation_date
+	FROM release_notification
+	WHERE deployment_id = $1 AND idempotency_key = $2 AND status <> $3 AND creation_date >= $4
+	ORDER BY creation_date DESC LIMIT 1;`
+		row = r.db.QueryRow(query, deploymentID, idempotencyKey, engine.NotificationFailed, since)
+	} else {
+		query := `SELECT id, idempotency_key, image_tag, values_hash, status, reports, creation_date
+	FROM release_notification
+	WHERE deployment_id = $1 AND image_tag = $2 AND values_hash = $3 AND status <> $4 AND creation_date >= $5
+	ORDER BY creation_date DESC LIMIT 1;`
+		row = r.db.QueryRow(query, deploymentID, imageTag, valuesHash, engine.NotificationFailed, since)
+	}
+	var id int
+	var key sql.NullString
+	var tag, hash, status string
+	var reports []byte
+	var creationDate time.Time
+	err := row.Scan(&id, &key, &tag, &hash, &status, &reports, &creationDate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, engine.ErrResourceNotFound
+		}
+		return nil, err
+	}
+	record := &engine.NotificationRecord{
+		ID:             id,
+		DeploymentID:   deploymentID,
+		IdempotencyKey: key.String,
+		ImageTag:       tag,
+		ValuesHash:     hash,
+		Status:         engine.NotificationStatus(status),
+		CreationDate:   creationDate,
+	}
+	if err = json.Unmarshal(reports, &record.Reports); err != nil {
+		return nil, errors.Wrap(err, "Cannot decode notification reports")
+	}
+	return record, nil
+}
+
+// CreateReleaseNotification saves a new notification record.
+// Returns engine.ErrDuplicateNotification if a notification which did not fail
+// is already registered with the same idempotency key or, for a notification without key,
+// with the same image tag and values hash since the given date. Notifications without key are not
+// deduplicated when since is the zero time.
+// Notifications without key of a deployment are registered one at a time, so that concurrent duplicates
+// cannot both miss each other
+func (r *pgRepository) CreateReleaseNotification(record *engine.NotificationRecord, since time.Time) error {
+	if record == nil {
+		return engine.ErrInvalidReleaseNotification
+	}
+	var key sql.NullString
+	if len(record.IdempotencyKey) != 0 {
+		key.Valid = true
+		key.String = record.IdempotencyKey
+	}
+	reports, err := json.Marshal(record.Reports)
+	if err != nil {
+		return errors.Wrap(err, "Cannot encode notification reports")
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Cannot init transaction")
+	}
+	defer tx.Rollback()
+	if !key.Valid && !since.IsZero() {
+		// The lock is released with the transaction
+		if _, err = tx.Exec(`SELECT pg_advisory_xact_lock($1, $2)`, notificationLock, record.DeploymentID); err != nil {
+			return errors.Wrap(err, "Cannot lock release notifications")
+		}
+		var duplicates int
+		query := `SELECT COUNT(*) FROM release_notification
+	WHERE deployment_id = $1 AND image_tag = $2 AND values_hash = $3 AND status <> $4 AND creation_date >= $5`
+		err = tx.QueryRow(query, record.DeploymentID, record.ImageTag, record.ValuesHash,
+			engine.NotificationFailed, since).Scan(&duplicates)
+		if err != nil {
+			return errors.Wrap(err, "Cannot look for duplicate notifications")
+		}
+		if duplicates != 0 {
+			return engine.ErrDuplicateNotification
+		}
+	}
+	query := `INSERT INTO release_notification(deployment_id, idempotency_key, image_tag, values_hash, status, reports)
+  VALUES($1, $2, $3, $4, $5, $6) RETURNING id, creation_date`
+	err = tx.QueryRow(query, record.DeploymentID, key, record.ImageTag, record.ValuesHash,
+		record.Status, reports).Scan(&record.ID, &record.CreationDate)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+			return engine.ErrDuplicateNotification
+		}
+		return errors.Wrap(err, "Cannot insert release notification")
+	}
+	return tx.Commit()
+}
+
+// UpdateReleaseNotification saves the outcome of a notification
+func (r *pgRepository) UpdateReleaseNotification(record *engine.NotificationRecord) error {
+	if record == nil || record.ID == 0 {
+		return engine.ErrInvalidReleaseNotification
+	}
+	reports, err := json.Marshal(record.Reports)
+	if err != nil {
+		return errors.Wrap(err, "Cannot encode notification reports")
+	}
+	query := `UPDATE release_notification SET status = $1, reports = $2 WHERE id = $3`
+	res, err := r.db.Exec(query, record.Status, reports, record.ID)
+	if err != nil {
+		return errors.Wrap(err, "Cannot update release notification")
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return engine.ErrResourceNotFound
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package pg
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vgheri/gennaker/engine"
+)
+
+func Test_ReleaseNotification(t *testing.T) {
+	teardown(db)
+	insertDummyData(db)
+	since := time.Now().Add(-time.Minute)
+	_, err := pg.GetReleaseNotification(firstTestDeploymentID, "", "0.0.3", "abc", since)
+	if err != engine.ErrResourceNotFound {
+		t.Fatalf("Expected resource not found, got %v", err)
+	}
+
+	record := &engine.NotificationRecord{
+		DeploymentID:   firstTestDeploymentID,
+		IdempotencyKey: "build-42",
+		ImageTag:       "0.0.3",
+		ValuesHash:     "abc",
+		Status:         engine.NotificationPending,
+		Reports:        []string{},
+	}
+	if err = pg.CreateReleaseNotification(record, since); err != nil {
+		t.Fatalf("Expected notification to be created, got %v", err)
+	}
+	if record.ID == 0 {
+		t.Fatalf("Expected id to be > 0")
+	}
+	duplicate := *record
+	if err = pg.CreateReleaseNotification(&duplicate, since); err != engine.ErrDuplicateNotification {
+		t.Fatalf("Expected ErrDuplicateNotification, got %v", err)
+	}
+
+	record.Status = engine.NotificationDone
+	record.Reports = []string{"deployed"}
+	if err = pg.UpdateReleaseNotification(record); err != nil {
+		t.Fatalf("Expected notification to be updated, got %v", err)
+	}
+
+	found, err := pg.GetReleaseNotification(firstTestDeploymentID, "build-42", "", "", since)
+	if err != nil {
+		t.Fatalf("Expected notification to be found by key, got %v", err)
+	}
+	if found.ID != record.ID || found.Status != engine.NotificationDone ||
+		len(found.Reports) != 1 || found.Reports[0] != "deployed" {
+		t.Fatalf("Malformed notification %+v", found)
+	}
+	found, err = pg.GetReleaseNotification(firstTestDeploymentID, "", "0.0.3", "abc", since)
+	if err != nil || found.ID != record.ID {
+		t.Fatalf("Expected notification to be found by content, got %+v (%v)", found, err)
+	}
+	_, err = pg.GetReleaseNotification(firstTestDeploymentID, "", "0.0.3", "abc", time.Now().Add(time.Minute))
+	if err != engine.ErrResourceNotFound {
+		t.Fatalf("Expected notification outside of the window to be ignored, got %v", err)
+	}
+
+	record.Status = engine.NotificationFailed
+	if err = pg.UpdateReleaseNotification(record); err != nil {
+		t.Fatalf("Expected notification to be updated, got %v", err)
+	}
+	retry := &engine.NotificationRecord{
+		DeploymentID:   firstTestDeploymentID,
+		IdempotencyKey: "build-42",
+		ImageTag:       "0.0.3",
+		ValuesHash:     "abc",
+		Status:         engine.NotificationPending,
+		Reports:        []string{},
+	}
+	if err = pg.CreateReleaseNotification(retry, since); err != nil {
+		t.Fatalf("Expected a failed notification to be retried, got %v", err)
+	}
+}
+
+func Test_ReleaseNotificationWithoutKey(t *testing.T) {
+	teardown(db)
+	insertDummyData(db)
+	since := time.Now().Add(-time.Minute)
+
+	// Concurrent retries of a notification without key register it once
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	created, duplicates := 0, 0
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			record := &engine.NotificationRecord{
+				DeploymentID: firstTestDeploymentID,
+				ImageTag:     "0.0.4",
+				ValuesHash:   "def",
+				Status:       engine.NotificationPending,
+				Reports:      []string{},
+			}
+			err := pg.CreateReleaseNotification(record, since)
+			mu.Lock()
+			defer mu.Unlock()
+			switch err {
+			case nil:
+				created++
+			case engine.ErrDuplicateNotification:
+				duplicates++
+			default:
+				t.Errorf("Unexpected error registering notification: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if created != 1 || duplicates != 4 {
+		t.Fatalf("Expected notification to be registered once, got %d created and %d duplicates", created, duplicates)
+	}
+
+	// Without deduplication window, the same content is registered again
+	record := &engine.NotificationRecord{
+		DeploymentID: firstTestDeploymentID,
+		ImageTag:     "0.0.4",
+		ValuesHash:   "def",
+		Status:       engine.NotificationPending,
+		Reports:      []string{},
+	}
+	if err := pg.CreateReleaseNotification(record, time.Time{}); err != nil {
+		t.Fatalf("Expected notification to be registered without deduplication, got %v", err)
+	}
+}
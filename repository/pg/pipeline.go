@@ -3,6 +3,7 @@ package pg
 import (
 	"database/sql"
 
+	"github.com/lib/pq"
 	"github.com/vgheri/gennaker/engine"
 )
 
@@ -10,53 +11,155 @@ func createPipelineStep(tx *sql.Tx, deploymentID int, step *engine.PipelineStep)
 	if step == nil {
 		return engine.ErrInvalidPipeline
 	}
-	query := `INSERT INTO pipeline_step(step_number, parent_step_number, deployment_id, target_namespace, auto_deploy)
-  VALUES($1, $2, $3, $4, $5) RETURNING id;`
+	options, err := marshalStepOptions(step.Options)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO pipeline_step(step_number, parent_step_number, parent_step_numbers, deployment_id,
+  target_namespace, auto_deploy, options)
+  VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING id;`
 	var id int
 	var row *sql.Row
 	if step.ParentStepNumber == 0 {
-		row = tx.QueryRow(query, step.StepNumber, nil, deploymentID,
-			step.TargetNamespace, step.AutomaticDeploy)
+		row = tx.QueryRow(query, step.StepNumber, nil, nil, deploymentID,
+			step.TargetNamespace, step.AutomaticDeploy, options)
 
 	} else {
-		row = tx.QueryRow(query, step.StepNumber, step.ParentStepNumber, deploymentID,
-			step.TargetNamespace, step.AutomaticDeploy)
+		row = tx.QueryRow(query, step.StepNumber, step.ParentStepNumber, pq.Array(parentStepNumbers(step)),
+			deploymentID, step.TargetNamespace, step.AutomaticDeploy, options)
 	}
-	err := row.Scan(&id)
+	err = row.Scan(&id)
 	if err != nil {
 		return err
 	}
 	step.ID = id
 	step.DeploymentID = deploymentID
-	for _, cs := range step.NextSteps {
-		err = createPipelineStep(tx, deploymentID, cs)
-		if err != nil {
-			return err
+	return nil
+}
+
+// createPipeline saves every step of the pipeline once, join steps being listed under each of their parents.
+// The constraint on parent steps must be deferred, as a join step can be reached before some of its parents
+func createPipeline(tx *sql.Tx, deploymentID int, pipeline []*engine.PipelineStep) error {
+	created := make(map[*engine.PipelineStep]bool)
+	var create func([]*engine.PipelineStep) error
+	create = func(steps []*engine.PipelineStep) error {
+		for _, step := range steps {
+			if created[step] {
+				continue
+			}
+			created[step] = true
+			if err := createPipelineStep(tx, deploymentID, step); err != nil {
+				return err
+			}
+			if err := create(step.NextSteps); err != nil {
+				return err
+			}
 		}
+		return nil
 	}
-	return nil
+	return create(pipeline)
+}
+
+// parentStepNumbers returns every parent of a step, steps built before join steps only having ParentStepNumber
+func parentStepNumbers(step *engine.PipelineStep) []int64 {
+	if len(step.ParentStepNumbers) == 0 {
+		return []int64{int64(step.ParentStepNumber)}
+	}
+	parents := make([]int64, 0, len(step.ParentStepNumbers))
+	for _, parent := range step.ParentStepNumbers {
+		parents = append(parents, int64(parent))
+	}
+	return parents
+}
+
+func updatePipelineStep(tx *sql.Tx, deploymentID int, step *engine.PipelineStep) error {
+	if step == nil {
+		return engine.ErrInvalidPipeline
+	}
+	options, err := marshalStepOptions(step.Options)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE pipeline_step SET parent_step_number = $1, parent_step_numbers = $2, target_namespace = $3,
+  auto_deploy = $4, options = $5
+  WHERE deployment_id = $6 AND step_number = $7;`
+	var parentStepNumber sql.NullInt64
+	var parents interface{}
+	if step.ParentStepNumber != 0 {
+		parentStepNumber.Valid = true
+		parentStepNumber.Int64 = int64(step.ParentStepNumber)
+		parents = pq.Array(parentStepNumbers(step))
+	}
+	_, err = tx.Exec(query, parentStepNumber, parents, step.TargetNamespace, step.AutomaticDeploy, options,
+		deploymentID, step.StepNumber)
+	return err
+}
+
+func deletePipelineStep(tx *sql.Tx, deploymentID int, step *engine.PipelineStep) error {
+	if step == nil {
+		return engine.ErrInvalidPipeline
+	}
+	query := `DELETE FROM pipeline_step WHERE deployment_id = $1 AND step_number = $2;`
+	_, err := tx.Exec(query, deploymentID, step.StepNumber)
+	return err
+}
+
+// createPipelineVersion saves a snapshot of the pipeline currently stored for the deployment
+func createPipelineVersion(tx *sql.Tx, deploymentID, version int) error {
+	query := `INSERT INTO pipeline_version(deployment_id, version, steps)
+  SELECT $1, $2, COALESCE(jsonb_agg(jsonb_build_object(
+    'step_number', step_number,
+    'parent_step_number', parent_step_number,
+    'parent_step_numbers', parent_step_numbers,
+    'target_namespace', target_namespace,
+    'auto_deploy', auto_deploy,
+    'options', options) ORDER BY step_number), '[]'::jsonb)
+  FROM pipeline_step
+  WHERE deployment_id = $1;`
+	_, err := tx.Exec(query, deploymentID, version)
+	return err
 }
 
 func (r *pgRepository) getDeploymentPipeline(deploymentID int) ([]*engine.PipelineStep, error) {
-	// Build the pipeline
-	query := `SELECT id, step_number, parent_step_number, target_namespace, auto_deploy
+	pipelines, err := r.getDeploymentsPipelines([]int{deploymentID})
+	if err != nil {
+		return nil, err
+	}
+	if pipeline, found := pipelines[deploymentID]; found {
+		return pipeline, nil
+	}
+	return []*engine.PipelineStep{}, nil
+}
+
+// getDeploymentsPipelines builds the pipeline of each of the given deployments
+// with a single query. Pipelines are indexed by deployment id.
+func (r *pgRepository) getDeploymentsPipelines(deploymentIDs []int) (map[int][]*engine.PipelineStep, error) {
+	query := `SELECT id, step_number, parent_step_number, parent_step_numbers, deployment_id, target_namespace,
+  auto_deploy, options
   FROM pipeline_step
-  WHERE deployment_id = $1
-  ORDER BY step_number asc;`
-	rows, err := r.db.Query(query, deploymentID)
+  WHERE deployment_id = ANY($1)
+  ORDER BY deployment_id, step_number asc;`
+	rows, err := r.db.Query(query, pq.Array(deploymentIDs))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	stepsMap := make(map[int]*engine.PipelineStep)
+	steps := make(map[int][]*engine.PipelineStep)
 	for rows.Next() {
-		var stepID, stepNumber, parentStepNumber int
+		var stepID, stepNumber, parentStepNumber, deploymentID int
 		var sqlParentStepNumber sql.NullInt64
 		var targetNamespace string
 		var autoDeploy bool
+		var options []byte
 
-		err = rows.Scan(&stepID, &stepNumber, &sqlParentStepNumber, &targetNamespace, &autoDeploy)
+		var sqlParentStepNumbers []int64
+		err = rows.Scan(&stepID, &stepNumber, &sqlParentStepNumber, pq.Array(&sqlParentStepNumbers), &deploymentID,
+			&targetNamespace, &autoDeploy, &options)
+		if err != nil {
+			return nil, err
+		}
+		stepOptions, err := unmarshalStepOptions(options)
 		if err != nil {
 			return nil, err
 		}
@@ -64,22 +167,26 @@ func (r *pgRepository) getDeploymentPipeline(deploymentID int) ([]*engine.Pipeli
 			// in db parent_step_number is an int, so should be safe
 			parentStepNumber = int(sqlParentStepNumber.Int64)
 		}
-		step := &engine.PipelineStep{
-			ID:               stepID,
-			StepNumber:       stepNumber,
-			ParentStepNumber: parentStepNumber,
-			DeploymentID:     deploymentID,
-			TargetNamespace:  targetNamespace,
-			AutomaticDeploy:  autoDeploy,
-			NextSteps:        []*engine.PipelineStep{},
+		var parentStepNumbers []int
+		for _, parent := range sqlParentStepNumbers {
+			parentStepNumbers = append(parentStepNumbers, int(parent))
 		}
-		stepsMap[step.StepNumber] = step
-		// Add itself to list of nextsteps of parent step, if any
-		if step.ParentStepNumber > 0 {
-			if parent, found := stepsMap[step.ParentStepNumber]; found {
-				parent.NextSteps = append(parent.NextSteps, step)
-			}
+		// Steps saved before join steps were supported only have a parent step number
+		if len(parentStepNumbers) == 0 && parentStepNumber != 0 {
+			parentStepNumbers = []int{parentStepNumber}
 		}
+		step := &engine.PipelineStep{
+			ID:                stepID,
+			StepNumber:        stepNumber,
+			ParentStepNumber:  parentStepNumber,
+			ParentStepNumbers: parentStepNumbers,
+			DeploymentID:      deploymentID,
+			TargetNamespace:   targetNamespace,
+			AutomaticDeploy:   autoDeploy,
+			Options:           stepOptions,
+			NextSteps:         []*engine.PipelineStep{},
+		}
+		steps[deploymentID] = append(steps[deploymentID], step)
 	}
 	// get any error encountered during iteration
 	err = rows.Err()
@@ -87,12 +194,32 @@ func (r *pgRepository) getDeploymentPipeline(deploymentID int) ([]*engine.Pipeli
 		return nil, err
 	}
 
-	//select steps which are root of the tree
+	pipelines := make(map[int][]*engine.PipelineStep)
+	for deploymentID, deploymentSteps := range steps {
+		pipelines[deploymentID] = assemblePipeline(deploymentSteps)
+	}
+	return pipelines, nil
+}
+
+// assemblePipeline links steps ordered by step number to their parent steps
+// and returns the steps which are root of the graph
+func assemblePipeline(steps []*engine.PipelineStep) []*engine.PipelineStep {
+	stepsMap := make(map[int]*engine.PipelineStep)
+	for _, step := range steps {
+		stepsMap[step.StepNumber] = step
+	}
 	roots := []*engine.PipelineStep{}
-	for _, s := range stepsMap {
-		if s.ParentStepNumber == 0 {
-			roots = append(roots, s)
+	for _, step := range steps {
+		if len(step.ParentStepNumbers) == 0 {
+			roots = append(roots, step)
+			continue
+		}
+		// Add itself to list of nextsteps of parent steps
+		for _, parentStepNumber := range step.ParentStepNumbers {
+			if parent, found := stepsMap[parentStepNumber]; found {
+				parent.NextSteps = append(parent.NextSteps, step)
+			}
 		}
 	}
-	return roots, nil
+	return roots
 }
@@ -6,31 +6,59 @@ import (
 	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	"github.com/vgheri/gennaker/engine"
 )
 
 func (r *pgRepository) CreateRelease(release *engine.Release) (int, error) {
 	var releaseID int
-	var values, chartVersion sql.NullString
-	if len(strings.TrimSpace(release.Values)) != 0 {
-		values.Valid = true
-		values.String = release.Values
-	}
+	var chartVersion sql.NullString
 	if len(strings.TrimSpace(release.ChartVersion)) != 0 {
 		chartVersion.Valid = true
 		chartVersion.String = release.ChartVersion
 	}
 
-	query := `INSERT INTO release(name, deployment_id, image_tag, namespace, values, chart, chart_version, revision, status)
-  VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`
+	imageTags, err := marshalStringMap(release.ImageTags)
+	if err != nil {
+		return 0, errors.Wrap(err, "Cannot encode image tags")
+	}
+	values, err := marshalValues(release.Values)
+	if err != nil {
+		return 0, errors.Wrap(err, "Cannot encode release values")
+	}
+	hooks, err := marshalHookResults(release.Hooks)
+	if err != nil {
+		return 0, errors.Wrap(err, "Cannot encode hook results")
+	}
+	healthChecks, err := marshalHealthCheckResults(release.HealthChecks)
+	if err != nil {
+		return 0, errors.Wrap(err, "Cannot encode health check results")
+	}
+	workloads, err := marshalWorkloadStatuses(release.Workloads)
+	if err != nil {
+		return 0, errors.Wrap(err, "Cannot encode workloads")
+	}
+	diagnostics, err := marshalDiagnostics(release.Diagnostics)
+	if err != nil {
+		return 0, errors.Wrap(err, "Cannot encode diagnostics")
+	}
+	stage, err := marshalStage(release.Stage)
+	if err != nil {
+		return 0, errors.Wrap(err, "Cannot encode release stage")
+	}
+
+	query := `INSERT INTO release(name, deployment_id, image_tag, image_tags, namespace, values, secret_values, chart,
+  chart_version, revision, status, hooks, health_checks, workloads, diagnostics, stage)
+  VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16) RETURNING id`
 	tx, err := r.db.Begin()
 	if err != nil {
 		return 0, errors.Wrap(err, "Cannot init transaction")
 	}
 	defer tx.Rollback()
-	err = tx.QueryRow(query, release.Name, release.DeploymentID, release.ImageTag, release.Namespace,
-		values, release.Chart, chartVersion, release.Revision, release.Status).Scan(&releaseID)
+	err = tx.QueryRow(query, release.Name, release.DeploymentID, release.ImageTag, imageTags, release.Namespace,
+		values, release.EncryptedSecretValues, release.Chart, chartVersion, release.Revision, release.Status,
+		hooks, healthChecks, workloads, diagnostics, stage).Scan(&releaseID)
 	if err != nil {
 		fmt.Printf("Error %v\n", err)
 		return 0, errors.Wrap(err, "Cannot insert release")
@@ -41,43 +69,106 @@ func (r *pgRepository) CreateRelease(release *engine.Release) (int, error) {
 	return releaseID, nil
 }
 
+// GetReleaseDiagnostics returns the diagnostics of a release of the deployment, nil if none were collected
+func (r *pgRepository) GetReleaseDiagnostics(deploymentID, releaseID int) (*engine.ReleaseDiagnostics, error) {
+	var diagnostics []byte
+	query := `SELECT diagnostics FROM release WHERE id = $1 AND deployment_id = $2`
+	err := r.db.QueryRow(query, releaseID, deploymentID).Scan(&diagnostics)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, engine.ErrResourceNotFound
+		}
+		return nil, errors.Wrap(err, "Cannot get release diagnostics")
+	}
+	return unmarshalDiagnostics(diagnostics)
+}
+
 func (r *pgRepository) GetDeploymentReleases(deploymentID int) ([]*engine.Release, error) {
-	query := `SELECT id, name, image_tag, timestamp, namespace, values, chart,
-	chart_version, revision, status
+	releases, err := r.getDeploymentsReleases([]int{deploymentID})
+	if err != nil {
+		return nil, err
+	}
+	if deploymentReleases, found := releases[deploymentID]; found {
+		return deploymentReleases, nil
+	}
+	return []*engine.Release{}, nil
+}
+
+// getDeploymentsReleases returns the releases of each of the given deployments,
+// from most recent to less recent, with a single query. Releases are indexed by deployment id.
+func (r *pgRepository) getDeploymentsReleases(deploymentIDs []int) (map[int][]*engine.Release, error) {
+	query := `SELECT id, name, deployment_id, image_tag, image_tags, timestamp, namespace, values, secret_values, chart,
+	chart_version, revision, status, hooks, health_checks, workloads, stage
 	FROM release
-	WHERE deployment_id = $1
+	WHERE deployment_id = ANY($1)
 	ORDER BY timestamp desc;`
-	rows, err := r.db.Query(query, deploymentID)
+	rows, err := r.db.Query(query, pq.Array(deploymentIDs))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	releases := []*engine.Release{}
+	releases := make(map[int][]*engine.Release)
 	for rows.Next() { // TODO: replace with call to getRelease
-		var releaseID, revision int
+		var releaseID, deploymentID, revision int
 		var timestamp time.Time
 		var imageTag, namespace, chart, name string
-		var values, chartVersion sql.NullString
+		var chartVersion sql.NullString
+		var imageTags, values, secretValues, hooks, healthChecks, workloads, stage []byte
 		var status uint8
-		err = rows.Scan(&releaseID, &name, &imageTag, &timestamp, &namespace,
-			&values, &chart, &chartVersion, &revision, &status)
+		err = rows.Scan(&releaseID, &name, &deploymentID, &imageTag, &imageTags, &timestamp, &namespace,
+			&values, &secretValues, &chart, &chartVersion, &revision, &status, &hooks, &healthChecks, &workloads, &stage)
 		if err != nil {
 			return nil, err
 		}
+		tags, err := unmarshalStringMap(imageTags)
+		if err != nil {
+			return nil, errors.Wrap(err, "Cannot decode image tags")
+		}
+		releaseValues, err := unmarshalValues(values)
+		if err != nil {
+			return nil, errors.Wrap(err, "Cannot decode release values")
+		}
+		hookResults, err := unmarshalHookResults(hooks)
+		if err != nil {
+			return nil, errors.Wrap(err, "Cannot decode hook results")
+		}
+		healthCheckResults, err := unmarshalHealthCheckResults(healthChecks)
+		if err != nil {
+			return nil, errors.Wrap(err, "Cannot decode health check results")
+		}
+		workloadStatuses, err := unmarshalWorkloadStatuses(workloads)
+		if err != nil {
+			return nil, errors.Wrap(err, "Cannot decode workloads")
+		}
+		releaseStage, err := unmarshalStage(stage)
+		if err != nil {
+			return nil, errors.Wrap(err, "Cannot decode release stage")
+		}
 		release := &engine.Release{
 			ID:           releaseID,
 			Name:         name,
 			ImageTag:     imageTag,
+			ImageTags:    tags,
 			DeploymentID: deploymentID,
 			Date:         timestamp,
 			Namespace:    namespace,
-			Values:       values.String,
+			Values:       releaseValues,
 			Chart:        chart,
 			ChartVersion: chartVersion.String,
 			Revision:     revision,
 			Status:       engine.GennakerReleaseOutcome(status),
+			Hooks:        hookResults,
+			HealthChecks: healthCheckResults,
+			Workloads:    workloadStatuses,
+			Stage:        releaseStage,
 		}
-		releases = append(releases, release)
+		release.EncryptedSecretValues = secretValues
+		releases[deploymentID] = append(releases[deploymentID], release)
+	}
+	// get any error encountered during iteration
+	err = rows.Err()
+	if err != nil {
+		return nil, err
 	}
 	return releases, nil
 }
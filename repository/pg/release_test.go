@@ -14,8 +14,14 @@ func Test_CreateRelease(t *testing.T) {
 		release   engine.Release
 		shouldErr bool
 	}{
-		{testName: "Create should succeed", release: engine.Release{Name: "happy-panda", DeploymentID: firstTestDeploymentID, ImageTag: "0.0.1", Namespace: "prod", Values: "dbname=test",
+		{testName: "Create should succeed", release: engine.Release{Name: "happy-panda", DeploymentID: firstTestDeploymentID, ImageTag: "0.0.1", Namespace: "prod", Values: engine.Values{"dbname": "test"},
 			Chart: "test-chart", ChartVersion: "0.1.0", Status: engine.Deployed}},
+		{testName: "Create should succeed with image tags", release: engine.Release{Name: "happy-panda", DeploymentID: firstTestDeploymentID, ImageTag: "0.0.1",
+			ImageTags: map[string]string{"api": "0.0.1", "worker": "0.0.2"}, Namespace: "int", Chart: "test-chart", Status: engine.Deployed}},
+		{testName: "Create should succeed with structured values", release: engine.Release{Name: "happy-panda", DeploymentID: firstTestDeploymentID, ImageTag: "0.0.1", Namespace: "ppd",
+			Values: engine.Values{"db": map[string]interface{}{"hosts": []interface{}{"a", "b"}, "port": 5432}}, Chart: "test-chart", Status: engine.Deployed}},
+		{testName: "Create should succeed with encrypted secret values", release: engine.Release{Name: "happy-panda", DeploymentID: firstTestDeploymentID, ImageTag: "0.0.1", Namespace: "prod",
+			EncryptedSecretValues: []byte{0x01, 0x02, 0x03}, Chart: "test-chart", Status: engine.Deployed}},
 		{testName: "Create should succeed with empty values", release: engine.Release{Name: "happy-panda", DeploymentID: firstTestDeploymentID, ImageTag: "0.0.1", Namespace: "dev",
 			Chart: "test-chart", Status: engine.Deployed}},
 		{testName: "Create should fail with non existent deployment id", release: engine.Release{Name: "happy-panda", DeploymentID: 25689, ImageTag: "0.0.1", Namespace: "dev",
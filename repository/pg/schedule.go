@@ -0,0 +1,243 @@
+package pg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/vgheri/gennaker/engine"
+)
+
+// notNullViolation is returned when inserting a job for an unknown deployment
+const notNullViolation = "23502"
+
+const selectSchedules = `SELECT j.id, j.kind, d.name, j.payload, j.secret_values, j.run_at, j.cron, j.status,
+	j.last_run, j.claimed_at, j.reports, j.error, j.creation_date
+	FROM scheduled_job j
+	JOIN deployment d ON d.id = j.deployment_id`
+
+func (r *pgRepository) CreateSchedule(schedule *engine.Schedule) error {
+	if schedule == nil {
+		return engine.ErrBadRequest
+	}
+	payload, err := marshalSchedulePayload(schedule)
+	if err != nil {
+		return err
+	}
+	reports, err := json.Marshal(schedule.Reports)
+	if err != nil {
+		return errors.Wrap(err, "Cannot encode reports")
+	}
+	query := `INSERT INTO scheduled_job(kind, deployment_id, payload, secret_values, run_at, cron, status, reports)
+  VALUES($1, (SELECT id FROM deployment WHERE name = $2), $3, $4, $5, $6, $7, $8) RETURNING id, creation_date`
+	err = r.db.QueryRow(query, schedule.Kind, schedule.DeploymentName, payload, schedule.EncryptedSecretValues,
+		schedule.RunAt, sql.NullString{String: schedule.Cron, Valid: len(schedule.Cron) != 0},
+		string(schedule.Status), reports).Scan(&schedule.ID, &schedule.CreationDate)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == notNullViolation {
+			return engine.ErrResourceNotFound
+		}
+		return errors.Wrap(err, "Cannot insert schedule")
+	}
+	return nil
+}
+
+// ListSchedules returns every scheduled job, the next to run first
+func (r *pgRepository) ListSchedules() ([]*engine.Schedule, error) {
+	return r.querySchedules(selectSchedules + `
+	ORDER BY j.run_at, j.id;`)
+}
+
+func (r *pgRepository) GetSchedule(id int) (*engine.Schedule, error) {
+	schedules, err := r.querySchedules(selectSchedules+`
+	WHERE j.id = $1;`, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(schedules) == 0 {
+		return nil, engine.ErrResourceNotFound
+	}
+	return schedules[0], nil
+}
+
+// CancelSchedule cancels a pending job.
+// Returns engine.ErrScheduleNotPending if the job is not pending anymore
+func (r *pgRepository) CancelSchedule(id int) error {
+	res, err := r.db.Exec(`UPDATE scheduled_job SET status = $1 WHERE id = $2 AND status = $3`,
+		string(engine.ScheduleCancelled), id, string(engine.SchedulePending))
+	if err != nil {
+		return errors.Wrap(err, "Cannot cancel schedule")
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return engine.ErrScheduleNotPending
+	}
+	return nil
+}
+
+// ClaimDueSchedules marks up to limit pending jobs whose time has come as running, and returns them.
+// Rows locked by the claim of another server are skipped, so that each job is claimed once
+func (r *pgRepository) ClaimDueSchedules(limit int) ([]*engine.Schedule, error) {
+	query := `WITH claimed AS (
+		UPDATE scheduled_job SET status = $1, last_run = NOW(), claimed_at = NOW()
+		WHERE id IN (
+			SELECT id FROM scheduled_job
+			WHERE status = $2 AND run_at <= NOW()
+			ORDER BY run_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED)
+		RETURNING *)
+	SELECT j.id, j.kind, d.name, j.payload, j.secret_values, j.run_at, j.cron, j.status,
+	j.last_run, j.claimed_at, j.reports, j.error, j.creation_date
+	FROM claimed j
+	JOIN deployment d ON d.id = j.deployment_id
+	ORDER BY j.run_at, j.id;`
+	return r.querySchedules(query, string(engine.ScheduleRunning), string(engine.SchedulePending), limit)
+}
+
+// RenewScheduleClaim extends the claim of a running job.
+// Returns engine.ErrResourceNotFound if the job is not running anymore
+func (r *pgRepository) RenewScheduleClaim(id int) error {
+	res, err := r.db.Exec(`UPDATE scheduled_job SET claimed_at = NOW() WHERE id = $1 AND status = $2`,
+		id, string(engine.ScheduleRunning))
+	if err != nil {
+		return errors.Wrap(err, "Cannot renew schedule claim")
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return engine.ErrResourceNotFound
+	}
+	return nil
+}
+
+// RecoverStaleSchedules releases the running jobs whose claim was not renewed for longer than lease,
+// recording engine.ErrScheduleInterrupted as their error: one-off jobs fail, cron jobs go back to pending
+// to run again. Returns the number of jobs recovered
+func (r *pgRepository) RecoverStaleSchedules(lease time.Duration) (int, error) {
+	query := `UPDATE scheduled_job
+	SET status = CASE WHEN cron IS NULL THEN $1 ELSE $2 END, error = $3, claimed_at = NULL
+	WHERE status = $4 AND COALESCE(claimed_at, last_run) < NOW() - $5::FLOAT * INTERVAL '1 second'`
+	res, err := r.db.Exec(query, string(engine.ScheduleFailed), string(engine.SchedulePending),
+		engine.ErrScheduleInterrupted.Error(), string(engine.ScheduleRunning), lease.Seconds())
+	if err != nil {
+		return 0, errors.Wrap(err, "Cannot recover stale schedules")
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(affected), nil
+}
+
+// UpdateSchedule records the outcome of the last run of a job and its next run
+func (r *pgRepository) UpdateSchedule(schedule *engine.Schedule) error {
+	if schedule == nil || schedule.ID == 0 {
+		return engine.ErrBadRequest
+	}
+	reports, err := json.Marshal(schedule.Reports)
+	if err != nil {
+		return errors.Wrap(err, "Cannot encode reports")
+	}
+	query := `UPDATE scheduled_job SET status = $1, run_at = $2, reports = $3, error = $4, claimed_at = NULL
+	WHERE id = $5`
+	res, err := r.db.Exec(query, string(schedule.Status), schedule.RunAt, reports,
+		sql.NullString{String: schedule.Error, Valid: len(schedule.Error) != 0}, schedule.ID)
+	if err != nil {
+		return errors.Wrap(err, "Cannot update schedule")
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return engine.ErrResourceNotFound
+	}
+	return nil
+}
+
+func (r *pgRepository) querySchedules(query string, args ...interface{}) ([]*engine.Schedule, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	schedules := []*engine.Schedule{}
+	for rows.Next() {
+		schedule := &engine.Schedule{}
+		var payload, reports []byte
+		var status string
+		var cron, jobError sql.NullString
+		var lastRun, claimedAt pq.NullTime
+		if err = rows.Scan(&schedule.ID, &schedule.Kind, &schedule.DeploymentName, &payload,
+			&schedule.EncryptedSecretValues, &schedule.RunAt, &cron, &status, &lastRun, &claimedAt, &reports,
+			&jobError, &schedule.CreationDate); err != nil {
+			return nil, err
+		}
+		schedule.Cron = cron.String
+		schedule.Status = engine.ScheduleStatus(status)
+		schedule.Error = jobError.String
+		if lastRun.Valid {
+			t := lastRun.Time
+			schedule.LastRun = &t
+		}
+		if claimedAt.Valid {
+			t := claimedAt.Time
+			schedule.ClaimedAt = &t
+		}
+		if err = unmarshalSchedulePayload(schedule, payload); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(reports, &schedule.Reports); err != nil {
+			return nil, errors.Wrap(err, "Cannot decode reports")
+		}
+		schedules = append(schedules, schedule)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+// marshalSchedulePayload encodes the request of the job, its secret values being stored encrypted apart
+func marshalSchedulePayload(schedule *engine.Schedule) ([]byte, error) {
+	var payload interface{}
+	switch schedule.Kind {
+	case engine.PromoteJob:
+		payload = schedule.Promote
+	case engine.NewReleaseJob:
+		payload = schedule.NewRelease
+	default:
+		return nil, errors.Errorf("Unknown kind of job %s", schedule.Kind)
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot encode schedule payload")
+	}
+	return b, nil
+}
+
+func unmarshalSchedulePayload(schedule *engine.Schedule, payload []byte) error {
+	var err error
+	switch schedule.Kind {
+	case engine.PromoteJob:
+		schedule.Promote = &engine.PromoteRequest{}
+		err = json.Unmarshal(payload, schedule.Promote)
+	case engine.NewReleaseJob:
+		schedule.NewRelease = &engine.ReleaseNotification{}
+		err = json.Unmarshal(payload, schedule.NewRelease)
+	default:
+		return errors.Errorf("Unknown kind of job %s", schedule.Kind)
+	}
+	if err != nil {
+		return errors.Wrap(err, "Cannot decode schedule payload")
+	}
+	return nil
+}
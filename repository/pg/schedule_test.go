@@ -0,0 +1,149 @@
+package pg
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/vgheri/gennaker/engine"
+)
+
+func TestSchedules(t *testing.T) {
+	teardown(db)
+	insertDummyData(db)
+
+	due := &engine.Schedule{Kind: engine.PromoteJob, DeploymentName: firstTestDeploymentName,
+		Promote: &engine.PromoteRequest{DeploymentName: firstTestDeploymentName, FromNamespace: "int"},
+		RunAt:   time.Now().Add(-time.Minute), Status: engine.SchedulePending, Reports: []string{},
+		EncryptedSecretValues: []byte("encrypted")}
+	later := &engine.Schedule{Kind: engine.NewReleaseJob, DeploymentName: secondTestDeploymentName,
+		NewRelease: &engine.ReleaseNotification{DeploymentName: secondTestDeploymentName, ImageTag: "0.0.3"},
+		RunAt:      time.Now().Add(time.Hour), Cron: "0 * * * *", Status: engine.SchedulePending, Reports: []string{}}
+	for _, schedule := range []*engine.Schedule{due, later} {
+		if err := pg.CreateSchedule(schedule); err != nil {
+			t.Fatalf("Expected schedule to be saved, got %v", err)
+		}
+	}
+	if err := pg.CreateSchedule(&engine.Schedule{Kind: engine.PromoteJob, DeploymentName: "unknown",
+		Promote: &engine.PromoteRequest{}, RunAt: time.Now(), Status: engine.SchedulePending}); err != engine.ErrResourceNotFound {
+		t.Fatalf("Expected ErrResourceNotFound for an unknown deployment, got %v", err)
+	}
+
+	schedule, err := pg.GetSchedule(later.ID)
+	if err != nil || schedule.NewRelease == nil || schedule.NewRelease.ImageTag != "0.0.3" || schedule.Cron != "0 * * * *" {
+		t.Fatalf("Unexpected schedule %+v (%v)", schedule, err)
+	}
+
+	// Concurrent schedulers claim each job once
+	var mu sync.Mutex
+	var claimed []*engine.Schedule
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			schedules, err := pg.ClaimDueSchedules(10)
+			if err != nil {
+				t.Errorf("Unexpected error claiming schedules: %v", err)
+			}
+			mu.Lock()
+			claimed = append(claimed, schedules...)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if len(claimed) != 1 || claimed[0].ID != due.ID || claimed[0].Status != engine.ScheduleRunning ||
+		claimed[0].LastRun == nil || string(claimed[0].EncryptedSecretValues) != "encrypted" {
+		t.Fatalf("Expected the due schedule to be claimed once, got %+v", claimed)
+	}
+
+	claimed[0].Status = engine.ScheduleFailed
+	claimed[0].Reports = []string{"promoted"}
+	claimed[0].Error = "helm failed"
+	if err = pg.UpdateSchedule(claimed[0]); err != nil {
+		t.Fatalf("Expected schedule to be updated, got %v", err)
+	}
+	if err = pg.CancelSchedule(due.ID); err != engine.ErrScheduleNotPending {
+		t.Fatalf("Expected ErrScheduleNotPending cancelling a schedule which ran, got %v", err)
+	}
+	if err = pg.CancelSchedule(later.ID); err != nil {
+		t.Fatalf("Expected pending schedule to be cancelled, got %v", err)
+	}
+	schedules, err := pg.ListSchedules()
+	if err != nil || len(schedules) != 2 || schedules[0].Error != "helm failed" ||
+		schedules[1].Status != engine.ScheduleCancelled {
+		t.Fatalf("Unexpected schedules %+v (%v)", schedules, err)
+	}
+}
+
+func TestScheduleLease(t *testing.T) {
+	teardown(db)
+	insertDummyData(db)
+
+	once := &engine.Schedule{Kind: engine.PromoteJob, DeploymentName: firstTestDeploymentName,
+		Promote: &engine.PromoteRequest{DeploymentName: firstTestDeploymentName, FromNamespace: "int"},
+		RunAt:   time.Now().Add(-time.Minute), Status: engine.SchedulePending, Reports: []string{}}
+	cron := &engine.Schedule{Kind: engine.PromoteJob, DeploymentName: secondTestDeploymentName,
+		Promote: &engine.PromoteRequest{DeploymentName: secondTestDeploymentName, FromNamespace: "int"},
+		RunAt:   time.Now().Add(-time.Minute), Cron: "0 * * * *", Status: engine.SchedulePending, Reports: []string{}}
+	renewed := &engine.Schedule{Kind: engine.PromoteJob, DeploymentName: firstTestDeploymentName,
+		Promote: &engine.PromoteRequest{DeploymentName: firstTestDeploymentName, FromNamespace: "dev"},
+		RunAt:   time.Now().Add(-time.Minute), Status: engine.SchedulePending, Reports: []string{}}
+	for _, schedule := range []*engine.Schedule{once, cron, renewed} {
+		if err := pg.CreateSchedule(schedule); err != nil {
+			t.Fatalf("Expected schedule to be saved, got %v", err)
+		}
+	}
+	claimed, err := pg.ClaimDueSchedules(10)
+	if err != nil || len(claimed) != 3 || claimed[0].ClaimedAt == nil {
+		t.Fatalf("Expected the due schedules to be claimed, got %+v (%v)", claimed, err)
+	}
+
+	// Claims are not recovered before their lease expires
+	if recovered, err := pg.RecoverStaleSchedules(time.Minute); err != nil || recovered != 0 {
+		t.Fatalf("Expected no schedule to be recovered, got %d (%v)", recovered, err)
+	}
+	// The schedulers running the jobs stopped ten minutes ago, the third one renewed its claim since
+	if _, err = db.Exec(`UPDATE scheduled_job SET claimed_at = NOW() - INTERVAL '10 minutes'`); err != nil {
+		t.Fatalf("Cannot age claims: %v", err)
+	}
+	if err = pg.RenewScheduleClaim(renewed.ID); err != nil {
+		t.Fatalf("Expected claim to be renewed, got %v", err)
+	}
+	recovered, err := pg.RecoverStaleSchedules(time.Minute)
+	if err != nil || recovered != 2 {
+		t.Fatalf("Expected 2 schedules to be recovered, got %d (%v)", recovered, err)
+	}
+
+	expected := []struct {
+		id     int
+		status engine.ScheduleStatus
+		err    string
+	}{
+		{once.ID, engine.ScheduleFailed, engine.ErrScheduleInterrupted.Error()},
+		{cron.ID, engine.SchedulePending, engine.ErrScheduleInterrupted.Error()},
+		{renewed.ID, engine.ScheduleRunning, ""},
+	}
+	for _, e := range expected {
+		schedule, err := pg.GetSchedule(e.id)
+		if err != nil || schedule.Status != e.status || schedule.Error != e.err {
+			t.Errorf("Expected schedule %d to be %s with error %q, got %+v (%v)", e.id, e.status, e.err, schedule, err)
+		}
+	}
+	if err = pg.RenewScheduleClaim(once.ID); err != engine.ErrResourceNotFound {
+		t.Fatalf("Expected ErrResourceNotFound renewing the claim of a recovered schedule, got %v", err)
+	}
+
+	// The recovered cron job is claimed again, and its claim is released with its outcome
+	claimed, err = pg.ClaimDueSchedules(10)
+	if err != nil || len(claimed) != 1 || claimed[0].ID != cron.ID {
+		t.Fatalf("Expected the recovered cron schedule to be claimed again, got %+v (%v)", claimed, err)
+	}
+	claimed[0].Status = engine.ScheduleDone
+	if err = pg.UpdateSchedule(claimed[0]); err != nil {
+		t.Fatalf("Expected schedule to be updated, got %v", err)
+	}
+	if schedule, err := pg.GetSchedule(cron.ID); err != nil || schedule.ClaimedAt != nil {
+		t.Fatalf("Expected claim to be released, got %+v (%v)", schedule, err)
+	}
+}
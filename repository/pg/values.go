@@ -0,0 +1,84 @@
+package pg
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/vgheri/gennaker/engine"
+)
+
+// GetValuesLayers returns the current version of every values layer of a deployment
+func (r *pgRepository) GetValuesLayers(deploymentID int) ([]*engine.ValuesLayer, error) {
+	query := `SELECT DISTINCT ON (namespace) id, namespace, version, values, creation_date
+	FROM values_layer
+	WHERE deployment_id = $1
+	ORDER BY namespace, version DESC;`
+	return r.queryValuesLayers(deploymentID, query, deploymentID)
+}
+
+// GetValuesLayerHistory returns every version of a values layer, from most recent to less recent
+func (r *pgRepository) GetValuesLayerHistory(deploymentID int, namespace string) ([]*engine.ValuesLayer, error) {
+	query := `SELECT id, namespace, version, values, creation_date
+	FROM values_layer
+	WHERE deployment_id = $1 AND namespace = $2
+	ORDER BY version DESC;`
+	return r.queryValuesLayers(deploymentID, query, deploymentID, namespace)
+}
+
+func (r *pgRepository) queryValuesLayers(deploymentID int, query string, args ...interface{}) ([]*engine.ValuesLayer, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	layers := []*engine.ValuesLayer{}
+	for rows.Next() {
+		var id, version int
+		var namespace string
+		var values []byte
+		var creationDate time.Time
+		if err = rows.Scan(&id, &namespace, &version, &values, &creationDate); err != nil {
+			return nil, err
+		}
+		layerValues, err := unmarshalValues(values)
+		if err != nil {
+			return nil, errors.Wrap(err, "Cannot decode values")
+		}
+		layers = append(layers, &engine.ValuesLayer{
+			ID:           id,
+			DeploymentID: deploymentID,
+			Namespace:    namespace,
+			Version:      version,
+			Values:       layerValues,
+			CreationDate: creationDate,
+		})
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return layers, nil
+}
+
+// CreateValuesLayer saves a new version of a values layer, setting its id, version and creation date
+func (r *pgRepository) CreateValuesLayer(layer *engine.ValuesLayer) error {
+	if layer == nil {
+		return engine.ErrBadRequest
+	}
+	values, err := marshalValues(layer.Values)
+	if err != nil {
+		return errors.Wrap(err, "Cannot encode values")
+	}
+	query := `INSERT INTO values_layer(deployment_id, namespace, version, values)
+  SELECT $1, $2, COALESCE(MAX(version), 0) + 1, $3 FROM values_layer WHERE deployment_id = $1 AND namespace = $2
+  RETURNING id, version, creation_date`
+	err = r.db.QueryRow(query, layer.DeploymentID, layer.Namespace, values).Scan(&layer.ID, &layer.Version,
+		&layer.CreationDate)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+			return errors.New("Values have been updated concurrently")
+		}
+		return errors.Wrap(err, "Cannot insert values")
+	}
+	return nil
+}
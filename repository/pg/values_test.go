@@ -0,0 +1,56 @@
+package pg
+
+import (
+	"testing"
+
+	"github.com/vgheri/gennaker/engine"
+)
+
+func TestValuesLayers(t *testing.T) {
+	teardown(db)
+	insertDummyData(db)
+
+	layers := []*engine.ValuesLayer{
+		{DeploymentID: firstTestDeploymentID, Values: engine.Values{"replicas": 1}},
+		{DeploymentID: firstTestDeploymentID, Values: engine.Values{"replicas": 2}},
+		{DeploymentID: firstTestDeploymentID, Namespace: "int", Values: engine.Values{"db": map[string]interface{}{"host": "pg"}}},
+	}
+	for i, layer := range layers {
+		if err := pg.CreateValuesLayer(layer); err != nil {
+			t.Fatalf("Expected layer %d to be saved, got %v", i, err)
+		}
+		if layer.ID == 0 || layer.CreationDate.IsZero() {
+			t.Fatalf("Expected id and creation date to be set, got %+v", layer)
+		}
+	}
+	if layers[0].Version != 1 || layers[1].Version != 2 || layers[2].Version != 1 {
+		t.Fatalf("Expected versions to be numbered per namespace, got %d, %d, %d",
+			layers[0].Version, layers[1].Version, layers[2].Version)
+	}
+
+	current, err := pg.GetValuesLayers(firstTestDeploymentID)
+	if err != nil {
+		t.Fatalf("Expected current layers, got %v", err)
+	}
+	if len(current) != 2 ||
+		current[0].Namespace != "" || current[0].Version != 2 || current[0].Values["replicas"] != float64(2) ||
+		current[1].Namespace != "int" || current[1].Version != 1 {
+		t.Fatalf("Unexpected current layers %+v", current)
+	}
+
+	history, err := pg.GetValuesLayerHistory(firstTestDeploymentID, "")
+	if err != nil {
+		t.Fatalf("Expected layer history, got %v", err)
+	}
+	if len(history) != 2 || history[0].Version != 2 || history[1].Version != 1 {
+		t.Fatalf("Unexpected layer history %+v", history)
+	}
+
+	current, err = pg.GetValuesLayers(secondTestDeploymentID)
+	if err != nil || len(current) != 0 {
+		t.Fatalf("Expected no layers for the second deployment, got %+v (%v)", current, err)
+	}
+	if err = pg.CreateValuesLayer(&engine.ValuesLayer{DeploymentID: 25689}); err == nil {
+		t.Fatalf("Expected an error saving values of a non existent deployment")
+	}
+}
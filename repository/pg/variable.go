@@ -0,0 +1,173 @@
+package pg
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/vgheri/gennaker/engine"
+)
+
+const foreignKeyViolation = "23503"
+
+func (r *pgRepository) ListVariableSets() ([]*engine.VariableSet, error) {
+	query := `SELECT id, name, description, variables, creation_date, last_update
+	FROM variable_set
+	ORDER BY name;`
+	return r.queryVariableSets(query)
+}
+
+func (r *pgRepository) GetVariableSet(name string) (*engine.VariableSet, error) {
+	query := `SELECT id, name, description, variables, creation_date, last_update
+	FROM variable_set
+	WHERE name = $1;`
+	sets, err := r.queryVariableSets(query, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(sets) == 0 {
+		return nil, engine.ErrResourceNotFound
+	}
+	return sets[0], nil
+}
+
+// GetDeploymentVariableSets returns the variable sets attached to a deployment, in order
+func (r *pgRepository) GetDeploymentVariableSets(deploymentID int) ([]*engine.VariableSet, error) {
+	query := `SELECT vs.id, vs.name, vs.description, vs.variables, vs.creation_date, vs.last_update
+	FROM variable_set vs
+	JOIN deployment_variable_set dvs ON dvs.variable_set_id = vs.id
+	WHERE dvs.deployment_id = $1
+	ORDER BY dvs.position;`
+	return r.queryVariableSets(query, deploymentID)
+}
+
+func (r *pgRepository) queryVariableSets(query string, args ...interface{}) ([]*engine.VariableSet, error) {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sets := []*engine.VariableSet{}
+	for rows.Next() {
+		var id int
+		var name string
+		var description sql.NullString
+		var variables []byte
+		var creationDate, lastUpdate time.Time
+		if err = rows.Scan(&id, &name, &description, &variables, &creationDate, &lastUpdate); err != nil {
+			return nil, err
+		}
+		set := &engine.VariableSet{
+			ID:           id,
+			Name:         name,
+			Description:  description.String,
+			Variables:    []*engine.Variable{},
+			CreationDate: creationDate,
+			LastUpdate:   lastUpdate,
+		}
+		if err = json.Unmarshal(variables, &set.Variables); err != nil {
+			return nil, errors.Wrap(err, "Cannot decode variables")
+		}
+		sets = append(sets, set)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sets, nil
+}
+
+func (r *pgRepository) CreateVariableSet(set *engine.VariableSet) error {
+	if set == nil {
+		return engine.ErrBadRequest
+	}
+	variables, err := marshalVariables(set.Variables)
+	if err != nil {
+		return err
+	}
+	query := `INSERT INTO variable_set(name, description, variables)
+  VALUES($1, $2, $3) RETURNING id, creation_date, last_update`
+	err = r.db.QueryRow(query, set.Name, set.Description, variables).Scan(&set.ID, &set.CreationDate, &set.LastUpdate)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+			return errors.Errorf("Variable set %s already exists", set.Name)
+		}
+		return errors.Wrap(err, "Cannot insert variable set")
+	}
+	return nil
+}
+
+func (r *pgRepository) UpdateVariableSet(set *engine.VariableSet) error {
+	if set == nil || set.ID == 0 {
+		return engine.ErrBadRequest
+	}
+	variables, err := marshalVariables(set.Variables)
+	if err != nil {
+		return err
+	}
+	query := `UPDATE variable_set SET description = $1, variables = $2, last_update = NOW()
+  WHERE id = $3 RETURNING last_update`
+	err = r.db.QueryRow(query, set.Description, variables, set.ID).Scan(&set.LastUpdate)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return engine.ErrResourceNotFound
+		}
+		return errors.Wrap(err, "Cannot update variable set")
+	}
+	return nil
+}
+
+// DeleteVariableSet deletes a variable set.
+// Returns engine.ErrVariableSetInUse if the set is attached to a deployment
+func (r *pgRepository) DeleteVariableSet(variableSetID int) error {
+	res, err := r.db.Exec(`DELETE FROM variable_set WHERE id = $1`, variableSetID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == foreignKeyViolation {
+			return engine.ErrVariableSetInUse
+		}
+		return errors.Wrap(err, "Cannot delete variable set")
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return engine.ErrResourceNotFound
+	}
+	return nil
+}
+
+// SetDeploymentVariableSets replaces the variable sets attached to a deployment,
+// keeping the order of variableSetIDs
+func (r *pgRepository) SetDeploymentVariableSets(deploymentID int, variableSetIDs []int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return errors.Wrap(err, "Cannot init transaction")
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec(`DELETE FROM deployment_variable_set WHERE deployment_id = $1`, deploymentID); err != nil {
+		return errors.Wrap(err, "Cannot detach variable sets")
+	}
+	query := `INSERT INTO deployment_variable_set(deployment_id, variable_set_id, position) VALUES($1, $2, $3)`
+	for position, variableSetID := range variableSetIDs {
+		if _, err = tx.Exec(query, deploymentID, variableSetID, position); err != nil {
+			return errors.Wrap(err, "Cannot attach variable set")
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "Cannot commit transaction")
+	}
+	return nil
+}
+
+func marshalVariables(variables []*engine.Variable) ([]byte, error) {
+	if variables == nil {
+		variables = []*engine.Variable{}
+	}
+	b, err := json.Marshal(variables)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot encode variables")
+	}
+	return b, nil
+}
@@ -0,0 +1,66 @@
+package pg
+
+import (
+	"testing"
+
+	"github.com/vgheri/gennaker/engine"
+)
+
+func TestVariableSets(t *testing.T) {
+	teardown(db)
+	insertDummyData(db)
+
+	eu := &engine.VariableSet{Name: "eu", Variables: []*engine.Variable{
+		{Name: "db_host", Value: "db.eu"},
+		{Name: "db_host", Value: "db.eu.prod", Namespaces: []string{"prod"}},
+	}}
+	us := &engine.VariableSet{Name: "us", Description: "US region"}
+	for _, set := range []*engine.VariableSet{eu, us} {
+		if err := pg.CreateVariableSet(set); err != nil {
+			t.Fatalf("Expected variable set %s to be saved, got %v", set.Name, err)
+		}
+	}
+	if err := pg.CreateVariableSet(&engine.VariableSet{Name: "eu"}); err == nil {
+		t.Fatalf("Expected an error creating a variable set with an existing name")
+	}
+
+	set, err := pg.GetVariableSet("eu")
+	if err != nil {
+		t.Fatalf("Expected variable set, got %v", err)
+	}
+	if set.ID != eu.ID || len(set.Variables) != 2 || set.Variables[1].Namespaces[0] != "prod" {
+		t.Fatalf("Unexpected variable set %+v", set)
+	}
+	if _, err = pg.GetVariableSet("asia"); err != engine.ErrResourceNotFound {
+		t.Fatalf("Expected ErrResourceNotFound, got %v", err)
+	}
+
+	us.Variables = []*engine.Variable{{Name: "db_host", Value: "db.us"}}
+	if err = pg.UpdateVariableSet(us); err != nil {
+		t.Fatalf("Expected variable set to be updated, got %v", err)
+	}
+	sets, err := pg.ListVariableSets()
+	if err != nil || len(sets) != 2 || sets[1].Name != "us" || len(sets[1].Variables) != 1 {
+		t.Fatalf("Unexpected variable sets %+v (%v)", sets, err)
+	}
+
+	if err = pg.SetDeploymentVariableSets(firstTestDeploymentID, []int{us.ID, eu.ID}); err != nil {
+		t.Fatalf("Expected variable sets to be attached, got %v", err)
+	}
+	sets, err = pg.GetDeploymentVariableSets(firstTestDeploymentID)
+	if err != nil || len(sets) != 2 || sets[0].Name != "us" || sets[1].Name != "eu" {
+		t.Fatalf("Expected attached variable sets in order, got %+v (%v)", sets, err)
+	}
+	if err = pg.DeleteVariableSet(eu.ID); err != engine.ErrVariableSetInUse {
+		t.Fatalf("Expected ErrVariableSetInUse deleting an attached variable set, got %v", err)
+	}
+	if err = pg.SetDeploymentVariableSets(firstTestDeploymentID, []int{us.ID}); err != nil {
+		t.Fatalf("Expected variable sets to be replaced, got %v", err)
+	}
+	if err = pg.DeleteVariableSet(eu.ID); err != nil {
+		t.Fatalf("Expected detached variable set to be deleted, got %v", err)
+	}
+	if err = pg.DeleteVariableSet(eu.ID); err != engine.ErrResourceNotFound {
+		t.Fatalf("Expected ErrResourceNotFound deleting twice, got %v", err)
+	}
+}
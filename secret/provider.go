@@ -0,0 +1,57 @@
+package secret
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Provider resolves secrets kept outside of gennaker, ex: in a vault
+type Provider interface {
+	// Name identifies the provider in values, ex: {{ secret "file" "db/password" }}
+	Name() string
+	// GetSecret returns the secret stored under key
+	GetSecret(key string) (string, error)
+}
+
+// FileProvider reads each secret from a file of a directory,
+// the key being the path of the file relative to the directory.
+// It is meant for local use, ex: with Kubernetes secrets mounted as files
+type FileProvider struct {
+	dir string
+}
+
+// NewFileProvider returns a provider reading the secrets stored in dir
+func NewFileProvider(dir string) (*FileProvider, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot open secrets directory")
+	}
+	if !info.IsDir() {
+		return nil, errors.Errorf("%s is not a directory", dir)
+	}
+	return &FileProvider{dir: dir}, nil
+}
+
+func (p *FileProvider) Name() string {
+	return "file"
+}
+
+// GetSecret returns the content of the file, without trailing new lines
+func (p *FileProvider) GetSecret(key string) (string, error) {
+	cleaned := filepath.Clean("/" + key)
+	if len(strings.TrimSpace(key)) == 0 || cleaned == "/" {
+		return "", errors.New("Secret key cannot be empty")
+	}
+	content, err := ioutil.ReadFile(filepath.Join(p.dir, cleaned))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", errors.Errorf("Secret %s not found", key)
+		}
+		return "", errors.Wrap(err, "Cannot read secret "+key)
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
@@ -0,0 +1,56 @@
+package secret
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_FileProvider(t *testing.T) {
+	root, err := ioutil.TempDir("", "gennaker-secrets-")
+	if err != nil {
+		t.Fatalf("Cannot create secrets folder: %v", err)
+	}
+	defer os.RemoveAll(root)
+	dir := path.Join(root, "secrets")
+	os.MkdirAll(path.Join(dir, "db"), 0700)
+	ioutil.WriteFile(path.Join(dir, "db", "password"), []byte("s3cr3t\n"), 0600)
+	ioutil.WriteFile(path.Join(root, "outside"), []byte("nope"), 0600)
+
+	if _, err = NewFileProvider(path.Join(root, "missing")); err == nil {
+		t.Fatalf("Expected an error with a missing directory")
+	}
+	p, err := NewFileProvider(dir)
+	if err != nil {
+		t.Fatalf("Expected provider to be created, got %v", err)
+	}
+	if p.Name() != "file" {
+		t.Fatalf("Unexpected provider name %s", p.Name())
+	}
+	tt := []struct {
+		testName  string
+		key       string
+		expected  string
+		shouldErr bool
+	}{
+		{testName: "Existing secret", key: "db/password", expected: "s3cr3t"},
+		{testName: "Missing secret", key: "db/user", shouldErr: true},
+		{testName: "Empty key", key: "", shouldErr: true},
+		{testName: "Key outside of the directory", key: "../outside", shouldErr: true},
+	}
+	for _, tc := range tt {
+		t.Run(tc.testName, func(t *testing.T) {
+			value, err := p.GetSecret(tc.key)
+			if tc.shouldErr {
+				if err == nil {
+					t.Fatalf("Expected test to fail, got %s", value)
+				}
+				return
+			}
+			if err != nil || value != tc.expected {
+				t.Fatalf("Expected %s, got %s (%v)", tc.expected, value, err)
+			}
+		})
+	}
+}
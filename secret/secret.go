@@ -0,0 +1,106 @@
+// Package secret encrypts the secret values stored by gennaker and
+// resolves the secrets kept by external providers
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// KeySize is the size in bytes of the encryption keys, which select AES-256
+const KeySize = 32
+
+// KeyEnvVar is the environment variable holding the encryption key
+// when no key file is specified
+const KeyEnvVar = "GENNAKER_SECRET_KEY"
+
+// Cipher encrypts and decrypts secrets with AES-GCM
+type Cipher struct {
+	aead      cipher.AEAD
+	digestKey []byte
+}
+
+// NewCipher returns a cipher using a key of KeySize bytes
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, errors.Errorf("Encryption key must be %d bytes long, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot create block cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot create GCM cipher")
+	}
+	// Digests must not be computed with the encryption key itself
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("gennaker digest key"))
+	return &Cipher{aead: aead, digestKey: mac.Sum(nil)}, nil
+}
+
+// LoadKey reads a base64 encoded key from keyFile, or from the KeyEnvVar
+// environment variable if keyFile is empty.
+// Returns a nil key if none is configured
+func LoadKey(keyFile string) ([]byte, error) {
+	var encoded string
+	if len(keyFile) != 0 {
+		content, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "Cannot read encryption key file")
+		}
+		encoded = string(content)
+	} else {
+		encoded = os.Getenv(KeyEnvVar)
+	}
+	encoded = strings.TrimSpace(encoded)
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "Encryption key must be base64 encoded")
+	}
+	return key, nil
+}
+
+// Encrypt seals plaintext, prefixing the result with a random nonce
+func (c *Cipher) Encrypt(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "Cannot generate nonce")
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Decrypt opens a ciphertext produced by Encrypt
+func (c *Cipher) Decrypt(ciphertext []byte) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(ciphertext) < nonceSize {
+		return nil, errors.New("Ciphertext is too short")
+	}
+	plaintext, err := c.aead.Open(nil, ciphertext[:nonceSize], ciphertext[nonceSize:], nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Cannot decrypt secret")
+	}
+	return plaintext, nil
+}
+
+// Digest returns a keyed digest of data, which can be compared
+// without revealing low entropy secrets
+func (c *Cipher) Digest(data []byte) string {
+	mac := hmac.New(sha256.New, c.digestKey)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
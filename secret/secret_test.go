@@ -0,0 +1,97 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func Test_NewCipher(t *testing.T) {
+	if _, err := NewCipher([]byte("short")); err == nil {
+		t.Fatalf("Expected an error with a key of the wrong size")
+	}
+	if _, err := NewCipher(testKey); err != nil {
+		t.Fatalf("Expected cipher to be created, got %v", err)
+	}
+}
+
+func Test_EncryptDecrypt(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	plaintext := []byte(`{"password":"s3cr3t"}`)
+	ciphertext, err := c.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Expected encryption to succeed, got %v", err)
+	}
+	if bytes.Contains(ciphertext, []byte("s3cr3t")) {
+		t.Fatalf("Expected ciphertext not to contain the plaintext")
+	}
+	other, _ := c.Encrypt(plaintext)
+	if bytes.Equal(ciphertext, other) {
+		t.Fatalf("Expected each encryption to use a different nonce")
+	}
+	decrypted, err := c.Decrypt(ciphertext)
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Fatalf("Expected %s, got %s (%v)", plaintext, decrypted, err)
+	}
+
+	ciphertext[len(ciphertext)-1] ^= 0xff
+	if _, err = c.Decrypt(ciphertext); err == nil {
+		t.Fatalf("Expected tampered ciphertext to be rejected")
+	}
+	otherCipher, _ := NewCipher([]byte("abcdef0123456789abcdef0123456789"))
+	if _, err = otherCipher.Decrypt(other); err == nil {
+		t.Fatalf("Expected decryption with another key to fail")
+	}
+	if _, err = c.Decrypt([]byte("abc")); err == nil {
+		t.Fatalf("Expected short ciphertext to be rejected")
+	}
+}
+
+func Test_Digest(t *testing.T) {
+	c, _ := NewCipher(testKey)
+	if c.Digest([]byte("a")) != c.Digest([]byte("a")) {
+		t.Fatalf("Expected digest to be deterministic")
+	}
+	if c.Digest([]byte("a")) == c.Digest([]byte("b")) {
+		t.Fatalf("Expected different data to have different digests")
+	}
+}
+
+func Test_LoadKey(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(testKey)
+	f, err := ioutil.TempFile("", "gennaker-key-")
+	if err != nil {
+		t.Fatalf("Cannot create key file: %v", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(encoded + "\n")
+	f.Close()
+
+	key, err := LoadKey(f.Name())
+	if err != nil || !bytes.Equal(key, testKey) {
+		t.Fatalf("Expected key from file, got %v (%v)", key, err)
+	}
+
+	defer os.Unsetenv(KeyEnvVar)
+	os.Setenv(KeyEnvVar, encoded)
+	key, err = LoadKey("")
+	if err != nil || !bytes.Equal(key, testKey) {
+		t.Fatalf("Expected key from environment, got %v (%v)", key, err)
+	}
+	os.Setenv(KeyEnvVar, "not base64!")
+	if _, err = LoadKey(""); err == nil {
+		t.Fatalf("Expected an error with an invalid key")
+	}
+	os.Unsetenv(KeyEnvVar)
+	key, err = LoadKey("")
+	if err != nil || key != nil {
+		t.Fatalf("Expected no key, got %v (%v)", key, err)
+	}
+	if _, err = LoadKey("/does/not/exist"); err == nil {
+		t.Fatalf("Expected an error with a missing key file")
+	}
+}
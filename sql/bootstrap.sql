@@ -1,15 +1,37 @@
 BEGIN;
 
-CREATE TABLE IF NOT EXISTS deployment (id SERIAL PRIMARY KEY, name TEXT UNIQUE NOT NULL, chart TEXT NOT NULL, chart_version TEXT, repository_url TEXT NOT NULL, creation_date TIMESTAMP WITH TIME ZONE DEFAULT NOW(), last_update TIMESTAMP WITH TIME ZONE DEFAULT NOW());
-CREATE TABLE IF NOT EXISTS pipeline_step (id SERIAL PRIMARY KEY, step_number INT NOT NULL, parent_step_number int, deployment_id INT NOT NULL, target_namespace TEXT NOT NULL, auto_deploy BOOLEAN DEFAULT FALSE);
-CREATE TABLE IF NOT EXISTS release (id SERIAL PRIMARY KEY, name TEXT NOT NULL, deployment_id INT NOT NULL, image_tag TEXT NOT NULL, timestamp TIMESTAMP WITH TIME ZONE DEFAULT NOW(), namespace TEXT NOT NULL, values TEXT, chart TEXT NOT NULL, chart_version TEXT, revision INT NOT NULL, status SMALLINT NOT NULL);
+CREATE TABLE IF NOT EXISTS deployment (id SERIAL PRIMARY KEY, name TEXT UNIQUE NOT NULL, chart TEXT NOT NULL, chart_version TEXT, repository_url TEXT NOT NULL, pipeline_version INT NOT NULL DEFAULT 1, image_paths JSONB NOT NULL DEFAULT '{}', creation_date TIMESTAMP WITH TIME ZONE DEFAULT NOW(), last_update TIMESTAMP WITH TIME ZONE DEFAULT NOW(), archive_date TIMESTAMP WITH TIME ZONE);
+CREATE TABLE IF NOT EXISTS pipeline_step (id SERIAL PRIMARY KEY, step_number INT NOT NULL, parent_step_number int, parent_step_numbers INT[], deployment_id INT NOT NULL, target_namespace TEXT NOT NULL, auto_deploy BOOLEAN DEFAULT FALSE, options JSONB);
+CREATE TABLE IF NOT EXISTS pipeline_version (id SERIAL PRIMARY KEY, deployment_id INT NOT NULL, version INT NOT NULL, steps JSONB NOT NULL, creation_date TIMESTAMP WITH TIME ZONE DEFAULT NOW());
+CREATE TABLE IF NOT EXISTS release (id SERIAL PRIMARY KEY, name TEXT NOT NULL, deployment_id INT NOT NULL, image_tag TEXT NOT NULL, image_tags JSONB NOT NULL DEFAULT '{}', timestamp TIMESTAMP WITH TIME ZONE DEFAULT NOW(), namespace TEXT NOT NULL, values JSONB NOT NULL DEFAULT '{}', secret_values BYTEA, chart TEXT NOT NULL, chart_version TEXT, revision INT NOT NULL, status SMALLINT NOT NULL, hooks JSONB NOT NULL DEFAULT '[]', health_checks JSONB NOT NULL DEFAULT '[]', workloads JSONB NOT NULL DEFAULT '[]', diagnostics JSONB, stage JSONB);
+CREATE TABLE IF NOT EXISTS release_notification (id SERIAL PRIMARY KEY, deployment_id INT NOT NULL, idempotency_key TEXT, image_tag TEXT NOT NULL, values_hash TEXT NOT NULL, status TEXT NOT NULL, reports JSONB NOT NULL DEFAULT '[]', creation_date TIMESTAMP WITH TIME ZONE DEFAULT NOW());
+CREATE TABLE IF NOT EXISTS values_layer (id SERIAL PRIMARY KEY, deployment_id INT NOT NULL, namespace TEXT NOT NULL DEFAULT '', version INT NOT NULL, values JSONB NOT NULL DEFAULT '{}', creation_date TIMESTAMP WITH TIME ZONE DEFAULT NOW());
+CREATE TABLE IF NOT EXISTS variable_set (id SERIAL PRIMARY KEY, name TEXT UNIQUE NOT NULL, description TEXT, variables JSONB NOT NULL DEFAULT '[]', creation_date TIMESTAMP WITH TIME ZONE DEFAULT NOW(), last_update TIMESTAMP WITH TIME ZONE DEFAULT NOW());
+CREATE TABLE IF NOT EXISTS deployment_variable_set (deployment_id INT NOT NULL, variable_set_id INT NOT NULL, position INT NOT NULL, PRIMARY KEY (deployment_id, variable_set_id));
+CREATE TABLE IF NOT EXISTS application (id SERIAL PRIMARY KEY, name TEXT UNIQUE NOT NULL, deployments JSONB NOT NULL DEFAULT '[]', creation_date TIMESTAMP WITH TIME ZONE DEFAULT NOW(), last_update TIMESTAMP WITH TIME ZONE DEFAULT NOW());
+CREATE TABLE IF NOT EXISTS application_snapshot (id SERIAL PRIMARY KEY, application_id INT NOT NULL, name TEXT NOT NULL, image_tags JSONB NOT NULL DEFAULT '{}', creation_date TIMESTAMP WITH TIME ZONE DEFAULT NOW());
+CREATE TABLE IF NOT EXISTS scheduled_job (id SERIAL PRIMARY KEY, kind TEXT NOT NULL, deployment_id INT NOT NULL, payload JSONB NOT NULL, secret_values BYTEA, run_at TIMESTAMP WITH TIME ZONE NOT NULL, cron TEXT, status TEXT NOT NULL, last_run TIMESTAMP WITH TIME ZONE, claimed_at TIMESTAMP WITH TIME ZONE, reports JSONB NOT NULL DEFAULT '[]', error TEXT, creation_date TIMESTAMP WITH TIME ZONE DEFAULT NOW());
 
 CREATE INDEX ON pipeline_step (deployment_id);
 CREATE INDEX ON pipeline_step (id, parent_step_number);
 ALTER TABLE pipeline_step ADD CONSTRAINT FK_PIPELINE_STEP_DEPLOYMENT_ID FOREIGN KEY (deployment_id) REFERENCES deployment (id);
 ALTER TABLE pipeline_step ADD CONSTRAINT PIPELINE_STEP_UNIQUE_STEP_NUMBER_DEPLOYMENT_ID UNIQUE (step_number, deployment_id);
-ALTER TABLE pipeline_step ADD CONSTRAINT FK_PIPELINE_STEP_PARENT_STEP_NUMBER FOREIGN KEY (parent_step_number, deployment_id) REFERENCES pipeline_step (step_number, deployment_id);
+ALTER TABLE pipeline_step ADD CONSTRAINT FK_PIPELINE_STEP_PARENT_STEP_NUMBER FOREIGN KEY (parent_step_number, deployment_id) REFERENCES pipeline_step (step_number, deployment_id) DEFERRABLE INITIALLY IMMEDIATE;
+ALTER TABLE pipeline_version ADD CONSTRAINT FK_PIPELINE_VERSION_DEPLOYMENT_ID FOREIGN KEY (deployment_id) REFERENCES deployment (id);
+ALTER TABLE pipeline_version ADD CONSTRAINT PIPELINE_VERSION_UNIQUE_VERSION_DEPLOYMENT_ID UNIQUE (version, deployment_id);
 CREATE INDEX on release (deployment_id);
 ALTER TABLE release ADD CONSTRAINT FK_RELEASE_DEPLOYMENT_ID FOREIGN KEY (deployment_id) REFERENCES deployment (id);
+ALTER TABLE release_notification ADD CONSTRAINT FK_RELEASE_NOTIFICATION_DEPLOYMENT_ID FOREIGN KEY (deployment_id) REFERENCES deployment (id);
+CREATE UNIQUE INDEX RELEASE_NOTIFICATION_UNIQUE_IDEMPOTENCY_KEY ON release_notification (deployment_id, idempotency_key) WHERE idempotency_key IS NOT NULL AND status <> 'failed';
+CREATE INDEX ON release_notification (deployment_id, image_tag, values_hash, creation_date);
+ALTER TABLE values_layer ADD CONSTRAINT FK_VALUES_LAYER_DEPLOYMENT_ID FOREIGN KEY (deployment_id) REFERENCES deployment (id);
+ALTER TABLE values_layer ADD CONSTRAINT VALUES_LAYER_UNIQUE_VERSION_DEPLOYMENT_ID_NAMESPACE UNIQUE (version, deployment_id, namespace);
+ALTER TABLE deployment_variable_set ADD CONSTRAINT FK_DEPLOYMENT_VARIABLE_SET_DEPLOYMENT_ID FOREIGN KEY (deployment_id) REFERENCES deployment (id);
+ALTER TABLE deployment_variable_set ADD CONSTRAINT FK_DEPLOYMENT_VARIABLE_SET_VARIABLE_SET_ID FOREIGN KEY (variable_set_id) REFERENCES variable_set (id);
+ALTER TABLE application_snapshot ADD CONSTRAINT FK_APPLICATION_SNAPSHOT_APPLICATION_ID FOREIGN KEY (application_id) REFERENCES application (id);
+ALTER TABLE application_snapshot ADD CONSTRAINT APPLICATION_SNAPSHOT_UNIQUE_NAME_APPLICATION_ID UNIQUE (name, application_id);
+ALTER TABLE scheduled_job ADD CONSTRAINT FK_SCHEDULED_JOB_DEPLOYMENT_ID FOREIGN KEY (deployment_id) REFERENCES deployment (id);
+CREATE INDEX ON scheduled_job (run_at) WHERE status = 'pending';
+CREATE INDEX ON scheduled_job (claimed_at) WHERE status = 'running';
 
 COMMIT;